@@ -7,10 +7,12 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/Xunop/e-oasis/internal/config"
 	"github.com/Xunop/e-oasis/internal/log"
 	"github.com/Xunop/e-oasis/internal/server"
+	"github.com/Xunop/e-oasis/internal/storage"
 	"github.com/Xunop/e-oasis/internal/store"
 	"github.com/Xunop/e-oasis/internal/store/db"
 	"github.com/Xunop/e-oasis/internal/worker"
@@ -47,41 +49,35 @@ var (
 			ctx, cancle := context.WithCancel(context.Background())
 			defer cancle()
 
-			// Will create a sqlite database
-			systemDb, err := db.NewDB(config.Opts.DSN, "system")
+			// A restore refuses to run while the server uses the data directory
+			unlock, err := worker.LockDataDir()
 			if err != nil {
-				cancle()
-				fmt.Println("Error connecting to database", err)
+				fmt.Println(err)
 				return
 			}
-			defer systemDb.Close()
-			if err := systemDb.Migrate(ctx); err != nil {
-				cancle()
-				fmt.Println("Error migrating database,", err)
-			}
+			defer unlock()
 
-			metaDb, err := db.NewDB(config.Opts.MetaDSN, "meta")
+			store, closeStore, err := openStore(ctx)
 			if err != nil {
 				cancle()
-				fmt.Println("Error connecting to metadata database", err)
+				fmt.Println(err)
 				return
 			}
-			defer metaDb.Close()
-			if err := metaDb.Migrate(ctx); err != nil {
-				cancle()
-				fmt.Println("Error migrating database, ", err)
-			}
+			defer closeStore()
 
-			store := store.NewStore(systemDb.DB, metaDb.DB)
-			if err := store.Ping(); err != nil {
-				cancle()
-				fmt.Println("Error pinging database", err)
-				return
-			}
+			// The books created or deleted when the server stopped are
+			// completed first, their jobs must not be resumed
+			worker.RecoverJournal(store)
+			// The quotas are checked against the usage counted now
+			worker.RefreshAllUsage(store)
 
 			uploadPool := worker.NewUploadPool(store, config.Opts.WorkerPoolSize)
 			parsePool := worker.NewParsePool(store, config.Opts.WorkerPoolSize)
-
+			if err := worker.Folders.Start(store, uploadPool); err != nil {
+				fmt.Println("Error watching folders", err)
+			}
+			defer worker.Folders.Close()
+			worker.StartTrashPurge(ctx, store)
 
 			// Start Server
 			s, err := server.StartServer(ctx, store, uploadPool, parsePool)
@@ -111,6 +107,204 @@ var (
 	}
 )
 
+// importCalibreCmd attaches the books of a calibre library to a user, the
+// library is left in place.
+var importCalibreCmd = &cobra.Command{
+	Use:   "import-calibre",
+	Short: "Import an existing calibre library in place",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		library, _ := cmd.Flags().GetString("library")
+		userID, _ := cmd.Flags().GetInt("user")
+
+		ctx, cancle := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancle()
+
+		store, closeStore, err := openStore(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeStore()
+
+		report, err := worker.ImportCalibreLibrary(ctx, store, library, userID, func(p worker.CalibreImportProgress) {
+			fmt.Printf("[%d/%d] %s\n", p.Done, p.Total, p.Title)
+		})
+		if report != nil {
+			for _, conflict := range report.Conflicts {
+				fmt.Printf("conflict: %s (%s) is already book %d\n", conflict.Title, conflict.Path, conflict.BookID)
+			}
+			for _, skipped := range report.Skipped {
+				fmt.Printf("skipped: %s (%s): %s\n", skipped.Title, skipped.Path, skipped.Reason)
+			}
+			fmt.Printf("Imported %d of %d books, %d skipped, %d conflicts\n",
+				report.Imported, report.Total, len(report.Skipped), len(report.Conflicts))
+		}
+		return err
+	},
+}
+
+// rebuildDbCmd restores the metadata database from the sidecars of the books
+var rebuildDbCmd = &cobra.Command{
+	Use:   "rebuild-db",
+	Short: "Rebuild the metadata database from the metadata.opf of the books",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancle := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancle()
+
+		store, closeStore, err := openStore(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeStore()
+
+		report, err := worker.RebuildMetaDB(ctx, store, func(p worker.RebuildProgress) {
+			fmt.Printf("[%d/%d] %s\n", p.Done, p.Total, p.Title)
+		})
+		if report != nil {
+			for _, skipped := range report.Skipped {
+				fmt.Printf("skipped: %s: %s\n", skipped.Path, skipped.Reason)
+			}
+			fmt.Printf("Restored %d of %d books, %d skipped\n", report.Restored, report.Total, len(report.Skipped))
+		}
+		return err
+	},
+}
+
+// doctorCmd checks the databases and the data directory against each other
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the library for inconsistencies, and repair them with --fix",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fix, _ := cmd.Flags().GetBool("fix")
+
+		ctx, cancle := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancle()
+
+		store, closeStore, err := openStore(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeStore()
+
+		report, err := worker.RunDoctor(ctx, store, fix)
+		if report != nil {
+			for _, issue := range report.Issues {
+				fmt.Println(issue)
+				if issue.Fix != "" {
+					fmt.Printf("  fixed: %s\n", issue.Fix)
+				} else if issue.Error != "" {
+					fmt.Printf("  not fixed: %s\n", issue.Error)
+				}
+			}
+			if report.Quarantine != "" {
+				fmt.Printf("Quarantined files are in %s\n", report.Quarantine)
+			}
+			fmt.Printf("Found %d issues, fixed %d\n", len(report.Issues), report.Fixed)
+		}
+		return err
+	},
+}
+
+// backupCmd backs the databases and the library up. It runs in its own
+// process, the writes of a running server don't wait for its snapshots.
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back the databases and the books up, incrementally with --incremental or --base",
+	Long: `Back the databases and the books up, incrementally with --incremental or --base.
+
+The two databases are snapshotted one after the other. When the server is
+running, its writes don't wait for the snapshots and a book saved in between
+may be in one of them only, back up through the server with POST /api/v1/backups
+to get both databases at the same point.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, _ := cmd.Flags().GetString("out")
+		base, _ := cmd.Flags().GetString("base")
+		incremental, _ := cmd.Flags().GetBool("incremental")
+
+		ctx, cancle := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancle()
+
+		store, closeStore, err := openStore(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeStore()
+		if pid, running := worker.DataDirOwner(); running {
+			fmt.Printf("e-oasis (pid %d) is running, the databases may not be backed up at the same point, POST /api/v1/backups does\n", pid)
+		}
+		if incremental && base == "" {
+			if base, err = worker.LatestBackup(); err != nil {
+				return err
+			}
+		}
+
+		info, err := worker.Backup(ctx, store, out, base)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Backed up %d files (%d bytes) to %s, %d bytes written\n", info.Files, info.Size, info.Dir, info.Stored)
+		return nil
+	},
+}
+
+// restoreCmd puts a backup back into the data directory, the server must be
+// stopped
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore a backup into the data directory, the server must be stopped",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, _ := cmd.Flags().GetString("from")
+		force, _ := cmd.Flags().GetBool("force")
+
+		manifest, err := worker.RestoreBackup(from, force)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Restored %d files of the backup made by %s at %s\n", len(manifest.Files), manifest.AppVersion,
+			time.Unix(manifest.CreatedTs, 0).Format(time.RFC3339))
+		return nil
+	},
+}
+
+// openStore opens and migrates the databases and opens the storage of the
+// books, the returned function closes the databases
+func openStore(ctx context.Context) (*store.Store, func(), error) {
+	// Will create a sqlite database
+	systemDb, err := db.NewDB(config.Opts.DSN, "system")
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error connecting to database: %w", err)
+	}
+	if err := systemDb.Migrate(ctx); err != nil {
+		systemDb.Close()
+		return nil, nil, fmt.Errorf("Error migrating database: %w", err)
+	}
+
+	metaDb, err := db.NewDB(config.Opts.MetaDSN, "meta")
+	if err != nil {
+		systemDb.Close()
+		return nil, nil, fmt.Errorf("Error connecting to metadata database: %w", err)
+	}
+	if err := metaDb.Migrate(ctx); err != nil {
+		systemDb.Close()
+		metaDb.Close()
+		return nil, nil, fmt.Errorf("Error migrating metadata database: %w", err)
+	}
+
+	store := store.NewStore(systemDb.DB, metaDb.DB)
+	closeStore := func() {
+		metaDb.Close()
+		systemDb.Close()
+	}
+	if err := store.Ping(); err != nil {
+		closeStore()
+		return nil, nil, fmt.Errorf("Error pinging database: %w", err)
+	}
+	if err := storage.Open(config.Opts); err != nil {
+		closeStore()
+		return nil, nil, fmt.Errorf("Error opening storage: %w", err)
+	}
+	return store, closeStore, nil
+}
+
 func Execute() error {
 	return rootCmd.Execute()
 }
@@ -135,6 +329,23 @@ func init() {
 	rootCmd.PersistentFlags().BoolP("help", "h", false, "Help")
 	rootCmd.PersistentFlags().BoolVarP(&configDump, "config-dump", "", false, "Dump config file")
 
+	importCalibreCmd.Flags().StringP("library", "l", "", "Calibre library directory, containing metadata.db")
+	importCalibreCmd.Flags().IntP("user", "u", 0, "ID of the user to attach the books to")
+	importCalibreCmd.MarkFlagRequired("library")
+	importCalibreCmd.MarkFlagRequired("user")
+	rootCmd.AddCommand(importCalibreCmd)
+	rootCmd.AddCommand(rebuildDbCmd)
+	doctorCmd.Flags().Bool("fix", false, "Repair the issues, the unknown files are moved to the quarantine")
+	rootCmd.AddCommand(doctorCmd)
+	backupCmd.Flags().StringP("out", "o", "", "Backup directory, a new one in the backup_dir option by default")
+	backupCmd.Flags().String("base", "", "Backup to start from, only the files it doesn't have are copied")
+	backupCmd.Flags().Bool("incremental", false, "Start from the latest backup of the backup_dir option")
+	rootCmd.AddCommand(backupCmd)
+	restoreCmd.Flags().StringP("from", "f", "", "Backup directory to restore")
+	restoreCmd.Flags().Bool("force", false, "Replace the library of the data directory")
+	restoreCmd.MarkFlagRequired("from")
+	rootCmd.AddCommand(restoreCmd)
+
 	// viper.SetEnvPrefix("eoasis")
 }
 
@@ -16,6 +16,7 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthInterceptor struct {
@@ -35,6 +36,12 @@ func (m *AuthInterceptor) AuthenticationInterceptor(next http.Handler) http.Hand
 		}
 		clientIP := request.FindClientIP(r)
 		accesstoken := getAccessToken(r)
+		// The OPDS readers send the password, or an access token, with
+		// HTTP Basic authentication
+		basicUser, basicPassword, basic := r.BasicAuth()
+		if isOpdsPath(r.URL.Path) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="E-Oasis", charset="UTF-8"`)
+		}
 
 		// if accesstoken == "" {
 		// 	log.Debug("Failed to authentica because no access token provided",
@@ -45,7 +52,13 @@ func (m *AuthInterceptor) AuthenticationInterceptor(next http.Handler) http.Hand
 		// 	return
 		// }
 
-		username, err := m.authenticate(r.Context(), accesstoken)
+		var username string
+		var err error
+		if basic && accesstoken == "" {
+			username, err = m.authenticateBasic(r.Context(), basicUser, basicPassword)
+		} else {
+			username, err = m.authenticate(r.Context(), accesstoken)
+		}
 		if err != nil {
 			log.Debug("Failed to authenticate user",
 				zap.String("client_ip", clientIP),
@@ -100,10 +113,13 @@ func (m *AuthInterceptor) AuthenticationInterceptor(next http.Handler) http.Hand
 				zap.String("role", user.Role.String()),
 			)
 			response.Unauthorized(w, r)
+			return
 		}
 
 		m.store.SetLastLogin(user.ID)
-		m.store.SetAPIKeyUsedTimeStamp(user.ID, accesstoken)
+		if accesstoken != "" {
+			m.store.SetAPIKeyUsedTimeStamp(user.ID, accesstoken)
+		}
 
 		// Set user context
 		ctx := r.Context()
@@ -166,6 +182,29 @@ func (m *AuthInterceptor) authenticate(ctx context.Context, accessToken string)
 	return user.Username, nil
 }
 
+// authenticateBasic checks the password of the user, or one of their access
+// tokens given as password
+func (m *AuthInterceptor) authenticateBasic(ctx context.Context, username, password string) (string, error) {
+	user, err := m.store.GetUser(&model.FindUser{Username: &username})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get user")
+	}
+	if user == nil {
+		return "", errors.Errorf("user not found with name: %s", username)
+	}
+	if user.RowStatus == model.Archived {
+		return "", errors.Errorf("user is archived with ID: %d", user.ID)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil {
+		return user.Username, nil
+	}
+	tokenUser, err := m.authenticate(ctx, password)
+	if err != nil || tokenUser != user.Username {
+		return "", errors.New("invalid password")
+	}
+	return user.Username, nil
+}
+
 func getAccessToken(r *http.Request) string {
 	// Check the HTTP Authorization header first
 	authorizationHeaders := r.Header.Get("Authorization")
@@ -22,7 +22,15 @@ func isUnauthorizeAllowed(fullMethodName string) bool {
 	return authenticationAllowlist[fullMethodName]
 }
 
-var allowedPathOnlyForAdmin = map[string]bool{}
+// isOpdsPath reports whether the path is one of the OPDS catalog, the
+// readers authenticate with HTTP Basic there
+func isOpdsPath(path string) bool {
+	return path == "/opds" || strings.HasPrefix(path, "/opds/")
+}
+
+var allowedPathOnlyForAdmin = map[string]bool{
+	"/api/v1/import/calibre": true,
+}
 
 // isOnlyForAdminAllowedPath returns true if the method is allowed to be called only by admin.
 func isOnlyForAdminAllowedPath(methodName string) bool {
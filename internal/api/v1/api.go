@@ -44,15 +44,14 @@ func Server(router *mux.Router, handler *Handler) {
 	}
 	jwtSecret := sSetting.JWTSecret
 	// Add authentication middleware
-	sr.Use(NewAuthInterceptor(handler.store, jwtSecret).AuthenticationInterceptor)
+	authInterceptor := NewAuthInterceptor(handler.store, jwtSecret)
+	sr.Use(authInterceptor.AuthenticationInterceptor)
+	// The tus discovery answers OPTIONS itself
+	sr.HandleFunc("/uploads", handler.tusOptions).Methods(http.MethodOptions)
 	sr.Methods(http.MethodOptions)
 
-
-	// opdsRouter := router.PathPrefix("/opds").Subrouter()
-	// opdsRouter.HandleFunc("", handler.opdsFeed).Methods(http.MethodGet)
-	// opdsRouter.HandleFunc("/download/{id:[0-9]+}", handler.downloadBook).Methods(http.MethodGet)
-
 	opdsRouter := router.PathPrefix("/opds").Subrouter()
+	opdsRouter.Use(authInterceptor.AuthenticationInterceptor)
 	opdsRouter.HandleFunc("", handler.opdsRootFeed).Methods(http.MethodGet)
 	opdsRouter.HandleFunc("/all", handler.opdsAllBooksFeed).Methods(http.MethodGet)
 	opdsRouter.HandleFunc("/tags", handler.opdsTagsFeed).Methods(http.MethodGet)
@@ -61,17 +60,45 @@ func Server(router *mux.Router, handler *Handler) {
 
 	sr.HandleFunc("/user", handler.createUser).Methods(http.MethodPost)
 	sr.HandleFunc("/users", handler.listUsers).Methods(http.MethodGet)
+	sr.HandleFunc("/users/{id:[0-9]+}/usage", handler.getUserUsage).Methods(http.MethodGet)
+	sr.HandleFunc("/users/{id:[0-9]+}/quota", handler.setUserQuota).Methods(http.MethodPut)
+	sr.HandleFunc("/usage", handler.usageReport).Methods(http.MethodGet)
+	sr.HandleFunc("/doctor", handler.checkLibrary).Methods(http.MethodGet)
+	sr.HandleFunc("/doctor", handler.fixLibrary).Methods(http.MethodPost)
+	sr.HandleFunc("/backups", handler.listBackups).Methods(http.MethodGet)
+	sr.HandleFunc("/backups", handler.createBackup).Methods(http.MethodPost)
 	sr.HandleFunc("/signup", handler.signUp).Methods(http.MethodPost)
 	sr.HandleFunc("/signin", handler.signIn).Methods(http.MethodPost)
 	sr.HandleFunc("/settings/general", handler.SetGeneralSettings).Methods(http.MethodPost)
+	sr.HandleFunc("/settings/quota", handler.SetQuotaSettings).Methods(http.MethodPost)
+	sr.HandleFunc("/settings/trash", handler.SetTrashSettings).Methods(http.MethodPost)
 	sr.HandleFunc("/import/books", handler.importBooks).Methods(http.MethodPost)
+	sr.HandleFunc("/import/calibre", handler.importCalibre).Methods(http.MethodPost)
 	sr.HandleFunc("/books", handler.listBooks).Methods(http.MethodGet)
 	sr.HandleFunc("/books", handler.addBookBatch).Methods(http.MethodPost)
 	sr.HandleFunc("/book", handler.addBookSingle).Methods(http.MethodPost)
+	sr.HandleFunc("/book", handler.putBook).Methods(http.MethodPut)
 	sr.HandleFunc("/book/{id:[0-9]+}", handler.deleteBook).Methods(http.MethodDelete)
 	sr.HandleFunc("/book/{id:[0-9]+}/tags", handler.addTagToBook).Methods(http.MethodPost)
-	// sr.HandleFunc("/book/{id}", handler.updateBook).Methods(http.MethodPut)
-	// sr.HandleFunc("/book/{id}", handler.getBook).Methods(http.MethodGet)
+	sr.HandleFunc("/book/{id:[0-9]+}", handler.getBook).Methods(http.MethodGet)
+	sr.HandleFunc("/book/{id:[0-9]+}", handler.updateBook).Methods(http.MethodPut, http.MethodPatch)
+	sr.HandleFunc("/book/{id:[0-9]+}/cover", handler.uploadCover).Methods(http.MethodPut)
+	sr.HandleFunc("/book/{id:[0-9]+}/cover/extract", handler.extractCover).Methods(http.MethodPost)
+	sr.HandleFunc("/book/{id:[0-9]+}/images", handler.listBookImages).Methods(http.MethodGet)
+	sr.HandleFunc("/trash", handler.listTrash).Methods(http.MethodGet)
+	sr.HandleFunc("/trash", handler.emptyTrash).Methods(http.MethodDelete)
+	sr.HandleFunc("/trash/{id:[0-9]+}/restore", handler.restoreBook).Methods(http.MethodPost)
+	sr.HandleFunc("/trash/{id:[0-9]+}", handler.purgeBook).Methods(http.MethodDelete)
+	sr.HandleFunc("/uploads", handler.createUpload).Methods(http.MethodPost)
+	sr.HandleFunc("/uploads/{id:[0-9a-f]+}", handler.headUpload).Methods(http.MethodHead)
+	sr.HandleFunc("/uploads/{id:[0-9a-f]+}", handler.patchUpload).Methods(http.MethodPatch)
+	sr.HandleFunc("/jobs", handler.listJobs).Methods(http.MethodGet)
+	sr.HandleFunc("/jobs/events", handler.jobEvents).Methods(http.MethodGet)
+	sr.HandleFunc("/jobs/{id:[0-9]+}", handler.getJob).Methods(http.MethodGet)
+	sr.HandleFunc("/jobs/{id:[0-9]+}", handler.cancelJob).Methods(http.MethodDelete)
+	sr.HandleFunc("/watch-folders", handler.listWatchFolders).Methods(http.MethodGet)
+	sr.HandleFunc("/watch-folders", handler.addWatchFolder).Methods(http.MethodPost)
+	sr.HandleFunc("/watch-folders/{id:[0-9]+}", handler.deleteWatchFolder).Methods(http.MethodDelete)
 	// Modify book status is only for user self
 	sr.HandleFunc("/bookStatus/{userID}/{bookID}", handler.upsetBookStatus).Methods(http.MethodPost)
 	sr.HandleFunc("/bookStatus/{userID}/{bookID}", handler.upsetBookStatus).Methods(http.MethodPut)
@@ -0,0 +1,84 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+
+	"go.uber.org/zap"
+
+	"github.com/Xunop/e-oasis/internal/http/request"
+	"github.com/Xunop/e-oasis/internal/http/response"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/worker"
+)
+
+type backupRequest struct {
+	// Incremental only copies the files the latest backup doesn't have
+	Incremental bool `json:"incremental"`
+}
+
+type backupResponse struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+}
+
+// createBackup backs the databases and the library up while the server is
+// running, for the admins. The backup goes on once the request is answered,
+// it is listed when finished and removed if it fails. The backups are
+// restored from the command line.
+func (h *Handler) createBackup(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		log.Error("Unauthorized request by", zap.String("role", request.GetUserRole(r).String()),
+			zap.String("username", request.GetUsername(r)))
+		response.Unauthorized(w, r)
+		return
+	}
+
+	var req backupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		log.Error("Failed to decode request body", zap.Error(err))
+		response.BadRequest(w, r, err)
+		return
+	}
+	var base string
+	if req.Incremental {
+		var err error
+		if base, err = worker.LatestBackup(); err != nil {
+			response.BadRequest(w, r, err)
+			return
+		}
+	}
+
+	dir := worker.NewBackupDir()
+	go func() {
+		// The client leaving doesn't stop the backup
+		info, err := worker.Backup(context.Background(), h.store, dir, base)
+		if err != nil {
+			log.Error("Failed to back up", zap.String("dir", dir), zap.Bool("incremental", req.Incremental), zap.Error(err))
+			return
+		}
+		log.Info("Backup created", zap.String("dir", info.Dir), zap.Int("files", info.Files))
+	}()
+	response.Accepted(w, r, &backupResponse{Name: filepath.Base(dir), Dir: dir})
+}
+
+// listBackups returns the backups of the backup directory, for the admins
+func (h *Handler) listBackups(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		log.Error("Unauthorized request by", zap.String("role", request.GetUserRole(r).String()),
+			zap.String("username", request.GetUsername(r)))
+		response.Unauthorized(w, r)
+		return
+	}
+
+	list, err := worker.ListBackups()
+	if err != nil {
+		log.Error("Failed to list backups", zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	response.OK(w, r, list)
+}
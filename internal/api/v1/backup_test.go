@@ -0,0 +1,55 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/worker"
+)
+
+func TestCreateBackup(t *testing.T) {
+	s := newTestStore(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateUser(&model.User{Username: "admin", PasswordHash: string(hash), Role: model.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	Server(router, NewHandler(s, nil, nil))
+
+	// The backup goes on once the client is gone
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/backups", nil).WithContext(ctx)
+	req.SetBasicAuth("admin", "secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	cancel()
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("create backup = %d, %s", w.Code, w.Body)
+	}
+	var created backupResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil || created.Name == "" {
+		t.Fatalf("response = %+v, %v", created, err)
+	}
+
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		list, err := worker.ListBackups()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) == 1 && list[0].Name == created.Name {
+			return
+		}
+	}
+	t.Fatal("backup not finished")
+}
@@ -1,23 +1,27 @@
 package v1
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Xunop/e-oasis/internal/config"
 	"github.com/Xunop/e-oasis/internal/http/request"
 	"github.com/Xunop/e-oasis/internal/http/response"
 	"github.com/Xunop/e-oasis/internal/log"
 	"github.com/Xunop/e-oasis/internal/model"
-	"github.com/Xunop/e-oasis/internal/util"
+	"github.com/Xunop/e-oasis/internal/storage"
+	"github.com/Xunop/e-oasis/internal/util/parsers"
+	"github.com/Xunop/e-oasis/internal/validator"
 	"github.com/Xunop/e-oasis/internal/worker"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -30,7 +34,8 @@ func (h *Handler) listBooks(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, r, err)
 		return
 	}
-	find := &model.FindBook{}
+	trashed := false
+	find := &model.FindBook{Trashed: &trashed}
 	// If user is not admin or host, only show own books
 	if request.GetUserRole(r) != model.RoleHost && request.GetUserRole(r) != model.RoleAdmin {
 		log.Debug("User is not admin or host, only show own books")
@@ -46,72 +51,152 @@ func (h *Handler) listBooks(w http.ResponseWriter, r *http.Request) {
 	response.OK(w, r, books)
 }
 
-// addBookBatch need to parse the format of the book and add it to the store
+// errMalformedUpload is returned when the body of an upload can't be read
+var errMalformedUpload = errors.New("malformed upload")
+
+// addBookBatch need to parse the format of the book and add it to the store.
+// The files are streamed to the staging directory as they arrive, their jobs
+// are queued once every file is staged.
 func (h *Handler) addBookBatch(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseMultipartForm(config.Opts.MaxUploadSize << 20); err != nil {
-		log.Error("Max upload size exceeded", zap.Error(err))
-		log.Error("Now size is", zap.Int64("size", r.ContentLength<<20))
+	uid, err := strconv.Atoi(request.GetUserID(r))
+	if err != nil {
+		log.Error("Filed to get user ID", zap.Error(err))
 		response.BadRequest(w, r, err)
 		return
 	}
 
-	files := r.MultipartForm.File["file"]
-
-	jobs := make([]model.Job, 0)
-	for _, file := range files {
-		uid, err := strconv.Atoi(request.GetUserID(r))
-		if err != nil {
-			log.Error("Filed to get user ID", zap.Error(err))
-			response.BadRequest(w, r, err)
-		}
+	payloads, err := stageUploads(r, uid)
+	if err == nil {
+		err = h.checkUploadQuota(uid, payloads)
+	}
+	if err != nil {
+		uploadError(w, r, err)
+		return
+	}
 
-		fileBase := filepath.Base(file.Filename)
-		ext := filepath.Ext(fileBase)
-		// Check if the file type is supported
-		if !config.CheckSupportedTypes(ext[1:]) {
-			log.Error("Unsupported file type", zap.String("file_type", ext))
-			response.BadRequest(w, r, fmt.Errorf("Unsupported file type: %s", ext[1:]))
-			return
-		}
-		bookDir := strings.TrimSuffix(fileBase, ext)
-		bookPath := fmt.Sprintf("%s/%d/books/%s", config.Opts.Data, uid, bookDir)
-		bookPath = util.GenerateNewDirName(bookPath)
-		job := model.Job{
-			UserID: uid,
-			Path:   bookPath,
-			Type:   "BATCH",
-			Status: model.JobStatusPending,
-			Item:   file,
-		}
-		go h.uploadPool.Push(job)
-		newJob, err := h.store.AddJob(job)
+	jobs := make([]model.Job, 0, len(payloads))
+	for i, payload := range payloads {
+		newJob, err := h.addUploadJob(uid, model.JobTypeBatch, payload)
 		if err != nil {
 			log.Error("Failed to add job", zap.Error(err))
+			removeStaged(payloads[i:])
 			response.ServerError(w, r, err)
 			return
 		}
+		go h.uploadPool.Push(*newJob)
 		jobs = append(jobs, *newJob)
 	}
 	response.OK(w, r, jobs)
 }
 
+// stageUploads streams the files of the "file" fields of a multipart request
+// to the staging directory of the user. Nothing is kept when one of them
+// fails.
+func stageUploads(r *http.Request, uid int) ([]*model.JobPayload, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.Wrap(errMalformedUpload, err.Error())
+	}
+
+	payloads := make([]*model.JobPayload, 0)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return payloads, nil
+		}
+		if err != nil {
+			removeStaged(payloads)
+			return nil, errors.Wrap(errMalformedUpload, err.Error())
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		payload, err := worker.StageUpload(uid, part.FileName(), part, worker.UploadLimit())
+		part.Close()
+		if err != nil {
+			removeStaged(payloads)
+			return nil, err
+		}
+		payloads = append(payloads, payload)
+	}
+}
+
+// checkUploadQuota checks that the staged books fit in the quota of the
+// user, they are removed when they don't
+func (h *Handler) checkUploadQuota(uid int, payloads []*model.JobPayload) error {
+	var size int64
+	for _, payload := range payloads {
+		size += payload.Size
+	}
+	if err := worker.CheckQuota(h.store, uid, len(payloads), size); err != nil {
+		removeStaged(payloads)
+		return err
+	}
+	return nil
+}
+
+func removeStaged(payloads []*model.JobPayload) {
+	for _, payload := range payloads {
+		os.Remove(payload.File)
+	}
+}
+
+// uploadError responds to an upload that couldn't be staged
+func uploadError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, worker.ErrUploadTooLarge):
+		response.RequestEntityTooLarge(w, r, err)
+	case errors.Is(err, worker.ErrQuotaExceeded):
+		response.Error(w, r, http.StatusInsufficientStorage, err)
+	case errors.Is(err, worker.ErrUnsupportedType), errors.Is(err, errMalformedUpload):
+		response.BadRequest(w, r, err)
+	default:
+		log.Error("Failed to stage upload", zap.Error(err))
+		response.ServerError(w, r, err)
+	}
+}
+
+// addUploadJob saves the job of a staged book, which is resumed if the
+// server stops. The caller queues the job.
+func (h *Handler) addUploadJob(uid int, jobType string, payload *model.JobPayload) (*model.Job, error) {
+	return worker.AddUploadJob(h.store, uid, jobType, payload)
+}
+
 // addBookSingle parse the book and return to user
 // User can modify book metadata(title, author, cover, etc), so when we parse book, we need to return metadata to user.
 // Besides, we can batch upload books, user don't need to modify book metadata.
 // job -> upload -> parse -> return metadata
 func (h *Handler) addBookSingle(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseMultipartForm(config.Opts.MaxUploadSize << 20); err != nil {
-		log.Error("Max upload size exceeded", zap.Error(err))
-		log.Error("Now size is", zap.Int64("size", r.ContentLength<<20))
+	uid, err := strconv.Atoi(request.GetUserID(r))
+	if err != nil {
+		log.Error("Filed to get user ID", zap.Error(err))
 		response.BadRequest(w, r, err)
 		return
 	}
 
-	files := r.MultipartForm.File["file"]
-	if len(files) > 1 || len(files) <= 0 {
+	payloads, err := stageUploads(r, uid)
+	if err != nil {
+		uploadError(w, r, err)
+		return
+	}
+	if len(payloads) != 1 {
+		removeStaged(payloads)
 		response.BadRequest(w, r, fmt.Errorf("Only one file is allowed"))
 		return
 	}
+	if err := h.checkUploadQuota(uid, payloads); err != nil {
+		uploadError(w, r, err)
+		return
+	}
+	h.uploadBook(w, r, uid, payloads[0])
+}
+
+// putBook uploads a single book sent as the raw request body, its name is
+// the filename of the Content-Disposition header or the filename query
+// parameter.
+func (h *Handler) putBook(w http.ResponseWriter, r *http.Request) {
 	uid, err := strconv.Atoi(request.GetUserID(r))
 	if err != nil {
 		log.Error("Filed to get user ID", zap.Error(err))
@@ -119,40 +204,64 @@ func (h *Handler) addBookSingle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the file type is supported
-	fileBase := filepath.Base(files[0].Filename)
-	ext := filepath.Ext(fileBase)
-	if !config.CheckSupportedTypes(ext[1:]) {
-		log.Error("Unsupported file type", zap.String("file_type", ext))
-		response.BadRequest(w, r, fmt.Errorf("Unsupported file type"))
+	name := request.QueryStringParam(r, "filename", "")
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		name = params["filename"]
+	}
+	if name == "" {
+		response.BadRequest(w, r, errors.New("missing file name"))
+		return
+	}
+	if limit := worker.UploadLimit(); limit > 0 && r.ContentLength > limit {
+		response.RequestEntityTooLarge(w, r, errors.Wrapf(worker.ErrUploadTooLarge, "%s is over %d bytes", name, limit))
 		return
 	}
 
-	bookFileName := strings.TrimSuffix(fileBase, ext)
-	log.Debug("Book file name", zap.String("file_name", bookFileName))
-	bookPath := fmt.Sprintf("%s/%d/books/%s", config.Opts.Data, uid, bookFileName)
-	bookPath = util.GenerateNewDirName(bookPath)
-	log.Debug("Book path", zap.String("path", bookPath))
-	job := model.Job{
-		UserID: uid,
-		Path:   bookPath,
-		Type:   "SINGLE",
-		Status: model.JobStatusPending,
-		Item:   files[0],
+	payload, err := worker.StageUpload(uid, name, r.Body, worker.UploadLimit())
+	if err == nil {
+		err = h.checkUploadQuota(uid, []*model.JobPayload{payload})
 	}
-	go h.uploadPool.Push(job)
-	_, err = h.store.AddJob(job)
+	if err != nil {
+		uploadError(w, r, err)
+		return
+	}
+	h.uploadBook(w, r, uid, payload)
+}
+
+// uploadBook queues the job of a staged book and responds with the book once
+// it is parsed. The job is canceled when the client goes away, the client
+// gets the job to follow when the book takes longer than the upload timeout.
+func (h *Handler) uploadBook(w http.ResponseWriter, r *http.Request, uid int, payload *model.JobPayload) {
+	job, err := h.addUploadJob(uid, model.JobTypeSingle, payload)
 	if err != nil {
 		log.Error("Failed to add job", zap.Error(err))
 		response.ServerError(w, r, err)
 		return
 	}
 
-	// FIXME: This is a blocking operation, need to be optimized
-	// If goruntine has error, we can't catch it
+	// Wait for the outcome of this job only, before it can be done
+	results, stopWaiting := worker.Results.Wait(job.ID)
+	defer stopWaiting()
+	go h.uploadPool.Push(*job)
+
+	var timeout <-chan time.Time
+	if config.Opts.UploadTimeout > 0 {
+		timer := time.NewTimer(time.Duration(config.Opts.UploadTimeout) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
 	select {
-	case bookMeta := <-worker.MetaSingle:
-		log.Debug("MetaSingleDone")
+	case result := <-results:
+		if errors.Is(result.Err, worker.ErrQuotaExceeded) {
+			response.Error(w, r, http.StatusInsufficientStorage, result.Err)
+			return
+		}
+		if result.Err != nil {
+			response.ServerError(w, r, result.Err)
+			return
+		}
+		bookMeta := result.Meta
 
 		// When We parse the book, we need to save the book metadata
 		// Save the book metadata
@@ -169,56 +278,224 @@ func (h *Handler) addBookSingle(w http.ResponseWriter, r *http.Request) {
 			LastModified: bookMeta.Book.LastModified,
 		}
 		response.OK(w, r, newBook)
-		return
-	case err := <-worker.ErrorChan:
-		response.ServerError(w, r, err)
-		return
+	case <-timeout:
+		// The job goes on, the client follows it instead
+		log.Warn("Book upload is taking long", zap.Int("job_id", job.ID))
+		response.Accepted(w, r, job)
+	case <-r.Context().Done():
+		// Nobody waits for the book anymore
+		log.Info("Client gone, canceling upload", zap.Int("job_id", job.ID))
+		if _, err := worker.CancelJob(h.store, job.ID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Error("Failed to cancel job", zap.Int("job_id", job.ID), zap.Error(err))
+		}
 	}
 }
 
+// importBooks imports the books of a zip, tar, tar.gz or tar.zst archive in
+// the "archive" field. Admins may import a directory of the server given in
+// the "path" field instead. With mapTags, the books are tagged with their
+// directories.
 func (h *Handler) importBooks(w http.ResponseWriter, r *http.Request) {
-	file, header, err := r.FormFile("archive") // Expect a form field named "archive"
+	uid, _ := strconv.Atoi(request.GetUserID(r))
+	mapTags := r.FormValue("mapTags") == "true"
+
+	payload := model.JobPayload{MapTags: mapTags}
+	if dir := r.FormValue("path"); dir != "" {
+		if request.GetUserRole(r) != model.RoleHost && request.GetUserRole(r) != model.RoleAdmin {
+			log.Error("Unauthorized request by", zap.String("role", request.GetUserRole(r).String()))
+			response.Unauthorized(w, r)
+			return
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() || !filepath.IsAbs(dir) {
+			response.BadRequest(w, r, fmt.Errorf("%s is not an absolute path to a directory", dir))
+			return
+		}
+		payload.Dir = filepath.Clean(dir)
+		payload.FileName = filepath.Base(payload.Dir)
+	} else {
+		archivePath, name, err := stageImportArchive(r, uid)
+		if err != nil {
+			if errors.Is(err, worker.ErrUnsupportedArchive) || errors.Is(err, http.ErrMissingFile) {
+				response.BadRequest(w, r, err)
+			} else {
+				response.ServerError(w, r, err)
+			}
+			return
+		}
+		payload.File, payload.FileName = archivePath, name
+	}
+
+	job, err := h.store.AddJob(model.Job{
+		UserID:  uid,
+		Type:    model.JobTypeArchive,
+		Status:  model.JobStatusPending,
+		Stage:   model.JobStageImport,
+		Payload: payload,
+	})
 	if err != nil {
-		response.BadRequest(w, r, errors.New("missing 'archive' file in request"))
+		if payload.File != "" {
+			os.Remove(payload.File)
+		}
+		response.ServerError(w, r, err)
 		return
 	}
-	defer file.Close()
 
-	mapTags := r.FormValue("mapTags") == "true"
+	// Launch the processing in a background goroutine.
+	// The API returns immediately, the job tells how the import goes.
+	go worker.ImportArchive(h.store, *job)
 
-	uid, _ := strconv.Atoi(request.GetUserID(r))
+	log.Info("Book import job accepted", zap.Int("uid", uid), zap.String("source", payload.FileName))
+	response.Accepted(w, r, job) // Respond with 202 Accepted
+}
+
+// stageImportArchive saves the archive of an import to the tmp directory of
+// the user, the job is resumed from it if the server stops. It returns the
+// staged archive and its name.
+func stageImportArchive(r *http.Request, uid int) (string, string, error) {
+	file, header, err := r.FormFile("archive") // Expect a form field named "archive"
+	if err != nil {
+		return "", "", errors.Wrap(http.ErrMissingFile, "missing 'archive' file in request")
+	}
+	defer file.Close()
 
-	// Save the archive to a temporary location
 	tmpDir := filepath.Join(config.Opts.Data, fmt.Sprintf("%d/tmp", uid))
 	if err := os.MkdirAll(tmpDir, os.ModePerm); err != nil {
-		response.ServerError(w, r, errors.Wrap(err, "could not create temp dir for import"))
+		return "", "", errors.Wrap(err, "could not create temp dir for import")
+	}
+	dst, err := os.CreateTemp(tmpDir, "import-*"+filepath.Ext(header.Filename))
+	if err != nil {
+		return "", "", errors.Wrap(err, "could not save import archive")
+	}
+	archivePath := dst.Name()
+	_, err = io.Copy(dst, file)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(archivePath)
+		return "", "", errors.Wrap(err, "could not write import archive")
+	}
+
+	if _, err := worker.ArchiveFormat(archivePath); err != nil {
+		os.Remove(archivePath)
+		return "", "", errors.Wrap(err, header.Filename)
+	}
+	return archivePath, filepath.Base(header.Filename), nil
+}
+
+// TODO: Add batch delete and delete link data
+// deleteBook moves the book to the trash, its files and links are kept until
+// it is purged
+func (h *Handler) deleteBook(w http.ResponseWriter, r *http.Request) {
+	bookID := request.RouteIntParam(r, "id")
+	userID, err := strconv.Atoi(request.GetUserID(r))
+	if err != nil {
+		log.Error("Failed to get user ID", zap.Error(err))
+		response.BadRequest(w, r, err)
 		return
 	}
 
-	archivePath := filepath.Join(tmpDir, header.Filename)
-	dst, err := os.Create(archivePath)
+	log.Debug("Trashing book", zap.Int("bookID", bookID), zap.Int("userID", userID))
+	// If user is not admin or host, only allow to delete own book, the
+	// admins trash the book of every owner
+	var owner *int
+	if request.GetUserRole(r) != model.RoleHost && request.GetUserRole(r) != model.RoleAdmin {
+		owner = &userID
+	}
+
+	n, err := h.store.TrashBook(bookID, owner)
 	if err != nil {
-		response.ServerError(w, r, errors.Wrap(err, "could not save import archive"))
+		log.Error("Failed to trash book", zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	if n == 0 {
+		response.NotFound(w, r)
 		return
 	}
 
-	if _, err := io.Copy(dst, file); err != nil {
-		dst.Close()
-		response.ServerError(w, r, errors.Wrap(err, "could not write import archive"))
+	response.NoContent(w, r)
+}
+
+// canAccessBook reports whether the user may see and edit the book, like
+// deleteBook: the owner, an admin or the host.
+func (h *Handler) canAccessBook(r *http.Request, bookID, userID int) (bool, error) {
+	trashed := false
+	find := &model.FindBook{BookID: &bookID, Trashed: &trashed}
+	if request.GetUserRole(r) != model.RoleHost && request.GetUserRole(r) != model.RoleAdmin {
+		find.UserID = &userID
+	}
+	books, err := h.store.ListBooks(find)
+	if err != nil {
+		return false, err
+	}
+	return len(books) > 0, nil
+}
+
+// bookDetail joins the metadata of a book with what the user sees of it
+func (h *Handler) bookDetail(meta *model.BookMeta, userID int) *model.BookDetail {
+	detail := &model.BookDetail{
+		BookMeta: meta,
+		CoverURL: fmt.Sprintf("/api/v1/covers/%d", meta.Book.ID),
+	}
+	// Only imported books have their formats in the database, the others
+	// have a single file
+	if len(meta.Formats) == 0 && meta.Book.Path != "" {
+		ext := parsers.Ext(meta.Book.Path)
+		format := &model.BookFormat{
+			Format: strings.ToUpper(strings.TrimPrefix(ext, ".")),
+			Name:   strings.TrimSuffix(filepath.Base(meta.Book.Path), ext),
+		}
+		if size, err := storage.Size(context.Background(), storage.Default, meta.Book.Path); err == nil {
+			format.Size = size
+		}
+		meta.Formats = []*model.BookFormat{format}
+	}
+
+	status, err := h.store.GetBookStatus(meta.Book.ID, userID)
+	if err == nil {
+		detail.Status = status
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		log.Error("Failed to get book status", zap.Int("book_id", meta.Book.ID), zap.Error(err))
+	}
+	return detail
+}
+
+func (h *Handler) getBook(w http.ResponseWriter, r *http.Request) {
+	bookID := request.RouteIntParam(r, "id")
+	userID, err := strconv.Atoi(request.GetUserID(r))
+	if err != nil {
+		log.Error("Failed to get user ID", zap.Error(err))
+		response.BadRequest(w, r, err)
 		return
 	}
-	dst.Close()
 
-	// Launch the processing in a background goroutine.
-	// The API returns immediately.
-	go h.processImportArchive(archivePath, uid, mapTags)
+	if ok, err := h.canAccessBook(r, bookID, userID); err != nil {
+		log.Error("Failed to get book", zap.Int("book_id", bookID), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	} else if !ok {
+		response.NotFound(w, r)
+		return
+	}
+
+	meta, err := h.store.GetBookMeta(bookID)
+	if err != nil {
+		log.Error("Failed to get book metadata", zap.Int("book_id", bookID), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	if meta == nil {
+		response.NotFound(w, r)
+		return
+	}
 
-	log.Info("Book import job accepted", zap.Int("uid", uid), zap.String("archive", header.Filename))
-	response.Accepted(w, r) // Respond with 202 Accepted
+	response.OK(w, r, h.bookDetail(meta, userID))
 }
 
-// TODO: Add batch delete and delete link data
-func (h *Handler) deleteBook(w http.ResponseWriter, r *http.Request) {
+// updateBook edits the metadata of a book, PUT and PATCH both only change
+// the fields of the request.
+func (h *Handler) updateBook(w http.ResponseWriter, r *http.Request) {
 	bookID := request.RouteIntParam(r, "id")
 	userID, err := strconv.Atoi(request.GetUserID(r))
 	if err != nil {
@@ -227,22 +504,49 @@ func (h *Handler) deleteBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Debug("Deleting book", zap.Int("bookID", bookID), zap.Int("userID", userID))
-	find := &model.FindBook{BookID: &bookID}
-	// If user is not admin or host, only allow to delete own book
-	if request.GetUserRole(r) != model.RoleHost && request.GetUserRole(r) != model.RoleAdmin {
-		find.UserID = &userID
+	var update model.BookUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		log.Error("Failed to decode request body", zap.Error(err))
+		response.BadRequest(w, r, errors.New("invalid request body"))
+		return
+	}
+	if err := validator.ValidateBookUpdateRequest(&update); err != nil {
+		response.BadRequest(w, r, err)
+		return
 	}
 
-	if err := h.store.RemoveBook(find); err != nil {
-		log.Error("Failed to delete book", zap.Error(err))
+	if ok, err := h.canAccessBook(r, bookID, userID); err != nil {
+		log.Error("Failed to get book", zap.Int("book_id", bookID), zap.Error(err))
 		response.ServerError(w, r, err)
 		return
+	} else if !ok {
+		response.NotFound(w, r)
+		return
 	}
-	// Delete book from cache
-	h.store.BookCache.Delete(bookID)
 
-	response.NoContent(w, r)
+	meta, err := h.store.UpdateBook(bookID, &update)
+	if err != nil {
+		log.Error("Failed to update book", zap.Int("book_id", bookID), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+
+	// The directory of the book may be named after its title and authors
+	if update.Title != nil || update.Authors != nil {
+		oldPath := meta.Book.Path
+		ownerID, err := h.store.GetBookOwnerID(bookID)
+		if err == nil {
+			meta.Book.Path, err = worker.ApplyBookLayout(h.store, bookID, ownerID)
+		}
+		if err != nil {
+			log.Error("Failed to move book", zap.Int("book_id", bookID), zap.Error(err))
+		} else {
+			worker.MoveStoredBook(oldPath, meta.Book.Path)
+		}
+	}
+
+	log.Info("Book updated", zap.Int("book_id", bookID), zap.Int("uid", userID))
+	response.OK(w, r, h.bookDetail(meta, userID))
 }
 
 func (h *Handler) upsetBookStatus(w http.ResponseWriter, r *http.Request) {
@@ -319,35 +623,6 @@ func (h *Handler) getBookStatus(w http.ResponseWriter, r *http.Request) {
 	response.OK(w, r, status)
 }
 
-func (h *Handler) getCover(w http.ResponseWriter, r *http.Request) {
-	bookID := request.RouteIntParam(r, "bookID")
-
-	book, err := h.store.GetBook(&model.FindBook{BookID: &bookID})
-	if err != nil {
-		log.Error("Failed to get book", zap.Int("book_id", bookID), zap.Error(err))
-		response.ServerError(w, r, err)
-		return
-	}
-	dir := filepath.Dir(book.Path)
-
-	log.Debug("Book dir", zap.String("dir", dir))
-
-	var covers []string
-	if book.HasCover {
-		covers, err = filepath.Glob(fmt.Sprintf("%s/cover.webp", dir))
-		if err != nil || len(covers) == 0 {
-			log.Error("Failed to get cover", zap.Int("book_id", bookID), zap.String("cover_dir", dir), zap.Error(err))
-			http.ServeFile(w, r, fmt.Sprintf("%s/default_cover.webp", "static/img"))
-			return
-		}
-		http.ServeFile(w, r, covers[0])
-	} else {
-		// Use default cover
-		log.Debug("Use default cover", zap.Int("book_id", bookID))
-		http.ServeFile(w, r, fmt.Sprintf("%s/default_cover.webp", "static/img"))
-	}
-}
-
 type addTagRequest struct {
 	TagName string `json:"tagName"`
 }
@@ -383,89 +658,73 @@ func (h *Handler) addTagToBook(w http.ResponseWriter, r *http.Request) {
 	response.OK(w, r, map[string]string{"message": "tag added successfully"})
 }
 
-// processImportArchive runs in the background to unpack and import books.
-func (h *Handler) processImportArchive(archivePath string, userID int, mapTags bool) {
-	// Clean up the archive file when done
-	defer os.Remove(archivePath)
+type importCalibreRequest struct {
+	// Library is the calibre library directory on the server
+	Library string `json:"library"`
+	// UserID is the user the books are attached to, the caller by default
+	UserID int `json:"userID"`
+}
 
-	log.Debug("Starting archive processing", zap.String("archive", archivePath))
+// importCalibre attaches the books of a calibre library on the server to a
+// user. The import runs as a job of the caller, its items tell the outcome of
+// every book and the duplicate ones are the conflicts.
+func (h *Handler) importCalibre(w http.ResponseWriter, r *http.Request) {
+	if request.GetUserRole(r) != model.RoleHost && request.GetUserRole(r) != model.RoleAdmin {
+		log.Error("Unauthorized request by", zap.String("role", request.GetUserRole(r).String()))
+		response.Unauthorized(w, r)
+		return
+	}
 
-	archiveFile, err := os.Open(archivePath)
+	var req importCalibreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, r, errors.New("invalid request body"))
+		return
+	}
+	if strings.TrimSpace(req.Library) == "" {
+		response.BadRequest(w, r, errors.New("library cannot be empty"))
+		return
+	}
+	library, err := filepath.Abs(req.Library)
 	if err != nil {
-		log.Error("Failed to open archive for processing", zap.Error(err))
+		response.BadRequest(w, r, err)
 		return
 	}
-	defer archiveFile.Close()
-
-	gzipReader, err := gzip.NewReader(archiveFile)
+	if _, err := os.Stat(filepath.Join(library, "metadata.db")); err != nil {
+		response.BadRequest(w, r, fmt.Errorf("%s is not a calibre library", library))
+		return
+	}
+	uid, _ := strconv.Atoi(request.GetUserID(r))
+	if req.UserID == 0 {
+		req.UserID = uid
+	}
+	ownerID := int32(req.UserID)
+	owner, err := h.store.GetUser(&model.FindUser{ID: &ownerID})
 	if err != nil {
-		log.Error("Failed to create gzip reader for archive", zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	if owner == nil {
+		response.BadRequest(w, r, fmt.Errorf("user %d not found", req.UserID))
 		return
 	}
-	defer gzipReader.Close()
-
-	tarReader := tar.NewReader(gzipReader)
-
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break // End of archive
-		}
-		if err != nil {
-			log.Error("Error reading tar header in archive", zap.String("archive", archivePath), zap.Error(err))
-			continue
-		}
-
-		// Skip directories and unsupported files
-		ext := filepath.Ext(header.Name)
-		if header.Typeflag != tar.TypeReg || !config.CheckSupportedTypes(ext[1:]) {
-			continue
-		}
-
-		var tagsToAdd []string
-		if mapTags {
-			// Get the directory part of the file's path within the archive
-			archiveDir := filepath.Dir(header.Name)
-			if archiveDir != "." {
-				// Split the path by the separator to get individual directory names
-				tagsToAdd = strings.Split(archiveDir, string(filepath.Separator))
-			}
-		}
-
-		// Determine final path for the book
-		bookDir := strings.TrimSuffix(filepath.Base(header.Name), ext)
-		finalBookDir := fmt.Sprintf("%s/%d/books/%s", config.Opts.Data, userID, bookDir)
-		finalBookDir = util.GenerateNewDirName(finalBookDir) // Ensure unique directory
-
-		if err := os.MkdirAll(finalBookDir, os.ModePerm); err != nil {
-			log.Error("Failed to create book directory during import", zap.Error(err))
-			continue
-		}
-
-		finalBookPath := filepath.Join(finalBookDir, filepath.Base(header.Name))
-
-		// Stream the file from the archive to its final destination
-		outFile, err := os.Create(finalBookPath)
-		if err != nil {
-			log.Error("Failed to create book file during import", zap.Error(err))
-			continue
-		}
 
-		if _, err := io.Copy(outFile, tarReader); err != nil {
-			log.Error("Failed to write book file during import", zap.Error(err))
-			outFile.Close()
-			continue
-		}
-		outFile.Close()
-
-		// Now that the file is saved, parse its metadata and save it to the DB.
-		log.Debug("Imported book saved, now parsing", zap.String("path", finalBookPath))
-		if err := h.store.ParseAndSaveBookMeta(finalBookPath, userID, tagsToAdd); err != nil {
-			log.Error("Failed to parse and save metadata for imported book", zap.String("path", finalBookPath), zap.Error(err))
-			// Optional: Clean up the saved book file on error
-			os.RemoveAll(finalBookDir)
-		}
+	job, err := h.store.AddJob(model.Job{
+		UserID: uid,
+		Type:   model.JobTypeCalibre,
+		Status: model.JobStatusPending,
+		Stage:  model.JobStageImport,
+		Payload: model.JobPayload{
+			Dir:      library,
+			FileName: filepath.Base(library),
+			OwnerID:  req.UserID,
+		},
+	})
+	if err != nil {
+		response.ServerError(w, r, err)
+		return
 	}
+	go worker.ImportCalibre(h.store, *job)
 
-	log.Info("Finished processing archive", zap.String("archive", archivePath))
+	log.Info("Calibre import job accepted", zap.Int("uid", uid), zap.String("library", library), zap.Int("owner", req.UserID))
+	response.Accepted(w, r, job)
 }
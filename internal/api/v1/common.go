@@ -2,10 +2,17 @@ package v1
 
 import (
 	"net/http"
+	"path/filepath"
+	"time"
 
 	"github.com/Xunop/e-oasis/internal/http/request"
+	"github.com/Xunop/e-oasis/internal/http/response"
+	"github.com/Xunop/e-oasis/internal/log"
 	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/storage"
 	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
 )
 
 func getCurrentUser(r *http.Request, s *store.Store) (*model.User, error) {
@@ -24,3 +31,40 @@ func getCurrentUser(r *http.Request, s *store.Store) (*model.User, error) {
 	}
 	return user, nil
 }
+
+// presignExpiry is how long the links the downloads are redirected to work
+const presignExpiry = 15 * time.Minute
+
+// serveStored serves the file at path of the data directory from the
+// storage. A download is redirected to the storage when it can sign a link.
+// The files out of the data directory, like the books of an imported
+// calibre library, are served from the disk.
+func serveStored(w http.ResponseWriter, r *http.Request, path string, download bool) {
+	key, ok := storage.Key(path)
+	if !ok {
+		http.ServeFile(w, r, path)
+		return
+	}
+
+	info, err := storage.Default.Stat(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		log.Warn("File not found in the storage", zap.String("key", key))
+		response.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Error("Failed to stat stored file", zap.String("key", key), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	if download {
+		if url, err := storage.Default.PresignGet(r.Context(), key, presignExpiry); err == nil {
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		}
+	}
+
+	reader := storage.NewReader(r.Context(), storage.Default, key, info.Size)
+	defer reader.Close()
+	http.ServeContent(w, r, filepath.Base(path), info.ModTime, reader)
+}
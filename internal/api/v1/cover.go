@@ -0,0 +1,187 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/http/request"
+	"github.com/Xunop/e-oasis/internal/http/response"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/static"
+	"github.com/Xunop/e-oasis/internal/worker"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// getCover serves the cover of a book, the size query parameter picks the
+// thumbnail, medium or full cover, the full one by default.
+func (h *Handler) getCover(w http.ResponseWriter, r *http.Request) {
+	bookID := request.RouteIntParam(r, "bookID")
+	size := request.QueryStringParam(r, "size", worker.CoverFull)
+	if !worker.ValidCoverSize(size) {
+		response.BadRequest(w, r, errors.Errorf("invalid cover size: %s", size))
+		return
+	}
+
+	book, err := h.store.GetBook(&model.FindBook{BookID: &bookID})
+	if err != nil {
+		log.Error("Failed to get book", zap.Int("book_id", bookID), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+
+	if !book.HasCover {
+		log.Debug("Use default cover", zap.Int("book_id", bookID))
+		serveDefaultCover(w, r)
+		return
+	}
+	cover, err := worker.EnsureCover(book.Path, size)
+	if err != nil {
+		log.Error("Failed to get cover", zap.Int("book_id", bookID), zap.String("size", size), zap.Error(err))
+		serveDefaultCover(w, r)
+		return
+	}
+	serveStored(w, r, cover, false)
+}
+
+func serveDefaultCover(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/webp")
+	http.ServeContent(w, r, "default_cover.webp", time.Time{}, bytes.NewReader(static.DefaultCover))
+}
+
+// accessibleBook returns the book of the route and the user, it writes the
+// error response when the user can't access the book.
+func (h *Handler) accessibleBook(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	bookID := request.RouteIntParam(r, "id")
+	userID, err := strconv.Atoi(request.GetUserID(r))
+	if err != nil {
+		log.Error("Failed to get user ID", zap.Error(err))
+		response.BadRequest(w, r, err)
+		return 0, 0, false
+	}
+
+	if ok, err := h.canAccessBook(r, bookID, userID); err != nil {
+		log.Error("Failed to get book", zap.Int("book_id", bookID), zap.Error(err))
+		response.ServerError(w, r, err)
+		return 0, 0, false
+	} else if !ok {
+		response.NotFound(w, r)
+		return 0, 0, false
+	}
+	return bookID, userID, true
+}
+
+// respondBookDetail writes the book after its cover changed
+func (h *Handler) respondBookDetail(w http.ResponseWriter, r *http.Request, bookID, userID int) {
+	meta, err := h.store.GetBookMeta(bookID)
+	if err != nil || meta == nil {
+		log.Error("Failed to get book metadata", zap.Int("book_id", bookID), zap.Error(err))
+		response.ServerError(w, r, errors.Errorf("failed to get book %d", bookID))
+		return
+	}
+	response.OK(w, r, h.bookDetail(meta, userID))
+}
+
+// uploadCover replaces the cover of a book with the image of the "file"
+// form field.
+func (h *Handler) uploadCover(w http.ResponseWriter, r *http.Request) {
+	bookID, userID, ok := h.accessibleBook(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseMultipartForm(config.Opts.MaxUploadSize << 20); err != nil {
+		log.Error("Max upload size exceeded", zap.Error(err))
+		response.BadRequest(w, r, err)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		response.BadRequest(w, r, errors.New("missing cover file"))
+		return
+	}
+	defer file.Close()
+
+	tmp, err := os.CreateTemp("", "e-oasis-cover-*"+filepath.Ext(header.Filename))
+	if err != nil {
+		response.ServerError(w, r, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, file)
+	tmp.Close()
+	if err != nil {
+		log.Error("Failed to save cover", zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+
+	if err := worker.SetBookCover(h.store, bookID, tmp.Name()); err != nil {
+		// The image is what the user sent, most failures are a bad image
+		log.Warn("Failed to set cover", zap.Int("book_id", bookID), zap.Error(err))
+		response.BadRequest(w, r, err)
+		return
+	}
+
+	log.Info("Book cover uploaded", zap.Int("book_id", bookID), zap.Int("uid", userID))
+	h.respondBookDetail(w, r, bookID, userID)
+}
+
+// listBookImages lists the images of a book that can be picked as cover
+func (h *Handler) listBookImages(w http.ResponseWriter, r *http.Request) {
+	bookID, _, ok := h.accessibleBook(w, r)
+	if !ok {
+		return
+	}
+
+	book, err := h.store.GetBook(&model.FindBook{BookID: &bookID})
+	if err != nil {
+		log.Error("Failed to get book", zap.Int("book_id", bookID), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	images, err := worker.BookImages(book.Path)
+	if err != nil {
+		log.Error("Failed to list book images", zap.Int("book_id", bookID), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	response.OK(w, r, images)
+}
+
+type extractCoverRequest struct {
+	// Image is one of the images of the book, the cover the book points at
+	// when empty.
+	Image string `json:"image"`
+}
+
+// extractCover sets the cover of a book from the book file again
+func (h *Handler) extractCover(w http.ResponseWriter, r *http.Request) {
+	bookID, userID, ok := h.accessibleBook(w, r)
+	if !ok {
+		return
+	}
+
+	var req extractCoverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		response.BadRequest(w, r, errors.New("invalid request body"))
+		return
+	}
+
+	if err := worker.ExtractBookCover(h.store, bookID, req.Image); err != nil {
+		log.Warn("Failed to extract cover", zap.Int("book_id", bookID), zap.String("image", req.Image), zap.Error(err))
+		response.BadRequest(w, r, err)
+		return
+	}
+
+	log.Info("Book cover extracted", zap.Int("book_id", bookID), zap.String("image", req.Image), zap.Int("uid", userID))
+	h.respondBookDetail(w, r, bookID, userID)
+}
@@ -0,0 +1,39 @@
+package v1
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/Xunop/e-oasis/internal/http/request"
+	"github.com/Xunop/e-oasis/internal/http/response"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/worker"
+)
+
+// checkLibrary reports the inconsistencies of the library, for the admins
+func (h *Handler) checkLibrary(w http.ResponseWriter, r *http.Request) {
+	h.runDoctor(w, r, false)
+}
+
+// fixLibrary repairs the inconsistencies of the library, for the admins
+func (h *Handler) fixLibrary(w http.ResponseWriter, r *http.Request) {
+	h.runDoctor(w, r, true)
+}
+
+func (h *Handler) runDoctor(w http.ResponseWriter, r *http.Request, fix bool) {
+	if !isAdmin(r) {
+		log.Error("Unauthorized request by", zap.String("role", request.GetUserRole(r).String()),
+			zap.String("username", request.GetUsername(r)))
+		response.Unauthorized(w, r)
+		return
+	}
+
+	report, err := worker.RunDoctor(r.Context(), h.store, fix)
+	if err != nil {
+		log.Error("Failed to check library", zap.Bool("fix", fix), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	response.OK(w, r, report)
+}
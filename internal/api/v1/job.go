@@ -0,0 +1,173 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Xunop/e-oasis/internal/http/request"
+	"github.com/Xunop/e-oasis/internal/http/response"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/worker"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// keepAliveInterval is how often an idle event stream gets a comment, so
+// that proxies don't close it.
+const keepAliveInterval = 30 * time.Second
+
+// listJobs lists the jobs of the user, the status query parameter filters
+// them by status.
+func (h *Handler) listJobs(w http.ResponseWriter, r *http.Request) {
+	uid, err := strconv.Atoi(request.GetUserID(r))
+	if err != nil {
+		log.Error("Failed to get user ID", zap.Error(err))
+		response.BadRequest(w, r, err)
+		return
+	}
+
+	find := &model.FindJob{UserID: &uid}
+	if status := request.QueryStringParam(r, "status", ""); status != "" {
+		find.Statuses = []string{status}
+	}
+	jobs, err := h.store.ListJobs(find)
+	if err != nil {
+		log.Error("Failed to list jobs", zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	response.OK(w, r, jobs)
+}
+
+func (h *Handler) getJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.userJob(w, r)
+	if !ok {
+		return
+	}
+	response.OK(w, r, job)
+}
+
+// cancelJob cancels a job of the user that isn't finished, the worker
+// running it removes its files.
+func (h *Handler) cancelJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.userJob(w, r)
+	if !ok {
+		return
+	}
+
+	canceled, err := worker.CancelJob(h.store, job.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		response.Conflict(w, r, fmt.Errorf("job %d is already %s", job.ID, job.Status))
+		return
+	}
+	if err != nil {
+		log.Error("Failed to cancel job", zap.Int("job_id", job.ID), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	response.OK(w, r, canceled)
+}
+
+// userJob returns the job of the route, it writes the error response when
+// the job isn't one of the user's.
+func (h *Handler) userJob(w http.ResponseWriter, r *http.Request) (*model.Job, bool) {
+	uid, err := strconv.Atoi(request.GetUserID(r))
+	if err != nil {
+		log.Error("Failed to get user ID", zap.Error(err))
+		response.BadRequest(w, r, err)
+		return nil, false
+	}
+
+	job, err := h.store.GetJob(request.RouteIntParam(r, "id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		response.NotFound(w, r)
+		return nil, false
+	}
+	if err != nil {
+		log.Error("Failed to get job", zap.Error(err))
+		response.ServerError(w, r, err)
+		return nil, false
+	}
+	if job.UserID != uid {
+		response.NotFound(w, r)
+		return nil, false
+	}
+	return job, true
+}
+
+// jobEvents streams the progress of the jobs of the user as server-sent
+// events. The unfinished jobs are sent first, then every change as it
+// happens: the bytes uploaded, the stage, the book saved, the duplicate
+// found, the error and the outcome of every book of an archive.
+func (h *Handler) jobEvents(w http.ResponseWriter, r *http.Request) {
+	uid, err := strconv.Atoi(request.GetUserID(r))
+	if err != nil {
+		log.Error("Failed to get user ID", zap.Error(err))
+		response.BadRequest(w, r, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		response.ServerError(w, r, errors.New("streaming is not supported"))
+		return
+	}
+
+	// Subscribe before reading the jobs so that no change is missed
+	events, unsubscribe := worker.Events.Subscribe(uid)
+	defer unsubscribe()
+
+	jobs, err := h.store.ListJobs(&model.FindJob{
+		UserID:   &uid,
+		Statuses: []string{model.JobStatusPending, model.JobStatusRunning},
+	})
+	if err != nil {
+		log.Error("Failed to list jobs", zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, job := range jobs {
+		if err := writeEvent(w, job.Event()); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event *model.JobEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: job\ndata: %s\n\n", data)
+	return err
+}
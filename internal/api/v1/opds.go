@@ -21,7 +21,7 @@ import (
 type OpdsEntry struct {
 	ID       string
 	Title    string
-	Author   string
+	Authors  []string
 	Content  string
 	Updated  time.Time
 	IsNav    bool // True if this is a link to another feed
@@ -30,6 +30,9 @@ type OpdsEntry struct {
 	MimeType string
 	CoverURL string
 	HasCover bool
+
+	// Contributors are the editors, translators and illustrators
+	Contributors []string
 }
 
 // OpdsTemplateData now holds entries that can be navigation or acquisition.
@@ -76,7 +79,11 @@ func (h *Handler) opdsRootFeed(w http.ResponseWriter, r *http.Request) {
 // OpdsAllBooksFeed handles the flat list of all books.
 func (h *Handler) opdsAllBooksFeed(w http.ResponseWriter, r *http.Request) {
 	// Fetch all books from the store
-	books, err := h.store.ListBooks(&model.FindBook{})
+	trashed := false
+	books, err := h.store.ListBooks(&model.FindBook{Trashed: &trashed})
+	if err == nil {
+		books, err = h.hideUserTrash(r, books)
+	}
 	if err != nil {
 		log.Logger.Error("failed to list books for OPDS 'all' feed", zap.Error(err))
 		response.ServerError(w, r, err)
@@ -125,6 +132,9 @@ func (h *Handler) opdsBooksByTagFeed(w http.ResponseWriter, r *http.Request) {
 	tagID, _ := strconv.Atoi(vars["id"])
 
 	books, err := h.store.ListBooksByTag(tagID)
+	if err == nil {
+		books, err = h.hideUserTrash(r, books)
+	}
 	if err != nil {
 		log.Logger.Error("failed to list books tags", zap.Error(err))
 		return
@@ -149,16 +159,30 @@ func (h *Handler) serveAcquisitionFeed(w http.ResponseWriter, r *http.Request, t
 			mimeType = "application/octet-stream"
 		}
 
+		var authors, contributors []string
+		for _, author := range book.Authors {
+			if author.IsPrimary() {
+				authors = append(authors, author.Name)
+			} else {
+				contributors = append(contributors, author.Name)
+			}
+		}
+		// Books imported before the authors were listed only have the sort
+		if len(authors) == 0 {
+			authors = []string{book.AuthorSort}
+		}
+
 		entries[i] = &OpdsEntry{
-			ID:       fmt.Sprintf("urn:uuid:%s", book.UUID),
-			Title:    book.Title,
-			Author:   book.AuthorSort,
-			Updated:  lastModifiedTime,
-			IsNav:    false, // This is an acquisition feed, so IsNav is always false.
-			AcqURL:   fmt.Sprintf("%s/opds/download/%d", baseURL, book.ID),
-			MimeType: mimeType,
-			HasCover: book.HasCover,
-			CoverURL: fmt.Sprintf("%s/api/v1/covers/%d", baseURL, book.ID),
+			ID:           fmt.Sprintf("urn:uuid:%s", book.UUID),
+			Title:        book.Title,
+			Authors:      authors,
+			Contributors: contributors,
+			Updated:      lastModifiedTime,
+			IsNav:        false, // This is an acquisition feed, so IsNav is always false.
+			AcqURL:       fmt.Sprintf("%s/opds/download/%d", baseURL, book.ID),
+			MimeType:     mimeType,
+			HasCover:     book.HasCover,
+			CoverURL:     fmt.Sprintf("%s/api/v1/covers/%d", baseURL, book.ID),
 		}
 	}
 
@@ -209,12 +233,42 @@ func (h *Handler) downloadBook(w http.ResponseWriter, r *http.Request) {
 		response.NotFound(w, r)
 		return
 	}
+	// The books of the trash are hidden
+	trashed, err := h.store.IsBookTrashed(bookID, opdsUserID(r))
+	if err != nil {
+		log.Logger.Error("failed to check book trash", zap.Int("bookID", bookID), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	if trashed {
+		response.NotFound(w, r)
+		return
+	}
 
 	// Set header to force download with the original filename
 	originalFilename := filepath.Base(book.Path)
 	w.Header().Set("Content-Disposition", "attachment; filename=\""+originalFilename+"\"")
 
-	http.ServeFile(w, r, book.Path)
+	serveStored(w, r, book.Path, true)
+}
+
+// opdsUserID returns the user reading the feed, nil when it is not known
+func opdsUserID(r *http.Request) *int {
+	uid, err := strconv.Atoi(request.GetUserID(r))
+	if err != nil || uid == 0 {
+		return nil
+	}
+	return &uid
+}
+
+// hideUserTrash removes the books the user reading the feed moved to their
+// trash, the feeds list the whole library
+func (h *Handler) hideUserTrash(r *http.Request, books []*model.Book) ([]*model.Book, error) {
+	uid := opdsUserID(r)
+	if uid == nil {
+		return books, nil
+	}
+	return h.store.HideTrashedBooks(books, *uid)
 }
 
 // getBaseURL determines the base URL for generating links.
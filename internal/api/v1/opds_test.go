@@ -0,0 +1,139 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	epub "github.com/go-shiori/go-epub"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/Xunop/e-oasis/internal/store/db"
+	"github.com/Xunop/e-oasis/internal/worker"
+)
+
+// TestMain runs the tests from the directory the OPDS template is read
+// from, and writes their logs to a temporary directory
+func TestMain(m *testing.M) {
+	if err := os.Chdir(filepath.Join("..", "..")); err != nil {
+		panic(err)
+	}
+	config.Opts = config.GetDefaultOptions()
+	dir, err := os.MkdirTemp("", "e-oasis-api-test")
+	if err != nil {
+		panic(err)
+	}
+	config.Opts.LogFile = filepath.Join(dir, "logs.log")
+	log.Logger = log.NewLogger()
+
+	code := m.Run()
+	log.Logger.Sync()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	dir := t.TempDir()
+	config.Opts.Data = dir
+	config.Opts.DSN = filepath.Join(dir, "e-oasis.db")
+	config.Opts.MetaDSN = filepath.Join(dir, "metadata.db")
+	open := func(path, name string) *db.DB {
+		d, err := db.NewDB(path, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { d.Close() })
+		if err := d.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	return store.NewStore(open(config.Opts.DSN, "system").DB, open(config.Opts.MetaDSN, "meta").DB)
+}
+
+func TestOpdsTrash(t *testing.T) {
+	s := newTestStore(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.CreateUser(&model.User{Username: "reader", PasswordHash: string(hash), Role: model.RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := int(user.ID)
+
+	dir := t.TempDir()
+	for _, title := range []string{"Kept", "Trashed"} {
+		b, err := epub.NewEpub(title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Write(filepath.Join(dir, title+".epub")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job, err := s.AddJob(model.Job{UserID: uid, Type: model.JobTypeArchive, Stage: model.JobStageImport, Payload: model.JobPayload{Dir: dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker.ImportArchive(s, *job)
+	if job, err = s.GetJob(job.ID); err != nil || job.Payload.Summary.Imported != 2 {
+		t.Fatalf("job = %+v, %v", job, err)
+	}
+	books := map[string]int{}
+	for _, item := range job.Payload.Items {
+		books[strings.TrimSuffix(item.Name, ".epub")] = item.BookID
+	}
+	if _, err := s.TrashBook(books["Trashed"], &uid); err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	Server(router, NewHandler(s, nil, nil))
+	get := func(path, username, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The readers are asked for their credentials
+	w := get("/opds/all", "", "")
+	if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic") {
+		t.Fatalf("anonymous feed = %d, WWW-Authenticate %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	if w := get("/opds/all", "reader", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("feed with a wrong password = %d", w.Code)
+	}
+
+	w = get("/opds/all", "reader", "secret")
+	body, _ := io.ReadAll(w.Body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("feed = %d, %s", w.Code, body)
+	}
+	if !strings.Contains(string(body), "Kept") || strings.Contains(string(body), "Trashed") {
+		t.Errorf("the feed should list Kept only:\n%s", body)
+	}
+	if w := get(fmt.Sprintf("/opds/download/%d", books["Trashed"]), "reader", "secret"); w.Code != http.StatusNotFound {
+		t.Errorf("download of the trashed book = %d, want 404", w.Code)
+	}
+	if w := get(fmt.Sprintf("/opds/download/%d", books["Kept"]), "reader", "secret"); w.Code != http.StatusOK {
+		t.Errorf("download of the kept book = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,141 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/Xunop/e-oasis/internal/http/request"
+	"github.com/Xunop/e-oasis/internal/http/response"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/worker"
+)
+
+// findTrash returns what of the trash the request works on: the users only
+// see their own, the admins see every trash or the one of ?user_id.
+func findTrash(r *http.Request) (*model.FindTrashedBook, error) {
+	find := &model.FindTrashedBook{}
+	if isAdmin(r) {
+		if v := r.URL.Query().Get("user_id"); v != "" {
+			userID, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid user_id")
+			}
+			find.UserID = &userID
+		}
+		return find, nil
+	}
+	userID, err := strconv.Atoi(request.GetUserID(r))
+	if err != nil {
+		return nil, err
+	}
+	find.UserID = &userID
+	return find, nil
+}
+
+// listTrash returns the books of the trash
+func (h *Handler) listTrash(w http.ResponseWriter, r *http.Request) {
+	find, err := findTrash(r)
+	if err != nil {
+		response.BadRequest(w, r, err)
+		return
+	}
+	list, err := worker.ListTrash(h.store, find)
+	if err != nil {
+		log.Error("Failed to list trash", zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	response.OK(w, r, list)
+}
+
+// restoreBook takes a book out of the trash
+func (h *Handler) restoreBook(w http.ResponseWriter, r *http.Request) {
+	bookID := request.RouteIntParam(r, "id")
+	find, err := findTrash(r)
+	if err != nil {
+		response.BadRequest(w, r, err)
+		return
+	}
+	n, err := h.store.RestoreBook(bookID, find.UserID)
+	if err != nil {
+		log.Error("Failed to restore book", zap.Int("bookID", bookID), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	if n == 0 {
+		response.NotFound(w, r)
+		return
+	}
+	response.NoContent(w, r)
+}
+
+// purgeBook removes a book of the trash for good
+func (h *Handler) purgeBook(w http.ResponseWriter, r *http.Request) {
+	bookID := request.RouteIntParam(r, "id")
+	find, err := findTrash(r)
+	if err != nil {
+		response.BadRequest(w, r, err)
+		return
+	}
+	find.BookID = &bookID
+	n, err := worker.PurgeTrash(h.store, find)
+	if err != nil {
+		log.Error("Failed to purge book", zap.Int("bookID", bookID), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	if n == 0 {
+		response.NotFound(w, r)
+		return
+	}
+	response.NoContent(w, r)
+}
+
+// emptyTrash purges every book of the trash
+func (h *Handler) emptyTrash(w http.ResponseWriter, r *http.Request) {
+	find, err := findTrash(r)
+	if err != nil {
+		response.BadRequest(w, r, err)
+		return
+	}
+	if _, err := worker.PurgeTrash(h.store, find); err != nil {
+		log.Error("Failed to empty trash", zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	response.NoContent(w, r)
+}
+
+// SetTrashSettings sets how long the trash keeps the books
+func (h *Handler) SetTrashSettings(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		log.Error("Unauthorized request by", zap.String("role", request.GetUserRole(r).String()),
+			zap.String("username", request.GetUsername(r)))
+		response.Unauthorized(w, r)
+		return
+	}
+
+	var settings model.SystemSettingTrash
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		log.Error("Failed to decode request body", zap.Error(err))
+		response.BadRequest(w, r, err)
+		return
+	}
+	if settings.RetentionDays < 0 {
+		response.BadRequest(w, r, errors.New("the retention can't be negative"))
+		return
+	}
+
+	newSettings, err := h.store.UpsetTrashSettings(&settings)
+	if err != nil {
+		log.Error("Failed to set trash settings", zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	response.OK(w, r, newSettings)
+}
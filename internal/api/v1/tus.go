@@ -0,0 +1,235 @@
+package v1
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Xunop/e-oasis/internal/http/request"
+	"github.com/Xunop/e-oasis/internal/http/response"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/worker"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// The resumable uploads follow the tus protocol, see https://tus.io/protocols/resumable-upload
+const (
+	tusExtensions = "creation,expiration,checksum"
+	// statusChecksumMismatch is the status of a chunk not matching its checksum
+	statusChecksumMismatch = 460
+)
+
+// tusOptions tells the clients what the server supports
+func (h *Handler) tusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", worker.TusVersion)
+	w.Header().Set("Tus-Version", worker.TusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Checksum-Algorithm", worker.TusChecksumAlgorithms)
+	if limit := worker.UploadLimit(); limit > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(limit, 10))
+	}
+	response.NoContent(w, r)
+}
+
+// createUpload starts a resumable upload, the client then sends the file to
+// the Location of the upload.
+func (h *Handler) createUpload(w http.ResponseWriter, r *http.Request) {
+	uid, ok := tusRequest(w, r)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		response.BadRequest(w, r, errors.New("invalid Upload-Length"))
+		return
+	}
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		response.BadRequest(w, r, err)
+		return
+	}
+
+	if err := worker.CheckQuota(h.store, uid, 1, length); err != nil {
+		uploadError(w, r, err)
+		return
+	}
+	upload, err := worker.CreateTusUpload(uid, length, metadata)
+	if err != nil {
+		uploadError(w, r, err)
+		return
+	}
+	log.Debug("Upload created", zap.Int("user_id", uid), zap.String("upload_id", upload.ID), zap.Int64("length", length))
+
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+upload.ID)
+	w.Header().Set("Upload-Expires", upload.Expires().UTC().Format(http.TimeFormat))
+	// An empty file is complete right away
+	if upload.Complete() {
+		h.completeUpload(w, r, upload)
+		return
+	}
+	response.Created(w, r, upload)
+}
+
+// headUpload tells the client where to resume the upload
+func (h *Handler) headUpload(w http.ResponseWriter, r *http.Request) {
+	uid, ok := tusRequest(w, r)
+	if !ok {
+		return
+	}
+	upload, ok := getUpload(w, r, uid)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.Expires().UTC().Format(http.TimeFormat))
+	if len(upload.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", formatUploadMetadata(upload.Metadata))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// patchUpload appends a chunk to the upload, the completed upload is handed
+// to the job pipeline and the Job-ID header tells its job.
+func (h *Handler) patchUpload(w http.ResponseWriter, r *http.Request) {
+	uid, ok := tusRequest(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		response.Error(w, r, http.StatusUnsupportedMediaType, errors.New("Content-Type must be application/offset+octet-stream"))
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		response.BadRequest(w, r, errors.New("invalid Upload-Offset"))
+		return
+	}
+	upload, ok := getUpload(w, r, uid)
+	if !ok {
+		return
+	}
+
+	err = upload.WriteChunk(offset, r.Body, r.Header.Get("Upload-Checksum"))
+	switch {
+	case errors.Is(err, worker.ErrUploadOffset), errors.Is(err, worker.ErrUploadLocked):
+		response.Conflict(w, r, err)
+		return
+	case errors.Is(err, worker.ErrChecksumMismatch):
+		response.Error(w, r, statusChecksumMismatch, err)
+		return
+	case errors.Is(err, worker.ErrChecksumAlgorithm):
+		response.BadRequest(w, r, err)
+		return
+	case err != nil:
+		// The client resumes from what was received
+		log.Warn("Upload interrupted", zap.String("upload_id", upload.ID), zap.Int64("offset", upload.Offset), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.Expires().UTC().Format(http.TimeFormat))
+	if upload.Complete() {
+		h.completeUpload(w, r, upload)
+		return
+	}
+	response.NoContent(w, r)
+}
+
+// completeUpload hands a complete upload over to the upload job pipeline
+func (h *Handler) completeUpload(w http.ResponseWriter, r *http.Request, upload *worker.TusUpload) {
+	payload, err := upload.Stage()
+	if err != nil {
+		uploadError(w, r, err)
+		return
+	}
+	job, err := h.addUploadJob(upload.UserID, model.JobTypeBatch, payload)
+	if err != nil {
+		log.Error("Failed to add job", zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	go h.uploadPool.Push(*job)
+
+	log.Info("Upload completed", zap.String("upload_id", upload.ID), zap.Int("job_id", job.ID))
+	w.Header().Set("Job-ID", strconv.Itoa(job.ID))
+	if r.Method == http.MethodPost {
+		response.Created(w, r, job)
+		return
+	}
+	response.NoContent(w, r)
+}
+
+// tusRequest returns the user of a tus request, it writes the error
+// response when the client speaks another version of the protocol.
+func tusRequest(w http.ResponseWriter, r *http.Request) (int, bool) {
+	w.Header().Set("Tus-Resumable", worker.TusVersion)
+	if r.Header.Get("Tus-Resumable") != worker.TusVersion {
+		w.Header().Set("Tus-Version", worker.TusVersion)
+		response.Error(w, r, http.StatusPreconditionFailed, errors.New("unsupported tus version"))
+		return 0, false
+	}
+	uid, err := strconv.Atoi(request.GetUserID(r))
+	if err != nil {
+		log.Error("Failed to get user ID", zap.Error(err))
+		response.BadRequest(w, r, err)
+		return 0, false
+	}
+	return uid, true
+}
+
+// getUpload returns the upload of the route, it writes the error response
+// when the user has no such upload.
+func getUpload(w http.ResponseWriter, r *http.Request, uid int) (*worker.TusUpload, bool) {
+	upload, err := worker.GetTusUpload(uid, request.RouteStringParam(r, "id"))
+	switch {
+	case errors.Is(err, worker.ErrUploadNotFound):
+		response.NotFound(w, r)
+		return nil, false
+	case errors.Is(err, worker.ErrUploadExpired):
+		response.Error(w, r, http.StatusGone, err)
+		return nil, false
+	case err != nil:
+		log.Error("Failed to get upload", zap.Error(err))
+		response.ServerError(w, r, err)
+		return nil, false
+	}
+	return upload, true
+}
+
+// parseUploadMetadata parses the Upload-Metadata header, comma separated
+// keys each followed by its base64 encoded value, if any.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value of %s", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func formatUploadMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
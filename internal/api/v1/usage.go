@@ -0,0 +1,158 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/Xunop/e-oasis/internal/http/request"
+	"github.com/Xunop/e-oasis/internal/http/response"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/worker"
+)
+
+func isAdmin(r *http.Request) bool {
+	return request.GetUserRole(r) == model.RoleHost || request.GetUserRole(r) == model.RoleAdmin
+}
+
+// getUserUsage returns the usage and the quota of a user, the users only
+// see their own
+func (h *Handler) getUserUsage(w http.ResponseWriter, r *http.Request) {
+	userID := request.RouteIntParam(r, "id")
+	if !isAdmin(r) && request.GetUserID(r) != strconv.Itoa(userID) {
+		response.Unauthorized(w, r)
+		return
+	}
+	if !h.userExists(w, r, userID) {
+		return
+	}
+
+	usage, err := worker.GetUsage(h.store, userID)
+	if err != nil {
+		log.Error("Failed to get user usage", zap.Int("userID", userID), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	response.OK(w, r, usage)
+}
+
+// usageReport returns the usage of every user, for the admins
+func (h *Handler) usageReport(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		log.Error("Unauthorized request by", zap.String("role", request.GetUserRole(r).String()),
+			zap.String("username", request.GetUsername(r)))
+		response.Unauthorized(w, r)
+		return
+	}
+
+	users, err := h.store.ListUsers(&model.FindUser{})
+	if err != nil {
+		log.Error("Failed to list users", zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	report := &model.UsageReport{Users: make([]*model.Usage, 0, len(users))}
+	for _, user := range users {
+		usage, err := worker.GetUsage(h.store, int(user.ID))
+		if err != nil {
+			log.Error("Failed to get user usage", zap.Int32("userID", user.ID), zap.Error(err))
+			response.ServerError(w, r, err)
+			return
+		}
+		report.Users = append(report.Users, usage)
+		report.Bytes += usage.Bytes
+		report.Books += usage.Books
+	}
+	if report.StoredBytes, err = h.store.GetStoredBytes(); err != nil {
+		log.Error("Failed to get stored bytes", zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	response.OK(w, r, report)
+}
+
+// SetQuotaSettings sets the default quota of the users
+func (h *Handler) SetQuotaSettings(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		log.Error("Unauthorized request by", zap.String("role", request.GetUserRole(r).String()),
+			zap.String("username", request.GetUsername(r)))
+		response.Unauthorized(w, r)
+		return
+	}
+
+	var settings model.SystemSettingQuota
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		log.Error("Failed to decode request body", zap.Error(err))
+		response.BadRequest(w, r, err)
+		return
+	}
+	if settings.MaxBytes < 0 || settings.MaxBooks < 0 {
+		response.BadRequest(w, r, errors.New("quota limits can't be negative"))
+		return
+	}
+
+	newSettings, err := h.store.UpsetQuotaSettings(&settings)
+	if err != nil {
+		log.Error("Failed to set quota settings", zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	response.OK(w, r, newSettings)
+}
+
+// setUserQuota sets the quota of a user, a missing limit is the default one
+func (h *Handler) setUserQuota(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		log.Error("Unauthorized request by", zap.String("role", request.GetUserRole(r).String()),
+			zap.String("username", request.GetUsername(r)))
+		response.Unauthorized(w, r)
+		return
+	}
+	userID := request.RouteIntParam(r, "id")
+	if !h.userExists(w, r, userID) {
+		return
+	}
+
+	var quota model.QuotaUserSetting
+	if err := json.NewDecoder(r.Body).Decode(&quota); err != nil {
+		log.Error("Failed to decode request body", zap.Error(err))
+		response.BadRequest(w, r, err)
+		return
+	}
+	if (quota.MaxBytes != nil && *quota.MaxBytes < 0) || (quota.MaxBooks != nil && *quota.MaxBooks < 0) {
+		response.BadRequest(w, r, errors.New("quota limits can't be negative"))
+		return
+	}
+
+	if _, err := h.store.UpsetUserQuotaSetting(int32(userID), &quota); err != nil {
+		log.Error("Failed to set user quota", zap.Int("userID", userID), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	usage, err := worker.GetUsage(h.store, userID)
+	if err != nil {
+		response.ServerError(w, r, err)
+		return
+	}
+	response.OK(w, r, usage)
+}
+
+// userExists responds with not found when there is no user userID
+func (h *Handler) userExists(w http.ResponseWriter, r *http.Request, userID int) bool {
+	id := int32(userID)
+	user, err := h.store.GetUser(&model.FindUser{ID: &id})
+	if err != nil {
+		log.Error("Failed to get user", zap.Int("userID", userID), zap.Error(err))
+		response.ServerError(w, r, err)
+		return false
+	}
+	if user == nil {
+		response.NotFound(w, r)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package v1
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Xunop/e-oasis/internal/http/request"
+	"github.com/Xunop/e-oasis/internal/http/response"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/worker"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+type addWatchFolderRequest struct {
+	UserID      int    `json:"user_id"`
+	Path        string `json:"path"`
+	AfterImport string `json:"after_import"`
+	ArchiveDir  string `json:"archive_dir"`
+	// MapTags defaults to true
+	MapTags *bool `json:"map_tags"`
+}
+
+// listWatchFolders lists the watched folders, the user_id query parameter
+// filters them by user.
+func (h *Handler) listWatchFolders(w http.ResponseWriter, r *http.Request) {
+	if request.GetUserRole(r) != model.RoleHost && request.GetUserRole(r) != model.RoleAdmin {
+		log.Error("Unauthorized request by", zap.String("role", request.GetUserRole(r).String()))
+		response.Unauthorized(w, r)
+		return
+	}
+
+	find := &model.FindWatchedFolder{}
+	if v := request.QueryStringParam(r, "user_id", ""); v != "" {
+		uid, err := strconv.Atoi(v)
+		if err != nil {
+			response.BadRequest(w, r, errors.New("invalid user_id"))
+			return
+		}
+		find.UserID = &uid
+	}
+	folders, err := h.store.ListWatchedFolders(find)
+	if err != nil {
+		log.Error("Failed to list watched folders", zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	response.OK(w, r, folders)
+}
+
+// addWatchFolder watches a directory of the server, the books dropped into
+// it are imported into the library of the user.
+func (h *Handler) addWatchFolder(w http.ResponseWriter, r *http.Request) {
+	if request.GetUserRole(r) != model.RoleHost && request.GetUserRole(r) != model.RoleAdmin {
+		log.Error("Unauthorized request by", zap.String("role", request.GetUserRole(r).String()))
+		response.Unauthorized(w, r)
+		return
+	}
+
+	var req addWatchFolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, r, errors.New("invalid request body"))
+		return
+	}
+	folder, err := h.watchFolderFromRequest(r, &req)
+	if err != nil {
+		response.BadRequest(w, r, err)
+		return
+	}
+
+	folder, err = h.store.AddWatchedFolder(folder)
+	if err != nil {
+		log.Error("Failed to add watched folder", zap.String("path", req.Path), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	if err := worker.Folders.Watch(folder); err != nil {
+		log.Error("Failed to watch folder", zap.String("path", folder.Path), zap.Error(err))
+		h.store.DeleteWatchedFolder(folder.ID)
+		response.BadRequest(w, r, err)
+		return
+	}
+	response.Created(w, r, folder)
+}
+
+// deleteWatchFolder stops watching a folder, its files are left alone
+func (h *Handler) deleteWatchFolder(w http.ResponseWriter, r *http.Request) {
+	if request.GetUserRole(r) != model.RoleHost && request.GetUserRole(r) != model.RoleAdmin {
+		log.Error("Unauthorized request by", zap.String("role", request.GetUserRole(r).String()))
+		response.Unauthorized(w, r)
+		return
+	}
+
+	id := request.RouteIntParam(r, "id")
+	if _, err := h.store.GetWatchedFolder(id); errors.Is(err, sql.ErrNoRows) {
+		response.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Error("Failed to get watched folder", zap.Int("folder_id", id), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+
+	worker.Folders.Unwatch(id)
+	if err := h.store.DeleteWatchedFolder(id); err != nil {
+		log.Error("Failed to delete watched folder", zap.Int("folder_id", id), zap.Error(err))
+		response.ServerError(w, r, err)
+		return
+	}
+	response.NoContent(w, r)
+}
+
+// watchFolderFromRequest checks the folder asked for, the folder is for the
+// admin when no user is given.
+func (h *Handler) watchFolderFromRequest(r *http.Request, req *addWatchFolderRequest) (*model.WatchedFolder, error) {
+	if req.UserID == 0 {
+		req.UserID, _ = strconv.Atoi(request.GetUserID(r))
+	}
+	uid := int32(req.UserID)
+	if user, err := h.store.GetUser(&model.FindUser{ID: &uid}); err != nil || user == nil {
+		return nil, fmt.Errorf("user %d not found", req.UserID)
+	}
+
+	if !filepath.IsAbs(req.Path) {
+		return nil, errors.New("path must be absolute")
+	}
+	path := filepath.Clean(req.Path)
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", path)
+	}
+
+	folder := &model.WatchedFolder{
+		UserID:      req.UserID,
+		Path:        path,
+		AfterImport: req.AfterImport,
+		MapTags:     req.MapTags == nil || *req.MapTags,
+	}
+	switch req.AfterImport {
+	case "":
+		folder.AfterImport = model.AfterImportKeep
+	case model.AfterImportKeep, model.AfterImportDelete:
+	case model.AfterImportArchive:
+		// Hidden directories of the folder aren't watched
+		folder.ArchiveDir = filepath.Join(path, ".imported")
+		if req.ArchiveDir != "" {
+			if !filepath.IsAbs(req.ArchiveDir) {
+				return nil, errors.New("archive_dir must be absolute")
+			}
+			folder.ArchiveDir = filepath.Clean(req.ArchiveDir)
+		}
+	default:
+		return nil, fmt.Errorf("after_import must be %s, %s or %s",
+			model.AfterImportKeep, model.AfterImportArchive, model.AfterImportDelete)
+	}
+	return folder, nil
+}
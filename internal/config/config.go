@@ -8,6 +8,7 @@ import (
 	"slices"
 	"strings"
 
+	"github.com/Xunop/e-oasis/internal/util/parsers"
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 )
@@ -105,11 +106,16 @@ func ParseFile(file string) (*Options, error) {
 	return Opts, nil
 }
 
-// CheckSupportedTypes checks if the file type is supported
+// CheckSupportedTypes checks if the file type, an extension or a MIME type,
+// belongs to a registered book format. SupportedTypes narrows the formats
+// down when it is configured.
 func CheckSupportedTypes(fileType string) bool {
-	if len(Opts.SupportedTypes) == 0 {
+	if parsers.Lookup(fileType) == nil {
 		return false
 	}
+	if len(Opts.SupportedTypes) == 0 {
+		return true
+	}
 
-	return slices.Contains(Opts.SupportedTypes, fileType)
+	return slices.Contains(Opts.SupportedTypes, strings.TrimPrefix(fileType, "."))
 }
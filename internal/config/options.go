@@ -19,7 +19,10 @@ const (
 	defaultMetricsPassword        = ""
 	defaultWorkerPoolSize         = 10
 	defaultMaxUploadSize          = 100
-	defaultSupportedTypes         = "application/zip"
+	defaultUploadTimeout          = 120
+	defaultStorageBackend         = "local"
+	defaultS3Region               = "us-east-1"
+	defaultS3PathStyle            = true
 )
 
 type Option struct {
@@ -53,10 +56,38 @@ type Options struct {
 	// data is the directory to store data
 	Data           string `mapstructure:"data"`
 	WorkerPoolSize int    `mapstructure:"worker_pool_size"`
-	// MaxUploadSize is the maximum size of the upload, in MiB
+	// MaxUploadSize is the maximum size of an uploaded file, in MiB. There is
+	// no limit when it is 0.
 	MaxUploadSize int64 `mapstructure:"max_upload_size"`
-	// SupportedTypes is the supported types of books
+	// UploadTimeout is how long a single upload waits for its book to be
+	// parsed, in seconds. The job goes on when it's over, the client gets the
+	// job to follow instead of the book.
+	UploadTimeout int `mapstructure:"upload_timeout"`
+	// BookPathTemplate lays the book directories out under <data>/<uid>/books,
+	// e.g. "{author}/{title} ({id})" like calibre. {author}, {title} and {id}
+	// are replaced, the directory is named after the book file when it's empty.
+	BookPathTemplate string `mapstructure:"book_path_template"`
+	// SupportedTypes restricts the book formats to these extensions or MIME
+	// types, every registered format is supported when it's empty
 	SupportedTypes []string `mapstructure:"supported_types"`
+	// StorageBackend is where the books and their covers are stored, "local"
+	// for the data directory or "s3" for an S3 compatible bucket. With s3,
+	// the book directories of the data directory are a cache of the bucket,
+	// the databases stay in the data directory.
+	StorageBackend string `mapstructure:"storage_backend"`
+	// S3Endpoint is the URL of the S3 service, like http://127.0.0.1:9000
+	S3Endpoint  string `mapstructure:"s3_endpoint"`
+	S3Region    string `mapstructure:"s3_region"`
+	S3Bucket    string `mapstructure:"s3_bucket"`
+	S3AccessKey string `mapstructure:"s3_access_key"`
+	S3SecretKey string `mapstructure:"s3_secret_key"`
+	// S3PathStyle puts the bucket in the path of the URLs instead of the host
+	S3PathStyle bool `mapstructure:"s3_path_style"`
+	// S3Prefix is put before the keys of the objects
+	S3Prefix string `mapstructure:"s3_prefix"`
+	// BackupDir is where the backups are written, <data>/backups when it's
+	// empty
+	BackupDir string `mapstructure:"backup_dir"`
 	// For metrics
 	MetricsCollector       bool     `mapstructure:"metrics_collector"`
 	MetricsRefreshInterval int      `mapstructure:"metrics_refresh_interval"`
@@ -79,7 +110,11 @@ func GetDefaultOptions() *Options {
 		Host:                   defaultHost,
 		Data:                   defaultData,
 		WorkerPoolSize:         defaultWorkerPoolSize,
-		SupportedTypes:         []string{defaultSupportedTypes, "epub"},
+		MaxUploadSize:          defaultMaxUploadSize,
+		UploadTimeout:          defaultUploadTimeout,
+		StorageBackend:         defaultStorageBackend,
+		S3Region:               defaultS3Region,
+		S3PathStyle:            defaultS3PathStyle,
 		MetricsCollector:       defaultMetricsCollector,
 		MetricsRefreshInterval: defaultMetricsRefreshInterval,
 		MetricsAllowedNetworks: []string{defaultMetricsAllowedNetworks},
@@ -26,3 +26,13 @@ func RouteStringParam(r *http.Request, param string) string {
 	vars := mux.Vars(r)
 	return vars[param]
 }
+
+// QueryStringParam returns a query string parameter, defaultValue when it is
+// missing or empty.
+func QueryStringParam(r *http.Request, param, defaultValue string) string {
+	value := r.URL.Query().Get(param)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
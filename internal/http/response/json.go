@@ -37,10 +37,14 @@ func NoContent(w http.ResponseWriter, r *http.Request) {
 	builder.Write()
 }
 
-func Accepted(w http.ResponseWriter, r *http.Request) {
+// Accepted sends an accepted response to the client, body may be nil.
+func Accepted(w http.ResponseWriter, r *http.Request, body interface{}) {
 	builder := New(w, r)
 	builder.WithStatus(http.StatusAccepted)
 	builder.WithHeader("Content-Type", contentTypeHeader)
+	if body != nil {
+		builder.WithBody(toJSON(body))
+	}
 	builder.Write()
 }
 
@@ -80,6 +84,63 @@ func BadRequest(w http.ResponseWriter, r *http.Request, err error) {
 	builder.Write()
 }
 
+// Conflict sends a conflict error to the client, the request doesn't fit the
+// current state of the resource.
+func Conflict(w http.ResponseWriter, r *http.Request, err error) {
+	log.Warn(http.StatusText(http.StatusConflict),
+		zap.Any("error", err),
+		zap.String("client_ip", request.FindClientIP(r)),
+		zap.String("request.method", r.Method),
+		zap.String("request.uri", r.RequestURI),
+		zap.String("request.user_agent", r.UserAgent()),
+		zap.Int("response.status_code", http.StatusConflict),
+	)
+
+	builder := New(w, r)
+	builder.WithStatus(http.StatusConflict)
+	builder.WithHeader("Content-Type", contentTypeHeader)
+	builder.WithBody(toJSONError(err))
+	builder.Write()
+}
+
+// RequestEntityTooLarge sends a request entity too large error to the
+// client, the upload is over the size limit.
+func RequestEntityTooLarge(w http.ResponseWriter, r *http.Request, err error) {
+	log.Warn(http.StatusText(http.StatusRequestEntityTooLarge),
+		zap.Any("error", err),
+		zap.String("client_ip", request.FindClientIP(r)),
+		zap.String("request.method", r.Method),
+		zap.String("request.uri", r.RequestURI),
+		zap.String("request.user_agent", r.UserAgent()),
+		zap.Int("response.status_code", http.StatusRequestEntityTooLarge),
+	)
+
+	builder := New(w, r)
+	builder.WithStatus(http.StatusRequestEntityTooLarge)
+	builder.WithHeader("Content-Type", contentTypeHeader)
+	builder.WithBody(toJSONError(err))
+	builder.Write()
+}
+
+// Error sends an error with the given status code to the client, for the
+// statuses without a helper of their own.
+func Error(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
+	log.Warn(http.StatusText(statusCode),
+		zap.Any("error", err),
+		zap.String("client_ip", request.FindClientIP(r)),
+		zap.String("request.method", r.Method),
+		zap.String("request.uri", r.RequestURI),
+		zap.String("request.user_agent", r.UserAgent()),
+		zap.Int("response.status_code", statusCode),
+	)
+
+	builder := New(w, r)
+	builder.WithStatus(statusCode)
+	builder.WithHeader("Content-Type", contentTypeHeader)
+	builder.WithBody(toJSONError(err))
+	builder.Write()
+}
+
 // Unauthorized sends a not authorized error to the client.
 func Unauthorized(w http.ResponseWriter, r *http.Request) {
 	log.Warn(http.StatusText(http.StatusUnauthorized),
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/gorilla/mux"
 
 	"github.com/Xunop/e-oasis/internal/http/request"
 	"github.com/Xunop/e-oasis/internal/log"
@@ -24,12 +25,20 @@ func NewMiddleware(store *store.Store) *Middleware {
 func (m *Middleware) HandleCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "X-Auth-Token, Authorization, Content-Type, Accept")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, HEAD, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "X-Auth-Token, Authorization, Content-Type, Accept, "+
+			"Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset, Upload-Checksum")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, Tus-Extension, "+
+			"Tus-Max-Size, Tus-Checksum-Algorithm, Upload-Length, Upload-Metadata, Upload-Offset, Upload-Expires, Job-ID")
 		if r.Method == http.MethodOptions {
 			w.Header().Set("Access-Control-Max-Age", "7200")
-			w.WriteHeader(http.StatusOK)
-			return
+			// The routes answering OPTIONS themselves, like the tus discovery,
+			// get the requests which aren't preflights
+			route := mux.CurrentRoute(r)
+			if r.Header.Get("Access-Control-Request-Method") != "" || route == nil || route.GetHandler() == nil {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
 		}
 		// FIXME: json: unsupported type: func() (io.ReadCloser, error)
 		log.Debug("test in cors", zap.Any("r", r))
@@ -1,8 +1,24 @@
 package model
 
+// Roles of the creators of a book, the MARC relator codes used by OPF
+const (
+	AuthorRoleAuthor      = "aut"
+	AuthorRoleEditor      = "edt"
+	AuthorRoleTranslator  = "trl"
+	AuthorRoleIllustrator = "ill"
+)
+
 type Author struct {
-    ID   int    `json:"id"`
-    Name string `json:"name"`
-    Sort string `json:"sort"`
-    Link string `json:"link"`
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Sort string `json:"sort"`
+	Link string `json:"link"`
+	// Role is the role of the author in a book, only set along with a book
+	Role string `json:"role,omitempty"`
+}
+
+// IsPrimary reports whether the author wrote the book, rather than edited,
+// translated or illustrated it.
+func (a *Author) IsPrimary() bool {
+	return a.Role == "" || a.Role == AuthorRoleAuthor
 }
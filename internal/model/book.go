@@ -10,20 +10,22 @@ type Tag struct {
 }
 
 type Book struct {
-	ID           int    `json:"id"`
-	Title        string `json:"title"`
-	SortTitle    string `json:"sort"`
-	TimeStamp    string `json:"timestamp"`
-	PublishDate  string `json:"pubdate"`
-	SeriesIndex  int    `json:"series_index"`
-	AuthorSort   string `json:"author_sort"`
-	ISBN         string `json:"isbn"`
-	LCCN         string `json:"lccn"`
-	Path         string `json:"path"`
-	Flags        int    `json:"flags"`
-	UUID         string `json:"uuid"`
-	HasCover     bool   `json:"has_cover"`
-	LastModified string `json:"last_modified"`
+	ID           int     `json:"id"`
+	Title        string  `json:"title"`
+	SortTitle    string  `json:"sort"`
+	TimeStamp    string  `json:"timestamp"`
+	PublishDate  string  `json:"pubdate"`
+	SeriesIndex  float64 `json:"series_index"`
+	AuthorSort   string  `json:"author_sort"`
+	ISBN         string  `json:"isbn"`
+	LCCN         string  `json:"lccn"`
+	Path         string  `json:"path"`
+	Flags        int     `json:"flags"`
+	UUID         string  `json:"uuid"`
+	HasCover     bool    `json:"has_cover"`
+	LastModified string  `json:"last_modified"`
+	// Authors are the authors then the other creators of the book, in order
+	Authors []*Author `json:"authors,omitempty"`
 }
 
 type FindBook struct {
@@ -41,6 +43,9 @@ type FindBook struct {
 	Random bool `json:"random"`
 	// The maximum number of books to return.
 	Limit *int `json:"limit"`
+	// Trashed only returns the books of the trash when true and hides them
+	// when false, nil returns both.
+	Trashed *bool `json:"trashed"`
 }
 
 type Publisher struct {
@@ -54,11 +59,62 @@ type Language struct {
 	LangCode string `json:"lang_code"`
 }
 
+// BookFormat is a file of a book, a row of the data table
+type BookFormat struct {
+	// Format is the upper case extension, e.g. EPUB
+	Format string `json:"format"`
+	Size   int64  `json:"size"`
+	// Name is the file name without the extension, in the book directory
+	Name string `json:"name"`
+}
+
 type BookMeta struct {
 	Book      *Book      `json:"book"`
 	Publisher *Publisher `json:"publisher"`
-	Language  *Language  `json:"language"`
-	Author    *Author    `json:"author"`
+	// Languages are in the book order, the first is the main language
+	Languages []*Language `json:"languages"`
+	// Authors are the creators of the book in order along with their roles
+	Authors []*Author `json:"authors"`
+	Series  *Series   `json:"series"`
+	// Description is saved as the book comment
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	// Identifiers maps an identifier type (isbn, amazon...) to its value
+	Identifiers map[string]string `json:"identifiers"`
+	// Rating is from 0 to 10 like calibre, 0 is not rated
+	Rating int `json:"rating"`
+	// Formats are the files of the book, when it has more than the one at
+	// Book.Path
+	Formats []*BookFormat `json:"formats,omitempty"`
+}
+
+// BookDetail is a book with all its metadata, as shown to a user
+type BookDetail struct {
+	*BookMeta
+	CoverURL string `json:"cover_url"`
+	// Status is the reading status of the user, nil if never read
+	Status *BookReadingStatusLink `json:"status"`
+}
+
+// BookUpdateRequest holds the metadata to change on a book, the fields left
+// out are unchanged while empty values clear them.
+type BookUpdateRequest struct {
+	Title       *string `json:"title"`
+	PublishDate *string `json:"pubdate"`
+	ISBN        *string `json:"isbn"`
+	Publisher   *string `json:"publisher"`
+	// Authors replace the creators of the book, in order
+	Authors     []*Author `json:"authors"`
+	Series      *string   `json:"series"`
+	SeriesIndex *float64  `json:"series_index"`
+	Tags        []string  `json:"tags"`
+	// Languages are language codes, the first is the main language
+	Languages []string `json:"languages"`
+	// Identifiers replace all the identifiers of the book
+	Identifiers map[string]string `json:"identifiers"`
+	Description *string           `json:"description"`
+	// Rating is from 0 to 10, 0 removes it
+	Rating *int `json:"rating"`
 }
 
 type BookUserLink struct {
@@ -67,6 +123,16 @@ type BookUserLink struct {
 	UserID int `json:"user"`
 }
 
+// BookBlob is a book file of the content-addressed store, the book
+// directories hard link to it. RefCount is the number of users owning the
+// book, the file is removed along with the last of them.
+type BookBlob struct {
+	Hash      string `json:"hash"`
+	Size      int64  `json:"size"`
+	RefCount  int    `json:"ref_count"`
+	CreatedTs int64  `json:"created_ts"`
+}
+
 type BookAuthorLink struct {
 	ID       int `json:"id"`
 	BookID   int `json:"book"`
@@ -129,5 +195,16 @@ type BookReadingStatusLink struct {
 	// The total reading duration in seconds.
 	Duration int `json:"duration"`
 	// The LastRead is the last time the book was read.
-    LastRead string `json:"last_read"`
+	LastRead string `json:"last_read"`
+}
+
+// PrimaryAuthors returns the creators who wrote the book
+func (m *BookMeta) PrimaryAuthors() []*Author {
+	var authors []*Author
+	for _, author := range m.Authors {
+		if author.IsPrimary() {
+			authors = append(authors, author)
+		}
+	}
+	return authors
 }
@@ -1,18 +1,189 @@
 package model //import "github.com/Xunop/e-oasis/internal/model"
 
+import "path/filepath"
+
+const (
+	JobStatusPending  = "pending"
+	JobStatusRunning  = "running"
+	JobStatusDone     = "done"
+	JobStatusFailed   = "failed"
+	JobStatusCanceled = "canceled"
+)
+
+// A job goes through the upload pool, which moves the staged file into the
+// book directory, then through the parse pool.
+const (
+	JobStageUpload = "upload"
+	JobStageParse  = "parse"
+	// JobStageImport is the only stage of the archive imports
+	JobStageImport = "import"
+)
+
 const (
-	JobStatusPending = "pending"
-	JobStatusRunning = "running"
-	JobStatusDone    = "done"
+	// JobTypeBatch saves the book without waiting for the user
+	JobTypeBatch = "BATCH"
+	// JobTypeSingle returns the metadata of the book to the waiting request
+	JobTypeSingle = "SINGLE"
+	// JobTypeArchive imports every book of an archive or a directory
+	JobTypeArchive = "ARCHIVE"
+	// JobTypeWatch saves a book found in a watched folder
+	JobTypeWatch = "WATCH"
+	// JobTypeCalibre imports the books of a calibre library in place
+	JobTypeCalibre = "CALIBRE"
 )
 
 type Job struct {
-	ID     int
-	UserID int
-	Path   string
-	Type   string
-	Status string
-	Item   interface{}
+	ID     int `json:"id"`
+	UserID int `json:"user_id"`
+	// Path is the directory of the book
+	Path   string `json:"path"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Stage  string `json:"stage"`
+	// Payload is saved along with the job, so that it can be resumed
+	Payload    JobPayload `json:"payload"`
+	Attempts   int        `json:"attempts"`
+	Error      string     `json:"error"`
+	CreatedTs  int64      `json:"created_ts"`
+	UpdatedTs  int64      `json:"updated_ts"`
+	StartedTs  int64      `json:"started_ts"`
+	FinishedTs int64      `json:"finished_ts"`
+}
+
+// JobPayload is what a job works on
+type JobPayload struct {
+	// File is the staged upload, removed once moved into the book directory
+	File string `json:"file"`
+	// FileName is the name of the uploaded file
+	FileName string `json:"file_name"`
+	// Size, SHA256 and MIMEType describe the uploaded file as it arrived
+	Size     int64  `json:"size,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	MIMEType string `json:"mime_type,omitempty"`
+	// BookID is the book the job saved
+	BookID int `json:"book_id,omitempty"`
+	// DuplicateOf is the book found with the same content
+	DuplicateOf int `json:"duplicate_of,omitempty"`
+	// Shared is set when the book of another user with the same content was
+	// linked to the user instead of saved again
+	Shared bool `json:"shared,omitempty"`
+	// Dir is the directory of the server imported instead of an archive, it
+	// is left in place
+	Dir string `json:"dir,omitempty"`
+	// OwnerID is the user the books of a calibre library are attached to,
+	// the job belongs to the admin who started it
+	OwnerID int `json:"owner_id,omitempty"`
+	// Total is the number of books of a calibre library
+	Total int `json:"total,omitempty"`
+	// MapTags tags the books of an archive with their directories
+	MapTags bool `json:"map_tags,omitempty"`
+	// Tags are added to the book along with its own
+	Tags []string `json:"tags,omitempty"`
+	// Items are the outcomes of the books of an archive
+	Items []*JobItem `json:"items,omitempty"`
+	// Summary counts the outcomes of the items once the import is done
+	Summary *ImportSummary `json:"summary,omitempty"`
+}
+
+// Outcomes of an item of a job
+const (
+	JobItemImported  = "imported"
+	JobItemDuplicate = "duplicate"
+	JobItemFailed    = "failed"
+	// JobItemUnsupported is a file which isn't a book
+	JobItemUnsupported = "unsupported"
+	// JobItemShared is a book of another user linked to the user
+	JobItemShared = "shared"
+)
+
+// JobItem is the outcome of a book of an archive
+type JobItem struct {
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	BookID      int    `json:"book_id,omitempty"`
+	DuplicateOf int    `json:"duplicate_of,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ImportSummary counts the outcomes of the items of an import, the reasons
+// are in the items.
+type ImportSummary struct {
+	Imported    int `json:"imported"`
+	Shared      int `json:"shared"`
+	Duplicates  int `json:"duplicates"`
+	Unsupported int `json:"unsupported"`
+	Failed      int `json:"failed"`
+}
+
+// Summarize counts the outcomes of the items of the job
+func (p *JobPayload) Summarize() *ImportSummary {
+	summary := &ImportSummary{}
+	for _, item := range p.Items {
+		switch item.Status {
+		case JobItemImported:
+			summary.Imported++
+		case JobItemShared:
+			summary.Shared++
+		case JobItemDuplicate:
+			summary.Duplicates++
+		case JobItemUnsupported:
+			summary.Unsupported++
+		default:
+			summary.Failed++
+		}
+	}
+	return summary
+}
+
+// JobEvent is the progress of a job sent to the user
+type JobEvent struct {
+	JobID  int    `json:"job_id"`
+	UserID int    `json:"-"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Stage  string `json:"stage"`
+	// BytesDone and BytesTotal are the progress of the upload stage
+	BytesDone  int64 `json:"bytes_done,omitempty"`
+	BytesTotal int64 `json:"bytes_total,omitempty"`
+	// ItemsDone and ItemsTotal are the progress of a calibre import
+	ItemsDone   int    `json:"items_done,omitempty"`
+	ItemsTotal  int    `json:"items_total,omitempty"`
+	BookID      int    `json:"book_id,omitempty"`
+	DuplicateOf int    `json:"duplicate_of,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// Item is the book of an archive the event is about
+	Item *JobItem `json:"item,omitempty"`
+}
+
+// BookFile returns the path of the book file once uploaded
+func (j *Job) BookFile() string {
+	return filepath.Join(j.Path, j.Payload.FileName)
+}
+
+// Finished reports whether the job won't run anymore
+func (j *Job) Finished() bool {
+	return j.Status == JobStatusDone || j.Status == JobStatusFailed || j.Status == JobStatusCanceled
+}
+
+// Event returns the current state of the job as an event
+func (j *Job) Event() *JobEvent {
+	return &JobEvent{
+		JobID:       j.ID,
+		UserID:      j.UserID,
+		Type:        j.Type,
+		Status:      j.Status,
+		Stage:       j.Stage,
+		BookID:      j.Payload.BookID,
+		DuplicateOf: j.Payload.DuplicateOf,
+		Error:       j.Error,
+	}
+}
+
+type FindJob struct {
+	ID       *int     `json:"id"`
+	UserID   *int     `json:"user_id"`
+	Statuses []string `json:"statuses"`
+	Stage    *string  `json:"stage"`
 }
 
 type JobList []Job
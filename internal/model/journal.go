@@ -0,0 +1,30 @@
+package model //import "github.com/Xunop/e-oasis/internal/model"
+
+const (
+	// JournalOpCreateBook saves a new book in both databases
+	JournalOpCreateBook = "create_book"
+	// JournalOpDeleteBook deletes a book from both databases and its files
+	JournalOpDeleteBook = "delete_book"
+)
+
+// JournalEntry is the intent of an operation spanning both databases and the
+// files. It is written before the operation starts and removed once it is
+// done, the entries left at startup were interrupted.
+type JournalEntry struct {
+	ID     int    `json:"id"`
+	Op     string `json:"op"`
+	BookID int    `json:"book_id"`
+	UserID int    `json:"user_id"`
+	// JobID is the parse job saving the book, it is finished along with it
+	JobID     int            `json:"job_id"`
+	Payload   JournalPayload `json:"payload"`
+	CreatedTs int64          `json:"created_ts"`
+}
+
+type JournalPayload struct {
+	// Path is the book file
+	Path string `json:"path,omitempty"`
+	// Dir is removed when the creation of the book is undone
+	Dir    string   `json:"dir,omitempty"`
+	Hashes []string `json:"hashes,omitempty"`
+}
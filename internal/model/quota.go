@@ -0,0 +1,31 @@
+package model //import "github.com/Xunop/e-oasis/internal/model"
+
+// Quota limits the library of a user, a zero limit is no limit
+type Quota struct {
+	MaxBytes int64 `json:"max_bytes"`
+	MaxBooks int   `json:"max_books"`
+}
+
+// Unlimited reports whether the quota limits nothing
+func (q *Quota) Unlimited() bool {
+	return q == nil || (q.MaxBytes <= 0 && q.MaxBooks <= 0)
+}
+
+// Usage is what the library of a user takes, the bytes are the sizes of its
+// book files. A book shared with other users counts for each of them.
+type Usage struct {
+	UserID    int    `json:"user_id"`
+	Bytes     int64  `json:"bytes"`
+	Books     int    `json:"books"`
+	UpdatedTs int64  `json:"updated_ts"`
+	Quota     *Quota `json:"quota,omitempty"`
+}
+
+// UsageReport is the usage of every user. StoredBytes is what the books take
+// on disk once, the shared books are counted once.
+type UsageReport struct {
+	Users       []*Usage `json:"users"`
+	Bytes       int64    `json:"bytes"`
+	Books       int      `json:"books"`
+	StoredBytes int64    `json:"stored_bytes"`
+}
@@ -0,0 +1,10 @@
+package model
+
+type Series struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Sort string `json:"sort"`
+	// Index is the position of a book in the series, it's stored with the
+	// book rather than the series.
+	Index float64 `json:"index,omitempty"`
+}
@@ -8,6 +8,8 @@ const (
 	SettingTypePlugins  = "SETTINGS_PLUGINS"
 	SettingTypeSecurity = "SETTINGS_SECURITY"
 	SettingTypeCustom   = "SETTINGS_CUSTOM"
+	SettingTypeQuota    = "SETTINGS_QUOTA"
+	SettingTypeTrash    = "SETTINGS_TRASH"
 )
 
 type SystemSetting struct {
@@ -36,6 +38,33 @@ func (s *SystemSettingGeneral) ToJSON() string {
 	return string(b)
 }
 
+// SystemSettingQuota is the quota of the users without one of their own, a
+// zero limit is no limit
+type SystemSettingQuota struct {
+	MaxBytes int64 `json:"max_bytes"`
+	MaxBooks int   `json:"max_books"`
+}
+
+func (s *SystemSettingQuota) ToJSON() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// DefaultTrashRetentionDays is how long the trash keeps books until the
+// retention is set
+const DefaultTrashRetentionDays = 30
+
+// SystemSettingTrash is how many days the books stay in the trash before
+// they are purged, zero keeps them until they are purged by hand
+type SystemSettingTrash struct {
+	RetentionDays int `json:"retention_days"`
+}
+
+func (s *SystemSettingTrash) ToJSON() string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
 type SystemSettingPlugins struct {
 }
 
@@ -101,3 +130,21 @@ func (s *SystemSetting) GetCustom() (*SystemSettingCustom, error) {
 	}
 	return &custom, nil
 }
+
+func (s *SystemSetting) GetQuota() (*SystemSettingQuota, error) {
+	var quota SystemSettingQuota
+	err := json.Unmarshal([]byte(s.Value), &quota)
+	if err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+func (s *SystemSetting) GetTrash() (*SystemSettingTrash, error) {
+	var trash SystemSettingTrash
+	err := json.Unmarshal([]byte(s.Value), &trash)
+	if err != nil {
+		return nil, err
+	}
+	return &trash, nil
+}
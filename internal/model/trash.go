@@ -0,0 +1,19 @@
+package model //import "github.com/Xunop/e-oasis/internal/model"
+
+// TrashedBook is a book a user moved to the trash. It keeps its files and
+// links until it is purged.
+type TrashedBook struct {
+	BookID    int   `json:"book_id"`
+	UserID    int   `json:"user_id"`
+	TrashedTs int64 `json:"trashed_ts"`
+	// PurgeTs is when the book is purged, zero when the trash is kept
+	PurgeTs int64 `json:"purge_ts,omitempty"`
+	Book    *Book `json:"book,omitempty"`
+}
+
+type FindTrashedBook struct {
+	BookID *int
+	UserID *int
+	// TrashedBefore only returns the books trashed before the timestamp
+	TrashedBefore *int64
+}
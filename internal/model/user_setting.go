@@ -14,6 +14,8 @@ const (
 	UserSettingKey_USER_SETTING_APPEARANCE UserSettingKey = 3
 	// The visibility of the memo.
 	UserSettingKey_USER_SETTING_MEMO_VISIBILITY UserSettingKey = 4
+	// The quota of the user.
+	UserSettingKey_USER_SETTING_QUOTA UserSettingKey = 5
 
 	// Default view settings.
 	DefaultViewSettings       = `{"show_hot_book":true}`
@@ -27,6 +29,7 @@ var (
 		2: "USER_SETTING_LOCALE",
 		3: "USER_SETTING_APPEARANCE",
 		4: "USER_SETTING_MEMO_VISIBILITY",
+		5: "USER_SETTING_QUOTA",
 	}
 	UserSettingKey_value = map[string]int32{
 		"USER_SETTING_KEY_UNSPECIFIED": 0,
@@ -34,6 +37,7 @@ var (
 		"USER_SETTING_LOCALE":          2,
 		"USER_SETTING_APPEARANCE":      3,
 		"USER_SETTING_MEMO_VISIBILITY": 4,
+		"USER_SETTING_QUOTA":           5,
 	}
 )
 
@@ -86,6 +90,21 @@ func (a *AccessTokensUserSetting) String() string {
 	return string(b)
 }
 
+// QuotaUserSetting overrides the default quota for the user, a missing
+// limit is the default one and a zero limit is no limit.
+type QuotaUserSetting struct {
+	MaxBytes *int64 `json:"max_bytes,omitempty"`
+	MaxBooks *int   `json:"max_books,omitempty"`
+}
+
+func (q *QuotaUserSetting) String() string {
+	if q == nil {
+		return ""
+	}
+	b, _ := json.Marshal(q)
+	return string(b)
+}
+
 func (e UserSettingKey) String() string {
 	switch e {
 	case UserSettingKey_USER_SETTING_ACCESS_TOKENS:
@@ -96,6 +115,8 @@ func (e UserSettingKey) String() string {
 		return "USER_SETTING_APPEARANCE"
 	case UserSettingKey_USER_SETTING_MEMO_VISIBILITY:
 		return "USER_SETTING_MEMO_VISIBILITY"
+	case UserSettingKey_USER_SETTING_QUOTA:
+		return "USER_SETTING_QUOTA"
 	default:
 		return "USER_SETTING_KEY_UNSPECIFIED"
 	}
@@ -119,3 +140,14 @@ func (x *AccessTokensUserSetting) GetAccessTokens() []*AccessTokensUserSetting_A
 	}
 	return nil
 }
+
+func (x *UserSetting) GetQuota() *QuotaUserSetting {
+	var quota QuotaUserSetting
+	if x != nil {
+		if err := json.Unmarshal([]byte(x.Value), &quota); err != nil {
+			return nil
+		}
+		return &quota
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package model //import "github.com/Xunop/e-oasis/internal/model"
+
+// What is done with the files of a watched folder once imported
+const (
+	AfterImportKeep    = "keep"
+	AfterImportArchive = "archive"
+	AfterImportDelete  = "delete"
+)
+
+// WatchedFolder is a directory whose new books are imported into the library
+// of a user
+type WatchedFolder struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	Path   string `json:"path"`
+	// AfterImport is what is done with the imported files
+	AfterImport string `json:"after_import"`
+	// ArchiveDir is where the imported files are moved to
+	ArchiveDir string `json:"archive_dir,omitempty"`
+	// MapTags tags the books with their subdirectories
+	MapTags   bool  `json:"map_tags"`
+	CreatedTs int64 `json:"created_ts"`
+}
+
+type FindWatchedFolder struct {
+	ID     *int `json:"id"`
+	UserID *int `json:"user_id"`
+}
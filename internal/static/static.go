@@ -0,0 +1,11 @@
+// Package static holds the files embedded in the binary.
+package static // import "github.com/Xunop/e-oasis/internal/static"
+
+import (
+	_ "embed"
+)
+
+// DefaultCover is served for the books without a cover
+//
+//go:embed default_cover.webp
+var DefaultCover []byte
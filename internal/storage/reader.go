@@ -0,0 +1,68 @@
+package storage // import "github.com/Xunop/e-oasis/internal/storage"
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Reader reads an object as an io.ReadSeeker, each seek starts a range read
+// so that http.ServeContent serves the ranges of an object without reading
+// it whole.
+type Reader struct {
+	ctx     context.Context
+	storage Storage
+	key     string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+// NewReader returns a reader of the object key of size bytes
+func NewReader(ctx context.Context, s Storage, key string, size int64) *Reader {
+	return &Reader{ctx: ctx, storage: s, key: key, size: size}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.storage.GetRange(r.ctx, r.key, r.offset, r.size-r.offset)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return r.offset, nil
+}
+
+// Close closes the current range read
+func (r *Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
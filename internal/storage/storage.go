@@ -1,8 +1,283 @@
-package storage
+package storage // import "github.com/Xunop/e-oasis/internal/storage"
 
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/Xunop/e-oasis/internal/config"
+)
+
+// Storage backends
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+var (
+	// ErrNotFound is returned for a key with no object
+	ErrNotFound = errors.New("object not found")
+	// ErrNotSupported is returned by the backends which can't do an operation
+	ErrNotSupported = errors.New("not supported by the storage backend")
+	// ErrInvalidKey is returned for a key escaping the storage
+	ErrInvalidKey = errors.New("invalid key")
+)
+
+// Storage keeps the files of the library by key. The keys are slash
+// separated paths relative to the data directory, like
+// "1/books/Title/Title.epub".
+//
+// The storage holds the library: the files are put into it once they are
+// written and the downloads are served from it. With a storage other than
+// the data directory itself, the book directories of the data directory
+// are a cache: the files are written there first, and fetched back from
+// the storage when they are worked on. The databases stay in the data
+// directory.
 type Storage interface {
-    // Save saves the data to the storage
-    Save(data []byte) error
-    // Load loads the data from the storage
-    Load() ([]byte, error)
+	// Put saves the size bytes read from r under key, replacing the former
+	// object. The size is unknown when it is negative.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get reads the object key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange reads length bytes of the object key from offset, up to its
+	// end when length is negative
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// Delete removes the object key, a missing object isn't an error
+	Delete(ctx context.Context, key string) error
+	// List returns the objects whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]*ObjectInfo, error)
+	// PresignGet returns a URL the object key can be downloaded from without
+	// credentials until expires, ErrNotSupported when the backend can't.
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// ObjectInfo describes an object of the storage
+type ObjectInfo struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	ETag    string    `json:"etag,omitempty"`
+}
+
+// Default is the storage of the library, the data directory until Open is
+// called.
+var Default Storage = &LocalStorage{}
+
+// Open sets Default to the storage backend of the options, every command
+// working on the library opens it
+func Open(opts *config.Options) error {
+	switch opts.StorageBackend {
+	case "", BackendLocal:
+		Default = &LocalStorage{}
+	case BackendS3:
+		s3, err := NewS3Storage(S3Config{
+			Endpoint:  opts.S3Endpoint,
+			Region:    opts.S3Region,
+			Bucket:    opts.S3Bucket,
+			AccessKey: opts.S3AccessKey,
+			SecretKey: opts.S3SecretKey,
+			PathStyle: opts.S3PathStyle,
+			Prefix:    opts.S3Prefix,
+		})
+		if err != nil {
+			return err
+		}
+		Default = s3
+	default:
+		return errors.Errorf("unknown storage backend %s", opts.StorageBackend)
+	}
+	return nil
+}
+
+// Key returns the key of the file at path in the data directory, false for
+// the files out of it, like the books of an imported calibre library.
+func Key(filePath string) (string, bool) {
+	rel, err := filepath.Rel(config.Opts.Data, filePath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// cleanKey checks that key stays in the storage
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return "", errors.Wrap(ErrInvalidKey, key)
+	}
+	cleaned := path.Clean(key)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", errors.Wrap(ErrInvalidKey, key)
+	}
+	return cleaned, nil
+}
+
+// isWorkingCopy reports whether s keeps its objects in the data directory
+// itself, the files there are then already stored.
+func isWorkingCopy(s Storage) bool {
+	local, ok := s.(*LocalStorage)
+	return ok && filepath.Clean(local.dir()) == filepath.Clean(config.Opts.Data)
+}
+
+// Cached reports whether the files of the data directory are a cache of s,
+// they can then be removed from the disk
+func Cached(s Storage) bool {
+	return !isWorkingCopy(s)
+}
+
+// PutFile puts the file at filePath of the data directory into s under its
+// key. The files out of the data directory are left alone.
+func PutFile(ctx context.Context, s Storage, filePath string) error {
+	key, ok := Key(filePath)
+	if !ok || isWorkingCopy(s) {
+		return nil
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to open file")
+	}
+	return s.Put(ctx, key, f, info.Size())
+}
+
+// PutDir puts the files of the directory dir of the data directory into s,
+// like the book file, the covers and the sidecar of a book.
+func PutDir(ctx context.Context, s Storage, dir string) error {
+	if _, ok := Key(dir); !ok || isWorkingCopy(s) {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to read directory")
+	}
+	for _, entry := range entries {
+		// The hidden files are the ones being written
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if err := PutFile(ctx, s, filepath.Join(dir, entry.Name())); err != nil {
+			return errors.Wrapf(err, "failed to store %s", entry.Name())
+		}
+	}
+	return nil
+}
+
+// DeleteDir deletes the objects of the directory dir of the data directory
+// from s
+func DeleteDir(ctx context.Context, s Storage, dir string) error {
+	prefix, ok := Key(dir)
+	if !ok {
+		return nil
+	}
+	objects, err := s.List(ctx, prefix+"/")
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := s.Delete(ctx, object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Size returns the size of the file at filePath, read from s when it isn't
+// on the disk. ErrNotFound is returned when neither has it.
+func Size(ctx context.Context, s Storage, filePath string) (int64, error) {
+	info, err := os.Stat(filePath)
+	if err == nil {
+		return info.Size(), nil
+	}
+	if !os.IsNotExist(err) {
+		return 0, errors.Wrap(err, "failed to read file")
+	}
+	key, ok := Key(filePath)
+	if !ok || isWorkingCopy(s) {
+		return 0, errors.Wrap(ErrNotFound, filePath)
+	}
+	object, err := s.Stat(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return object.Size, nil
+}
+
+// Fetch gets the file at filePath of the data directory from s when it
+// isn't on the disk. The book directories of the data directory are a cache
+// of the storage, the files are fetched for the work done on them, like
+// parsing a book or resizing its cover.
+func Fetch(ctx context.Context, s Storage, filePath string) error {
+	key, ok := Key(filePath)
+	if !ok || isWorkingCopy(s) {
+		return nil
+	}
+	if _, err := os.Stat(filePath); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	return fetchObject(ctx, s, key, filePath)
+}
+
+// FetchDir gets the objects of the directory dir of the data directory, or
+// of the whole data directory, from s. The files on the disk are kept.
+func FetchDir(ctx context.Context, s Storage, dir string) error {
+	if isWorkingCopy(s) {
+		return nil
+	}
+	prefix, ok := Key(dir)
+	if ok {
+		prefix += "/"
+	} else if filepath.Clean(dir) != filepath.Clean(config.Opts.Data) {
+		return nil
+	}
+	objects, err := s.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		filePath := filepath.Join(config.Opts.Data, filepath.FromSlash(object.Key))
+		if _, err := os.Stat(filePath); err == nil {
+			continue
+		}
+		if err := fetchObject(ctx, s, object.Key, filePath); err != nil {
+			return errors.Wrapf(err, "failed to fetch %s", object.Key)
+		}
+	}
+	return nil
+}
+
+// fetchObject writes the object key to filePath, through a hidden file so
+// that it isn't put back into the storage half written
+func fetchObject(ctx context.Context, s Storage, key, filePath string) error {
+	r, err := s.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return errors.Wrap(err, "failed to create directory")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".fetch-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create file")
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to fetch %s", key)
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), filePath), "failed to fetch file")
 }
@@ -1,68 +1,180 @@
 package storage // import "github.com/Xunop/e-oasis/internal/storage"
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"fmt"
+	"context"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"github.com/Xunop/e-oasis/internal/config"
-	"github.com/Xunop/e-oasis/internal/log"
-	"github.com/Xunop/e-oasis/internal/util"
-	"go.uber.org/zap"
 )
 
+// LocalStorage keeps the objects as files of a directory, the key is the
+// path of the file.
 type LocalStorage struct {
-	// Path to the storage directory
+	// Path is the directory of the files, the data directory when empty
 	Path string
-	// User ID
-	UID int
 }
 
-func (s *LocalStorage) Save(data []byte) error {
-	return nil
+func (s *LocalStorage) dir() string {
+	if s.Path != "" {
+		return s.Path
+	}
+	return config.Opts.Data
 }
 
-func (s *LocalStorage) Load() ([]byte, error) {
-	return nil, nil
+func (s *LocalStorage) path(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir(), filepath.FromSlash(key)), nil
 }
 
-func storeFile(reader io.Reader, fileName string, uid int) (string, error) {
-	// Check if the file type is supported
-	ext := filepath.Ext(fileName)
-	if !config.CheckSupportedTypes(ext[1:]) {
-		return "", fmt.Errorf("Unsupported file type: %s", ext)
+// Put writes the object to a temporary file first, a reader never sees half
+// of it.
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return errors.Wrap(err, "failed to create directory")
 	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create file")
+	}
+	defer os.Remove(tmp.Name())
 
-	// Generate the storage path
-	fileBase := strings.TrimSuffix(filepath.Base(fileName), ext)
-	bookPath := fmt.Sprintf("%s/%d/books/%s", config.Opts.Data, uid, fileBase)
-	bookPath = util.GenerateNewDirName(bookPath)
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && n != size {
+		err = errors.Errorf("wrote %d bytes of %d", n, size)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to write %s", key)
+	}
+	return errors.Wrapf(os.Rename(tmp.Name(), path), "failed to write %s", key)
+}
 
-	// Create directories if not exist
-	if err := os.MkdirAll(bookPath, os.ModePerm); err != nil {
-		return "", fmt.Errorf("Failed to create directories: %v", err)
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.GetRange(ctx, key, 0, -1)
+}
+
+func (s *LocalStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
 	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, errors.Wrap(ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", key)
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "failed to read %s", key)
+		}
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (s *LocalStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return nil, errors.Wrap(ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to stat %s", key)
+	}
+	return &ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
 
-	// Calculate hash and save the file
-	hash := sha256.New()
-	filePath := filepath.Join(bookPath, fileBase+ext)
-	outFile, err := os.Create(filePath)
+// Delete removes the file of the object, then the directories it leaves
+// empty.
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
 	if err != nil {
-		return "", fmt.Errorf("Failed to create file: %v", err)
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to delete %s", key)
 	}
-	defer outFile.Close()
+	root := filepath.Clean(s.dir())
+	for dir := filepath.Dir(path); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
 
-	// Copy data to the file and calculate the hash
-	if _, err := io.Copy(io.MultiWriter(outFile, hash), reader); err != nil {
-		return "", fmt.Errorf("Failed to write file: %v", err)
+func (s *LocalStorage) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	root := s.dir()
+	// Only the directory holding the prefix is walked
+	start := root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir, err := s.path(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		start = dir
 	}
 
-	fileHash := hex.EncodeToString(hash.Sum(nil))
-	log.Debug("Stored file", zap.String("path", filePath), zap.String("hash", fileHash))
+	list := make([]*ObjectInfo, 0)
+	err := filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		list = append(list, &ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list objects")
+	}
+	return list, nil
+}
 
-	return filePath, nil
+// PresignGet isn't supported, the files are served by the server
+func (s *LocalStorage) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrNotSupported
 }
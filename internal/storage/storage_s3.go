@@ -0,0 +1,397 @@
+package storage // import "github.com/Xunop/e-oasis/internal/storage"
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	s3Algorithm = "AWS4-HMAC-SHA256"
+	s3Service   = "s3"
+	// s3UnsignedPayload lets the bodies be streamed without hashing them first
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3TimeFormat      = "20060102T150405Z"
+	s3DateFormat      = "20060102"
+	// s3MaxPresign is the longest a presigned URL is valid, 7 days
+	s3MaxPresign = 7 * 24 * time.Hour
+)
+
+// S3Config is the bucket of an S3Storage
+type S3Config struct {
+	// Endpoint is the URL of the service, like https://s3.amazonaws.com or
+	// http://127.0.0.1:9000 for MinIO
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle puts the bucket in the path of the URLs instead of the
+	// host, most self-hosted services want it
+	PathStyle bool
+	// Prefix is put before every key, the library may share the bucket
+	Prefix string
+}
+
+// S3Storage keeps the objects in a bucket of an S3 compatible service, the
+// requests are signed with AWS Signature Version 4.
+type S3Storage struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	// now is the time the requests are signed at
+	now func() time.Time
+}
+
+// NewS3Storage returns the storage of the bucket of config
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, errors.Errorf("invalid s3 endpoint %q", config.Endpoint)
+	}
+	config.Prefix = strings.Trim(config.Prefix, "/")
+	return &S3Storage{config: config, endpoint: endpoint, client: http.DefaultClient, now: time.Now}, nil
+}
+
+// objectURL returns the URL of the object key, or of the bucket when the
+// key is empty
+func (s *S3Storage) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	objectPath := ""
+	if key != "" {
+		if s.config.Prefix != "" {
+			key = s.config.Prefix + "/" + key
+		}
+		objectPath = "/" + key
+	}
+	if s.config.PathStyle {
+		u.Path = "/" + s.config.Bucket + objectPath
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path = objectPath
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawPath = s3Escape(u.Path)
+	return &u
+}
+
+func (s *S3Storage) newRequest(ctx context.Context, method, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := s.objectURL(key)
+	u.RawQuery = s3Query(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create s3 request")
+	}
+	return req, nil
+}
+
+// do signs and sends req, the response is returned when its status is one
+// of ok
+func (s *S3Storage) do(req *http.Request, key string, ok ...int) (*http.Response, error) {
+	s.sign(req, s3UnsignedPayload)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "s3 request failed")
+	}
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.Wrap(ErrNotFound, key)
+	}
+	return nil, s3Error(resp)
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		// A PUT needs its length
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", key)
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
+	req, err := s.newRequest(ctx, http.MethodPut, key, nil, io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	resp, err := s.do(req, key, http.StatusOK)
+	if err != nil {
+		return errors.Wrapf(err, "failed to put %s", key)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.GetRange(ctx, key, 0, -1)
+}
+
+func (s *S3Storage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	req, err := s.newRequest(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if length > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.do(req, key, http.StatusOK, http.StatusPartialContent)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s", key)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := s.newRequest(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, key, http.StatusOK)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to stat %s", key)
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &ObjectInfo{Key: key, Size: resp.ContentLength, ModTime: modTime, ETag: strings.Trim(resp.Header.Get("ETag"), `"`)}, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	req, err := s.newRequest(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, key, http.StatusNoContent, http.StatusOK)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete %s", key)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listResult is the response of ListObjectsV2
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	bucketPrefix := ""
+	if s.config.Prefix != "" {
+		bucketPrefix = s.config.Prefix + "/"
+	}
+
+	list := make([]*ObjectInfo, 0)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {bucketPrefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.newRequest(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req, prefix, http.StatusOK)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list objects")
+		}
+		var result listResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list objects")
+		}
+
+		for _, object := range result.Contents {
+			list = append(list, &ObjectInfo{
+				Key:     strings.TrimPrefix(object.Key, bucketPrefix),
+				Size:    object.Size,
+				ModTime: object.LastModified,
+				ETag:    strings.Trim(object.ETag, `"`),
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return list, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// PresignGet signs the URL of the object in its query
+func (s *S3Storage) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	if expires <= 0 || expires > s3MaxPresign {
+		expires = s3MaxPresign
+	}
+	now := s.now().UTC()
+	query := url.Values{
+		"X-Amz-Algorithm":     {s3Algorithm},
+		"X-Amz-Credential":    {s.config.AccessKey + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format(s3TimeFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	u := s.objectURL(key)
+	u.RawQuery = s3Query(query)
+
+	header := http.Header{}
+	canonical := s.canonicalRequest(http.MethodGet, u, header, u.Host, []string{"host"}, s3UnsignedPayload)
+	signature := s.signature(now, canonical)
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+// sign adds the Authorization header of req
+func (s *S3Storage) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := []string{"host"}
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-type" || name == "content-md5" {
+			signed = append(signed, name)
+		}
+	}
+	sort.Strings(signed)
+
+	canonical := s.canonicalRequest(req.Method, req.URL, req.Header, req.URL.Host, signed, payloadHash)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.config.AccessKey, s.scope(now), strings.Join(signed, ";"), s.signature(now, canonical)))
+}
+
+func (s *S3Storage) canonicalRequest(method string, u *url.URL, header http.Header, host string, signed []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signed {
+		value := host
+		if name != "host" {
+			value = strings.Join(header.Values(name), ",")
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	return strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		headers.String(),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func (s *S3Storage) scope(t time.Time) string {
+	return strings.Join([]string{t.Format(s3DateFormat), s.config.Region, s3Service, "aws4_request"}, "/")
+}
+
+func (s *S3Storage) signature(t time.Time, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := strings.Join([]string{s3Algorithm, t.Format(s3TimeFormat), s.scope(t), hex.EncodeToString(hash[:])}, "\n")
+
+	key := s3HMAC([]byte("AWS4"+s.config.SecretKey), t.Format(s3DateFormat))
+	key = s3HMAC(key, s.config.Region)
+	key = s3HMAC(key, s3Service)
+	key = s3HMAC(key, "aws4_request")
+	return hex.EncodeToString(s3HMAC(key, stringToSign))
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape escapes a path the way the signature expects, everything but
+// the unreserved characters and the slashes
+func s3Escape(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// s3Query encodes the query sorted by key, with the spaces as %20
+func s3Query(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+// s3Error returns the error of a failed response
+func s3Error(resp *http.Response) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &body) == nil && body.Code != "" {
+		return errors.Errorf("s3: %s: %s", body.Code, body.Message)
+	}
+	return errors.Errorf("s3: unexpected status %s", resp.Status)
+}
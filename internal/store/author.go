@@ -1,12 +1,21 @@
 package store
 
 import (
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/Xunop/e-oasis/internal/log"
 	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
 )
 
+// creatorsPluginData names the books_plugin_data entry that keeps every
+// creator of a book with its role, calibre only knows about authors.
+const creatorsPluginData = "e_oasis_creators"
+
 func (s *Store) AddAuthor(author *model.Author) (*model.Author, error) {
 	// Get Author ID if author is exist
 	// FIXME: Author may have same name
@@ -116,3 +125,140 @@ func (s *Store) AddBookAuthorLink(link *model.BookAuthorLink) (*model.BookAuthor
 
 	return &authorLink, nil
 }
+
+// SetBookAuthors replaces the authors and the other creators of a book
+func (s *Store) SetBookAuthors(bookID int, authors []*model.Author) error {
+	s.metaDbLock.Lock()
+	defer s.metaDbLock.Unlock()
+	tx, err := s.metaDb.Begin()
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	if err := s.setBookAuthorsTx(tx, bookID, authors); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.writeBookSidecar(bookID)
+	return nil
+}
+
+// setBookAuthorsTx links the primary authors to the book in order, calibre
+// orders the authors of a book by link id. Every creator, with its role, is
+// kept in books_plugin_data. The IDs of the authors are set on success.
+func (s *Store) setBookAuthorsTx(tx *sql.Tx, bookID int, authors []*model.Author) error {
+	if _, err := tx.Exec(`DELETE FROM books_authors_link WHERE book = ?`, bookID); err != nil {
+		return errors.Wrap(err, "failed to clear book authors")
+	}
+
+	for _, author := range authors {
+		if !author.IsPrimary() {
+			continue
+		}
+		authorID, err := s.findOrCreateAuthorTx(tx, author.Name, author.Sort)
+		if err != nil {
+			return errors.Wrapf(err, "failed to find or create author '%s'", author.Name)
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO books_authors_link (book, author) VALUES (?, ?)`, bookID, authorID); err != nil {
+			return errors.Wrapf(err, "failed to link book to author '%s'", author.Name)
+		}
+		author.ID = authorID
+	}
+
+	if len(authors) == 0 {
+		_, err := tx.Exec(`DELETE FROM books_plugin_data WHERE book = ? AND name = ?`, bookID, creatorsPluginData)
+		return errors.Wrap(err, "failed to clear book creators")
+	}
+	val, err := json.Marshal(authors)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode book creators")
+	}
+	stmt := `INSERT INTO books_plugin_data (book, name, val) VALUES (?, ?, ?)
+			 ON CONFLICT(book, name) DO UPDATE SET val = excluded.val`
+	if _, err := tx.Exec(stmt, bookID, creatorsPluginData, string(val)); err != nil {
+		return errors.Wrap(err, "failed to save book creators")
+	}
+	return nil
+}
+
+// fillBookAuthors sets the authors of the books, followed by the other
+// creators when they are known.
+func (s *Store) fillBookAuthors(books []*model.Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(books))
+	for _, book := range books {
+		ids = append(ids, fmt.Sprintf("%d", book.ID))
+	}
+	in := "(" + strings.Join(ids, ",") + ")"
+
+	query := `
+		SELECT bal.book, a.id, a.name, a.sort, a.link
+		FROM books_authors_link bal
+		JOIN authors a ON a.id = bal.author
+		WHERE bal.book IN ` + in + `
+		ORDER BY bal.id`
+	log.Debug("SQL query and args:")
+	log.Fallback("Debug", fmt.Sprintf("query: %s\n", query))
+
+	rows, err := s.metaDb.Query(query)
+	if err != nil {
+		return errors.Wrap(err, "failed to query book authors")
+	}
+	defer rows.Close()
+
+	authors := map[int][]*model.Author{}
+	for rows.Next() {
+		var bookID int
+		var sort sql.NullString
+		author := &model.Author{Role: model.AuthorRoleAuthor}
+		if err := rows.Scan(&bookID, &author.ID, &author.Name, &sort, &author.Link); err != nil {
+			return errors.Wrap(err, "failed to scan book author")
+		}
+		author.Sort = sort.String
+		authors[bookID] = append(authors[bookID], author)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	creators := map[int][]*model.Author{}
+	rows, err = s.metaDb.Query(`SELECT book, val FROM books_plugin_data WHERE name = ? AND book IN `+in, creatorsPluginData)
+	if err != nil {
+		return errors.Wrap(err, "failed to query book creators")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bookID int
+		var val string
+		if err := rows.Scan(&bookID, &val); err != nil {
+			return errors.Wrap(err, "failed to scan book creators")
+		}
+		var list []*model.Author
+		if err := json.Unmarshal([]byte(val), &list); err != nil {
+			log.Warn("Invalid book creators", zap.Int("book_id", bookID), zap.Error(err))
+			continue
+		}
+		creators[bookID] = list
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, book := range books {
+		book.Authors = authors[book.ID]
+		// The links are authoritative for the authors since calibre may
+		// have edited them, only the other creators are taken from the list
+		for _, creator := range creators[book.ID] {
+			if !creator.IsPrimary() {
+				book.Authors = append(book.Authors, creator)
+			}
+		}
+	}
+	return nil
+}
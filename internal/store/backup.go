@@ -0,0 +1,80 @@
+package store
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/Xunop/e-oasis/internal/version"
+)
+
+// SnapshotDatabases writes a consistent copy of the application database to
+// appPath and of the metadata database to metaPath with VACUUM INTO, the
+// server keeps running. The writes of this process wait for the copies so
+// that both databases are taken at the same point, the ones of another
+// process don't. during is called once the copies are written, while the
+// writes still wait, to list the files going with them.
+func (s *Store) SnapshotDatabases(appPath, metaPath string, during func() error) error {
+	s.metaDbLock.Lock()
+	defer s.metaDbLock.Unlock()
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+
+	if _, err := s.appDb.Exec(`VACUUM INTO ?`, appPath); err != nil {
+		return errors.Wrap(err, "failed to snapshot application database")
+	}
+	if _, err := s.metaDb.Exec(`VACUUM INTO ?`, metaPath); err != nil {
+		return errors.Wrap(err, "failed to snapshot metadata database")
+	}
+	if during != nil {
+		return during()
+	}
+	return nil
+}
+
+// ListMigrationFiles returns the names of the migration files applied to the
+// application database
+func (s *Store) ListMigrationFiles() ([]string, error) {
+	rows, err := s.appDb.Query(`SELECT name FROM migration_file ORDER BY name`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query migration files")
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.Wrap(err, "failed to scan migration file")
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// GetSchemaVersion returns the latest version the application database was
+// migrated to
+func (s *Store) GetSchemaVersion() (string, error) {
+	rows, err := s.appDb.Query(`SELECT version FROM migration_history`)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to query migration history")
+	}
+	defer rows.Close()
+
+	versions := make([]string, 0)
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return "", errors.Wrap(err, "failed to scan migration history")
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(versions) == 0 {
+		return "", errors.New("no migration history")
+	}
+	sort.Sort(version.SortVersion(versions))
+	return versions[len(versions)-1], nil
+}
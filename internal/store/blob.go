@@ -0,0 +1,184 @@
+package store
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"go.uber.org/zap"
+)
+
+// BlobDir is the directory of the data directory keeping the book files by
+// the hash of their book
+const BlobDir = "blobs"
+
+// BlobPath returns the file of the content-addressed store for the book
+// hash, spread over directories named after its first two characters.
+func BlobPath(hash string) string {
+	shard := "00"
+	if len(hash) >= 2 {
+		shard = hash[:2]
+	}
+	return filepath.Join(config.Opts.Data, BlobDir, shard, hash)
+}
+
+// SaveBookBlob puts the book file of the data directory into the
+// content-addressed store, as a hard link so that the bytes are kept once.
+// A book file whose content is already stored is replaced by a link to it.
+func (s *Store) SaveBookBlob(hash, bookFile string) error {
+	if !isDataPath(bookFile) {
+		return nil
+	}
+	blob := BlobPath(hash)
+	if err := os.MkdirAll(filepath.Dir(blob), os.ModePerm); err != nil {
+		return errors.Wrap(err, "failed to create blob directory")
+	}
+
+	if _, err := os.Stat(blob); err == nil {
+		if err := linkBlob(blob, bookFile); err != nil {
+			return err
+		}
+	} else if err := os.Link(bookFile, blob); err != nil {
+		return errors.Wrap(err, "failed to link book file")
+	}
+	info, err := os.Stat(blob)
+	if err != nil {
+		return errors.Wrap(err, "failed to read blob")
+	}
+
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+	tx, err := s.appDb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO book_blob (hash, size) VALUES (?, ?) ON CONFLICT(hash) DO NOTHING`
+	if _, err := tx.Exec(stmt, hash, info.Size()); err != nil {
+		return errors.Wrap(err, "failed to save blob")
+	}
+	if err := refreshBlobRefs(tx, `hash = ?`, hash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// linkBlob replaces the file at path by a hard link to blob
+func linkBlob(blob, path string) error {
+	if same, err := sameFile(blob, path); err != nil || same {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), ".blob-"+filepath.Base(path))
+	os.Remove(tmp)
+	if err := os.Link(blob, tmp); err != nil {
+		return errors.Wrap(err, "failed to link blob")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "failed to link blob")
+	}
+	return nil
+}
+
+func sameFile(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read blob")
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read book file")
+	}
+	return os.SameFile(infoA, infoB), nil
+}
+
+// GetBookBlob returns the blob of the book, nil when its file isn't in the
+// content-addressed store.
+func (s *Store) GetBookBlob(bookID int) (*model.BookBlob, error) {
+	stmt := `
+		SELECT b.hash, b.size, b.ref_count, b.created_ts
+		FROM book_blob b
+		JOIN book_hash_link h ON h.hash = b.hash
+		WHERE h.book_id = ?`
+	var blob model.BookBlob
+	err := s.appDb.QueryRow(stmt, bookID).Scan(&blob.Hash, &blob.Size, &blob.RefCount, &blob.CreatedTs)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get book blob")
+	}
+	return &blob, nil
+}
+
+// CheckBookUserLink reports whether the user owns the book
+func (s *Store) CheckBookUserLink(bookID, userID int) bool {
+	var exists bool
+	stmt := `SELECT EXISTS(SELECT 1 FROM book_user_link WHERE book_id = ? AND user_id = ?)`
+	if err := s.appDb.QueryRow(stmt, bookID, userID).Scan(&exists); err != nil {
+		return false
+	}
+	return exists
+}
+
+// refreshBlobRefs counts again the owners of the blobs matching where
+func refreshBlobRefs(tx *sql.Tx, where string, args ...any) error {
+	stmt := `
+		UPDATE book_blob SET ref_count = (
+			SELECT COUNT(DISTINCT l.user_id)
+			FROM book_user_link l
+			JOIN book_hash_link h ON h.book_id = l.book_id
+			WHERE h.hash = book_blob.hash
+		)
+		WHERE ` + where
+	if _, err := tx.Exec(stmt, args...); err != nil {
+		return errors.Wrap(err, "failed to count blob references")
+	}
+	return nil
+}
+
+// unlinkBookUser takes the book out of the library of the user, along with
+// the reading history, bookmarks and shelves of the user, the book itself
+// stays with its other owners.
+func (s *Store) unlinkBookUser(bookID, userID int) error {
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+	tx, err := s.appDb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"book_user_link", "bookmark", "duration_info", "reading_status"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE book_id = ? AND user_id = ?`, bookID, userID); err != nil {
+			return errors.Wrapf(err, "failed to delete from %s table", table)
+		}
+	}
+	stmt := `DELETE FROM book_shelf_link WHERE book_id = ? AND shelf_id IN (SELECT id FROM shelf WHERE user_id = ?)`
+	if _, err := tx.Exec(stmt, bookID, userID); err != nil {
+		return errors.Wrap(err, "failed to delete from book_shelf_link table")
+	}
+	if err := refreshBlobRefs(tx, `hash IN (SELECT hash FROM book_hash_link WHERE book_id = ?)`, bookID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Info("Book unlinked from user", zap.Int("bookID", bookID), zap.Int("userID", userID))
+	return nil
+}
+
+// countBookOwners returns the number of users owning the book
+func (s *Store) countBookOwners(bookID int) (int, error) {
+	var count int
+	if err := s.appDb.QueryRow(`SELECT COUNT(*) FROM book_user_link WHERE book_id = ?`, bookID).Scan(&count); err != nil {
+		return 0, errors.Wrap(err, "failed to count book owners")
+	}
+	return count, nil
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
@@ -8,10 +9,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Xunop/e-oasis/internal/config"
 	"github.com/Xunop/e-oasis/internal/log"
 	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/storage"
 	"github.com/Xunop/e-oasis/internal/util"
-	"github.com/Xunop/e-oasis/internal/util/parsers/epub"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
@@ -37,6 +39,7 @@ func (s *Store) GetBook(find *model.FindBook) (*model.Book, error) {
 }
 
 // RemoveBook removes book records from the database AND its corresponding file from storage.
+// A user removing a book shared with other users only loses their own link to it.
 func (s *Store) RemoveBook(find *model.FindBook) error {
 	// Find the book to get its path before deleting the record.
 	// We use ListBooks because it can handle all find criteria.
@@ -54,6 +57,49 @@ func (s *Store) RemoveBook(find *model.FindBook) error {
 	bookID := bookToDelete.ID
 	bookPath := bookToDelete.Path
 
+	// A book shared with other users is only taken out of the library of
+	// the user, its files are deleted along with the last owner.
+	if find.UserID != nil {
+		owners, err := s.countBookOwners(bookID)
+		if err != nil {
+			return err
+		}
+		if owners > 1 {
+			return s.unlinkBookUser(bookID, *find.UserID)
+		}
+	}
+
+	// The deletion is journaled first, an interrupted one is completed at
+	// the next startup.
+	hashes, err := s.listBookHashes(bookID)
+	if err != nil {
+		return err
+	}
+	entry, err := s.AddJournalEntry(&model.JournalEntry{
+		Op:      model.JournalOpDeleteBook,
+		BookID:  bookID,
+		Payload: model.JournalPayload{Path: bookPath, Hashes: hashes},
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.DeleteBookData(entry); err != nil {
+		return err
+	}
+	if err := s.DeleteJournalEntry(entry.ID); err != nil {
+		log.Error("Failed to delete journal entry", zap.Int("id", entry.ID), zap.Error(err))
+	}
+
+	log.Info("Book deleted successfully", zap.Int("bookID", bookID))
+	return nil
+}
+
+// DeleteBookData deletes the book of a delete_book journal entry from both
+// databases, then its files and its blobs. Deleting a book again does
+// nothing, an interrupted deletion is completed by running it again.
+func (s *Store) DeleteBookData(entry *model.JournalEntry) error {
+	bookID, bookPath := entry.BookID, entry.Payload.Path
+
 	// Delete database records in a transaction.
 	// metaDb transaction
 	metaTx, err := s.metaDb.Begin()
@@ -76,35 +122,31 @@ func (s *Store) RemoveBook(find *model.FindBook) error {
 	defer appTx.Rollback()
 
     // Delete all links to the book from the application database tables.
-	tablesToClean := []string{
-		"book_user_link",
-		"book_shelf_link",
-		"bookmark",
-		"duration_info",
-		"reading_status",
-		"book_hash_link",
-	}
-
-	for _, table := range tablesToClean {
+	for _, table := range BookLinkTables {
 		stmt := fmt.Sprintf("DELETE FROM %s WHERE book_id = ?", table)
 		if _, err := appTx.Exec(stmt, bookID); err != nil {
 			return errors.Wrapf(err, "failed to delete from %s table", table)
 		}
 	}
+	for _, hash := range entry.Payload.Hashes {
+		if _, err := appTx.Exec(`DELETE FROM book_blob WHERE hash = ?`, hash); err != nil {
+			return errors.Wrap(err, "failed to delete from book_blob table")
+		}
+	}
 
-	// Commit transactions for both databases.
+	// Commit transactions for both databases. When appDb fails to commit
+	// after metaDb, the journal entry is left and the deletion is done
+	// again at the next startup.
 	if err := metaTx.Commit(); err != nil {
 		return errors.Wrap(err, "failed to commit metaDb transaction")
 	}
 	if err := appTx.Commit(); err != nil {
-		// Note: At this point, metaDb is committed. If appDb fails to commit,
-		// the data will be in an inconsistent state. A more complex system might require
-		// a two-phase commit or compensation logic, but for now, we'll just return the error.
 		return errors.Wrap(err, "failed to commit appDb transaction")
 	}
 
-	// If database operations were successful, delete the physical file.
-	if bookPath != "" {
+	// If database operations were successful, delete the physical file. Books
+	// outside the data directory belong to an imported library, leave them.
+	if bookPath != "" && isDataPath(bookPath) {
 		// The book file is stored in its own directory, so we remove the entire directory.
 		bookDir := filepath.Dir(bookPath)
 		log.Debug("Deleting book directory", zap.String("path", bookDir))
@@ -115,15 +157,50 @@ func (s *Store) RemoveBook(find *model.FindBook) error {
 				zap.Error(err))
 			return errors.Wrap(err, "failed to delete book directory")
 		}
+		if err := storage.DeleteDir(context.Background(), storage.Default, bookDir); err != nil {
+			log.Error("Failed to delete stored book", zap.String("path", bookDir), zap.Error(err))
+			return errors.Wrap(err, "failed to delete stored book")
+		}
 	}
 
-	log.Info("Book deleted successfully", zap.Int("bookID", bookID))
+	for _, hash := range entry.Payload.Hashes {
+		if err := os.Remove(BlobPath(hash)); err != nil && !os.IsNotExist(err) {
+			log.Error("Failed to delete book blob", zap.String("hash", hash), zap.Error(err))
+		}
+	}
+	s.BookCache.Delete(bookID)
 	return nil
 }
 
+// isDataPath reports whether path is inside the data directory
+func isDataPath(path string) bool {
+	rel, err := filepath.Rel(config.Opts.Data, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func (s *Store) ListBooks(find *model.FindBook) ([]*model.Book, error) {
 	if v := find.UserID; v != nil {
-		return s.ListBooksByUserID(*v)
+		list, err := s.ListBooksByUserID(*v)
+		if err != nil {
+			return nil, err
+		}
+		if find.Trashed != nil {
+			ids, err := s.listTrashedBookIDs(v)
+			if err != nil {
+				return nil, err
+			}
+			list = filterTrashedBooks(list, ids, *find.Trashed)
+		}
+		if find.BookID == nil {
+			return list, nil
+		}
+		// Only the book of the user
+		for _, book := range list {
+			if book.ID == *find.BookID {
+				return []*model.Book{book}, nil
+			}
+		}
+		return []*model.Book{}, nil
 	}
 
 	where, args := []string{"1 = 1"}, []any{}
@@ -143,6 +220,21 @@ func (s *Store) ListBooks(find *model.FindBook) ([]*model.Book, error) {
 	if v := find.LCCN; v != nil {
 		where, args = append(where, "lccn = ?"), append(args, *v)
 	}
+	if v := find.Trashed; v != nil {
+		ids, err := s.listTrashedBookIDs(nil)
+		if err != nil {
+			return nil, err
+		}
+		trashed := make([]string, 0, len(ids))
+		for id := range ids {
+			trashed = append(trashed, fmt.Sprintf("%d", id))
+		}
+		if *v {
+			where = append(where, "id IN ("+strings.Join(trashed, ",")+")")
+		} else if len(trashed) > 0 {
+			where = append(where, "id NOT IN ("+strings.Join(trashed, ",")+")")
+		}
+	}
 
 	// Default order by title
 	orderBy := []string{"title"}
@@ -210,6 +302,10 @@ func (s *Store) ListBooks(find *model.FindBook) ([]*model.Book, error) {
 		list = append(list, &book)
 	}
 
+	if err := s.fillBookAuthors(list); err != nil {
+		log.Error("Failed to get book authors", zap.Error(err))
+		return nil, err
+	}
 	return list, nil
 }
 
@@ -295,39 +391,79 @@ func (s *Store) ListBooksByUserID(userID int) ([]*model.Book, error) {
 		}
 		listBooks = append(listBooks, &book)
 	}
+	if err := s.fillBookAuthors(listBooks); err != nil {
+		log.Error("Failed to get book authors", zap.Error(err))
+		return nil, err
+	}
 	return listBooks, nil
 }
 
 func (s *Store) RemoveBookByUserID(userID int, bookID ...int) error {
-	where, args := []string{"1 = 1"}, []any{}
-
-	where, args = append(where, "user_id = ?"), append(args, userID)
 	bookIDs := make([]string, 0)
 
 	for _, id := range bookID {
 		bookIDs = append(bookIDs, fmt.Sprintf("%d", id))
 	}
 
+	// The application database is released before the metadata one is taken
+	rmList, err := s.removeBookUserLinks(userID, bookIDs)
+	if err != nil {
+		return err
+	}
+
+	if len(rmList) == 0 {
+		return nil
+	}
+
+	where, args := []string{"1 = 1"}, []any{}
+	where, args = append(where, "id IN ("+strings.Join(bookIDs, ",")+")"), append(args, rmList)
+
+	s.metaDbLock.Lock()
+	defer s.metaDbLock.Unlock()
+	tx, err := s.metaDb.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+		DELETE FROM books
+		WHERE ` + strings.Join(where, " AND ")
+	if _, err = tx.Exec(stmt, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// removeBookUserLinks unlinks the books from the user and returns the ones
+// unlinked
+func (s *Store) removeBookUserLinks(userID int, bookIDs []string) ([]int, error) {
 	stmt := `
 		DELETE FROM book_user_link
 		WHERE user_id = ? AND book_id IN (` + strings.Join(bookIDs, ",") + `)
 	    RETURNING book_id
 	`
+	args := []any{userID}
 
 	s.appDbLock.Lock()
 	defer s.appDbLock.Unlock()
 	tx, err := s.appDb.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer tx.Rollback()
 
 	log.Debug("SQL query and args:")
 	log.Fallback("Debug", fmt.Sprintf("query: %s\nargs: %s\n", stmt, args))
 
 	rows, err := tx.Query(stmt, args...)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -335,42 +471,16 @@ func (s *Store) RemoveBookByUserID(userID int, bookID ...int) error {
 	for rows.Next() {
 		var bookID int
 		if err := rows.Scan(&bookID); err != nil {
-			return err
+			return nil, err
 		}
 		rmList = append(rmList, bookID)
 	}
+	rows.Close()
 
 	if err := tx.Commit(); err != nil {
-		return err
-	}
-
-	if len(rmList) == 0 {
-		return nil
-	}
-
-	where, args = []string{"1 = 1"}, []any{}
-	where, args = append(where, "id IN ("+strings.Join(bookIDs, ",")+")"), append(args, rmList)
-
-	s.metaDbLock.Lock()
-	defer s.metaDbLock.Unlock()
-	tx, err = s.metaDb.Begin()
-	if err != nil {
-		return err
-	}
-
-	stmt = `
-		DELETE FROM books
-		WHERE ` + strings.Join(where, " AND ")
-	if _, err = tx.Exec(stmt, args...); err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	if err := tx.Commit(); err != nil {
-		return err
+		return nil, err
 	}
-
-	return nil
+	return rmList, nil
 }
 
 func (s *Store) AddBook(book *model.Book) (*model.Book, error) {
@@ -476,39 +586,95 @@ func (s *Store) AddPublisher(publisher *model.Publisher) (*model.Publisher, erro
 	return &newPublisher, nil
 }
 
+// AddLanguage returns the language of code, it is created if it doesn't exist.
 func (s *Store) AddLanguage(code string) (*model.Language, error) {
-	stmt := `
-        INSERT INTO languages (
-            lang_code,
-        ) VALUES (?) RETURNING id, lang_code`
-	args := []any{}
+	s.metaDbLock.Lock()
+	defer s.metaDbLock.Unlock()
+	tx, err := s.metaDb.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
 
-	args = append(args, code)
+	langID, err := s.findOrCreateLanguageTx(tx, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &model.Language{ID: langID, LangCode: code}, nil
+}
 
+// SetBookLanguages replaces the languages of a book, the order of the codes
+// is kept as the item order.
+func (s *Store) SetBookLanguages(bookID int, codes []string) error {
 	s.metaDbLock.Lock()
 	defer s.metaDbLock.Unlock()
-	tx, err := s.appDb.Begin()
+	tx, err := s.metaDb.Begin()
 	if err != nil {
-		return nil, err
+		return errors.Wrap(err, "failed to begin transaction")
 	}
+	defer tx.Rollback()
 
-	log.Debug("SQL query and args:")
-	log.Fallback("Debug", fmt.Sprintf("query: %s\nargs: %s\n", stmt, args))
+	if err := s.setBookLanguagesTx(tx, bookID, codes); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.writeBookSidecar(bookID)
+	return nil
+}
 
-	var newLanguage model.Language
-	if err := tx.QueryRow(stmt, args...).Scan(&newLanguage.ID, &newLanguage.LangCode); err != nil {
-		tx.Rollback()
-		return nil, err
+func (s *Store) setBookLanguagesTx(tx *sql.Tx, bookID int, codes []string) error {
+	if _, err := tx.Exec(`DELETE FROM books_languages_link WHERE book = ?`, bookID); err != nil {
+		return errors.Wrap(err, "failed to clear book languages")
+	}
+
+	order := 0
+	for _, code := range codes {
+		code = strings.TrimSpace(code)
+		if code == "" {
+			continue
+		}
+		langID, err := s.findOrCreateLanguageTx(tx, code)
+		if err != nil {
+			return err
+		}
+		// "en" and "en-US" end up as the same language, keep the first
+		res, err := tx.Exec(`INSERT OR IGNORE INTO books_languages_link (book, lang_code, item_order) VALUES (?, ?, ?)`, bookID, langID, order)
+		if err != nil {
+			return errors.Wrapf(err, "failed to link book to language '%s'", code)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			order++
+		}
 	}
-	return &newLanguage, nil
+	return nil
+}
+
+// findOrCreateLanguageTx finds a language by code or creates it if it doesn't exist.
+func (s *Store) findOrCreateLanguageTx(tx *sql.Tx, code string) (int, error) {
+	var langID int
+	err := tx.QueryRow(`SELECT id FROM languages WHERE lang_code = ?`, code).Scan(&langID)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(`INSERT INTO languages (lang_code) VALUES (?) RETURNING id`, code).Scan(&langID)
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to find or create language '%s'", code)
+	}
+	return langID, nil
 }
 
 func (s *Store) AddBookUserLink(create *model.BookUserLink) (*model.BookUserLink, error) {
+	// Linking a book twice returns the existing link
 	stmt := `
 		INSERT INTO book_user_link (
 			book_id,
 			user_id
 		) VALUES (?,?)
+		ON CONFLICT(book_id, user_id) DO UPDATE SET book_id = excluded.book_id
 		RETURNING id, book_id, user_id`
 	args := []any{}
 
@@ -530,12 +696,26 @@ func (s *Store) AddBookUserLink(create *model.BookUserLink) (*model.BookUserLink
 		tx.Rollback()
 		return nil, err
 	}
+	if err := refreshBlobRefs(tx, `hash IN (SELECT hash FROM book_hash_link WHERE book_id = ?)`, create.BookID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
 	return &newLink, nil
 }
 
+// GetBookOwnerID returns the user the book was first added for
+func (s *Store) GetBookOwnerID(bookID int) (int, error) {
+	var userID int
+	err := s.appDb.QueryRow(`SELECT user_id FROM book_user_link WHERE book_id = ? ORDER BY id LIMIT 1`, bookID).Scan(&userID)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get book owner")
+	}
+	return userID, nil
+}
+
 // UpsetBookStatus upset book status
 // If the book status is not exist, insert new record
 // If the book status is exist, update the record, except for book_id, user_id, page
@@ -688,6 +868,10 @@ func (s *Store) AddBookHashLink(bookID int, hash string) error {
 		tx.Rollback()
 		return err
 	}
+	if err := refreshBlobRefs(tx, `hash = ?`, hash); err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	return tx.Commit()
 }
@@ -778,6 +962,16 @@ func (s *Store) ListBooksByTag(tagID int) ([]*model.Book, error) {
 		}
 		books = append(books, &book)
 	}
+	// The books every owner moved to the trash are hidden
+	trashed, err := s.listTrashedBookIDs(nil)
+	if err != nil {
+		return nil, err
+	}
+	books = filterTrashedBooks(books, trashed, false)
+	if err := s.fillBookAuthors(books); err != nil {
+		log.Error("Failed to get book authors", zap.Error(err))
+		return nil, err
+	}
 	return books, nil
 }
 
@@ -809,7 +1003,11 @@ func (s *Store) AddTagToBook(bookID int, tagName string) error {
 		return errors.Wrap(err, "failed to insert into books_tags_link")
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.writeBookSidecar(bookID)
+	return nil
 }
 
 // findOrCreateTagTx finds a tag by name or creates it if it doesn't exist.
@@ -838,125 +1036,218 @@ func (s *Store) findOrCreateTagTx(tx *sql.Tx, tagName string) (int, error) {
 	return tagID, nil
 }
 
-// ParseAndSaveBookMeta takes a file path, parses it, and saves the book and all
-// its related metadata (author, publisher, links) in a single transaction.
-func (s *Store) ParseAndSaveBookMeta(path string, userID int, tags []string) error {
-	// Parse the book file to get its metadata.
-	book, err := epub.Open(path)
-	if err != nil {
-		return errors.Wrap(err, "failed to open epub for parsing")
+// SetBookComment sets the description of a book, replacing any existing one.
+func (s *Store) SetBookComment(bookID int, text string) error {
+	s.metaDbLock.Lock()
+	defer s.metaDbLock.Unlock()
+
+	stmt := `INSERT INTO comments (book, text) VALUES (?, ?)
+			 ON CONFLICT(book) DO UPDATE SET text = excluded.text`
+	log.Debug("SQL query and args:")
+	log.Fallback("Debug", fmt.Sprintf("query: %s\nargs: %v\n", stmt, []any{bookID, text}))
+	if _, err := s.metaDb.Exec(stmt, bookID, text); err != nil {
+		return errors.Wrap(err, "failed to save book comment")
 	}
-	defer book.Close()
+	s.writeBookSidecar(bookID)
+	return nil
+}
 
-	hasCover := false
-	bookCover, _ := book.GetCover(filepath.Dir(path))
-	if bookCover != "" {
-		hasCover = true
-		// Handle cover conversion in a separate goroutine so it doesn't slow down the import
-		go func(coverPath string) {
-			webpPath := util.ImageToWebp(coverPath, 75)
-			if webpPath != "" { // If conversion succeeds, remove original
-				os.Remove(coverPath)
-			}
-		}(bookCover)
+// SetBookIdentifier sets an identifier of a book, replacing any existing one
+// of the same type.
+func (s *Store) SetBookIdentifier(bookID int, typ, val string) error {
+	s.metaDbLock.Lock()
+	defer s.metaDbLock.Unlock()
+
+	stmt := `INSERT INTO identifiers (book, type, val) VALUES (?, ?, ?)
+			 ON CONFLICT(book, type) DO UPDATE SET val = excluded.val`
+	log.Debug("SQL query and args:")
+	log.Fallback("Debug", fmt.Sprintf("query: %s\nargs: %v\n", stmt, []any{bookID, typ, val}))
+	if _, err := s.metaDb.Exec(stmt, bookID, strings.ToLower(typ), val); err != nil {
+		return errors.Wrap(err, "failed to save book identifier")
 	}
+	s.writeBookSidecar(bookID)
+	return nil
+}
 
+// SaveImportedBook saves a parsed book and all its related metadata (authors,
+// publisher, series, tags, languages, comment, identifiers, links) in a single
+// transaction. The extra tags are added to the ones found in the book.
+func (s *Store) SaveImportedBook(meta *model.BookMeta, userID int, tags []string) (*model.Book, error) {
 	s.metaDbLock.Lock()
 	defer s.metaDbLock.Unlock()
 	// Begin a database transaction.
 	tx, err := s.metaDb.Begin()
 	if err != nil {
-		return errors.Wrap(err, "failed to begin transaction")
+		return nil, errors.Wrap(err, "failed to begin transaction")
 	}
 	defer tx.Rollback() // Rollback on any error
 
-	// Create or find the author within the transaction.
-	authorName := book.GetAuthor()
-	authorSort := util.AuthorSort(authorName)
-	authorID, err := s.findOrCreateAuthorTx(tx, authorName, authorSort)
-	if err != nil {
-		return err
-	}
-
 	// Create or find the publisher within the transaction.
-	publisherName := book.GetPublisher()
-	if strings.TrimSpace(publisherName) == "" {
-		publisherName = "Unknown"
+	publisherName := "Unknown"
+	if meta.Publisher != nil && strings.TrimSpace(meta.Publisher.Name) != "" {
+		publisherName = meta.Publisher.Name
 	}
 	publisherID, err := s.findOrCreatePublisherTx(tx, publisherName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Insert the book record.
-	bookToCreate := &model.Book{
-		Title:        book.GetTitle(),
-		SortTitle:    util.TitleSort(book.GetTitle()),
-		PublishDate:  book.GetDate(),
-		AuthorSort:   authorSort,
-		ISBN:         book.GetISBN(),
-		Path:         path,
-		UUID:         book.GetUUID(),
-		HasCover:     hasCover,
-		LastModified: time.Now().UTC().Format(time.RFC3339),
-	}
-
-	bookInsertStmt := `INSERT INTO books (title, sort, pubdate, author_sort, isbn, path, uuid, has_cover, last_modified) 
-					   VALUES (?,?,?,?,?,?,?,?,?) RETURNING id`
-	var bookID int
-	err = tx.QueryRow(bookInsertStmt, bookToCreate.Title, bookToCreate.SortTitle, bookToCreate.PublishDate,
-		bookToCreate.AuthorSort, bookToCreate.ISBN, bookToCreate.Path, bookToCreate.UUID,
-		bookToCreate.HasCover, bookToCreate.LastModified).Scan(&bookID)
+	book := *meta.Book
+	book.LastModified = time.Now().UTC().Format(time.RFC3339)
+
+	// The id and the timestamp are only set for books imported from another
+	// library or restored from their sidecar
+	bookInsertStmt := `INSERT INTO books (id, title, sort, timestamp, pubdate, author_sort, isbn, path, uuid, has_cover, last_modified) 
+					   VALUES (NULLIF(?, 0),?,?,COALESCE(NULLIF(?, ''), CURRENT_TIMESTAMP),?,?,?,?,?,?,?) RETURNING id`
+	err = tx.QueryRow(bookInsertStmt, book.ID, book.Title, book.SortTitle, book.TimeStamp, book.PublishDate,
+		book.AuthorSort, book.ISBN, book.Path, book.UUID,
+		book.HasCover, book.LastModified).Scan(&book.ID)
 	if err != nil {
-		return errors.Wrap(err, "failed to insert book record")
+		return nil, errors.Wrap(err, "failed to insert book record")
+	}
+	// The insert trigger gives every book a new uuid, a restored book keeps
+	// its own
+	if meta.Book.ID != 0 && book.UUID != "" {
+		if _, err := tx.Exec(`UPDATE books SET uuid = ? WHERE id = ?`, book.UUID, book.ID); err != nil {
+			return nil, errors.Wrap(err, "failed to set book uuid")
+		}
 	}
 
-	// Link book to author and publisher.
-	_, err = tx.Exec(`INSERT OR IGNORE INTO books_authors_link (book, author) VALUES (?, ?)`, bookID, authorID)
-	if err != nil {
-		return errors.Wrap(err, "failed to link book to author")
+	// Link book to the authors and publisher.
+	if err := s.setBookAuthorsTx(tx, book.ID, meta.Authors); err != nil {
+		return nil, err
 	}
+	book.Authors = meta.Authors
 
-	_, err = tx.Exec(`INSERT OR IGNORE INTO books_publishers_link (book, publisher) VALUES (?, ?)`, bookID, publisherID)
+	_, err = tx.Exec(`INSERT OR IGNORE INTO books_publishers_link (book, publisher) VALUES (?, ?)`, book.ID, publisherID)
 	if err != nil {
-		return errors.Wrap(err, "failed to link book to publisher")
+		return nil, errors.Wrap(err, "failed to link book to publisher")
 	}
 
-	if len(tags) > 0 {
-		for _, tagName := range tags {
-			// Sanitize tag name
-			tagName = strings.TrimSpace(tagName)
-			if tagName == "" {
-				continue
-			}
+	for _, tagName := range append(append([]string{}, meta.Tags...), tags...) {
+		// Sanitize tag name
+		tagName = strings.TrimSpace(tagName)
+		if tagName == "" {
+			continue
+		}
 
-			// Find or create the tag within the transaction
-			tagID, err := s.findOrCreateTagTx(tx, tagName)
-			if err != nil {
-				// If one tag fails, the whole transaction for this book will be rolled back.
-				return errors.Wrapf(err, "failed to find or create tag '%s'", tagName)
-			}
+		// Find or create the tag within the transaction
+		tagID, err := s.findOrCreateTagTx(tx, tagName)
+		if err != nil {
+			// If one tag fails, the whole transaction for this book will be rolled back.
+			return nil, errors.Wrapf(err, "failed to find or create tag '%s'", tagName)
+		}
 
-			// Link the book to the tag
-			_, err = tx.Exec(`INSERT OR IGNORE INTO books_tags_link (book, tag) VALUES (?, ?)`, bookID, tagID)
-			if err != nil {
-				return errors.Wrapf(err, "failed to link book to tag '%s'", tagName)
-			}
+		// Link the book to the tag
+		_, err = tx.Exec(`INSERT OR IGNORE INTO books_tags_link (book, tag) VALUES (?, ?)`, book.ID, tagID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to link book to tag '%s'", tagName)
+		}
+	}
+
+	if meta.Series != nil && strings.TrimSpace(meta.Series.Name) != "" {
+		seriesID, err := s.findOrCreateSeriesTx(tx, meta.Series.Name, meta.Series.Sort)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO books_series_link (book, series) VALUES (?, ?)`, book.ID, seriesID); err != nil {
+			return nil, errors.Wrap(err, "failed to link book to series")
+		}
+		if _, err := tx.Exec(`UPDATE books SET series_index = ? WHERE id = ?`, meta.Series.Index, book.ID); err != nil {
+			return nil, errors.Wrap(err, "failed to set series index")
+		}
+		book.SeriesIndex = meta.Series.Index
+	}
+
+	if len(meta.Languages) > 0 {
+		codes := make([]string, 0, len(meta.Languages))
+		for _, lang := range meta.Languages {
+			codes = append(codes, lang.LangCode)
+		}
+		if err := s.setBookLanguagesTx(tx, book.ID, codes); err != nil {
+			return nil, err
+		}
+	}
+
+	if meta.Description != "" {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO comments (book, text) VALUES (?, ?)`, book.ID, meta.Description); err != nil {
+			return nil, errors.Wrap(err, "failed to save book comment")
+		}
+	}
+
+	for typ, val := range meta.Identifiers {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO identifiers (book, type, val) VALUES (?, ?, ?)`, book.ID, strings.ToLower(typ), val); err != nil {
+			return nil, errors.Wrapf(err, "failed to save book identifier '%s'", typ)
+		}
+	}
+
+	if meta.Rating > 0 {
+		if err := s.setBookRatingTx(tx, book.ID, meta.Rating); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, format := range meta.Formats {
+		_, err := tx.Exec(`INSERT OR REPLACE INTO data (book, format, uncompressed_size, name) VALUES (?, ?, ?, ?)`,
+			book.ID, strings.ToUpper(format.Format), format.Size, format.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to save book format '%s'", format.Format)
 		}
 	}
 
 	// Link the book to the user in the other database.
 	// This can't be in the same transaction, but should happen before we commit.
-	if _, err := s.AddBookUserLink(&model.BookUserLink{BookID: bookID, UserID: userID}); err != nil {
-		return errors.Wrap(err, "failed to link book to user")
+	if _, err := s.AddBookUserLink(&model.BookUserLink{BookID: book.ID, UserID: userID}); err != nil {
+		return nil, errors.Wrap(err, "failed to link book to user")
 	}
 
 	// Commit the transaction.
-	log.Debug("Successfully imported and saved metadata", zap.String("book", book.GetTitle()))
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "failed to commit transaction")
+	}
+	log.Debug("Successfully imported and saved metadata", zap.String("book", book.Title))
+	s.writeBookSidecar(book.ID)
+	return &book, nil
 }
 
 // Helper function for finding/creating authors within a transaction.
+// findOrCreateSeriesTx returns the id of a series, creating it if needed
+func (s *Store) findOrCreateSeriesTx(tx *sql.Tx, name, sort string) (int, error) {
+	var seriesID int
+	err := tx.QueryRow(`SELECT id FROM series WHERE name = ?`, name).Scan(&seriesID)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(`INSERT INTO series (name, sort) VALUES (?, ?) RETURNING id`, name, sort).Scan(&seriesID)
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to find or create series")
+	}
+	return seriesID, nil
+}
+
+// setBookRatingTx links the book to a rating from 0 to 10, replacing the
+// previous one.
+func (s *Store) setBookRatingTx(tx *sql.Tx, bookID, rating int) error {
+	if rating < 0 || rating > 10 {
+		return errors.Errorf("invalid rating %d", rating)
+	}
+	var ratingID int
+	err := tx.QueryRow(`SELECT id FROM ratings WHERE rating = ?`, rating).Scan(&ratingID)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(`INSERT INTO ratings (rating) VALUES (?) RETURNING id`, rating).Scan(&ratingID)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to find or create rating")
+	}
+	if _, err := tx.Exec(`DELETE FROM books_ratings_link WHERE book = ?`, bookID); err != nil {
+		return errors.Wrap(err, "failed to unlink book rating")
+	}
+	if _, err := tx.Exec(`INSERT INTO books_ratings_link (book, rating) VALUES (?, ?)`, bookID, ratingID); err != nil {
+		return errors.Wrap(err, "failed to link book to rating")
+	}
+	return nil
+}
+
 func (s *Store) findOrCreateAuthorTx(tx *sql.Tx, name, sort string) (int, error) {
 	var authorID int
 	err := tx.QueryRow(`SELECT id FROM authors WHERE name = ?`, name).Scan(&authorID)
@@ -975,3 +1266,347 @@ func (s *Store) findOrCreatePublisherTx(tx *sql.Tx, name string) (int, error) {
 	}
 	return publisherID, err
 }
+
+// GetBookMeta returns a book along with all its metadata, nil if the book
+// doesn't exist.
+func (s *Store) GetBookMeta(bookID int) (*model.BookMeta, error) {
+	books, err := s.ListBooks(&model.FindBook{BookID: &bookID})
+	if err != nil {
+		return nil, err
+	}
+	if len(books) == 0 {
+		return nil, nil
+	}
+
+	meta := &model.BookMeta{
+		Book:        books[0],
+		Authors:     books[0].Authors,
+		Identifiers: map[string]string{},
+	}
+
+	var publisher model.Publisher
+	err = s.metaDb.QueryRow(`SELECT p.id, p.name, COALESCE(p.sort, '') FROM books_publishers_link bpl
+		JOIN publishers p ON p.id = bpl.publisher WHERE bpl.book = ? LIMIT 1`, bookID).Scan(&publisher.ID, &publisher.Name, &publisher.Sort)
+	if err == nil {
+		meta.Publisher = &publisher
+	} else if err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "failed to get book publisher")
+	}
+
+	var series model.Series
+	err = s.metaDb.QueryRow(`SELECT s.id, s.name, COALESCE(s.sort, '') FROM books_series_link bsl
+		JOIN series s ON s.id = bsl.series WHERE bsl.book = ? LIMIT 1`, bookID).Scan(&series.ID, &series.Name, &series.Sort)
+	if err == nil {
+		series.Index = meta.Book.SeriesIndex
+		meta.Series = &series
+	} else if err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "failed to get book series")
+	}
+
+	err = s.metaDb.QueryRow(`SELECT text FROM comments WHERE book = ?`, bookID).Scan(&meta.Description)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "failed to get book comment")
+	}
+
+	err = s.metaDb.QueryRow(`SELECT COALESCE(r.rating, 0) FROM books_ratings_link brl
+		JOIN ratings r ON r.id = brl.rating WHERE brl.book = ? LIMIT 1`, bookID).Scan(&meta.Rating)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "failed to get book rating")
+	}
+
+	err = s.queryBookMeta(`SELECT l.id, l.lang_code FROM books_languages_link bll
+		JOIN languages l ON l.id = bll.lang_code WHERE bll.book = ? ORDER BY bll.item_order`, bookID, func(rows *sql.Rows) error {
+		var lang model.Language
+		if err := rows.Scan(&lang.ID, &lang.LangCode); err != nil {
+			return err
+		}
+		meta.Languages = append(meta.Languages, &lang)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get book languages")
+	}
+
+	err = s.queryBookMeta(`SELECT t.name FROM books_tags_link btl
+		JOIN tags t ON t.id = btl.tag WHERE btl.book = ? ORDER BY btl.id`, bookID, func(rows *sql.Rows) error {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return err
+		}
+		meta.Tags = append(meta.Tags, tag)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get book tags")
+	}
+
+	err = s.queryBookMeta(`SELECT type, val FROM identifiers WHERE book = ?`, bookID, func(rows *sql.Rows) error {
+		var typ, val string
+		if err := rows.Scan(&typ, &val); err != nil {
+			return err
+		}
+		meta.Identifiers[typ] = val
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get book identifiers")
+	}
+
+	err = s.queryBookMeta(`SELECT format, uncompressed_size, name FROM data WHERE book = ? ORDER BY id`, bookID, func(rows *sql.Rows) error {
+		var format model.BookFormat
+		if err := rows.Scan(&format.Format, &format.Size, &format.Name); err != nil {
+			return err
+		}
+		meta.Formats = append(meta.Formats, &format)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get book formats")
+	}
+	return meta, nil
+}
+
+// queryBookMeta runs a query on the metadata of a book and calls scan for
+// every row
+func (s *Store) queryBookMeta(query string, bookID int, scan func(rows *sql.Rows) error) error {
+	rows, err := s.metaDb.Query(query, bookID)
+	if err != nil {
+		return err
+	}
+	return scanRows(rows, scan)
+}
+
+func (s *Store) queryBookMetaTx(tx *sql.Tx, query string, bookID int, scan func(rows *sql.Rows) error) error {
+	rows, err := tx.Query(query, bookID)
+	if err != nil {
+		return err
+	}
+	return scanRows(rows, scan)
+}
+
+func scanRows(rows *sql.Rows, scan func(rows *sql.Rows) error) error {
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// SetBookPath moves the book to another file in the database, the file itself
+// has to be moved by the caller.
+func (s *Store) SetBookPath(bookID int, path string) error {
+	s.metaDbLock.Lock()
+	defer s.metaDbLock.Unlock()
+
+	if _, err := s.metaDb.Exec(`UPDATE books SET path = ? WHERE id = ?`, path, bookID); err != nil {
+		return errors.Wrap(err, "failed to set book path")
+	}
+	s.BookCache.Delete(bookID)
+	s.writeBookSidecar(bookID)
+	return nil
+}
+
+// SetBookHasCover records whether the book has a cover, the cover changing
+// also changes the book for the readers syncing it.
+func (s *Store) SetBookHasCover(bookID int, hasCover bool) error {
+	s.metaDbLock.Lock()
+	defer s.metaDbLock.Unlock()
+
+	res, err := s.metaDb.Exec(`UPDATE books SET has_cover = ?, last_modified = ? WHERE id = ?`,
+		hasCover, time.Now().UTC().Format(time.RFC3339), bookID)
+	if err != nil {
+		return errors.Wrap(err, "failed to set book cover")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.Errorf("book %d not found", bookID)
+	}
+	s.BookCache.Delete(bookID)
+	s.writeBookSidecar(bookID)
+	return nil
+}
+
+// CountBooks returns the number of books in the library
+func (s *Store) CountBooks() (int, error) {
+	var count int
+	if err := s.metaDb.QueryRow(`SELECT COUNT(*) FROM books`).Scan(&count); err != nil {
+		return 0, errors.Wrap(err, "failed to count books")
+	}
+	return count, nil
+}
+
+// UpdateBook changes the metadata of a book, see model.BookUpdateRequest, and
+// returns the updated metadata. The sort of the title and of the authors
+// follow them.
+func (s *Store) UpdateBook(bookID int, update *model.BookUpdateRequest) (*model.BookMeta, error) {
+	s.metaDbLock.Lock()
+	defer s.metaDbLock.Unlock()
+	tx, err := s.metaDb.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	set, args := []string{"last_modified = ?"}, []any{time.Now().UTC().Format(time.RFC3339)}
+	if v := update.Title; v != nil {
+		title := strings.TrimSpace(*v)
+		set, args = append(set, "title = ?", "sort = ?"), append(args, title, util.TitleSort(title))
+	}
+	if v := update.PublishDate; v != nil {
+		set, args = append(set, "pubdate = ?"), append(args, strings.TrimSpace(*v))
+	}
+	if v := update.ISBN; v != nil {
+		set, args = append(set, "isbn = ?"), append(args, strings.TrimSpace(*v))
+	}
+	if v := update.SeriesIndex; v != nil {
+		set, args = append(set, "series_index = ?"), append(args, *v)
+	}
+	if update.Authors != nil {
+		var sorts []string
+		for _, author := range update.Authors {
+			author.Name = strings.TrimSpace(author.Name)
+			if author.Role == "" {
+				author.Role = model.AuthorRoleAuthor
+			}
+			if author.Sort = strings.TrimSpace(author.Sort); author.Sort == "" {
+				author.Sort = util.AuthorSort(author.Name)
+			}
+			if author.IsPrimary() {
+				sorts = append(sorts, author.Sort)
+			}
+		}
+		set, args = append(set, "author_sort = ?"), append(args, strings.Join(sorts, " & "))
+	}
+
+	result, err := tx.Exec(`UPDATE books SET `+strings.Join(set, ", ")+` WHERE id = ?`, append(args, bookID)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update book")
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, errors.Errorf("book %d not found", bookID)
+	}
+
+	if update.Authors != nil {
+		if err := s.setBookAuthorsTx(tx, bookID, update.Authors); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := update.Publisher; v != nil {
+		if _, err := tx.Exec(`DELETE FROM books_publishers_link WHERE book = ?`, bookID); err != nil {
+			return nil, errors.Wrap(err, "failed to unlink book publisher")
+		}
+		if name := strings.TrimSpace(*v); name != "" {
+			publisherID, err := s.findOrCreatePublisherTx(tx, name)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to find or create publisher")
+			}
+			if _, err := tx.Exec(`INSERT INTO books_publishers_link (book, publisher) VALUES (?, ?)`, bookID, publisherID); err != nil {
+				return nil, errors.Wrap(err, "failed to link book to publisher")
+			}
+		}
+	}
+
+	if v := update.Series; v != nil {
+		if _, err := tx.Exec(`DELETE FROM books_series_link WHERE book = ?`, bookID); err != nil {
+			return nil, errors.Wrap(err, "failed to unlink book series")
+		}
+		if name := strings.TrimSpace(*v); name != "" {
+			seriesID, err := s.findOrCreateSeriesTx(tx, name, util.TitleSort(name))
+			if err != nil {
+				return nil, err
+			}
+			if _, err := tx.Exec(`INSERT INTO books_series_link (book, series) VALUES (?, ?)`, bookID, seriesID); err != nil {
+				return nil, errors.Wrap(err, "failed to link book to series")
+			}
+		}
+	}
+
+	if update.Tags != nil {
+		if _, err := tx.Exec(`DELETE FROM books_tags_link WHERE book = ?`, bookID); err != nil {
+			return nil, errors.Wrap(err, "failed to unlink book tags")
+		}
+		for _, tagName := range update.Tags {
+			if tagName = strings.TrimSpace(tagName); tagName == "" {
+				continue
+			}
+			tagID, err := s.findOrCreateTagTx(tx, tagName)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to find or create tag '%s'", tagName)
+			}
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO books_tags_link (book, tag) VALUES (?, ?)`, bookID, tagID); err != nil {
+				return nil, errors.Wrapf(err, "failed to link book to tag '%s'", tagName)
+			}
+		}
+	}
+
+	if update.Languages != nil {
+		if err := s.setBookLanguagesTx(tx, bookID, update.Languages); err != nil {
+			return nil, err
+		}
+	}
+
+	identifiers := update.Identifiers
+	if update.ISBN != nil {
+		// The isbn of the book is also one of its identifiers
+		if identifiers == nil {
+			identifiers = map[string]string{}
+			err := s.queryBookMetaTx(tx, `SELECT type, val FROM identifiers WHERE book = ?`, bookID, func(rows *sql.Rows) error {
+				var typ, val string
+				if err := rows.Scan(&typ, &val); err != nil {
+					return err
+				}
+				identifiers[typ] = val
+				return nil
+			})
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get book identifiers")
+			}
+		}
+		if isbn := strings.TrimSpace(*update.ISBN); isbn != "" {
+			identifiers["isbn"] = isbn
+		} else {
+			delete(identifiers, "isbn")
+		}
+	}
+	if identifiers != nil {
+		if _, err := tx.Exec(`DELETE FROM identifiers WHERE book = ?`, bookID); err != nil {
+			return nil, errors.Wrap(err, "failed to delete book identifiers")
+		}
+		for typ, val := range identifiers {
+			if _, err := tx.Exec(`INSERT INTO identifiers (book, type, val) VALUES (?, ?, ?)`, bookID, strings.ToLower(strings.TrimSpace(typ)), strings.TrimSpace(val)); err != nil {
+				return nil, errors.Wrapf(err, "failed to save book identifier '%s'", typ)
+			}
+		}
+	}
+
+	if v := update.Description; v != nil {
+		if strings.TrimSpace(*v) == "" {
+			_, err = tx.Exec(`DELETE FROM comments WHERE book = ?`, bookID)
+		} else {
+			_, err = tx.Exec(`INSERT INTO comments (book, text) VALUES (?, ?)
+					ON CONFLICT(book) DO UPDATE SET text = excluded.text`, bookID, *v)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to save book comment")
+		}
+	}
+
+	if v := update.Rating; v != nil {
+		if *v == 0 {
+			if _, err := tx.Exec(`DELETE FROM books_ratings_link WHERE book = ?`, bookID); err != nil {
+				return nil, errors.Wrap(err, "failed to unlink book rating")
+			}
+		} else if err := s.setBookRatingTx(tx, bookID, *v); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "failed to commit transaction")
+	}
+	s.BookCache.Delete(bookID)
+	s.writeBookSidecar(bookID)
+	return s.GetBookMeta(bookID)
+}
@@ -0,0 +1,100 @@
+package store_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/Xunop/e-oasis/internal/store/db"
+)
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	dir := t.TempDir()
+	config.Opts.Data = dir
+	config.Opts.DSN = filepath.Join(dir, "e-oasis.db")
+	config.Opts.MetaDSN = filepath.Join(dir, "metadata.db")
+
+	var dbs []*db.DB
+	for _, d := range []struct{ path, name string }{{config.Opts.DSN, "system"}, {config.Opts.MetaDSN, "meta"}} {
+		conn, err := db.NewDB(d.path, d.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if err := conn.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		dbs = append(dbs, conn)
+	}
+	return store.NewStore(dbs[0].DB, dbs[1].DB)
+}
+
+func TestUpdateBook(t *testing.T) {
+	s := newTestStore(t)
+	book, err := s.SaveImportedBook(&model.BookMeta{
+		Book:        &model.Book{Title: "Old Title", Path: filepath.Join(t.TempDir(), "book.epub")},
+		Authors:     []*model.Author{{Name: "Jane Doe", Sort: "Doe, Jane", Role: model.AuthorRoleAuthor}},
+		Tags:        []string{"Old"},
+		Series:      &model.Series{Name: "Old Series", Index: 1},
+		Identifiers: map[string]string{"isbn": "9780000000000", "goodreads": "1"},
+		Description: "Old comment",
+	}, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fill the book cache, the update has to invalidate it
+	s.GetBook(&model.FindBook{BookID: &book.ID})
+
+	title, publisher, series, description, isbn := "A New Title", "Publisher", "", "", "9781111111111"
+	rating, index := 8, 2.0
+	meta, err := s.UpdateBook(book.ID, &model.BookUpdateRequest{
+		Title:       &title,
+		ISBN:        &isbn,
+		Publisher:   &publisher,
+		Authors:     []*model.Author{{Name: "John Roe"}, {Name: "Jane Doe", Sort: "Doe, Jane"}, {Name: "An Editor", Role: model.AuthorRoleEditor}},
+		Series:      &series,
+		SeriesIndex: &index,
+		Tags:        []string{"New", " ", "Fiction"},
+		Languages:   []string{"fra"},
+		Description: &description,
+		Rating:      &rating,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.Book.Title != title || meta.Book.SortTitle != "New Title, A" || meta.Book.ISBN != isbn {
+		t.Errorf("book = %+v", meta.Book)
+	}
+	if meta.Book.AuthorSort != "Roe, John & Doe, Jane" {
+		t.Errorf("author sort = %q", meta.Book.AuthorSort)
+	}
+	if meta.Book.LastModified == "" {
+		t.Error("last modified should be set")
+	}
+	if len(meta.Authors) != 3 || meta.Authors[0].Name != "John Roe" || meta.Authors[2].Role != model.AuthorRoleEditor {
+		t.Errorf("authors = %+v", meta.Authors)
+	}
+	if meta.Publisher == nil || meta.Publisher.Name != publisher || meta.Series != nil || meta.Description != "" || meta.Rating != 8 {
+		t.Errorf("meta = %+v", meta)
+	}
+	if len(meta.Tags) != 2 || meta.Tags[0] != "New" || len(meta.Languages) != 1 || meta.Languages[0].LangCode != "fra" {
+		t.Errorf("tags = %v, languages = %v", meta.Tags, meta.Languages)
+	}
+	if meta.Identifiers["isbn"] != isbn || meta.Identifiers["goodreads"] != "1" {
+		t.Errorf("identifiers = %v", meta.Identifiers)
+	}
+
+	cached, err := s.GetBook(&model.FindBook{BookID: &book.ID})
+	if err != nil || cached.Title != title {
+		t.Errorf("the book cache should be invalidated, got %+v", cached)
+	}
+
+	if _, err := s.UpdateBook(book.ID+1, &model.BookUpdateRequest{Title: &title}); err == nil {
+		t.Error("updating a missing book should fail")
+	}
+}
@@ -0,0 +1,16 @@
+-- job: persist the payload and the progress of the jobs
+ALTER TABLE job ADD COLUMN stage TEXT NOT NULL DEFAULT 'upload';
+ALTER TABLE job ADD COLUMN payload TEXT NOT NULL DEFAULT '{}';
+ALTER TABLE job ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE job ADD COLUMN error TEXT NOT NULL DEFAULT '';
+-- SQLite can't add a column with a non-constant default
+ALTER TABLE job ADD COLUMN created_ts BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE job ADD COLUMN updated_ts BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE job ADD COLUMN started_ts BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE job ADD COLUMN finished_ts BIGINT NOT NULL DEFAULT 0;
+
+UPDATE job SET created_ts = strftime('%s', 'now'), updated_ts = strftime('%s', 'now');
+-- The files of the former jobs were only in memory, they can't be resumed
+UPDATE job SET status = 'failed', error = 'interrupted by the upgrade' WHERE status NOT IN ('done', 'failed');
+
+CREATE INDEX idx_job_status ON job (status);
@@ -0,0 +1,11 @@
+-- watched_folder: the directories imported into the library of a user
+CREATE TABLE watched_folder (
+  id INTEGER PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  `path` TEXT NOT NULL UNIQUE,
+  after_import TEXT NOT NULL CHECK (after_import IN ('keep', 'archive', 'delete')) DEFAULT 'keep',
+  archive_dir TEXT NOT NULL DEFAULT '',
+  map_tags SMALLINT NOT NULL DEFAULT 1,
+  created_ts BIGINT NOT NULL DEFAULT (strftime('%s', 'now')),
+  FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
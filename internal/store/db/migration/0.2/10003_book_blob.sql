@@ -0,0 +1,8 @@
+-- book_blob: the book files of the content-addressed store, keyed by the
+-- hash of their book. ref_count is the number of users owning the book.
+CREATE TABLE book_blob (
+  hash TEXT NOT NULL PRIMARY KEY,
+  size BIGINT NOT NULL DEFAULT 0,
+  ref_count INTEGER NOT NULL DEFAULT 0,
+  created_ts BIGINT NOT NULL DEFAULT (strftime('%s', 'now'))
+);
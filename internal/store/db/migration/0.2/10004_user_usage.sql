@@ -0,0 +1,8 @@
+-- user_usage: the number of books of a user and the bytes of their files
+CREATE TABLE user_usage (
+  user_id INTEGER PRIMARY KEY,
+  bytes BIGINT NOT NULL DEFAULT 0,
+  books INTEGER NOT NULL DEFAULT 0,
+  updated_ts BIGINT NOT NULL DEFAULT (strftime('%s', 'now')),
+  FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
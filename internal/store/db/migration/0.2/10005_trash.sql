@@ -0,0 +1,2 @@
+-- book_user_link: a book is in the trash of the user since trashed_ts, 0 when it isn't
+ALTER TABLE book_user_link ADD COLUMN trashed_ts BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,11 @@
+-- journal: the intents of the operations spanning both databases and the
+-- files, completed or undone at startup when they were interrupted
+CREATE TABLE journal (
+  id INTEGER PRIMARY KEY,
+  op TEXT NOT NULL CHECK (op IN ('create_book', 'delete_book')),
+  book_id INTEGER NOT NULL DEFAULT 0,
+  user_id INTEGER NOT NULL DEFAULT 0,
+  job_id INTEGER NOT NULL DEFAULT 0,
+  payload TEXT NOT NULL DEFAULT '{}',
+  created_ts BIGINT NOT NULL DEFAULT (strftime('%s', 'now'))
+);
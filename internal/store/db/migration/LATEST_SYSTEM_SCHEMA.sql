@@ -4,6 +4,12 @@ CREATE TABLE migration_history (
   created_ts BIGINT NOT NULL DEFAULT (strftime('%s', 'now'))
 );
 
+-- migration_file: the migration files applied to the database
+CREATE TABLE migration_file (
+  name TEXT NOT NULL PRIMARY KEY,
+  created_ts BIGINT NOT NULL DEFAULT (strftime('%s', 'now'))
+);
+
 -- system_setting
 CREATE TABLE system_setting (
   name TEXT NOT NULL,
@@ -36,6 +42,7 @@ CREATE TABLE book_user_link (
   id INTEGER NOT NULL,
   user_id INTEGER,
   book_id INTEGER,
+  trashed_ts BIGINT NOT NULL DEFAULT 0,
   PRIMARY KEY (id),
   FOREIGN KEY(user_id) REFERENCES user (id),
   UNIQUE(book_id, user_id)
@@ -135,13 +142,63 @@ CREATE TABLE job (
   `path` TEXT NOT NULL,
   type TEXT NOT NULL,
   status TEXT NOT NULL DEFAULT 'pending',
+  stage TEXT NOT NULL DEFAULT 'upload',
+  payload TEXT NOT NULL DEFAULT '{}',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  error TEXT NOT NULL DEFAULT '',
+  created_ts BIGINT NOT NULL DEFAULT (strftime('%s', 'now')),
+  updated_ts BIGINT NOT NULL DEFAULT (strftime('%s', 'now')),
+  started_ts BIGINT NOT NULL DEFAULT 0,
+  finished_ts BIGINT NOT NULL DEFAULT 0,
   FOREIGN KEY (user_id) REFERENCES user(id),
   CONSTRAINT path_length CHECK (LENGTH(path) <= 255)
 );
 
+CREATE INDEX idx_job_status ON job (status);
+
+-- watched_folder
+CREATE TABLE watched_folder (
+  id INTEGER PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  `path` TEXT NOT NULL UNIQUE,
+  after_import TEXT NOT NULL CHECK (after_import IN ('keep', 'archive', 'delete')) DEFAULT 'keep',
+  archive_dir TEXT NOT NULL DEFAULT '',
+  map_tags SMALLINT NOT NULL DEFAULT 1,
+  created_ts BIGINT NOT NULL DEFAULT (strftime('%s', 'now')),
+  FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
 -- book_hash_link
 CREATE TABLE book_hash_link (
   book_id INTEGER NOT NULL,
   hash TEXT NOT NULL,
   PRIMARY KEY (book_id, hash)
 );
+
+-- book_blob
+CREATE TABLE book_blob (
+  hash TEXT NOT NULL PRIMARY KEY,
+  size BIGINT NOT NULL DEFAULT 0,
+  ref_count INTEGER NOT NULL DEFAULT 0,
+  created_ts BIGINT NOT NULL DEFAULT (strftime('%s', 'now'))
+);
+
+-- user_usage
+CREATE TABLE user_usage (
+  user_id INTEGER PRIMARY KEY,
+  bytes BIGINT NOT NULL DEFAULT 0,
+  books INTEGER NOT NULL DEFAULT 0,
+  updated_ts BIGINT NOT NULL DEFAULT (strftime('%s', 'now')),
+  FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+-- journal
+CREATE TABLE journal (
+  id INTEGER PRIMARY KEY,
+  op TEXT NOT NULL CHECK (op IN ('create_book', 'delete_book')),
+  book_id INTEGER NOT NULL DEFAULT 0,
+  user_id INTEGER NOT NULL DEFAULT 0,
+  job_id INTEGER NOT NULL DEFAULT 0,
+  payload TEXT NOT NULL DEFAULT '{}',
+  created_ts BIGINT NOT NULL DEFAULT (strftime('%s', 'now'))
+);
@@ -70,3 +70,42 @@ func (d *DB) CheckTableExists(ctx context.Context, tableName string) (bool, erro
 
 	return true, nil
 }
+
+// createMigrationFileTable is created on the databases migrated before the
+// files were recorded one by one
+const createMigrationFileTable = `
+	CREATE TABLE IF NOT EXISTS migration_file (
+		name TEXT NOT NULL PRIMARY KEY,
+		created_ts BIGINT NOT NULL DEFAULT (strftime('%s', 'now'))
+	)
+`
+
+type execer interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+}
+
+// addMigrationFile records the migration file as applied, within the
+// transaction applying it if there is one
+func addMigrationFile(ctx context.Context, e execer, name string) error {
+	_, err := e.ExecContext(ctx, "INSERT INTO migration_file (name) VALUES (?) ON CONFLICT(name) DO NOTHING", name)
+	return err
+}
+
+// ListMigrationFiles returns the migration files applied to the database
+func (d *DB) ListMigrationFiles(ctx context.Context) (map[string]bool, error) {
+	rows, err := d.DB.QueryContext(ctx, "SELECT name FROM migration_file")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		applied[name] = true
+	}
+	return applied, rows.Err()
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/version"
+)
+
+// TestMigrateRecordedVersion migrates a database recorded at the current
+// version but missing the migration files added after it was created
+func TestMigrateRecordedVersion(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	config.Opts = config.GetDefaultOptions()
+	config.Opts.Data = dir
+	config.Opts.DSN = filepath.Join(dir, "e-oasis.db")
+
+	d, err := NewDB(config.Opts.DSN, "system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A database of a build that didn't have the later files nor the
+	// migration_file table
+	for _, stmt := range []string{
+		`DROP TABLE migration_file`,
+		`DROP TABLE journal`,
+		`DROP TABLE user_usage`,
+		`ALTER TABLE book_user_link DROP COLUMN trashed_ts`,
+	} {
+		if _, err := d.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"journal", "user_usage", "watched_folder"} {
+		if exists, err := d.CheckTableExists(ctx, table); err != nil || !exists {
+			t.Fatalf("table %s exists = %v, %v", table, exists, err)
+		}
+	}
+	var trashed int
+	if err := d.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('book_user_link') WHERE name = 'trashed_ts'`).Scan(&trashed); err != nil || trashed != 1 {
+		t.Fatalf("trashed_ts columns = %d, %v", trashed, err)
+	}
+	applied, err := d.ListMigrationFiles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := getMigrationFileList(version.GetCurrentVersion()); len(applied) != len(files) {
+		t.Fatalf("applied = %v, want %v", applied, files)
+	}
+
+	// Nothing is left to apply
+	pending, err := d.listPendingMigrationFiles(ctx, version.GetCurrentVersion())
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("pending = %v, %v", pending, err)
+	}
+}
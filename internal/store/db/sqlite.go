@@ -10,6 +10,7 @@ import (
 	"os"
 	"regexp"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -127,6 +128,12 @@ func (d *DB) migrateSystem(ctx context.Context) error {
 			if err := d.applyLatestSchema(ctx); err != nil {
 				return errors.Wrap(err, "failed to apply latest schema")
 			}
+			// The latest schema has every migration file in it
+			for _, filename := range getMigrationFileList(currentVersion) {
+				if err := addMigrationFile(ctx, d.DB, migrationFileName(filename)); err != nil {
+					return errors.Wrap(err, "failed to record migration file")
+				}
+			}
 			// Upset the newest version to migration table
 			// Upsert the newest version to migration_history.
 			if _, err := d.UpsertMigrationHistory(ctx, &store.UpsertMigrationHistory{
@@ -137,66 +144,45 @@ func (d *DB) migrateSystem(ctx context.Context) error {
 		} else {
 			return errors.Wrap(err, "failed to check database file")
 		}
-	} else {
-		// If db file exist, check need to migrate or not
-		migrationHistoryList, err := d.FindMigrationHistoryList(ctx, &store.FindMigrationHistory{})
-		if err != nil {
-			return errors.Wrap(err, "failed to find migration history list")
-		}
+		return nil
+	}
 
-		// If no migration history, apply latest schema
-		if len(migrationHistoryList) == 0 {
-			minorVersion := version.GetMinorVersion(currentVersion)
-			if err := d.applyMigrationForMinorVersion(ctx, minorVersion); err != nil {
-				return errors.Wrapf(err, "failed to apply version %s migration", minorVersion)
-			}
-			_, err = d.UpsertMigrationHistory(ctx, &store.UpsertMigrationHistory{
-				Version: currentVersion,
-			})
-			if err != nil {
-				return errors.Wrap(err, "failed to upsert migration history")
-			}
-			return nil
-		}
+	// If db file exist, apply the migration files it doesn't have
+	pending, err := d.listPendingMigrationFiles(ctx, currentVersion)
+	if err != nil {
+		return errors.Wrap(err, "failed to find pending migration files")
+	}
+	if len(pending) == 0 {
+		return nil
+	}
 
-		migrationHistoryVersionList := []string{}
-		for _, migrationHistory := range migrationHistoryList {
-			migrationHistoryVersionList = append(migrationHistoryVersionList, migrationHistory.Version)
+	// Backup the raw database file before migration
+	rawBytes, err := os.ReadFile(config.Opts.DSN)
+	if err != nil {
+		return errors.Wrap(err, "failed to read raw database file")
+	}
+	backupDBFilePath := fmt.Sprintf("%s/e-oasis_%s_%d_backup.db", config.Opts.Data, version.GetCurrentVersion(), time.Now().Unix())
+	if err := os.WriteFile(backupDBFilePath, rawBytes, 0644); err != nil {
+		return errors.Wrap(err, "failed to write backup database file")
+	}
+	fmt.Println("Backup database file: ", backupDBFilePath)
+	fmt.Printf("Start migration to %s\n", currentVersion)
+	for _, filename := range pending {
+		fmt.Println("Applying migration", migrationFileName(filename))
+		if err := d.applyMigrationFile(ctx, filename); err != nil {
+			return err
 		}
-		// Sort and get the latest version
-		slices.Sort(version.SortVersion(migrationHistoryVersionList))
-		latestMigrationHistoryVersion := migrationHistoryVersionList[len(migrationHistoryVersionList)-1]
-
-		if version.IsVersionGreaterThan(version.GetSchemaVersion(currentVersion), latestMigrationHistoryVersion) {
-			minorVersionList := getMinorVersionList()
-			// Backup the raw database file before migration
-			rawBytes, err := os.ReadFile(config.Opts.DSN)
-			if err != nil {
-				return errors.Wrap(err, "failed to read raw database file")
-			}
-			backupDBFilePath := fmt.Sprintf("%s/e-oasis_%s_%d_backup.db", config.Opts.Data, version.GetCurrentVersion(), time.Now().Unix())
-			if err := os.WriteFile(backupDBFilePath, rawBytes, 0644); err != nil {
-				return errors.Wrap(err, "failed to write backup database file")
-			}
-			fmt.Println("Backup database file: ", backupDBFilePath)
-			fmt.Printf("Start migration from %s to %s", latestMigrationHistoryVersion, currentVersion)
-			for _, minorVersion := range minorVersionList {
-				// Sicnce the patch don't need to update sql
-				normalizedVersion := minorVersion + ".0"
-				if version.IsVersionGreaterThan(normalizedVersion, latestMigrationHistoryVersion) && version.IsVersionGreaterOrEqualThan(currentVersion, normalizedVersion) {
-					fmt.Println("Applying migration for", normalizedVersion)
-					if err := d.applyMigrationForMinorVersion(ctx, minorVersion); err != nil {
-						return errors.Wrap(err, "failed to apply minor version migration")
-					}
-				}
-			}
-			fmt.Println("End migrate")
+	}
+	if _, err := d.UpsertMigrationHistory(ctx, &store.UpsertMigrationHistory{
+		Version: currentVersion,
+	}); err != nil {
+		return errors.Wrap(err, "failed to upsert migration history")
+	}
+	fmt.Println("End migrate")
 
-			// Remove the created backup db file after migrate succeed.
-			if err := os.Remove(backupDBFilePath); err != nil {
-				fmt.Printf("Failed to remove temp database file, err: %v", err)
-			}
-		}
+	// Remove the created backup db file after migrate succeed.
+	if err := os.Remove(backupDBFilePath); err != nil {
+		fmt.Printf("Failed to remove temp database file, err: %v", err)
 	}
 	return nil
 }
@@ -264,39 +250,111 @@ func (d *DB) applyLatestSchema(ctx context.Context) error {
 	return nil
 }
 
-func (d *DB) applyMigrationForMinorVersion(ctx context.Context, minorVersion string) error {
-	// Get all migration files for the minor version
-	filenames, err := fs.Glob(migrationFS, fmt.Sprintf("migration/%s/*.sql", minorVersion))
+// listPendingMigrationFiles returns the migration files of the versions up to
+// currentVersion the database doesn't have. The files are recorded one by one
+// since the migration_file table, the ones applied before it are told apart
+// by the schema they create.
+func (d *DB) listPendingMigrationFiles(ctx context.Context, currentVersion string) ([]string, error) {
+	recorded, err := d.CheckTableExists(ctx, "migration_file")
 	if err != nil {
-		return errors.Wrapf(err, "Failed to find migration files for version %s", minorVersion)
+		return nil, errors.Wrap(err, "failed to check database table")
+	}
+	if !recorded {
+		if err := d.execute(ctx, createMigrationFileTable); err != nil {
+			return nil, errors.Wrap(err, "failed to create migration_file table")
+		}
+	}
+	applied, err := d.ListMigrationFiles(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list migration files")
 	}
 
-	// The filename files are sorted by name, so that they are applied in order.
-	// 10001_example.sql, 10002_example.sql, 10003_example.sql, ...
-	slices.Sort(filenames)
-	migrationStmt := ""
-
-	for _, filename := range filenames {
-		buf, err := migrationFS.ReadFile(filename)
-		if err != nil {
-			return errors.Wrapf(err, "Failed to read migration file: %q", filename)
+	pending := []string{}
+	for _, filename := range getMigrationFileList(currentVersion) {
+		name := migrationFileName(filename)
+		if applied[name] {
+			continue
 		}
-		stmt := string(buf)
-		migrationStmt += stmt
-		if err := d.execute(ctx, migrationStmt); err != nil {
-			return errors.Wrapf(err, "Failed to apply migration: %s", migrationStmt)
+		if !recorded {
+			buf, err := migrationFS.ReadFile(filename)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to read migration file: %q", filename)
+			}
+			done, err := d.isMigrationApplied(ctx, string(buf))
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to check migration %s", name)
+			}
+			if done {
+				if err := addMigrationFile(ctx, d.DB, name); err != nil {
+					return nil, errors.Wrap(err, "failed to record migration file")
+				}
+				continue
+			}
 		}
+		pending = append(pending, filename)
 	}
+	return pending, nil
+}
 
-	// Upsert the newest version to migration_history.
-	version := minorVersion + ".0"
-	if _, err := d.UpsertMigrationHistory(ctx, &store.UpsertMigrationHistory{
-		Version: version,
-	}); err != nil {
-		return errors.Wrapf(err, "Failed to upsert migration history for version %s", version)
+// applyMigrationFile applies the migration file and records it in the same
+// transaction
+func (d *DB) applyMigrationFile(ctx context.Context, filename string) error {
+	buf, err := migrationFS.ReadFile(filename)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read migration file: %q", filename)
 	}
+	stmt := string(buf)
 
-	return nil
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, stmt); err != nil {
+		return errors.Wrapf(err, "Failed to apply migration %s: %s", filename, stmt)
+	}
+	if err := addMigrationFile(ctx, tx, migrationFileName(filename)); err != nil {
+		return errors.Wrapf(err, "Failed to record migration %s", filename)
+	}
+	return tx.Commit()
+}
+
+// migrationObjectRegexp finds the first table, index or column a migration
+// creates
+var migrationObjectRegexp = regexp.MustCompile("(?i)(CREATE TABLE|CREATE INDEX|ALTER TABLE)\\s+`?(\\w+)`?(?:\\s+ADD COLUMN\\s+`?(\\w+)`?)?")
+
+// isMigrationApplied reports whether the schema has the first table, index or
+// column the migration creates
+func (d *DB) isMigrationApplied(ctx context.Context, stmt string) (bool, error) {
+	lines := []string{}
+	for _, line := range strings.Split(stmt, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	match := migrationObjectRegexp.FindStringSubmatch(strings.Join(lines, "\n"))
+	if match == nil {
+		return false, nil
+	}
+
+	var query string
+	args := []any{match[2]}
+	switch strings.ToUpper(match[1]) {
+	case "CREATE TABLE":
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	case "CREATE INDEX":
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = ?"
+	default:
+		if match[3] == "" {
+			return false, nil
+		}
+		query, args = "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", append(args, match[3])
+	}
+	var count int
+	if err := d.DB.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
 }
 
 func (d *DB) seed(ctx context.Context) error {
@@ -359,3 +417,38 @@ func getMinorVersionList() []string {
 
 	return minorVersionList
 }
+
+// getMigrationFileList returns the migration files of the minor versions up
+// to currentVersion, in the order they are applied
+func getMigrationFileList(currentVersion string) []string {
+	filenames := []string{}
+	for _, minorVersion := range getMinorVersionList() {
+		if version.IsVersionGreaterThan(minorVersion+".0", currentVersion) {
+			continue
+		}
+		files, err := fs.Glob(migrationFS, fmt.Sprintf("migration/%s/*.sql", minorVersion))
+		if err != nil {
+			panic(err)
+		}
+		// 10001_example.sql, 10002_example.sql, 10003_example.sql, ...
+		slices.Sort(files)
+		filenames = append(filenames, files...)
+	}
+	return filenames
+}
+
+// MigrationFiles returns the names of the migration files this version of
+// e-oasis applies, in the order they are applied
+func MigrationFiles() []string {
+	names := []string{}
+	for _, filename := range getMigrationFileList(version.GetCurrentVersion()) {
+		names = append(names, migrationFileName(filename))
+	}
+	return names
+}
+
+// migrationFileName is how the migration file is recorded, like
+// 0.2/10001_job.sql
+func migrationFileName(filename string) string {
+	return strings.TrimPrefix(filename, "migration/")
+}
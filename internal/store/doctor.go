@@ -0,0 +1,144 @@
+package store
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/Xunop/e-oasis/internal/model"
+)
+
+// BookLinkTables are the tables of the application database whose rows
+// belong to a book of the metadata database
+var BookLinkTables = []string{
+	"book_user_link",
+	"book_shelf_link",
+	"bookmark",
+	"duration_info",
+	"reading_status",
+	"book_hash_link",
+}
+
+// isBookLinkTable reports whether table is one of BookLinkTables, the table
+// names can't be query arguments
+func isBookLinkTable(table string) bool {
+	for _, t := range BookLinkTables {
+		if t == table {
+			return true
+		}
+	}
+	return false
+}
+
+// ListBookIDs returns the ids of every book of the metadata database
+func (s *Store) ListBookIDs() (map[int]bool, error) {
+	rows, err := s.metaDb.Query(`SELECT id FROM books`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query books")
+	}
+	defer rows.Close()
+
+	ids := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "failed to scan book id")
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// ListLinkedBookIDs returns the books the rows of a table of BookLinkTables
+// belong to
+func (s *Store) ListLinkedBookIDs(table string) ([]int, error) {
+	if !isBookLinkTable(table) {
+		return nil, errors.Errorf("%s doesn't link books", table)
+	}
+	rows, err := s.appDb.Query(`SELECT DISTINCT book_id FROM ` + table + ` ORDER BY book_id`)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query %s table", table)
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan %s table", table)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteBookLinks deletes the rows of a table of BookLinkTables belonging to
+// the book
+func (s *Store) DeleteBookLinks(table string, bookID int) error {
+	if !isBookLinkTable(table) {
+		return errors.Errorf("%s doesn't link books", table)
+	}
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+	if _, err := s.appDb.Exec(`DELETE FROM `+table+` WHERE book_id = ?`, bookID); err != nil {
+		return errors.Wrapf(err, "failed to delete from %s table", table)
+	}
+	return nil
+}
+
+// ListBookBlobs returns the blobs of the content-addressed store
+func (s *Store) ListBookBlobs() ([]*model.BookBlob, error) {
+	rows, err := s.appDb.Query(`SELECT hash, size, ref_count, created_ts FROM book_blob ORDER BY hash`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query book blobs")
+	}
+	defer rows.Close()
+
+	list := make([]*model.BookBlob, 0)
+	for rows.Next() {
+		blob := &model.BookBlob{}
+		if err := rows.Scan(&blob.Hash, &blob.Size, &blob.RefCount, &blob.CreatedTs); err != nil {
+			return nil, errors.Wrap(err, "failed to scan book blob")
+		}
+		list = append(list, blob)
+	}
+	return list, rows.Err()
+}
+
+// CountBlobOwners returns the number of users owning a book of the blob,
+// what its ref_count should be
+func (s *Store) CountBlobOwners(hash string) (int, error) {
+	stmt := `
+		SELECT COUNT(DISTINCT l.user_id)
+		FROM book_user_link l
+		JOIN book_hash_link h ON h.book_id = l.book_id
+		WHERE h.hash = ?`
+	var count int
+	if err := s.appDb.QueryRow(stmt, hash).Scan(&count); err != nil {
+		return 0, errors.Wrap(err, "failed to count blob owners")
+	}
+	return count, nil
+}
+
+// DeleteBookBlob deletes the row of a blob, its file is left
+func (s *Store) DeleteBookBlob(hash string) error {
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+	if _, err := s.appDb.Exec(`DELETE FROM book_blob WHERE hash = ?`, hash); err != nil {
+		return errors.Wrap(err, "failed to delete book blob")
+	}
+	return nil
+}
+
+// RefreshBlobRefs counts again the owners of the blob
+func (s *Store) RefreshBlobRefs(hash string) error {
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+	tx, err := s.appDb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := refreshBlobRefs(tx, `hash = ?`, hash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
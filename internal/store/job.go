@@ -1,40 +1,188 @@
 package store
 
 import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/Xunop/e-oasis/internal/log"
 	"github.com/Xunop/e-oasis/internal/model"
 )
 
-func (s *Store) ListJobs() (*[]model.Job, error) {
-	return nil, nil
+// ErrJobCanceled is returned when updating a job canceled by its user
+var ErrJobCanceled = errors.New("job canceled")
+
+const jobColumns = `id, user_id, path, type, status, stage, payload, attempts, error, created_ts, updated_ts, started_ts, finished_ts`
+
+// ListJobs returns the jobs matching find, the oldest first so that they are
+// resumed in order.
+func (s *Store) ListJobs(find *model.FindJob) ([]*model.Job, error) {
+	where, args := []string{"1 = 1"}, []any{}
+
+	if v := find.ID; v != nil {
+		where, args = append(where, "id = ?"), append(args, *v)
+	}
+	if v := find.UserID; v != nil {
+		where, args = append(where, "user_id = ?"), append(args, *v)
+	}
+	if v := find.Stage; v != nil {
+		where, args = append(where, "stage = ?"), append(args, *v)
+	}
+	if len(find.Statuses) > 0 {
+		placeholders := make([]string, len(find.Statuses))
+		for i, status := range find.Statuses {
+			placeholders[i] = "?"
+			args = append(args, status)
+		}
+		where = append(where, fmt.Sprintf("status IN (%s)", strings.Join(placeholders, ", ")))
+	}
+
+	query := `SELECT ` + jobColumns + ` FROM job WHERE ` + strings.Join(where, " AND ") + ` ORDER BY id`
+	rows, err := s.appDb.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query jobs")
+	}
+	defer rows.Close()
+
+	list := make([]*model.Job, 0)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to query jobs")
+	}
+	return list, nil
+}
+
+// GetJob returns the job id, sql.ErrNoRows when there is none
+func (s *Store) GetJob(id int) (*model.Job, error) {
+	jobs, err := s.ListJobs(&model.FindJob{ID: &id})
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return jobs[0], nil
 }
 
+// AddJob saves a new job along with its payload
 func (s *Store) AddJob(job model.Job) (*model.Job, error) {
+	payload, err := json.Marshal(job.Payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal job payload")
+	}
+	if job.Status == "" {
+		job.Status = model.JobStatusPending
+	}
+	if job.Stage == "" {
+		job.Stage = model.JobStageUpload
+	}
+	now := time.Now().Unix()
+
 	stmt := `
-    INSERT INTO job (user_id, path, type, status) VALUES (?, ?, ?, ?)
-    RETURNING id, user_id, path, type, status
-    `
+	INSERT INTO job (user_id, path, type, status, stage, payload, attempts, error, created_ts, updated_ts, started_ts, finished_ts)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	RETURNING ` + jobColumns
+
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
 
-    s.appDbLock.Lock()
-    defer s.appDbLock.Unlock()
-	tx, err := s.appDb.Begin()
+	j, err := scanJob(s.appDb.QueryRow(stmt, job.UserID, job.Path, job.Type, job.Status, job.Stage, string(payload),
+		job.Attempts, job.Error, now, now, job.StartedTs, job.FinishedTs))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to add job")
+	}
+	s.JobCache.Store(j.ID, j)
+	return j, nil
+}
+
+// UpdateJob saves the status, stage, payload and progress of a job. The jobs
+// canceled by their user are left alone, ErrJobCanceled is returned.
+func (s *Store) UpdateJob(job model.Job) (*model.Job, error) {
+	payload, err := json.Marshal(job.Payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal job payload")
+	}
+
+	stmt := `
+	UPDATE job
+	SET status = ?, stage = ?, payload = ?, attempts = ?, error = ?, updated_ts = ?, started_ts = ?, finished_ts = ?
+	WHERE id = ? AND status != ?
+	RETURNING ` + jobColumns
+
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+
+	j, err := scanJob(s.appDb.QueryRow(stmt, job.Status, job.Stage, string(payload), job.Attempts, job.Error,
+		time.Now().Unix(), job.StartedTs, job.FinishedTs, job.ID, model.JobStatusCanceled))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobCanceled
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to update job %d", job.ID)
+	}
+	s.JobCache.Store(j.ID, j)
+	return j, nil
+}
+
+// CancelJob cancels a job that isn't finished, sql.ErrNoRows is returned for
+// the finished ones. The worker running the job stops at its next step.
+func (s *Store) CancelJob(id int) (*model.Job, error) {
+	now := time.Now().Unix()
+	stmt := `
+	UPDATE job
+	SET status = ?, error = '', updated_ts = ?, finished_ts = ?
+	WHERE id = ? AND status IN (?, ?)
+	RETURNING ` + jobColumns
+
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+
+	j, err := scanJob(s.appDb.QueryRow(stmt, model.JobStatusCanceled, now, now, id, model.JobStatusPending, model.JobStatusRunning))
 	if err != nil {
 		return nil, err
 	}
+	s.JobCache.Store(j.ID, j)
+	return j, nil
+}
 
-	var j model.Job
-	if err := tx.QueryRow(stmt, job.UserID, job.Path, job.Type, job.Status).Scan(
-		&j.ID, &j.UserID, &j.Path, &j.Type, &j.Status,
-	); err != nil {
-        tx.Rollback()
-        return nil, err
-    }
-    if err := tx.Commit(); err != nil {
-        return nil, err
-    }
-
-	return &j, nil
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
-func (s *Store) UpdateJob(job model.Job) (*model.Job, error) {
-	return nil, nil
+func scanJob(row rowScanner) (*model.Job, error) {
+	var job model.Job
+	var payload string
+	if err := row.Scan(
+		&job.ID,
+		&job.UserID,
+		&job.Path,
+		&job.Type,
+		&job.Status,
+		&job.Stage,
+		&payload,
+		&job.Attempts,
+		&job.Error,
+		&job.CreatedTs,
+		&job.UpdatedTs,
+		&job.StartedTs,
+		&job.FinishedTs,
+	); err != nil {
+		return nil, err
+	}
+	// A broken payload only keeps the job from being resumed
+	if err := json.Unmarshal([]byte(payload), &job.Payload); err != nil {
+		log.Warn("Invalid job payload", zap.Int("job_id", job.ID), zap.Error(err))
+	}
+	return &job, nil
 }
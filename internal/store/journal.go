@@ -0,0 +1,121 @@
+package store
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/Xunop/e-oasis/internal/model"
+)
+
+const journalColumns = `id, op, book_id, user_id, job_id, payload, created_ts`
+
+// AddJournalEntry writes the intent of an operation before it starts
+func (s *Store) AddJournalEntry(entry *model.JournalEntry) (*model.JournalEntry, error) {
+	payload, err := json.Marshal(entry.Payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal journal payload")
+	}
+	stmt := `
+		INSERT INTO journal (op, book_id, user_id, job_id, payload)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING ` + journalColumns
+
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+	e, err := scanJournalEntry(s.appDb.QueryRow(stmt, entry.Op, entry.BookID, entry.UserID, entry.JobID, string(payload)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to add journal entry")
+	}
+	return e, nil
+}
+
+// SetJournalBook records the book an operation created
+func (s *Store) SetJournalBook(id, bookID int) error {
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+	if _, err := s.appDb.Exec(`UPDATE journal SET book_id = ? WHERE id = ?`, bookID, id); err != nil {
+		return errors.Wrap(err, "failed to update journal entry")
+	}
+	return nil
+}
+
+// DeleteJournalEntry removes the intent of an operation once it is done
+func (s *Store) DeleteJournalEntry(id int) error {
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+	if _, err := s.appDb.Exec(`DELETE FROM journal WHERE id = ?`, id); err != nil {
+		return errors.Wrap(err, "failed to delete journal entry")
+	}
+	return nil
+}
+
+// DeleteBookJournal removes the intents of the operations op on the book,
+// for the operations finished away from where they started
+func (s *Store) DeleteBookJournal(op string, bookID int) error {
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+	if _, err := s.appDb.Exec(`DELETE FROM journal WHERE op = ? AND book_id = ?`, op, bookID); err != nil {
+		return errors.Wrap(err, "failed to delete journal entry")
+	}
+	return nil
+}
+
+// ListJournalEntries returns the operations not done yet, the oldest first
+func (s *Store) ListJournalEntries() ([]*model.JournalEntry, error) {
+	rows, err := s.appDb.Query(`SELECT ` + journalColumns + ` FROM journal ORDER BY id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query journal")
+	}
+	defer rows.Close()
+
+	list := make([]*model.JournalEntry, 0)
+	for rows.Next() {
+		entry, err := scanJournalEntry(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan journal entry")
+		}
+		list = append(list, entry)
+	}
+	return list, rows.Err()
+}
+
+func scanJournalEntry(row interface{ Scan(...any) error }) (*model.JournalEntry, error) {
+	entry := &model.JournalEntry{}
+	var payload string
+	if err := row.Scan(&entry.ID, &entry.Op, &entry.BookID, &entry.UserID, &entry.JobID, &payload, &entry.CreatedTs); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(payload), &entry.Payload); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal journal payload")
+	}
+	return entry, nil
+}
+
+// GetBookIDByPath returns the book whose file is path
+func (s *Store) GetBookIDByPath(path string) (int, bool) {
+	var bookID int
+	if err := s.metaDb.QueryRow(`SELECT id FROM books WHERE path = ?`, path).Scan(&bookID); err != nil {
+		return 0, false
+	}
+	return bookID, true
+}
+
+// listBookHashes returns the hashes the book is found by
+func (s *Store) listBookHashes(bookID int) ([]string, error) {
+	rows, err := s.appDb.Query(`SELECT hash FROM book_hash_link WHERE book_id = ?`, bookID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query book hashes")
+	}
+	defer rows.Close()
+
+	hashes := make([]string, 0)
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, errors.Wrap(err, "failed to scan book hash")
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
@@ -0,0 +1,215 @@
+package store
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+
+	"github.com/Xunop/e-oasis/internal/model"
+)
+
+// GetSystemQuotaSetting returns the default quota, no limit until it is set
+func (s *Store) GetSystemQuotaSetting() (*model.SystemSettingQuota, error) {
+	systemSetting, err := s.GetSystemSetting(model.SettingTypeQuota)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &model.SystemSettingQuota{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get system quota setting")
+	}
+	quota, err := systemSetting.GetQuota()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal system quota setting")
+	}
+	return quota, nil
+}
+
+func (s *Store) UpsetQuotaSettings(settings *model.SystemSettingQuota) (*model.SystemSettingQuota, error) {
+	_, err := s.UpsetSystemSetting(&model.SystemSetting{
+		Name:  model.SettingTypeQuota,
+		Value: settings.ToJSON(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to upset quota settings")
+	}
+	return settings, nil
+}
+
+// GetUserQuotaSetting returns the quota set for the user, empty when the
+// user has the default one
+func (s *Store) GetUserQuotaSetting(userID int32) (*model.QuotaUserSetting, error) {
+	userSetting, err := s.GetUserSetting(&model.FindUserSetting{
+		UserID: &userID,
+		Key:    model.UserSettingKey_USER_SETTING_QUOTA,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get user quota")
+	}
+	if userSetting == nil {
+		return &model.QuotaUserSetting{}, nil
+	}
+	quota := userSetting.GetQuota()
+	if quota == nil {
+		return nil, errors.New("invalid user quota")
+	}
+	return quota, nil
+}
+
+func (s *Store) UpsetUserQuotaSetting(userID int32, quota *model.QuotaUserSetting) (*model.QuotaUserSetting, error) {
+	_, err := s.UpsertUserSetting(&model.UserSetting{
+		UserID: userID,
+		Key:    model.UserSettingKey_USER_SETTING_QUOTA,
+		Value:  quota.String(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to upset user quota")
+	}
+	return quota, nil
+}
+
+// GetUserQuota returns the quota of the user, the default quota overridden
+// by the one set for the user
+func (s *Store) GetUserQuota(userID int32) (*model.Quota, error) {
+	defaults, err := s.GetSystemQuotaSetting()
+	if err != nil {
+		return nil, err
+	}
+	quota := &model.Quota{MaxBytes: defaults.MaxBytes, MaxBooks: defaults.MaxBooks}
+
+	own, err := s.GetUserQuotaSetting(userID)
+	if err != nil {
+		return nil, err
+	}
+	if own.MaxBytes != nil {
+		quota.MaxBytes = *own.MaxBytes
+	}
+	if own.MaxBooks != nil {
+		quota.MaxBooks = *own.MaxBooks
+	}
+	return quota, nil
+}
+
+const userUsageColumns = `user_id, bytes, books, updated_ts`
+
+// GetUserUsage returns the usage of the user, nothing used when it wasn't
+// counted yet
+func (s *Store) GetUserUsage(userID int) (*model.Usage, error) {
+	usage := &model.Usage{UserID: userID}
+	err := s.appDb.QueryRow(`SELECT `+userUsageColumns+` FROM user_usage WHERE user_id = ?`, userID).
+		Scan(&usage.UserID, &usage.Bytes, &usage.Books, &usage.UpdatedTs)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "failed to get user usage")
+	}
+	return usage, nil
+}
+
+// ListUserUsage returns the usage of the users it was counted for
+func (s *Store) ListUserUsage() ([]*model.Usage, error) {
+	rows, err := s.appDb.Query(`SELECT ` + userUsageColumns + ` FROM user_usage ORDER BY user_id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query user usage")
+	}
+	defer rows.Close()
+
+	list := make([]*model.Usage, 0)
+	for rows.Next() {
+		usage := &model.Usage{}
+		if err := rows.Scan(&usage.UserID, &usage.Bytes, &usage.Books, &usage.UpdatedTs); err != nil {
+			return nil, errors.Wrap(err, "failed to scan user usage")
+		}
+		list = append(list, usage)
+	}
+	return list, rows.Err()
+}
+
+// SaveUserUsage replaces the usage of the user
+func (s *Store) SaveUserUsage(usage *model.Usage) error {
+	stmt := `
+		INSERT INTO user_usage (user_id, bytes, books, updated_ts)
+		VALUES (?, ?, ?, strftime('%s', 'now'))
+		ON CONFLICT(user_id) DO UPDATE SET
+			bytes = excluded.bytes,
+			books = excluded.books,
+			updated_ts = excluded.updated_ts`
+
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+	if _, err := s.appDb.Exec(stmt, usage.UserID, usage.Bytes, usage.Books); err != nil {
+		return errors.Wrap(err, "failed to save user usage")
+	}
+	return nil
+}
+
+// AddUserUsage adds books and bytes to the usage of the user
+func (s *Store) AddUserUsage(userID, books int, bytes int64) error {
+	stmt := `
+		INSERT INTO user_usage (user_id, bytes, books, updated_ts)
+		VALUES (?, ?, ?, strftime('%s', 'now'))
+		ON CONFLICT(user_id) DO UPDATE SET
+			bytes = bytes + excluded.bytes,
+			books = books + excluded.books,
+			updated_ts = excluded.updated_ts`
+
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+	if _, err := s.appDb.Exec(stmt, userID, bytes, books); err != nil {
+		return errors.Wrap(err, "failed to update user usage")
+	}
+	return nil
+}
+
+// ListUserBookSizes returns the sizes of the books of the user kept in the
+// content-addressed store, by book ID
+func (s *Store) ListUserBookSizes(userID int) (map[int]int64, error) {
+	stmt := `
+		SELECT l.book_id, MAX(b.size)
+		FROM book_user_link l
+		JOIN book_hash_link h ON h.book_id = l.book_id
+		JOIN book_blob b ON b.hash = h.hash
+		WHERE l.user_id = ?
+		GROUP BY l.book_id`
+	rows, err := s.appDb.Query(stmt, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query book sizes")
+	}
+	defer rows.Close()
+
+	sizes := make(map[int]int64)
+	for rows.Next() {
+		var bookID int
+		var size int64
+		if err := rows.Scan(&bookID, &size); err != nil {
+			return nil, errors.Wrap(err, "failed to scan book size")
+		}
+		sizes[bookID] = size
+	}
+	return sizes, rows.Err()
+}
+
+// GetStoredBytes returns the size of the content-addressed store
+func (s *Store) GetStoredBytes() (int64, error) {
+	var size int64
+	if err := s.appDb.QueryRow(`SELECT COALESCE(SUM(size), 0) FROM book_blob`).Scan(&size); err != nil {
+		return 0, errors.Wrap(err, "failed to sum blob sizes")
+	}
+	return size, nil
+}
+
+// ListBookOwners returns the users owning the book
+func (s *Store) ListBookOwners(bookID int) ([]int, error) {
+	rows, err := s.appDb.Query(`SELECT user_id FROM book_user_link WHERE book_id = ? ORDER BY id`, bookID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query book owners")
+	}
+	defer rows.Close()
+
+	owners := make([]int, 0)
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, errors.Wrap(err, "failed to scan book owner")
+		}
+		owners = append(owners, userID)
+	}
+	return owners, rows.Err()
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/pkg/errors"
+)
+
+func (s *Store) AddSeries(series *model.Series) (*model.Series, error) {
+	if sID, ok := s.CheckSeries(series.Name); ok {
+		series.ID = sID
+		return series, nil
+	}
+	stmt := `
+	    INSERT INTO series (
+	    name, sort
+	    ) VALUES (?,?) RETURNING id,name,sort`
+	args := []any{}
+
+	args = append(args, series.Name)
+	args = append(args, series.Sort)
+
+	s.metaDbLock.Lock()
+	defer s.metaDbLock.Unlock()
+
+	tx, err := s.metaDb.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug("SQL query and args:")
+	log.Fallback("Debug", fmt.Sprintf("query: %s\nargs: %s\n", stmt, args))
+
+	newSeries := model.Series{Index: series.Index}
+	if err := tx.QueryRow(stmt, args...).Scan(&newSeries.ID, &newSeries.Name, &newSeries.Sort); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &newSeries, nil
+}
+
+func (s *Store) CheckSeries(name string) (int, bool) {
+	stmt := `SELECT id FROM series WHERE name = ?`
+	args := []any{name}
+
+	var seriesID int
+	if err := s.metaDb.QueryRow(stmt, args...).Scan(&seriesID); err != nil {
+		return 0, false
+	}
+	return seriesID, true
+}
+
+// SetBookSeries links a book to a series, replacing its previous series, and
+// sets the position of the book in it.
+func (s *Store) SetBookSeries(bookID int, series *model.Series) error {
+	s.metaDbLock.Lock()
+	defer s.metaDbLock.Unlock()
+
+	tx, err := s.metaDb.Begin()
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	linkStmt := `INSERT INTO books_series_link (book, series) VALUES (?, ?)
+				 ON CONFLICT(book) DO UPDATE SET series = excluded.series`
+	log.Debug("SQL query and args:")
+	log.Fallback("Debug", fmt.Sprintf("query: %s\nargs: %v\n", linkStmt, []any{bookID, series.ID}))
+	if _, err := tx.Exec(linkStmt, bookID, series.ID); err != nil {
+		return errors.Wrap(err, "failed to link book to series")
+	}
+
+	if _, err := tx.Exec(`UPDATE books SET series_index = ? WHERE id = ?`, series.Index, bookID); err != nil {
+		return errors.Wrap(err, "failed to set series index")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+
+	if cache, ok := s.BookCache.Load(bookID); ok {
+		cache.(*model.Book).SeriesIndex = series.Index
+	}
+	s.writeBookSidecar(bookID)
+	return nil
+}
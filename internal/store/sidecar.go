@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/storage"
+	"github.com/Xunop/e-oasis/internal/util/opf"
+	"go.uber.org/zap"
+)
+
+// writeBookSidecar writes the metadata.opf of a book next to its file, so
+// that the metadata survives the loss of the database. It's called whenever
+// the metadata of a book changes. A failure is only logged, the database
+// stays the reference. Books of an imported library are left alone, calibre
+// keeps their sidecar itself.
+func (s *Store) writeBookSidecar(bookID int) {
+	meta, err := s.GetBookMeta(bookID)
+	if err != nil {
+		log.Warn("Failed to get book metadata for its sidecar", zap.Int("book_id", bookID), zap.Error(err))
+		return
+	}
+	if meta == nil || meta.Book.Path == "" || !isDataPath(meta.Book.Path) {
+		return
+	}
+
+	// The directory of a book only in the storage isn't on the disk
+	dir := filepath.Dir(meta.Book.Path)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		log.Warn("Failed to create book directory for its sidecar", zap.Int("book_id", bookID), zap.Error(err))
+		return
+	}
+	if err := opf.WriteFile(dir, meta); err != nil {
+		log.Warn("Failed to write book sidecar", zap.Int("book_id", bookID), zap.String("path", meta.Book.Path), zap.Error(err))
+		return
+	}
+	if err := storage.PutFile(context.Background(), storage.Default, filepath.Join(dir, opf.FileName)); err != nil {
+		log.Warn("Failed to store book sidecar", zap.Int("book_id", bookID), zap.Error(err))
+	}
+}
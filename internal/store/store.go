@@ -5,6 +5,8 @@ import (
 	"sync"
 )
 
+// Store is the application and the metadata databases. A method holding
+// both locks takes metaDbLock first.
 type Store struct {
 	appDb              *sql.DB    // appDb is web server database
 	appDbLock          sync.Mutex // appDbLock is used to lock appDb
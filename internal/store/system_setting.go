@@ -105,6 +105,20 @@ func (s *Store) UpsetSystemSetting(setting *model.SystemSetting) (*model.SystemS
 			return nil, err
 		}
 		value, err = json.Marshal(plugins)
+	case setting.Name == model.SettingTypeQuota:
+		log.Debug("Setting type is quota")
+		quota, err := setting.GetQuota()
+		if err != nil {
+			return nil, err
+		}
+		value, err = json.Marshal(quota)
+	case setting.Name == model.SettingTypeTrash:
+		log.Debug("Setting type is trash")
+		trash, err := setting.GetTrash()
+		if err != nil {
+			return nil, err
+		}
+		value, err = json.Marshal(trash)
 	case setting.Name == model.SettingTypeCustom:
 		log.Debug("Setting type is custom")
 		custom, err := setting.GetCustom()
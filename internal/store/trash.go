@@ -0,0 +1,168 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/Xunop/e-oasis/internal/model"
+)
+
+// GetTrashSetting returns the retention of the trash, the default one until
+// it is set
+func (s *Store) GetTrashSetting() (*model.SystemSettingTrash, error) {
+	systemSetting, err := s.GetSystemSetting(model.SettingTypeTrash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &model.SystemSettingTrash{RetentionDays: model.DefaultTrashRetentionDays}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get trash setting")
+	}
+	trash, err := systemSetting.GetTrash()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal trash setting")
+	}
+	return trash, nil
+}
+
+func (s *Store) UpsetTrashSettings(settings *model.SystemSettingTrash) (*model.SystemSettingTrash, error) {
+	_, err := s.UpsetSystemSetting(&model.SystemSetting{
+		Name:  model.SettingTypeTrash,
+		Value: settings.ToJSON(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to upset trash settings")
+	}
+	return settings, nil
+}
+
+// TrashBook moves the book to the trash of the user, or of all its owners
+// when userID is nil. It returns the number of links moved.
+func (s *Store) TrashBook(bookID int, userID *int) (int, error) {
+	return s.setBookTrashed(bookID, userID, true)
+}
+
+// RestoreBook takes the book out of the trash of the user, or of all its
+// owners when userID is nil. It returns the number of links restored.
+func (s *Store) RestoreBook(bookID int, userID *int) (int, error) {
+	return s.setBookTrashed(bookID, userID, false)
+}
+
+func (s *Store) setBookTrashed(bookID int, userID *int, trashed bool) (int, error) {
+	stmt := `UPDATE book_user_link SET trashed_ts = strftime('%s', 'now') WHERE book_id = ? AND trashed_ts = 0`
+	if !trashed {
+		stmt = `UPDATE book_user_link SET trashed_ts = 0 WHERE book_id = ? AND trashed_ts > 0`
+	}
+	args := []any{bookID}
+	if userID != nil {
+		stmt, args = stmt+` AND user_id = ?`, append(args, *userID)
+	}
+
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+	res, err := s.appDb.Exec(stmt, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to update book trash")
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// ListTrashedBooks returns the books of the trash, the oldest first. Their
+// Book is not filled.
+func (s *Store) ListTrashedBooks(find *model.FindTrashedBook) ([]*model.TrashedBook, error) {
+	where, args := []string{"trashed_ts > 0"}, []any{}
+	if v := find.BookID; v != nil {
+		where, args = append(where, "book_id = ?"), append(args, *v)
+	}
+	if v := find.UserID; v != nil {
+		where, args = append(where, "user_id = ?"), append(args, *v)
+	}
+	if v := find.TrashedBefore; v != nil {
+		where, args = append(where, "trashed_ts < ?"), append(args, *v)
+	}
+
+	stmt := `SELECT book_id, user_id, trashed_ts FROM book_user_link WHERE ` +
+		strings.Join(where, " AND ") + ` ORDER BY trashed_ts, id`
+	rows, err := s.appDb.Query(stmt, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query trashed books")
+	}
+	defer rows.Close()
+
+	list := make([]*model.TrashedBook, 0)
+	for rows.Next() {
+		trashed := &model.TrashedBook{}
+		if err := rows.Scan(&trashed.BookID, &trashed.UserID, &trashed.TrashedTs); err != nil {
+			return nil, errors.Wrap(err, "failed to scan trashed book")
+		}
+		list = append(list, trashed)
+	}
+	return list, rows.Err()
+}
+
+// listTrashedBookIDs returns the books in the trash of the user. Without a
+// user, a book is in the trash once every owner moved it there.
+func (s *Store) listTrashedBookIDs(userID *int) (map[int]bool, error) {
+	stmt := `SELECT book_id FROM book_user_link GROUP BY book_id HAVING MIN(trashed_ts) > 0`
+	args := []any{}
+	if userID != nil {
+		stmt, args = `SELECT book_id FROM book_user_link WHERE user_id = ? AND trashed_ts > 0`, append(args, *userID)
+	}
+	rows, err := s.appDb.Query(stmt, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query trashed books")
+	}
+	defer rows.Close()
+
+	ids := make(map[int]bool)
+	for rows.Next() {
+		var bookID int
+		if err := rows.Scan(&bookID); err != nil {
+			return nil, errors.Wrap(err, "failed to scan trashed book")
+		}
+		ids[bookID] = true
+	}
+	return ids, rows.Err()
+}
+
+// IsBookTrashed reports whether every owner of the book moved it to the
+// trash, or the user when userID is set and they moved their copy there
+func (s *Store) IsBookTrashed(bookID int, userID *int) (bool, error) {
+	var trashed bool
+	stmt := `SELECT COUNT(*) > 0 AND MIN(trashed_ts) > 0 FROM book_user_link WHERE book_id = ?`
+	if err := s.appDb.QueryRow(stmt, bookID).Scan(&trashed); err != nil {
+		return false, errors.Wrap(err, "failed to check book trash")
+	}
+	if trashed || userID == nil {
+		return trashed, nil
+	}
+	stmt = `SELECT COUNT(*) > 0 FROM book_user_link WHERE book_id = ? AND user_id = ? AND trashed_ts > 0`
+	if err := s.appDb.QueryRow(stmt, bookID, *userID).Scan(&trashed); err != nil {
+		return false, errors.Wrap(err, "failed to check book trash")
+	}
+	return trashed, nil
+}
+
+// HideTrashedBooks removes the books in the trash of the user from list, for
+// the lists of the whole library
+func (s *Store) HideTrashedBooks(list []*model.Book, userID int) ([]*model.Book, error) {
+	ids, err := s.listTrashedBookIDs(&userID)
+	if err != nil {
+		return nil, err
+	}
+	return filterTrashedBooks(list, ids, false), nil
+}
+
+// filterTrashedBooks keeps the books in the trash when trashed is true and
+// the others when it is false
+func filterTrashedBooks(list []*model.Book, ids map[int]bool, trashed bool) []*model.Book {
+	kept := make([]*model.Book, 0, len(list))
+	for _, book := range list {
+		if ids[book.ID] == trashed {
+			kept = append(kept, book)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/Xunop/e-oasis/internal/model"
+)
+
+const watchedFolderColumns = `id, user_id, path, after_import, archive_dir, map_tags, created_ts`
+
+// ListWatchedFolders returns the watched folders matching find
+func (s *Store) ListWatchedFolders(find *model.FindWatchedFolder) ([]*model.WatchedFolder, error) {
+	where, args := []string{"1 = 1"}, []any{}
+
+	if v := find.ID; v != nil {
+		where, args = append(where, "id = ?"), append(args, *v)
+	}
+	if v := find.UserID; v != nil {
+		where, args = append(where, "user_id = ?"), append(args, *v)
+	}
+
+	query := `SELECT ` + watchedFolderColumns + ` FROM watched_folder WHERE ` + strings.Join(where, " AND ") + ` ORDER BY id`
+	rows, err := s.appDb.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query watched folders")
+	}
+	defer rows.Close()
+
+	list := make([]*model.WatchedFolder, 0)
+	for rows.Next() {
+		folder, err := scanWatchedFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, folder)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to query watched folders")
+	}
+	return list, nil
+}
+
+// GetWatchedFolder returns the watched folder id, sql.ErrNoRows when there
+// is none
+func (s *Store) GetWatchedFolder(id int) (*model.WatchedFolder, error) {
+	folders, err := s.ListWatchedFolders(&model.FindWatchedFolder{ID: &id})
+	if err != nil {
+		return nil, err
+	}
+	if len(folders) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return folders[0], nil
+}
+
+// AddWatchedFolder saves a new watched folder
+func (s *Store) AddWatchedFolder(folder *model.WatchedFolder) (*model.WatchedFolder, error) {
+	if folder.AfterImport == "" {
+		folder.AfterImport = model.AfterImportKeep
+	}
+	stmt := `
+	INSERT INTO watched_folder (user_id, path, after_import, archive_dir, map_tags)
+	VALUES (?, ?, ?, ?, ?)
+	RETURNING ` + watchedFolderColumns
+
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+
+	f, err := scanWatchedFolder(s.appDb.QueryRow(stmt, folder.UserID, folder.Path, folder.AfterImport, folder.ArchiveDir, folder.MapTags))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to add watched folder")
+	}
+	return f, nil
+}
+
+// DeleteWatchedFolder forgets the watched folder id, its files are left alone
+func (s *Store) DeleteWatchedFolder(id int) error {
+	s.appDbLock.Lock()
+	defer s.appDbLock.Unlock()
+
+	if _, err := s.appDb.Exec(`DELETE FROM watched_folder WHERE id = ?`, id); err != nil {
+		return errors.Wrap(err, "failed to delete watched folder")
+	}
+	return nil
+}
+
+func scanWatchedFolder(row rowScanner) (*model.WatchedFolder, error) {
+	folder := &model.WatchedFolder{}
+	if err := row.Scan(&folder.ID, &folder.UserID, &folder.Path, &folder.AfterImport, &folder.ArchiveDir,
+		&folder.MapTags, &folder.CreatedTs); err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
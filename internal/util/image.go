@@ -0,0 +1,89 @@
+package util
+
+import (
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+
+	"github.com/chai2010/webp"
+	"github.com/pkg/errors"
+)
+
+// ImageToWebpWidth converts the image src to a webp file at dest, scaled
+// down to width when the image is wider. A width of 0 keeps the size. The
+// file is written next to dest first so that readers never see half of it.
+func ImageToWebpWidth(src, dest string, width int, quality float32) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to open image")
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode image")
+	}
+	if width > 0 && img.Bounds().Dx() > width {
+		img = ScaleImage(img, width)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".webp-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create webp file")
+	}
+	defer os.Remove(tmp.Name())
+	if err := webp.Encode(tmp, img, &webp.Options{Quality: quality}); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to encode webp")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write webp file")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), dest), "failed to write webp file")
+}
+
+// ScaleImage scales img to width keeping its aspect ratio. Every pixel is
+// the average of the pixels it covers, which is good enough to shrink covers.
+func ScaleImage(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if width <= 0 || b.Dx() == 0 || b.Dy() == 0 {
+		return img
+	}
+	height := b.Dy() * width / b.Dx()
+	if height == 0 {
+		height = 1
+	}
+
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0, y1 := y*b.Dy()/height, (y+1)*b.Dy()/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*b.Dx()/width, (x+1)*b.Dx()/width
+			if x1 == x0 {
+				x1++
+			}
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := y*dst.Stride + x*4
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
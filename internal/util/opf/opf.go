@@ -0,0 +1,342 @@
+// Package opf reads and writes metadata.opf, the sidecar calibre keeps next
+// to every book so that the library can be rebuilt from the filesystem.
+package opf // import "github.com/Xunop/e-oasis/internal/util/opf"
+
+import (
+	"encoding/xml"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/pkg/errors"
+)
+
+// FileName is the name of the sidecar in the book directory
+const FileName = "metadata.opf"
+
+const (
+	nsOPF = "http://www.idpf.org/2007/opf"
+	nsDC  = "http://purl.org/dc/elements/1.1/"
+)
+
+// The elements are written with literal prefixes like calibre does,
+// encoding/xml would declare the namespace on every element otherwise.
+type xmlPackage struct {
+	XMLName          xml.Name    `xml:"package"`
+	Xmlns            string      `xml:"xmlns,attr"`
+	UniqueIdentifier string      `xml:"unique-identifier,attr"`
+	Version          string      `xml:"version,attr"`
+	Metadata         xmlMetadata `xml:"metadata"`
+	Guide            *xmlGuide   `xml:"guide,omitempty"`
+}
+
+type xmlMetadata struct {
+	XmlnsDC      string          `xml:"xmlns:dc,attr"`
+	XmlnsOPF     string          `xml:"xmlns:opf,attr"`
+	Identifiers  []xmlIdentifier `xml:"dc:identifier"`
+	Title        string          `xml:"dc:title"`
+	Creators     []xmlCreator    `xml:"dc:creator"`
+	Contributors []xmlCreator    `xml:"dc:contributor"`
+	Date         string          `xml:"dc:date,omitempty"`
+	Description  string          `xml:"dc:description,omitempty"`
+	Publisher    string          `xml:"dc:publisher,omitempty"`
+	Languages    []string        `xml:"dc:language"`
+	Subjects     []string        `xml:"dc:subject"`
+	Meta         []xmlMeta       `xml:"meta"`
+}
+
+type xmlIdentifier struct {
+	ID     string `xml:"id,attr,omitempty"`
+	Scheme string `xml:"opf:scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type xmlCreator struct {
+	FileAs string `xml:"opf:file-as,attr,omitempty"`
+	Role   string `xml:"opf:role,attr"`
+	Name   string `xml:",chardata"`
+}
+
+type xmlMeta struct {
+	Name    string `xml:"name,attr"`
+	Content string `xml:"content,attr"`
+}
+
+type xmlGuide struct {
+	References []xmlReference `xml:"reference"`
+}
+
+type xmlReference struct {
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr"`
+	Href  string `xml:"href,attr"`
+}
+
+// opfPackage is what is read back, names are matched whatever their prefix
+type opfPackage struct {
+	Metadata struct {
+		Identifiers []struct {
+			Scheme string `xml:"scheme,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"identifier"`
+		Title        string       `xml:"title"`
+		Creators     []opfCreator `xml:"creator"`
+		Contributors []opfCreator `xml:"contributor"`
+		Date         string       `xml:"date"`
+		Description  string       `xml:"description"`
+		Publisher    string       `xml:"publisher"`
+		Languages    []string     `xml:"language"`
+		Subjects     []string     `xml:"subject"`
+		Meta         []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Guide struct {
+		References []xmlReference `xml:"reference"`
+	} `xml:"guide"`
+}
+
+type opfCreator struct {
+	FileAs string `xml:"file-as,attr"`
+	Role   string `xml:"role,attr"`
+	Name   string `xml:",chardata"`
+}
+
+// Marshal returns the OPF document of a book
+func Marshal(meta *model.BookMeta) ([]byte, error) {
+	if meta == nil || meta.Book == nil {
+		return nil, errors.New("no book to marshal")
+	}
+	book := meta.Book
+
+	pkg := xmlPackage{
+		Xmlns:            nsOPF,
+		UniqueIdentifier: "uuid_id",
+		Version:          "2.0",
+		Metadata: xmlMetadata{
+			XmlnsDC:     nsDC,
+			XmlnsOPF:    nsOPF,
+			Title:       book.Title,
+			Date:        book.PublishDate,
+			Description: meta.Description,
+			Languages:   []string{},
+			Subjects:    []string{},
+		},
+	}
+	m := &pkg.Metadata
+
+	m.Identifiers = append(m.Identifiers, xmlIdentifier{ID: "calibre_id", Scheme: "calibre", Value: strconv.Itoa(book.ID)})
+	if book.UUID != "" {
+		m.Identifiers = append(m.Identifiers, xmlIdentifier{ID: "uuid_id", Scheme: "uuid", Value: book.UUID})
+	}
+	identifiers := map[string]string{}
+	for typ, val := range meta.Identifiers {
+		identifiers[strings.ToLower(typ)] = val
+	}
+	if _, ok := identifiers["isbn"]; !ok && book.ISBN != "" {
+		identifiers["isbn"] = book.ISBN
+	}
+	types := make([]string, 0, len(identifiers))
+	for typ := range identifiers {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		m.Identifiers = append(m.Identifiers, xmlIdentifier{Scheme: strings.ToUpper(typ), Value: identifiers[typ]})
+	}
+
+	for _, author := range meta.Authors {
+		creator := xmlCreator{FileAs: author.Sort, Role: author.Role, Name: author.Name}
+		if author.IsPrimary() {
+			creator.Role = model.AuthorRoleAuthor
+			m.Creators = append(m.Creators, creator)
+		} else {
+			m.Contributors = append(m.Contributors, creator)
+		}
+	}
+
+	if meta.Publisher != nil {
+		m.Publisher = meta.Publisher.Name
+	}
+	for _, lang := range meta.Languages {
+		m.Languages = append(m.Languages, lang.LangCode)
+	}
+	m.Subjects = append(m.Subjects, meta.Tags...)
+
+	if meta.Series != nil && meta.Series.Name != "" {
+		index := meta.Series.Index
+		if index == 0 {
+			index = book.SeriesIndex
+		}
+		m.Meta = append(m.Meta,
+			xmlMeta{Name: "calibre:series", Content: meta.Series.Name},
+			xmlMeta{Name: "calibre:series_index", Content: strconv.FormatFloat(index, 'f', -1, 64)})
+	}
+	if meta.Rating > 0 {
+		m.Meta = append(m.Meta, xmlMeta{Name: "calibre:rating", Content: strconv.Itoa(meta.Rating)})
+	}
+	if book.TimeStamp != "" {
+		m.Meta = append(m.Meta, xmlMeta{Name: "calibre:timestamp", Content: book.TimeStamp})
+	}
+	if book.SortTitle != "" {
+		m.Meta = append(m.Meta, xmlMeta{Name: "calibre:title_sort", Content: book.SortTitle})
+	}
+
+	if book.HasCover {
+		pkg.Guide = &xmlGuide{References: []xmlReference{{Type: "cover", Title: "Cover", Href: "cover.webp"}}}
+	}
+
+	data, err := xml.MarshalIndent(pkg, "", "    ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal opf")
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// Unmarshal reads the metadata of a book from an OPF document, written by
+// Marshal or by calibre. Book.ID is the calibre id of the book, 0 if unknown.
+func Unmarshal(data []byte) (*model.BookMeta, error) {
+	var pkg opfPackage
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal opf")
+	}
+	m := &pkg.Metadata
+
+	meta := &model.BookMeta{
+		Book: &model.Book{
+			Title:       strings.TrimSpace(m.Title),
+			PublishDate: strings.TrimSpace(m.Date),
+		},
+		Description: strings.TrimSpace(m.Description),
+		Identifiers: map[string]string{},
+	}
+	book := meta.Book
+
+	for _, identifier := range m.Identifiers {
+		typ, val := strings.ToLower(strings.TrimSpace(identifier.Scheme)), strings.TrimSpace(identifier.Value)
+		switch {
+		case typ == "" || val == "":
+		case typ == "calibre":
+			book.ID, _ = strconv.Atoi(val)
+		case typ == "uuid":
+			book.UUID = val
+		default:
+			meta.Identifiers[typ] = val
+		}
+	}
+	book.ISBN = meta.Identifiers["isbn"]
+
+	var authorSorts []string
+	add := func(creator opfCreator, contributor bool) {
+		author := &model.Author{
+			Name: strings.TrimSpace(creator.Name),
+			Sort: strings.TrimSpace(creator.FileAs),
+			Role: strings.TrimSpace(creator.Role),
+		}
+		if author.Name == "" || (contributor && (author.Role == "" || author.Role == "bkp")) {
+			return
+		}
+		if author.Role == "" {
+			author.Role = model.AuthorRoleAuthor
+		}
+		if author.Sort == "" {
+			author.Sort = author.Name
+		}
+		if author.IsPrimary() {
+			authorSorts = append(authorSorts, author.Sort)
+		}
+		meta.Authors = append(meta.Authors, author)
+	}
+	for _, creator := range m.Creators {
+		add(creator, false)
+	}
+	for _, creator := range m.Contributors {
+		add(creator, true)
+	}
+	book.AuthorSort = strings.Join(authorSorts, " & ")
+
+	if publisher := strings.TrimSpace(m.Publisher); publisher != "" {
+		meta.Publisher = &model.Publisher{Name: publisher}
+	}
+	for _, lang := range m.Languages {
+		if lang = strings.TrimSpace(lang); lang != "" {
+			meta.Languages = append(meta.Languages, &model.Language{LangCode: lang})
+		}
+	}
+	for _, subject := range m.Subjects {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			meta.Tags = append(meta.Tags, subject)
+		}
+	}
+
+	var series string
+	index := 1.0
+	for _, field := range m.Meta {
+		content := strings.TrimSpace(field.Content)
+		switch field.Name {
+		case "calibre:series":
+			series = content
+		case "calibre:series_index":
+			if v, err := strconv.ParseFloat(content, 64); err == nil {
+				index = v
+			}
+		case "calibre:rating":
+			// calibre writes the rating as a float
+			if v, err := strconv.ParseFloat(content, 64); err == nil && v >= 0 && v <= 10 {
+				meta.Rating = int(math.Round(v))
+			}
+		case "calibre:timestamp":
+			book.TimeStamp = content
+		case "calibre:title_sort":
+			book.SortTitle = content
+		}
+	}
+	if series != "" {
+		meta.Series = &model.Series{Name: series, Sort: series, Index: index}
+		book.SeriesIndex = index
+	}
+
+	for _, ref := range pkg.Guide.References {
+		if ref.Type == "cover" {
+			book.HasCover = true
+		}
+	}
+	return meta, nil
+}
+
+// WriteFile writes the sidecar of a book into dir. The file is replaced
+// atomically so that a crash never leaves a truncated sidecar behind.
+func WriteFile(dir string, meta *model.BookMeta) error {
+	data, err := Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, FileName+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create opf")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write opf")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write opf")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), filepath.Join(dir, FileName)), "failed to replace opf")
+}
+
+// ReadFile reads the sidecar in dir
+func ReadFile(dir string) (*model.BookMeta, error) {
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		return nil, err
+	}
+	return Unmarshal(data)
+}
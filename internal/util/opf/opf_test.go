@@ -0,0 +1,109 @@
+package opf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Xunop/e-oasis/internal/model"
+)
+
+func TestRoundTrip(t *testing.T) {
+	meta := &model.BookMeta{
+		Book: &model.Book{
+			ID:          42,
+			Title:       "The Book & Co",
+			SortTitle:   "Book & Co, The",
+			TimeStamp:   "2024-01-02T03:04:05Z",
+			PublishDate: "2020-01-01T00:00:00Z",
+			SeriesIndex: 2.5,
+			UUID:        "1b4e28ba-2fa1-11d2-883f-0016d3cca427",
+			HasCover:    true,
+		},
+		Publisher: &model.Publisher{Name: "Publisher"},
+		Languages: []*model.Language{{LangCode: "eng"}, {LangCode: "fra"}},
+		Authors: []*model.Author{
+			{Name: "Jane Doe", Sort: "Doe, Jane", Role: model.AuthorRoleAuthor},
+			{Name: "Some Editor", Sort: "Editor, Some", Role: model.AuthorRoleEditor},
+			{Name: "John Roe", Sort: "Roe, John", Role: model.AuthorRoleAuthor},
+		},
+		Series:      &model.Series{Name: "The Series"},
+		Description: "<p>A description</p>",
+		Tags:        []string{"Fiction", "Adventure"},
+		Identifiers: map[string]string{"isbn": "9780101010101", "goodreads": "12345"},
+		Rating:      8,
+	}
+
+	data, err := Marshal(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<dc:identifier id="calibre_id" opf:scheme="calibre">42</dc:identifier>`,
+		`<dc:creator opf:file-as="Doe, Jane" opf:role="aut">Jane Doe</dc:creator>`,
+		`<dc:contributor opf:file-as="Editor, Some" opf:role="edt">Some Editor</dc:contributor>`,
+		`<meta name="calibre:series_index" content="2.5"></meta>`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("missing %s in\n%s", want, data)
+		}
+	}
+
+	got, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := got.Book
+	if book.ID != 42 || book.Title != meta.Book.Title || book.SortTitle != meta.Book.SortTitle ||
+		book.TimeStamp != meta.Book.TimeStamp || book.PublishDate != meta.Book.PublishDate ||
+		book.UUID != meta.Book.UUID || book.ISBN != "9780101010101" || !book.HasCover {
+		t.Errorf("book = %+v", book)
+	}
+	if book.AuthorSort != "Doe, Jane & Roe, John" {
+		t.Errorf("author sort = %q", book.AuthorSort)
+	}
+	if len(got.Authors) != 3 || got.Authors[2].Name != "Some Editor" || got.Authors[2].Role != model.AuthorRoleEditor {
+		t.Errorf("authors = %+v", got.Authors)
+	}
+	if got.Publisher == nil || got.Publisher.Name != "Publisher" || got.Description != meta.Description || got.Rating != 8 {
+		t.Errorf("meta = %+v", got)
+	}
+	if len(got.Languages) != 2 || got.Languages[1].LangCode != "fra" || strings.Join(got.Tags, ",") != "Fiction,Adventure" {
+		t.Errorf("languages = %v, tags = %v", got.Languages, got.Tags)
+	}
+	if got.Series == nil || got.Series.Name != "The Series" || got.Series.Index != 2.5 {
+		t.Errorf("series = %+v", got.Series)
+	}
+	if len(got.Identifiers) != 2 || got.Identifiers["goodreads"] != "12345" {
+		t.Errorf("identifiers = %v", got.Identifiers)
+	}
+}
+
+func TestUnmarshalCalibre(t *testing.T) {
+	data := `<?xml version='1.0' encoding='utf-8'?>
+<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="uuid_id" version="2.0">
+    <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+        <dc:identifier opf:scheme="calibre" id="calibre_id">7</dc:identifier>
+        <dc:identifier opf:scheme="uuid" id="uuid_id">abc</dc:identifier>
+        <dc:title>Calibre Book</dc:title>
+        <dc:creator opf:file-as="Doe, Jane" opf:role="aut">Jane Doe</dc:creator>
+        <dc:contributor opf:file-as="calibre" opf:role="bkp">calibre (7.0.0) [https://calibre-ebook.com]</dc:contributor>
+        <dc:identifier opf:scheme="ISBN">9780101010101</dc:identifier>
+        <dc:language>eng</dc:language>
+        <meta name="calibre:rating" content="6.0"/>
+    </metadata>
+    <guide>
+        <reference type="cover" title="Cover" href="cover.jpg"/>
+    </guide>
+</package>`
+
+	meta, err := Unmarshal([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Book.ID != 7 || meta.Book.UUID != "abc" || meta.Book.ISBN != "9780101010101" || !meta.Book.HasCover {
+		t.Errorf("book = %+v", meta.Book)
+	}
+	if len(meta.Authors) != 1 || meta.Authors[0].Sort != "Doe, Jane" || meta.Rating != 6 {
+		t.Errorf("authors = %+v, rating = %d", meta.Authors, meta.Rating)
+	}
+}
@@ -0,0 +1,267 @@
+package comic // import "github.com/Xunop/e-oasis/internal/util/parsers/comic"
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/nwaples/rardecode/v2"
+)
+
+// archive is the common view over the zip, rar and 7z containers
+type archive interface {
+	// Names returns the names of the regular files in the archive
+	Names() []string
+	// ReadFile returns the content of the named file
+	ReadFile(name string) ([]byte, error)
+	// Walk calls fn for every regular file in the archive order, it stops
+	// without error when fn returns io.EOF.
+	Walk(fn func(name string, r io.Reader) error) error
+	Close() error
+}
+
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	rarMagic      = []byte("Rar!\x1a\x07")
+	sevenZipMagic = []byte("7z\xbc\xaf\x27\x1c")
+)
+
+// IsRar reports whether data starts with a RAR signature
+func IsRar(data []byte) bool {
+	return bytes.HasPrefix(data, rarMagic)
+}
+
+// IsSevenZip reports whether data starts with a 7z signature
+func IsSevenZip(data []byte) bool {
+	return bytes.HasPrefix(data, sevenZipMagic)
+}
+
+// openArchive opens f based on its signature rather than its extension,
+// since comics renamed from cbr to cbz and back are common.
+func openArchive(f string) (archive, error) {
+	fd, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 8)
+	n, _ := io.ReadFull(fd, magic)
+	fd.Close()
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		r, err := zip.OpenReader(f)
+		if err != nil {
+			return nil, err
+		}
+		return &zipArchive{r: r}, nil
+	case IsRar(magic):
+		return openRarArchive(f)
+	case IsSevenZip(magic):
+		r, err := sevenzip.OpenReader(f)
+		if err != nil {
+			return nil, err
+		}
+		return &sevenZipArchive{r: r}, nil
+	}
+	return nil, fmt.Errorf("comic: unsupported archive format")
+}
+
+// skipName reports whether an entry is metadata left by the archiver
+func skipName(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if part == "__MACOSX" || strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+type zipArchive struct {
+	r *zip.ReadCloser
+}
+
+func (a *zipArchive) Names() []string {
+	var names []string
+	for _, f := range a.r.File {
+		if !f.FileInfo().IsDir() && !skipName(f.Name) {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+func (a *zipArchive) ReadFile(name string) ([]byte, error) {
+	for _, f := range a.r.File {
+		if f.Name == name {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return io.ReadAll(rc)
+		}
+	}
+	return nil, fmt.Errorf("file not found: %s", name)
+}
+
+func (a *zipArchive) Walk(fn func(name string, r io.Reader) error) error {
+	for _, f := range a.r.File {
+		if f.FileInfo().IsDir() || skipName(f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(f.Name, rc)
+		rc.Close()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *zipArchive) Close() error {
+	return a.r.Close()
+}
+
+type sevenZipArchive struct {
+	r *sevenzip.ReadCloser
+}
+
+func (a *sevenZipArchive) Names() []string {
+	var names []string
+	for _, f := range a.r.File {
+		if !f.FileInfo().IsDir() && !skipName(f.Name) {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+func (a *sevenZipArchive) ReadFile(name string) ([]byte, error) {
+	for _, f := range a.r.File {
+		if f.Name == name {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return io.ReadAll(rc)
+		}
+	}
+	return nil, fmt.Errorf("file not found: %s", name)
+}
+
+func (a *sevenZipArchive) Walk(fn func(name string, r io.Reader) error) error {
+	for _, f := range a.r.File {
+		if f.FileInfo().IsDir() || skipName(f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(f.Name, rc)
+		rc.Close()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *sevenZipArchive) Close() error {
+	return a.r.Close()
+}
+
+// rarArchive can only be read sequentially, so the archive is reopened for
+// every pass over it.
+type rarArchive struct {
+	path  string
+	names []string
+}
+
+func openRarArchive(f string) (*rarArchive, error) {
+	a := &rarArchive{path: f}
+	err := a.Walk(func(name string, _ io.Reader) error {
+		a.names = append(a.names, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *rarArchive) Names() []string {
+	return a.names
+}
+
+func (a *rarArchive) ReadFile(name string) ([]byte, error) {
+	var data []byte
+	err := a.Walk(func(n string, r io.Reader) error {
+		if n != name {
+			return nil
+		}
+		var err error
+		data, err = io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return io.EOF
+	})
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("file not found: %s", name)
+	}
+	return data, nil
+}
+
+func (a *rarArchive) Walk(fn func(name string, r io.Reader) error) error {
+	rc, err := rardecode.OpenReader(a.path)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	for {
+		h, err := rc.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// RAR archives made on Windows may use backslashes.
+		name := path.Clean(strings.ReplaceAll(h.Name, "\\", "/"))
+		if h.IsDir || skipName(name) {
+			continue
+		}
+		if err := fn(name, rc); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (a *rarArchive) Close() error {
+	return nil
+}
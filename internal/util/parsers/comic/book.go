@@ -0,0 +1,280 @@
+package comic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Book is the main struct that holds the metadata of the comic archive
+type Book struct {
+	// Info is nil when the archive has no ComicInfo.xml
+	Info *ComicInfo `json:"comic_info"`
+	// Images lists the pages of the comic in natural sort order
+	Images []string `json:"images"`
+
+	arc archive
+}
+
+var imageExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".bmp": true,
+}
+
+func isImage(name string) bool {
+	return imageExts[strings.ToLower(path.Ext(name))]
+}
+
+func isComicInfo(name string) bool {
+	return strings.EqualFold(path.Base(name), "ComicInfo.xml")
+}
+
+// Open opens a cbz, cbr or cb7 file
+func Open(f string) (*Book, error) {
+	arc, err := openArchive(f)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Book{arc: arc}
+	var infoName string
+	for _, name := range arc.Names() {
+		switch {
+		case isImage(name):
+			b.Images = append(b.Images, name)
+		// Prefer the ComicInfo.xml at the root over nested ones.
+		case isComicInfo(name) && (infoName == "" || !strings.Contains(name, "/")):
+			infoName = name
+		}
+	}
+	if len(b.Images) == 0 {
+		arc.Close()
+		return nil, fmt.Errorf("comic: no images in archive")
+	}
+	sort.SliceStable(b.Images, func(i, j int) bool {
+		return naturalLess(b.Images[i], b.Images[j])
+	})
+
+	if infoName != "" {
+		data, err := arc.ReadFile(infoName)
+		if err != nil {
+			arc.Close()
+			return nil, err
+		}
+		var info ComicInfo
+		// A broken ComicInfo.xml shouldn't make the comic unreadable.
+		if err := xml.Unmarshal(data, &info); err == nil {
+			b.Info = &info
+		}
+	}
+	return b, nil
+}
+
+// Close closes the comic archive
+func (p *Book) Close() error {
+	return p.arc.Close()
+}
+
+// info returns the ComicInfo, or an empty one when the archive has none
+func (p *Book) info() *ComicInfo {
+	if p.Info == nil {
+		return &ComicInfo{}
+	}
+	return p.Info
+}
+
+// GetTitle returns the issue title, or "Series #Number" for untitled issues
+func (p *Book) GetTitle() string {
+	info := p.info()
+	if title := strings.TrimSpace(info.Title); title != "" {
+		return title
+	}
+	series := p.GetSeries()
+	if series == "" {
+		return ""
+	}
+	if number := strings.TrimSpace(info.Number); number != "" {
+		return fmt.Sprintf("%s #%s", series, number)
+	}
+	return series
+}
+
+func (p *Book) GetSeries() string {
+	return strings.TrimSpace(p.info().Series)
+}
+
+// GetNumber returns the issue number as a series index. Numbers such as
+// "12a" use their numeric prefix, ok is false when there is none.
+func (p *Book) GetNumber() (float64, bool) {
+	number := strings.TrimSpace(p.info().Number)
+	end := 0
+	for end < len(number) && (isDigit(number[end]) || number[end] == '.' || (end == 0 && number[end] == '-')) {
+		end++
+	}
+	n, err := strconv.ParseFloat(strings.TrimRight(number[:end], "."), 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// splitList splits the comma separated lists used by ComicInfo
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func (p *Book) GetWriters() []string {
+	return splitList(p.info().Writer)
+}
+
+func (p *Book) GetPencillers() []string {
+	return splitList(p.info().Penciller)
+}
+
+// GetAuthor returns the writers, or the pencillers of comics credited to
+// their artists only.
+func (p *Book) GetAuthor() string {
+	if writers := p.GetWriters(); len(writers) > 0 {
+		return strings.Join(writers, " & ")
+	}
+	return strings.Join(p.GetPencillers(), " & ")
+}
+
+func (p *Book) GetPublisher() string {
+	return strings.TrimSpace(p.info().Publisher)
+}
+
+func (p *Book) GetDescription() string {
+	return strings.TrimSpace(p.info().Summary)
+}
+
+func (p *Book) GetLanguage() string {
+	return strings.TrimSpace(p.info().LanguageISO)
+}
+
+// GetTags returns the genres and tags of the comic
+func (p *Book) GetTags() []string {
+	return append(splitList(p.info().Genre), splitList(p.info().Tags)...)
+}
+
+// GetDate returns the cover date in RFC3339 format
+func (p *Book) GetDate() string {
+	info := p.info()
+	if info.Year <= 0 {
+		return ""
+	}
+	month, day := info.Month, info.Day
+	if month < 1 || month > 12 {
+		month = 1
+	}
+	if day < 1 || day > 31 {
+		day = 1
+	}
+	return time.Date(info.Year, time.Month(month), day, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+}
+
+// coverImage returns the page marked as front cover, or the first page
+func (p *Book) coverImage() string {
+	for _, page := range p.info().Pages {
+		if page.Type == "FrontCover" && page.Image >= 0 && page.Image < len(p.Images) {
+			return p.Images[page.Image]
+		}
+	}
+	return p.Images[0]
+}
+
+// GetCover copy the cover image to the destination and return the path
+func (p *Book) GetCover(dest string) (string, error) {
+	name := p.coverImage()
+	data, err := p.arc.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(dest); os.IsNotExist(err) {
+		return "", fmt.Errorf("Please make sure dirctory exist")
+	}
+	fileDest := filepath.Join(dest, "cover"+strings.ToLower(path.Ext(name)))
+	if err := os.WriteFile(fileDest, data, 0644); err != nil {
+		return "", err
+	}
+	return fileDest, nil
+}
+
+// GetImage copy the page name to the destination and return the path
+func (p *Book) GetImage(name, dest string) (string, error) {
+	found := false
+	for _, image := range p.Images {
+		found = found || image == name
+	}
+	if !found {
+		return "", fmt.Errorf("comic: image not found: %s", name)
+	}
+
+	data, err := p.arc.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	fileDest := filepath.Join(dest, "image"+strings.ToLower(path.Ext(name)))
+	if err := os.WriteFile(fileDest, data, 0644); err != nil {
+		return "", err
+	}
+	return fileDest, nil
+}
+
+// Hash returns a sha256 over the pages of the comic at path. Each page is
+// hashed on its own and the digests are combined in name order, so neither
+// the archive format, the entry order nor ComicInfo.xml edits change it.
+func Hash(f string) (string, error) {
+	arc, err := openArchive(f)
+	if err != nil {
+		return "", err
+	}
+	defer arc.Close()
+
+	digests := map[string]string{}
+	err = arc.Walk(func(name string, r io.Reader) error {
+		if !isImage(name) {
+			return nil
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return err
+		}
+		digests[name] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(digests) == 0 {
+		return "", fmt.Errorf("comic: no images in archive")
+	}
+
+	names := make([]string, 0, len(digests))
+	for name := range digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	for _, name := range names {
+		io.WriteString(hash, name)
+		io.WriteString(hash, digests[name])
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
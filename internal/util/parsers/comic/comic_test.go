@@ -0,0 +1,151 @@
+package comic
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+const testComicInfo = `<?xml version="1.0" encoding="utf-8"?>
+<ComicInfo xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <Series>Test Series</Series>
+  <Number>12.5</Number>
+  <Summary>An issue.</Summary>
+  <Year>2020</Year>
+  <Month>7</Month>
+  <Writer>Jane Doe, John Roe</Writer>
+  <Penciller>Ann Artist</Penciller>
+  <Publisher>Acme Comics</Publisher>
+  <Genre>Superhero</Genre>
+  <LanguageISO>en</LanguageISO>
+</ComicInfo>`
+
+func pageImage(shade uint8) []byte {
+	img := image.NewGray(image.Rect(0, 0, 1, 1))
+	img.SetGray(0, 0, color.Gray{Y: shade})
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// createCbz writes a cbz with the given files, in the given order
+func createCbz(n string, files [][2]string) error {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range files {
+		fw, err := w.Create(f[0])
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write([]byte(f[1])); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(n, buf.Bytes(), 0644)
+}
+
+func TestComic(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "test.cbz")
+	err := createCbz(f, [][2]string{
+		{"page10.png", string(pageImage(10))},
+		{"page2.png", string(pageImage(2))},
+		{"page1.png", string(pageImage(1))},
+		{"__MACOSX/._page1.png", "junk"},
+		{"ComicInfo.xml", testComicInfo},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if got := b.Images; len(got) != 3 || got[0] != "page1.png" || got[2] != "page10.png" {
+		t.Errorf("images = %q", got)
+	}
+	if got := b.GetTitle(); got != "Test Series #12.5" {
+		t.Errorf("title = %q", got)
+	}
+	if got, ok := b.GetNumber(); !ok || got != 12.5 {
+		t.Errorf("number = %v, %v", got, ok)
+	}
+	if got := b.GetAuthor(); got != "Jane Doe & John Roe" {
+		t.Errorf("author = %q", got)
+	}
+	if got := b.GetPencillers(); len(got) != 1 || got[0] != "Ann Artist" {
+		t.Errorf("pencillers = %q", got)
+	}
+	if got := b.GetPublisher(); got != "Acme Comics" {
+		t.Errorf("publisher = %q", got)
+	}
+	if got := b.GetDate(); got != "2020-07-01T00:00:00Z" {
+		t.Errorf("date = %q", got)
+	}
+	if got := b.GetTags(); len(got) != 1 || got[0] != "Superhero" {
+		t.Errorf("tags = %q", got)
+	}
+
+	dir := t.TempDir()
+	cover, err := b.GetCover(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(cover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, pageImage(1)) {
+		t.Error("cover isn't the first page in natural order")
+	}
+}
+
+func TestHash(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.cbz"), filepath.Join(dir, "b.cbz")
+	pages := [][2]string{
+		{"01.png", string(pageImage(1))},
+		{"02.png", string(pageImage(2))},
+	}
+	if err := createCbz(a, append(pages, [2]string{"ComicInfo.xml", testComicInfo})); err != nil {
+		t.Fatal(err)
+	}
+	// Same pages in another order and without metadata.
+	if err := createCbz(b, [][2]string{pages[1], pages[0]}); err != nil {
+		t.Fatal(err)
+	}
+
+	ha, err := Hash(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb, err := Hash(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ha != hb {
+		t.Errorf("hash depends on entry order or metadata: %s != %s", ha, hb)
+	}
+}
+
+func TestNaturalLess(t *testing.T) {
+	names := []string{"p10.jpg", "P2.jpg", "p1.jpg", "p01a.jpg", "cover.jpg", "p1b.jpg"}
+	sort.Slice(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+	want := []string{"cover.jpg", "p1.jpg", "p1b.jpg", "p01a.jpg", "P2.jpg", "p10.jpg"}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("sorted = %q, want %q", names, want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package comic
+
+import (
+	"encoding/xml"
+)
+
+// ComicInfo is the ComicInfo.xml metadata file, see
+// https://anansi-project.github.io/docs/comicinfo/schemas/v2.0
+type ComicInfo struct {
+	XMLName     xml.Name `xml:"ComicInfo"`
+	Title       string   `xml:"Title"`
+	Series      string   `xml:"Series"`
+	Number      string   `xml:"Number"`
+	Count       int      `xml:"Count"`
+	Volume      int      `xml:"Volume"`
+	Summary     string   `xml:"Summary"`
+	Year        int      `xml:"Year"`
+	Month       int      `xml:"Month"`
+	Day         int      `xml:"Day"`
+	Writer      string   `xml:"Writer"`
+	Penciller   string   `xml:"Penciller"`
+	Inker       string   `xml:"Inker"`
+	Colorist    string   `xml:"Colorist"`
+	Letterer    string   `xml:"Letterer"`
+	CoverArtist string   `xml:"CoverArtist"`
+	Editor      string   `xml:"Editor"`
+	Publisher   string   `xml:"Publisher"`
+	Imprint     string   `xml:"Imprint"`
+	Genre       string   `xml:"Genre"`
+	Tags        string   `xml:"Tags"`
+	Web         string   `xml:"Web"`
+	LanguageISO string   `xml:"LanguageISO"`
+	GTIN        string   `xml:"GTIN"`
+	Pages       []Page   `xml:"Pages>Page"`
+}
+
+// Page describes one image of the comic
+type Page struct {
+	Image int    `xml:"Image,attr"`
+	Type  string `xml:"Type,attr"`
+}
@@ -0,0 +1,91 @@
+package comic
+
+import (
+	"bytes"
+
+	"github.com/Xunop/e-oasis/internal/util/parsers"
+)
+
+func init() {
+	parsers.Register(parser{})
+}
+
+type parser struct{}
+
+func (parser) Name() string         { return "comic" }
+func (parser) Extensions() []string { return []string{".cbz", ".cbr", ".cb7"} }
+func (parser) MIMETypes() []string {
+	return []string{"application/vnd.comicbook+zip", "application/vnd.comicbook-rar",
+		"application/x-cbr", "application/x-rar-compressed", "application/x-7z-compressed"}
+}
+
+// Detect accepts any of the three containers whatever the extension says,
+// comics renamed from cbr to cbz and back are common.
+func (parser) Detect(head []byte) bool {
+	return bytes.HasPrefix(head, zipMagic) || IsRar(head) || IsSevenZip(head)
+}
+
+func (parser) Metadata(path string) (*parsers.Metadata, error) {
+	book, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+
+	meta := &parsers.Metadata{
+		Title:       book.GetTitle(),
+		Publisher:   book.GetPublisher(),
+		Description: book.GetDescription(),
+		Tags:        book.GetTags(),
+		Series:      book.GetSeries(),
+		Date:        book.GetDate(),
+	}
+	// Comics credited to their artists only have them as authors
+	writers := book.GetWriters()
+	if len(writers) > 0 {
+		meta.Creators = parsers.Authors(writers...)
+		for _, penciller := range book.GetPencillers() {
+			meta.Creators = append(meta.Creators, parsers.Creator{Name: penciller, Role: "ill"})
+		}
+	} else {
+		meta.Creators = parsers.Authors(book.GetPencillers()...)
+	}
+	if number, ok := book.GetNumber(); ok {
+		meta.SeriesIndex = number
+	}
+	if lang := book.GetLanguage(); lang != "" {
+		meta.Languages = []string{lang}
+	}
+	return meta, nil
+}
+
+func (parser) Cover(path, dest string) (string, error) {
+	book, err := Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer book.Close()
+	return book.GetCover(dest)
+}
+
+func (parser) Images(path string) ([]string, error) {
+	book, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+	return book.Images, nil
+}
+
+func (parser) Image(path, name, dest string) (string, error) {
+	book, err := Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer book.Close()
+	return book.GetImage(name, dest)
+}
+
+func (parser) Hash(path string) (string, error) {
+	return Hash(path)
+}
@@ -218,3 +218,35 @@ func (p *Book) GetContent(href string) (string, error) {
 	}
 	return "", fmt.Errorf("content not found: %s", href)
 }
+
+// GetImages returns the images of the manifest, with their path in the epub
+func (p *Book) GetImages() []string {
+	var images []string
+	for _, m := range p.Opf.Manifest {
+		if strings.HasPrefix(m.MediaType, "image/") {
+			images = append(images, p.filename(m.Href))
+		}
+	}
+	return images
+}
+
+// GetImage copy the image name to the destination and return the path
+func (p *Book) GetImage(name, dest string) (string, error) {
+	found := false
+	for _, image := range p.GetImages() {
+		found = found || image == name
+	}
+	if !found {
+		return "", fmt.Errorf("image not found: %s", name)
+	}
+
+	data, err := p.readBytes(name)
+	if err != nil {
+		return "", err
+	}
+	fileDest := filepath.Join(dest, "image"+strings.ToLower(path.Ext(name)))
+	if err := os.WriteFile(fileDest, data, 0644); err != nil {
+		return "", err
+	}
+	return fileDest, nil
+}
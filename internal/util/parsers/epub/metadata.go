@@ -0,0 +1,209 @@
+package epub
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	isbnRegexp = regexp.MustCompile(`^(97[89])?\d{9}[\dXx]$`)
+)
+
+// identifierPrefixes are the schemes recognized in front of a value, as in
+// "isbn:9780101010101", when the identifier has no opf:scheme.
+var identifierPrefixes = []string{"isbn", "uuid", "asin", "amazon", "mobi-asin", "goodreads", "google", "doi", "issn"}
+
+// GetLanguages returns every dc:language of the book in order
+func (p *Book) GetLanguages() []string {
+	var languages []string
+	for _, lang := range p.Opf.Metadata.Language {
+		if lang = strings.TrimSpace(lang); lang != "" {
+			languages = append(languages, lang)
+		}
+	}
+	return languages
+}
+
+// GetSubjects returns the dc:subject of the book, the tags in calibre
+func (p *Book) GetSubjects() []string {
+	var subjects []string
+	for _, subject := range p.Opf.Metadata.Subject {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			subjects = append(subjects, subject)
+		}
+	}
+	return subjects
+}
+
+// GetIdentifiers returns every dc:identifier of the book keyed by its type
+// (isbn, uuid, amazon, goodreads...). The type comes from opf:scheme, from a
+// urn or scheme prefix of the value, or is guessed from the value itself;
+// identifiers whose type can't be told are left out.
+func (p *Book) GetIdentifiers() map[string]string {
+	identifiers := map[string]string{}
+	for _, identifier := range p.Opf.Metadata.Identifier {
+		typ, val := parseIdentifier(identifier)
+		if typ == "" || val == "" {
+			continue
+		}
+		// The first identifier of a type wins, like GetISBN
+		if _, ok := identifiers[typ]; !ok {
+			identifiers[typ] = val
+		}
+	}
+	return identifiers
+}
+
+func parseIdentifier(identifier Identifier) (string, string) {
+	typ := strings.ToLower(strings.TrimSpace(identifier.Scheme))
+	val := strings.TrimSpace(identifier.Data)
+
+	if strings.HasPrefix(strings.ToLower(val), "urn:") {
+		if nid, nss, ok := strings.Cut(val[len("urn:"):], ":"); ok {
+			if typ == "" {
+				typ = strings.ToLower(nid)
+			}
+			val = nss
+		}
+	} else if prefix, rest, ok := strings.Cut(val, ":"); ok {
+		for _, known := range identifierPrefixes {
+			if strings.EqualFold(prefix, known) {
+				if typ == "" {
+					typ = known
+				}
+				val = strings.TrimSpace(rest)
+				break
+			}
+		}
+	}
+
+	if typ == "" {
+		switch {
+		case isbnRegexp.MatchString(strings.ReplaceAll(val, "-", "")):
+			typ = "isbn"
+		case uuidRegexp.MatchString(val):
+			typ = "uuid"
+		}
+	}
+
+	switch typ {
+	case "isbn":
+		val = strings.ReplaceAll(strings.ReplaceAll(val, "-", ""), " ", "")
+	case "asin":
+		typ = "amazon"
+	case "calibre":
+		// The row id of the book in the library it was exported from, it
+		// means nothing here.
+		return "", ""
+	}
+	return typ, val
+}
+
+// GetSeries returns the series of the book and its position in it, read from
+// the calibre:series metadata or from an EPUB3 belongs-to-collection. The
+// index is 0 when the book has none.
+func (p *Book) GetSeries() (name string, index float64, ok bool) {
+	var rawIndex string
+	for _, meta := range p.Opf.Metadata.Meta {
+		switch meta.Name {
+		case "calibre:series":
+			name = strings.TrimSpace(meta.Content)
+		case "calibre:series_index":
+			rawIndex = meta.Content
+		}
+	}
+
+	if name == "" {
+		for _, collection := range p.Opf.Metadata.Meta {
+			if collection.Property != "belongs-to-collection" || strings.TrimSpace(collection.Data) == "" {
+				continue
+			}
+
+			typ, position := "", ""
+			if collection.ID != "" {
+				for _, meta := range p.Opf.Metadata.Meta {
+					if meta.Refines != "#"+collection.ID {
+						continue
+					}
+					switch meta.Property {
+					case "collection-type":
+						typ = strings.TrimSpace(meta.Data)
+					case "group-position":
+						position = meta.Data
+					}
+				}
+			}
+			// A "set" collection groups books that aren't read in order
+			if typ != "" && typ != "series" {
+				continue
+			}
+			name, rawIndex = strings.TrimSpace(collection.Data), position
+			break
+		}
+	}
+
+	if name == "" {
+		return "", 0, false
+	}
+	index, _ = strconv.ParseFloat(strings.TrimSpace(rawIndex), 64)
+	return name, index, true
+}
+
+// Creator is an author or a contributor of the book with its role
+type Creator struct {
+	Name string
+	// FileAs is the name as it should be sorted, from opf:file-as
+	FileAs string
+	// Role is the MARC relator code, e.g. aut, edt, trl or ill
+	Role string
+}
+
+// GetCreators returns the dc:creator of the book in order, followed by the
+// dc:contributor credited for the content. EPUB3 roles and file-as refining the
+// element are taken into account. Creators without a role are authors.
+func (p *Book) GetCreators() []Creator {
+	var creators []Creator
+	add := func(author Author, contributor bool) {
+		name := strings.TrimSpace(author.Data)
+		if name == "" {
+			return
+		}
+		creator := Creator{Name: name, FileAs: strings.TrimSpace(author.FileAs), Role: strings.TrimSpace(author.Role)}
+		if author.ID != "" {
+			for _, meta := range p.Opf.Metadata.Meta {
+				if meta.Refines != "#"+author.ID {
+					continue
+				}
+				switch meta.Property {
+				case "role":
+					if creator.Role == "" {
+						creator.Role = strings.TrimSpace(meta.Data)
+					}
+				case "file-as":
+					if creator.FileAs == "" {
+						creator.FileAs = strings.TrimSpace(meta.Data)
+					}
+				}
+			}
+		}
+		// Contributors without a role, and book producers, are mostly the
+		// tools that made the book
+		if contributor && (creator.Role == "" || creator.Role == "bkp") {
+			return
+		}
+		if creator.Role == "" {
+			creator.Role = "aut"
+		}
+		creators = append(creators, creator)
+	}
+
+	for _, author := range p.Opf.Metadata.Creator {
+		add(author, false)
+	}
+	for _, author := range p.Opf.Metadata.Contributor {
+		add(author, true)
+	}
+	return creators
+}
@@ -0,0 +1,177 @@
+package epub
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testContainer = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`
+
+func writeTestEpub(t *testing.T, metadata string) string {
+	t.Helper()
+
+	f := filepath.Join(t.TempDir(), "test.epub")
+	fd, err := os.Create(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	w := zip.NewWriter(fd)
+	files := []struct{ name, body string }{
+		{"mimetype", "application/epub+zip"},
+		{"META-INF/container.xml", testContainer},
+		{"OEBPS/content.opf", `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+` + metadata + `
+  </metadata>
+  <manifest/>
+  <spine/>
+</package>`},
+	}
+	for _, file := range files {
+		fw, err := w.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(file.body))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestMetadata(t *testing.T) {
+	f := writeTestEpub(t, `
+    <dc:title>Test title</dc:title>
+    <dc:language>en-US</dc:language>
+    <dc:language>fr</dc:language>
+    <dc:subject>Fiction</dc:subject>
+    <dc:subject> </dc:subject>
+    <dc:subject>Adventure</dc:subject>
+    <dc:identifier opf:scheme="calibre">42</dc:identifier>
+    <dc:identifier opf:scheme="uuid">urn:uuid:1b4e28ba-2fa1-11d2-883f-0016d3cca427</dc:identifier>
+    <dc:identifier>urn:isbn:978-0-10-101010-1</dc:identifier>
+    <dc:identifier opf:scheme="ASIN">B000FA5ZEG</dc:identifier>
+    <dc:identifier>goodreads:12345</dc:identifier>
+    <dc:identifier>not an identifier</dc:identifier>
+    <meta name="calibre:series" content="The Series"/>
+    <meta name="calibre:series_index" content="2.5"/>`)
+
+	b, err := Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if got := strings.Join(b.GetLanguages(), ","); got != "en-US,fr" {
+		t.Errorf("languages = %q", got)
+	}
+	if got := strings.Join(b.GetSubjects(), ","); got != "Fiction,Adventure" {
+		t.Errorf("subjects = %q", got)
+	}
+
+	want := map[string]string{
+		"uuid":      "1b4e28ba-2fa1-11d2-883f-0016d3cca427",
+		"isbn":      "9780101010101",
+		"amazon":    "B000FA5ZEG",
+		"goodreads": "12345",
+	}
+	identifiers := b.GetIdentifiers()
+	if len(identifiers) != len(want) {
+		t.Errorf("identifiers = %v", identifiers)
+	}
+	for typ, val := range want {
+		if identifiers[typ] != val {
+			t.Errorf("identifier %s = %q, want %q", typ, identifiers[typ], val)
+		}
+	}
+
+	if name, index, ok := b.GetSeries(); !ok || name != "The Series" || index != 2.5 {
+		t.Errorf("series = %q, %v, %v", name, index, ok)
+	}
+}
+
+func TestCollectionSeries(t *testing.T) {
+	f := writeTestEpub(t, `
+    <dc:title>Test title</dc:title>
+    <dc:identifier>9780101010101</dc:identifier>
+    <meta property="belongs-to-collection" id="c01">A Set</meta>
+    <meta refines="#c01" property="collection-type">set</meta>
+    <meta property="belongs-to-collection" id="c02">The Series</meta>
+    <meta refines="#c02" property="collection-type">series</meta>
+    <meta refines="#c02" property="group-position">3</meta>`)
+
+	b, err := Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if name, index, ok := b.GetSeries(); !ok || name != "The Series" || index != 3 {
+		t.Errorf("series = %q, %v, %v", name, index, ok)
+	}
+	if got := b.GetIdentifiers()["isbn"]; got != "9780101010101" {
+		t.Errorf("isbn = %q", got)
+	}
+}
+
+func TestNoSeries(t *testing.T) {
+	f := writeTestEpub(t, `<dc:title>Test title</dc:title>`)
+
+	b, err := Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if name, _, ok := b.GetSeries(); ok {
+		t.Errorf("unexpected series %q", name)
+	}
+}
+
+func TestCreators(t *testing.T) {
+	f := writeTestEpub(t, `
+    <dc:title>Test title</dc:title>
+    <dc:creator opf:role="aut" opf:file-as="Pratchett, Terry">Terry Pratchett</dc:creator>
+    <dc:creator id="c2">Neil Gaiman</dc:creator>
+    <meta refines="#c2" property="role" scheme="marc:relators">aut</meta>
+    <meta refines="#c2" property="file-as">Gaiman, Neil</meta>
+    <dc:creator opf:role="edt">Some Editor</dc:creator>
+    <dc:contributor opf:role="trl">A Translator</dc:contributor>
+    <dc:contributor opf:role="bkp">calibre (7.0.0)</dc:contributor>
+    <dc:contributor>Unknown Tool</dc:contributor>`)
+
+	b, err := Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	want := []Creator{
+		{Name: "Terry Pratchett", FileAs: "Pratchett, Terry", Role: "aut"},
+		{Name: "Neil Gaiman", FileAs: "Gaiman, Neil", Role: "aut"},
+		{Name: "Some Editor", Role: "edt"},
+		{Name: "A Translator", Role: "trl"},
+	}
+	creators := b.GetCreators()
+	if len(creators) != len(want) {
+		t.Fatalf("creators = %+v", creators)
+	}
+	for i := range want {
+		if creators[i] != want[i] {
+			t.Errorf("creator %d = %+v, want %+v", i, creators[i], want[i])
+		}
+	}
+	if got := b.GetAuthor(); got != "Terry Pratchett" {
+		t.Errorf("author = %q", got)
+	}
+}
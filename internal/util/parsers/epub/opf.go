@@ -36,6 +36,7 @@ type Identifier struct {
 
 // Author author
 type Author struct {
+	ID     string `xml:"id,attr" json:"id"`
 	Data   string `xml:",chardata" json:"author"`
 	FileAs string `xml:"file-as,attr" json:"file_as"`
 	Role   string `xml:"role,attr" json:"role"`
@@ -47,10 +48,15 @@ type Date struct {
 	Event string `xml:"event,attr" json:"event"`
 }
 
-// Metafield metafield
+// Metafield metafield, EPUB2 meta elements use name and content while EPUB3
+// ones hold the value as text and may refine another element.
 type Metafield struct {
-	Name    string `xml:"name,attr" json:"name"`
-	Content string `xml:"content,attr" json:"content"`
+	Name     string `xml:"name,attr" json:"name"`
+	Content  string `xml:"content,attr" json:"content"`
+	ID       string `xml:"id,attr" json:"id"`
+	Property string `xml:"property,attr" json:"property"`
+	Refines  string `xml:"refines,attr" json:"refines"`
+	Data     string `xml:",chardata" json:"data"`
 }
 
 // Manifest manifest
@@ -0,0 +1,125 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+
+	"github.com/Xunop/e-oasis/internal/util/parsers"
+)
+
+// MIMEType is the media type of epub files
+const MIMEType = "application/epub+zip"
+
+func init() {
+	parsers.Register(parser{})
+}
+
+type parser struct{}
+
+func (parser) Name() string         { return "epub" }
+func (parser) Extensions() []string { return []string{".epub"} }
+func (parser) MIMETypes() []string  { return []string{MIMEType, "application/zip"} }
+
+// Detect only checks for a zip file, the mimetype entry is supposed to come
+// first but plenty of epubs in the wild don't follow that.
+func (parser) Detect(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("PK\x03\x04"))
+}
+
+func (parser) Metadata(path string) (*parsers.Metadata, error) {
+	book, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+
+	meta := &parsers.Metadata{
+		Title:       book.GetTitle(),
+		Publisher:   book.GetPublisher(),
+		Description: book.GetDescription(),
+		Languages:   book.GetLanguages(),
+		Tags:        book.GetSubjects(),
+		UUID:        book.GetUUID(),
+		Date:        book.GetDate(),
+		Identifiers: book.GetIdentifiers(),
+	}
+	meta.ISBN = meta.Identifiers["isbn"]
+	for _, creator := range book.GetCreators() {
+		meta.Creators = append(meta.Creators, parsers.Creator{Name: creator.Name, Sort: creator.FileAs, Role: creator.Role})
+	}
+	if name, index, ok := book.GetSeries(); ok {
+		meta.Series = name
+		meta.SeriesIndex = index
+	}
+	return meta, nil
+}
+
+func (parser) Cover(path, dest string) (string, error) {
+	book, err := Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer book.Close()
+	return book.GetCover(dest)
+}
+
+func (parser) Images(path string) ([]string, error) {
+	book, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+	return book.GetImages(), nil
+}
+
+func (parser) Image(path, name, dest string) (string, error) {
+	book, err := Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer book.Close()
+	return book.GetImage(name, dest)
+}
+
+func (parser) Hash(path string) (string, error) {
+	return Hash(path)
+}
+
+// Hash returns the sha256 of the files in the epub, so that a repacked epub
+// hashes the same.
+func Hash(f string) (string, error) {
+	r, err := zip.OpenReader(f)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	// To ensure the stability of the hash, we must always process files in the same order.
+	// Here, we sort them alphabetically by filename.
+	sort.Slice(r.File, func(i, j int) bool {
+		return r.File[i].Name < r.File[j].Name
+	})
+
+	hash := sha256.New()
+	for _, entry := range r.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(hash, rc); err != nil {
+			rc.Close()
+			return "", err
+		}
+		rc.Close()
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
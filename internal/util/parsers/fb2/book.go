@@ -0,0 +1,316 @@
+package fb2
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// MIMEType is the media type of FictionBook documents
+const MIMEType = "application/x-fictionbook+xml"
+
+// Book is the main struct that holds the metadata of the fb2 file
+type Book struct {
+	Description Description `json:"description"`
+	// Cover is the embedded cover image, nil when the book has none
+	Cover *Binary `json:"-"`
+}
+
+// IsZip reports whether the path names a zipped FictionBook (.fb2.zip)
+func IsZip(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".fb2.zip")
+}
+
+// IsFB2 reports whether data looks like the start of a FictionBook document
+func IsFB2(data []byte) bool {
+	if len(data) > 1024 {
+		data = data[:1024]
+	}
+	return bytes.Contains(data, []byte("<FictionBook"))
+}
+
+// Open opens a .fb2 or .fb2.zip file
+func Open(f string) (*Book, error) {
+	rc, err := openDocument(f)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return parse(rc)
+}
+
+// openDocument returns a reader over the FictionBook document, unpacking
+// the first .fb2 entry of a .fb2.zip file.
+func openDocument(f string) (io.ReadCloser, error) {
+	if !IsZip(f) {
+		return os.Open(f)
+	}
+
+	zr, err := zip.OpenReader(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range zr.File {
+		if strings.EqualFold(filepath.Ext(entry.Name), ".fb2") {
+			rc, err := entry.Open()
+			if err != nil {
+				zr.Close()
+				return nil, err
+			}
+			return &zipEntry{ReadCloser: rc, zr: zr}, nil
+		}
+	}
+	zr.Close()
+	return nil, fmt.Errorf("fb2: no .fb2 file in archive")
+}
+
+// zipEntry closes the archive along with the entry
+type zipEntry struct {
+	io.ReadCloser
+	zr *zip.ReadCloser
+}
+
+func (z *zipEntry) Close() error {
+	z.ReadCloser.Close()
+	return z.zr.Close()
+}
+
+// charsetReader handles the legacy encodings, mostly windows-1251, that a
+// lot of fb2 files are written in.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(label)
+	if err != nil {
+		return nil, fmt.Errorf("fb2: unsupported charset: %s", label)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// parse streams through the document so that the body, which can be large,
+// is skipped rather than loaded. Only the binary holding the cover is kept.
+func parse(r io.Reader) (*Book, error) {
+	dec := xml.NewDecoder(r)
+	dec.CharsetReader = charsetReader
+	dec.Strict = false
+
+	b := &Book{}
+	foundRoot, foundDescription := false, false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "FictionBook":
+			foundRoot = true
+		case "description":
+			if err := dec.DecodeElement(&b.Description, &start); err != nil {
+				return nil, err
+			}
+			foundDescription = true
+		case "binary":
+			coverID := b.coverID()
+			id := ""
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "id" {
+					id = attr.Value
+				}
+			}
+			if coverID == "" || id != coverID {
+				if err := dec.Skip(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			var bin Binary
+			if err := dec.DecodeElement(&bin, &start); err != nil {
+				return nil, err
+			}
+			b.Cover = &bin
+		default:
+			if foundRoot {
+				if err := dec.Skip(); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if !foundRoot || !foundDescription {
+		return nil, fmt.Errorf("fb2: missing FictionBook description")
+	}
+	return b, nil
+}
+
+// coverID returns the binary id the coverpage points to
+func (p *Book) coverID() string {
+	for _, img := range p.Description.TitleInfo.Coverpage.Images {
+		if href := strings.TrimPrefix(img.Href, "#"); href != "" {
+			return href
+		}
+	}
+	return ""
+}
+
+func (p *Book) GetTitle() string {
+	if title := strings.TrimSpace(p.Description.TitleInfo.BookTitle); title != "" {
+		return title
+	}
+	return strings.TrimSpace(p.Description.PublishInfo.BookName)
+}
+
+// GetAuthors returns the names of every author in order
+func (p *Book) GetAuthors() []string {
+	var authors []string
+	for _, author := range p.Description.TitleInfo.Authors {
+		if name := author.Name(); name != "" {
+			authors = append(authors, name)
+		}
+	}
+	return authors
+}
+
+// GetTranslators returns the names of the translators in order
+func (p *Book) GetTranslators() []string {
+	var translators []string
+	for _, translator := range p.Description.TitleInfo.Translator {
+		if name := translator.Name(); name != "" {
+			translators = append(translators, name)
+		}
+	}
+	return translators
+}
+
+func (p *Book) GetAuthor() string {
+	return strings.Join(p.GetAuthors(), " & ")
+}
+
+func (p *Book) GetGenres() []string {
+	var genres []string
+	for _, genre := range p.Description.TitleInfo.Genres {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			genres = append(genres, genre)
+		}
+	}
+	return genres
+}
+
+func (p *Book) GetDescription() string {
+	return p.Description.TitleInfo.Annotation.Text()
+}
+
+func (p *Book) GetLanguage() string {
+	return strings.TrimSpace(p.Description.TitleInfo.Lang)
+}
+
+func (p *Book) GetPublisher() string {
+	return strings.TrimSpace(p.Description.PublishInfo.Publisher)
+}
+
+func (p *Book) GetISBN() string {
+	isbn := strings.TrimSpace(p.Description.PublishInfo.ISBN)
+	return strings.ReplaceAll(isbn, "-", "")
+}
+
+// GetSequence returns the first series of the book and the book number in
+// it, ok is false when the book isn't part of a series. The title-info
+// sequence describes the work and is preferred over the publisher's.
+func (p *Book) GetSequence() (name string, number float64, ok bool) {
+	sequences := append(append([]Sequence{}, p.Description.TitleInfo.Sequences...), p.Description.PublishInfo.Sequences...)
+	for _, seq := range sequences {
+		if name = strings.TrimSpace(seq.Name); name == "" {
+			continue
+		}
+		if _, err := fmt.Sscanf(strings.TrimSpace(seq.Number), "%g", &number); err != nil {
+			number = 0
+		}
+		return name, number, true
+	}
+	return "", 0, false
+}
+
+// GetDate returns the date of the work, or the publishing year, in RFC3339
+// format.
+func (p *Book) GetDate() string {
+	date := p.Description.TitleInfo.Date
+	for _, raw := range []string{date.Value, date.Text, p.Description.PublishInfo.Year} {
+		raw = strings.TrimSpace(raw)
+		for _, layout := range []string{"2006-01-02", "2006-01", "2006"} {
+			if t, err := time.Parse(layout, raw); err == nil {
+				return t.Format(time.RFC3339)
+			}
+		}
+	}
+	return ""
+}
+
+// GetCover decodes the cover image to the destination and return the path
+func (p *Book) GetCover(dest string) (string, error) {
+	if p.Cover == nil {
+		return "", nil
+	}
+
+	// Base64 data is usually wrapped over many lines.
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(p.Cover.Data), ""))
+	if err != nil {
+		return "", err
+	}
+
+	var ext string
+	switch strings.ToLower(p.Cover.ContentType) {
+	case "image/jpeg", "image/jpg":
+		ext = ".jpg"
+	case "image/png":
+		ext = ".png"
+	case "image/gif":
+		ext = ".gif"
+	default:
+		ext = strings.ToLower(filepath.Ext(p.Cover.ID))
+	}
+	if ext == "" {
+		return "", fmt.Errorf("fb2: unknown cover type: %s", p.Cover.ContentType)
+	}
+
+	if _, err := os.Stat(dest); os.IsNotExist(err) {
+		return "", fmt.Errorf("Please make sure dirctory exist")
+	}
+	fileDest := filepath.Join(dest, "cover"+ext)
+	if err := os.WriteFile(fileDest, data, 0644); err != nil {
+		return "", err
+	}
+	return fileDest, nil
+}
+
+// Hash returns the sha256 of the FictionBook document, so a book hashes the
+// same whether it's zipped or not.
+func Hash(f string) (string, error) {
+	rc, err := openDocument(f)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, rc); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
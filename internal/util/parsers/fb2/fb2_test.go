@@ -0,0 +1,147 @@
+package fb2
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+var testCover = []byte("\xff\xd8\xff\xe0 not a real jpeg")
+
+func testDocument(encoding string) string {
+	return `<?xml version="1.0" encoding="` + encoding + `"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description>
+  <title-info>
+    <genre>sf_fantasy</genre>
+    <genre>adventure</genre>
+    <author><first-name>Лев</first-name><middle-name>Николаевич</middle-name><last-name>Толстой</last-name></author>
+    <author><nickname>anon</nickname></author>
+    <book-title>Война и мир</book-title>
+    <annotation><p>First <emphasis>line</emphasis>.</p><p>Second &amp; last.</p></annotation>
+    <date value="1869-01-01">1869</date>
+    <coverpage><image l:href="#cover.jpg"/></coverpage>
+    <lang>ru</lang>
+    <sequence name="Эпопея" number="2"/>
+  </title-info>
+  <publish-info>
+    <publisher>Acme</publisher>
+    <year>2001</year>
+    <isbn>978-5-17-000000-0</isbn>
+  </publish-info>
+</description>
+<body><section><p>Text</p></section></body>
+<binary id="other.png" content-type="image/png">AAAA</binary>
+<binary id="cover.jpg" content-type="image/jpeg">
+` + base64.StdEncoding.EncodeToString(testCover) + `
+</binary>
+</FictionBook>`
+}
+
+func TestFb2(t *testing.T) {
+	doc, err := charmap.Windows1251.NewEncoder().String(testDocument("windows-1251"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := filepath.Join(t.TempDir(), "test.fb2")
+	if err := os.WriteFile(f, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := b.GetTitle(); got != "Война и мир" {
+		t.Errorf("title = %q", got)
+	}
+	if got := b.GetAuthor(); got != "Лев Николаевич Толстой & anon" {
+		t.Errorf("author = %q", got)
+	}
+	if got := b.GetGenres(); strings.Join(got, ",") != "sf_fantasy,adventure" {
+		t.Errorf("genres = %q", got)
+	}
+	if got := b.GetDescription(); got != "First line .\nSecond & last." {
+		t.Errorf("description = %q", got)
+	}
+	if name, number, ok := b.GetSequence(); !ok || name != "Эпопея" || number != 2 {
+		t.Errorf("sequence = %q, %v, %v", name, number, ok)
+	}
+	if got := b.GetISBN(); got != "9785170000000" {
+		t.Errorf("isbn = %q", got)
+	}
+	if got := b.GetLanguage(); got != "ru" {
+		t.Errorf("language = %q", got)
+	}
+	if got := b.GetDate(); got != "1869-01-01T00:00:00Z" {
+		t.Errorf("date = %q", got)
+	}
+
+	dir := t.TempDir()
+	cover, err := b.GetCover(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(cover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(cover) != "cover.jpg" || !bytes.Equal(data, testCover) {
+		t.Errorf("unexpected cover %q", cover)
+	}
+}
+
+func TestFb2Zip(t *testing.T) {
+	dir := t.TempDir()
+	plain, zipped := filepath.Join(dir, "book.fb2"), filepath.Join(dir, "book.fb2.zip")
+	doc := testDocument("utf-8")
+	if err := os.WriteFile(plain, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fw, _ := w.Create("book.fb2")
+	fw.Write([]byte(doc))
+	w.Close()
+	if err := os.WriteFile(zipped, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Open(zipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.GetTitle(); got != "Война и мир" {
+		t.Errorf("title = %q", got)
+	}
+
+	hp, err := Hash(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hz, err := Hash(zipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hp != hz {
+		t.Errorf("zipped and plain hashes differ: %s != %s", hp, hz)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "bad.fb2")
+	if err := os.WriteFile(f, []byte(`<?xml version="1.0"?><html></html>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(f); err == nil {
+		t.Error("expected an error for a document without FictionBook description")
+	}
+}
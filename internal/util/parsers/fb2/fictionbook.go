@@ -0,0 +1,114 @@
+package fb2 // import "github.com/Xunop/e-oasis/internal/util/parsers/fb2"
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Description is the <description> element of a FictionBook document, see
+// http://www.fictionbook.org/index.php/Eng:XML_Schema_Fictionbook_2.1
+type Description struct {
+	TitleInfo    TitleInfo    `xml:"title-info"`
+	DocumentInfo DocumentInfo `xml:"document-info"`
+	PublishInfo  PublishInfo  `xml:"publish-info"`
+}
+
+type TitleInfo struct {
+	Genres     []string   `xml:"genre"`
+	Authors    []Author   `xml:"author"`
+	BookTitle  string     `xml:"book-title"`
+	Annotation Annotation `xml:"annotation"`
+	Keywords   string     `xml:"keywords"`
+	Date       Date       `xml:"date"`
+	Coverpage  Coverpage  `xml:"coverpage"`
+	Lang       string     `xml:"lang"`
+	SrcLang    string     `xml:"src-lang"`
+	Translator []Author   `xml:"translator"`
+	Sequences  []Sequence `xml:"sequence"`
+}
+
+type DocumentInfo struct {
+	ID string `xml:"id"`
+}
+
+type PublishInfo struct {
+	BookName  string     `xml:"book-name"`
+	Publisher string     `xml:"publisher"`
+	City      string     `xml:"city"`
+	Year      string     `xml:"year"`
+	ISBN      string     `xml:"isbn"`
+	Sequences []Sequence `xml:"sequence"`
+}
+
+type Author struct {
+	FirstName  string `xml:"first-name"`
+	MiddleName string `xml:"middle-name"`
+	LastName   string `xml:"last-name"`
+	Nickname   string `xml:"nickname"`
+}
+
+// Name returns the full name of the author, or the nickname if the author
+// has no name.
+func (a Author) Name() string {
+	var parts []string
+	for _, p := range []string{a.FirstName, a.MiddleName, a.LastName} {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return strings.TrimSpace(a.Nickname)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Annotation keeps the raw markup of the annotation, paragraphs may hold
+// inline elements such as <emphasis>.
+type Annotation struct {
+	Inner string `xml:",innerxml"`
+}
+
+var (
+	paragraphEndRegexp = regexp.MustCompile(`(?i)</p>|<empty-line\s*/>`)
+	tagRegexp          = regexp.MustCompile(`<[^>]*>`)
+	spaceRegexp        = regexp.MustCompile(`[ \t\r\n]+`)
+)
+
+// Text returns the annotation as plain text with one paragraph per line
+func (a Annotation) Text() string {
+	var paragraphs []string
+	for _, p := range paragraphEndRegexp.Split(a.Inner, -1) {
+		p = html.UnescapeString(tagRegexp.ReplaceAllString(p, " "))
+		if p = strings.TrimSpace(spaceRegexp.ReplaceAllString(p, " ")); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return strings.Join(paragraphs, "\n")
+}
+
+type Date struct {
+	Value string `xml:"value,attr"`
+	Text  string `xml:",chardata"`
+}
+
+type Coverpage struct {
+	Images []Image `xml:"image"`
+}
+
+type Image struct {
+	// Href is namespaced (l:href or xlink:href), so match on the local name
+	Href string `xml:"href,attr"`
+}
+
+type Sequence struct {
+	Name   string `xml:"name,attr"`
+	Number string `xml:"number,attr"`
+}
+
+// Binary is an embedded file such as the cover image
+type Binary struct {
+	ID          string `xml:"id,attr"`
+	ContentType string `xml:"content-type,attr"`
+	Data        string `xml:",chardata"`
+}
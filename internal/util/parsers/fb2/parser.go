@@ -0,0 +1,67 @@
+package fb2
+
+import (
+	"bytes"
+
+	"github.com/Xunop/e-oasis/internal/util/parsers"
+)
+
+func init() {
+	parsers.Register(parser{})
+}
+
+type parser struct{}
+
+func (parser) Name() string         { return "fb2" }
+func (parser) Extensions() []string { return []string{".fb2", ".fb2.zip"} }
+func (parser) MIMETypes() []string  { return []string{MIMEType} }
+
+// Detect accepts a zip for .fb2.zip files, the document inside is only
+// checked by Open.
+func (parser) Detect(head []byte) bool {
+	return IsFB2(head) || bytes.HasPrefix(head, []byte("PK\x03\x04"))
+}
+
+func (parser) Metadata(path string) (*parsers.Metadata, error) {
+	book, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &parsers.Metadata{
+		Title:       book.GetTitle(),
+		Creators:    parsers.Authors(book.GetAuthors()...),
+		Publisher:   book.GetPublisher(),
+		Description: book.GetDescription(),
+		Tags:        book.GetGenres(),
+		ISBN:        book.GetISBN(),
+		Date:        book.GetDate(),
+		Identifiers: map[string]string{},
+	}
+	for _, translator := range book.GetTranslators() {
+		meta.Creators = append(meta.Creators, parsers.Creator{Name: translator, Role: "trl"})
+	}
+	if name, number, ok := book.GetSequence(); ok {
+		meta.Series = name
+		meta.SeriesIndex = number
+	}
+	if isbn := book.GetISBN(); isbn != "" {
+		meta.Identifiers["isbn"] = isbn
+	}
+	if lang := book.GetLanguage(); lang != "" {
+		meta.Languages = []string{lang}
+	}
+	return meta, nil
+}
+
+func (parser) Cover(path, dest string) (string, error) {
+	book, err := Open(path)
+	if err != nil {
+		return "", err
+	}
+	return book.GetCover(dest)
+}
+
+func (parser) Hash(path string) (string, error) {
+	return Hash(path)
+}
@@ -0,0 +1,202 @@
+package mobi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MIMEType is the media type of Mobipocket files, AZW3 files share it
+const MIMEType = "application/x-mobipocket-ebook"
+
+// Book is the main struct that holds the metadata of the mobi file
+type Book struct {
+	DB     *PalmDB `json:"palmdb"`
+	Header *Header `json:"header"`
+
+	fd *os.File
+}
+
+// Close closes the mobi file
+func (p *Book) Close() error {
+	return p.fd.Close()
+}
+
+// IsKF8 reports whether the book is a KF8 (azw3) book
+func (p *Book) IsKF8() bool {
+	return p.Header.FileVersion >= 8
+}
+
+func (p *Book) GetTitle() string {
+	if title := strings.TrimSpace(p.Header.exthString(ExthUpdatedName)); title != "" {
+		return title
+	}
+	if title := strings.TrimSpace(p.Header.FullName); title != "" {
+		return title
+	}
+	return strings.TrimSpace(p.DB.Name)
+}
+
+// GetAuthors returns every author of the book in order
+func (p *Book) GetAuthors() []string {
+	var authors []string
+	for _, author := range p.Header.exthStrings(ExthAuthor) {
+		if author = strings.TrimSpace(author); author != "" {
+			authors = append(authors, author)
+		}
+	}
+	return authors
+}
+
+func (p *Book) GetAuthor() string {
+	return strings.Join(p.GetAuthors(), " & ")
+}
+
+func (p *Book) GetPublisher() string {
+	return strings.TrimSpace(p.Header.exthString(ExthPublisher))
+}
+
+func (p *Book) GetDescription() string {
+	return strings.TrimSpace(p.Header.exthString(ExthDescription))
+}
+
+func (p *Book) GetSubjects() []string {
+	var subjects []string
+	for _, subject := range p.Header.exthStrings(ExthSubject) {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			subjects = append(subjects, subject)
+		}
+	}
+	return subjects
+}
+
+func (p *Book) GetISBN() string {
+	isbn := strings.TrimSpace(p.Header.exthString(ExthISBN))
+	return strings.ReplaceAll(isbn, "-", "")
+}
+
+func (p *Book) GetASIN() string {
+	return strings.TrimSpace(p.Header.exthString(ExthASIN))
+}
+
+// GetLanguage returns the language code of the book, falling back to the
+// locale of the MOBI header.
+func (p *Book) GetLanguage() string {
+	if lang := strings.TrimSpace(p.Header.exthString(ExthLanguage)); lang != "" {
+		return lang
+	}
+	return localeLanguages[p.Header.Locale&0xff]
+}
+
+// GetDate returns the publish date in RFC3339 format, the raw value is kept
+// when it can't be parsed.
+func (p *Book) GetDate() string {
+	raw := strings.TrimSpace(p.Header.exthString(ExthPublishDate))
+	if raw == "" {
+		return ""
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t.Format(time.RFC3339)
+		}
+	}
+	return raw
+}
+
+// coverRecord returns the record index of the cover image
+func (p *Book) coverRecord() (int, bool) {
+	first := p.Header.FirstImageIndex
+	if first == noIndex {
+		return 0, false
+	}
+	for _, typ := range []uint32{ExthCoverOffset, ExthThumbOffset} {
+		if off, ok := p.Header.exthUint32(typ); ok && off != noIndex {
+			return int(first + off), true
+		}
+	}
+	// Without a cover record, the first image is the best guess.
+	return int(first), true
+}
+
+// GetCover copy the cover image to the destination and return the path
+func (p *Book) GetCover(dest string) (string, error) {
+	idx, ok := p.coverRecord()
+	if !ok {
+		return "", nil
+	}
+	data, err := p.DB.Record(idx)
+	if err != nil {
+		return "", err
+	}
+	ext := imageExt(data)
+	if ext == "" {
+		return "", nil
+	}
+
+	if _, err := os.Stat(dest); os.IsNotExist(err) {
+		return "", fmt.Errorf("Please make sure dirctory exist")
+	}
+	fileDest := filepath.Join(dest, "cover"+ext)
+	if err := os.WriteFile(fileDest, data, 0644); err != nil {
+		return "", err
+	}
+	return fileDest, nil
+}
+
+// imageExt returns the file extension of the image in data, or an empty
+// string if data isn't an image.
+func imageExt(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}):
+		return ".jpg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return ".png"
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return ".gif"
+	}
+	return ""
+}
+
+// Hash returns the sha256 of the text records of the book at path. Tools
+// like calibre rewrite the headers when editing metadata, so they are left
+// out to keep the hash stable across metadata edits.
+func Hash(path string) (string, error) {
+	book, err := Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer book.Close()
+	return book.textHash()
+}
+
+func (p *Book) textHash() (string, error) {
+	hash := sha256.New()
+	count := int(p.Header.TextRecordCount)
+	if count == 0 || count >= p.DB.NumRecords() {
+		return "", fmt.Errorf("mobi: invalid text record count: %d", count)
+	}
+	for i := 1; i <= count; i++ {
+		data, err := p.DB.Record(i)
+		if err != nil {
+			return "", err
+		}
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// localeLanguages maps the primary language of a Windows locale id to its
+// ISO 639-1 code.
+var localeLanguages = map[uint32]string{
+	0x01: "ar", 0x02: "bg", 0x03: "ca", 0x04: "zh", 0x05: "cs", 0x06: "da",
+	0x07: "de", 0x08: "el", 0x09: "en", 0x0a: "es", 0x0b: "fi", 0x0c: "fr",
+	0x0d: "he", 0x0e: "hu", 0x0f: "is", 0x10: "it", 0x11: "ja", 0x12: "ko",
+	0x13: "nl", 0x14: "no", 0x15: "pl", 0x16: "pt", 0x18: "ro", 0x19: "ru",
+	0x1a: "hr", 0x1b: "sk", 0x1d: "sv", 0x1e: "th", 0x1f: "tr", 0x21: "id",
+	0x22: "uk", 0x2a: "vi",
+}
@@ -0,0 +1,157 @@
+package mobi
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// EXTH record types, see https://wiki.mobileread.com/wiki/MOBI#EXTH_Header
+const (
+	ExthAuthor      = 100
+	ExthPublisher   = 101
+	ExthDescription = 103
+	ExthISBN        = 104
+	ExthSubject     = 105
+	ExthPublishDate = 106
+	ExthASIN        = 113
+	ExthCoverOffset = 201
+	ExthThumbOffset = 202
+	ExthUpdatedName = 503
+	ExthLanguage    = 524
+)
+
+const (
+	encodingCP1252 = 1252
+	encodingUTF8   = 65001
+
+	// noIndex marks an unset record index or offset
+	noIndex = 0xffffffff
+	// exthFlag is set in the MOBI header flags when an EXTH header follows
+	exthFlag = 0x40
+)
+
+// Header holds the fields of the PalmDOC and MOBI headers in record 0
+type Header struct {
+	Compression     uint16 `json:"compression"`
+	TextLength      uint32 `json:"text_length"`
+	TextRecordCount uint16 `json:"text_record_count"`
+	Encryption      uint16 `json:"encryption"`
+
+	MobiType        uint32 `json:"mobi_type"`
+	TextEncoding    uint32 `json:"text_encoding"`
+	FileVersion     uint32 `json:"file_version"`
+	FullName        string `json:"full_name"`
+	Locale          uint32 `json:"locale"`
+	FirstImageIndex uint32 `json:"first_image_index"`
+
+	// Exth maps record types to their values, types such as authors or
+	// subjects may repeat.
+	Exth map[uint32][][]byte `json:"-"`
+}
+
+func parseHeader(rec []byte) (*Header, error) {
+	if len(rec) < 16+8 || string(rec[16:20]) != "MOBI" {
+		return nil, fmt.Errorf("mobi: missing MOBI header")
+	}
+	u16 := func(off int) uint16 {
+		if off+2 > len(rec) {
+			return 0
+		}
+		return binary.BigEndian.Uint16(rec[off:])
+	}
+	u32 := func(off int) uint32 {
+		if off+4 > len(rec) {
+			return 0
+		}
+		return binary.BigEndian.Uint32(rec[off:])
+	}
+
+	h := &Header{
+		Compression:     u16(0),
+		TextLength:      u32(4),
+		TextRecordCount: u16(8),
+		Encryption:      u16(12),
+		MobiType:        u32(24),
+		TextEncoding:    u32(28),
+		FileVersion:     u32(36),
+		Locale:          u32(92),
+		FirstImageIndex: noIndex,
+		Exth:            map[uint32][][]byte{},
+	}
+
+	headerLen := u32(20)
+	// Old files have shorter headers, only read what they contain.
+	if headerLen >= 0x60 {
+		h.FirstImageIndex = u32(108)
+	}
+
+	nameOff, nameLen := u32(84), u32(88)
+	if nameLen > 0 && uint64(nameOff)+uint64(nameLen) <= uint64(len(rec)) {
+		h.FullName = h.decode(rec[nameOff : nameOff+nameLen])
+	}
+
+	if headerLen >= 0x74 && u32(128)&exthFlag != 0 {
+		h.parseExth(rec, 16+int(headerLen))
+	}
+	return h, nil
+}
+
+// parseExth reads the EXTH records starting at off, stopping silently at the
+// first malformed one.
+func (h *Header) parseExth(rec []byte, off int) {
+	if off+12 > len(rec) || string(rec[off:off+4]) != "EXTH" {
+		return
+	}
+	count := binary.BigEndian.Uint32(rec[off+8:])
+	pos := off + 12
+	for i := uint32(0); i < count; i++ {
+		if pos+8 > len(rec) {
+			return
+		}
+		typ := binary.BigEndian.Uint32(rec[pos:])
+		length := int(binary.BigEndian.Uint32(rec[pos+4:]))
+		if length < 8 || pos+length > len(rec) {
+			return
+		}
+		h.Exth[typ] = append(h.Exth[typ], rec[pos+8:pos+length])
+		pos += length
+	}
+}
+
+// decode converts text in the book encoding to UTF-8
+func (h *Header) decode(b []byte) string {
+	if h.TextEncoding == encodingCP1252 {
+		s, err := charmap.Windows1252.NewDecoder().Bytes(b)
+		if err == nil {
+			return string(s)
+		}
+	}
+	return string(b)
+}
+
+// exthStrings returns all the values of an EXTH record type as text
+func (h *Header) exthStrings(typ uint32) []string {
+	var values []string
+	for _, v := range h.Exth[typ] {
+		values = append(values, h.decode(v))
+	}
+	return values
+}
+
+// exthString returns the first value of an EXTH record type as text
+func (h *Header) exthString(typ uint32) string {
+	if values := h.Exth[typ]; len(values) > 0 {
+		return h.decode(values[0])
+	}
+	return ""
+}
+
+// exthUint32 returns the first value of a numeric EXTH record type
+func (h *Header) exthUint32(typ uint32) (uint32, bool) {
+	if values := h.Exth[typ]; len(values) > 0 && len(values[0]) >= 4 {
+		return binary.BigEndian.Uint32(values[0]), true
+	}
+	return 0, false
+}
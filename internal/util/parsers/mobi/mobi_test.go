@@ -0,0 +1,208 @@
+package mobi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type exthRecord struct {
+	typ  uint32
+	data []byte
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+// createMobi writes a minimal mobi file with one text record followed by a
+// png cover record.
+func createMobi(n string, text string, encoding uint32, exth []exthRecord) error {
+	var cover bytes.Buffer
+	if err := png.Encode(&cover, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		return err
+	}
+
+	var exthBuf bytes.Buffer
+	for _, r := range exth {
+		exthBuf.Write(u32(r.typ))
+		exthBuf.Write(u32(uint32(len(r.data) + 8)))
+		exthBuf.Write(r.data)
+	}
+
+	const mobiHeaderLen = 0xe8
+	fullName := []byte("Full Name")
+
+	rec0 := make([]byte, 16+mobiHeaderLen)
+	binary.BigEndian.PutUint16(rec0[0:], 1)
+	binary.BigEndian.PutUint32(rec0[4:], uint32(len(text)))
+	binary.BigEndian.PutUint16(rec0[8:], 1)
+	binary.BigEndian.PutUint16(rec0[10:], 4096)
+	copy(rec0[16:], "MOBI")
+	binary.BigEndian.PutUint32(rec0[20:], mobiHeaderLen)
+	binary.BigEndian.PutUint32(rec0[24:], 2)
+	binary.BigEndian.PutUint32(rec0[28:], encoding)
+	binary.BigEndian.PutUint32(rec0[36:], 6)
+	binary.BigEndian.PutUint32(rec0[92:], 0x0409)
+	binary.BigEndian.PutUint32(rec0[108:], 2)
+	binary.BigEndian.PutUint32(rec0[128:], exthFlag)
+
+	rec0 = append(rec0, "EXTH"...)
+	rec0 = append(rec0, u32(uint32(12+exthBuf.Len()))...)
+	rec0 = append(rec0, u32(uint32(len(exth)))...)
+	rec0 = append(rec0, exthBuf.Bytes()...)
+	binary.BigEndian.PutUint32(rec0[84:], uint32(len(rec0)))
+	binary.BigEndian.PutUint32(rec0[88:], uint32(len(fullName)))
+	rec0 = append(rec0, fullName...)
+
+	records := [][]byte{rec0, []byte(text), cover.Bytes()}
+
+	header := make([]byte, palmDBHeaderLen)
+	copy(header, "Palm_Name")
+	copy(header[60:], "BOOKMOBI")
+	binary.BigEndian.PutUint16(header[76:], uint16(len(records)))
+
+	var buf bytes.Buffer
+	buf.Write(header)
+	offset := palmDBHeaderLen + len(records)*recordEntryLen + 2
+	for i, r := range records {
+		buf.Write(u32(uint32(offset)))
+		buf.Write([]byte{0, 0, 0, byte(i)})
+		offset += len(r)
+	}
+	buf.Write([]byte{0, 0})
+	for _, r := range records {
+		buf.Write(r)
+	}
+	return os.WriteFile(n, buf.Bytes(), 0644)
+}
+
+func TestMobi(t *testing.T) {
+	exth := []exthRecord{
+		{ExthAuthor, []byte("Jane Doe")},
+		{ExthAuthor, []byte("John Roe")},
+		{ExthPublisher, []byte("Acme")},
+		{ExthDescription, []byte("A description")},
+		{ExthISBN, []byte("978-0-13-110362-7")},
+		{ExthSubject, []byte("Fiction")},
+		{ExthPublishDate, []byte("2011-03-04")},
+		{ExthASIN, []byte("B000000000")},
+		{ExthCoverOffset, u32(0)},
+		{ExthUpdatedName, []byte("Caf\xe9")},
+	}
+	f := filepath.Join(t.TempDir(), "test.mobi")
+	if err := createMobi(f, "hello world", encodingCP1252, exth); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if got := b.GetTitle(); got != "Café" {
+		t.Errorf("title = %q", got)
+	}
+	if got := b.GetAuthor(); got != "Jane Doe & John Roe" {
+		t.Errorf("author = %q", got)
+	}
+	if got := b.GetPublisher(); got != "Acme" {
+		t.Errorf("publisher = %q", got)
+	}
+	if got := b.GetDescription(); got != "A description" {
+		t.Errorf("description = %q", got)
+	}
+	if got := b.GetISBN(); got != "9780131103627" {
+		t.Errorf("isbn = %q", got)
+	}
+	if got := b.GetASIN(); got != "B000000000" {
+		t.Errorf("asin = %q", got)
+	}
+	if got := b.GetSubjects(); len(got) != 1 || got[0] != "Fiction" {
+		t.Errorf("subjects = %q", got)
+	}
+	if got := b.GetDate(); got != "2011-03-04T00:00:00Z" {
+		t.Errorf("date = %q", got)
+	}
+	if got := b.GetLanguage(); got != "en" {
+		t.Errorf("language = %q", got)
+	}
+
+	dir := t.TempDir()
+	cover, err := b.GetCover(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cover != filepath.Join(dir, "cover.png") {
+		t.Errorf("cover = %q", cover)
+	}
+}
+
+func TestMobiFallbacks(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "test.azw3")
+	if err := createMobi(f, "hello world", encodingUTF8, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if got := b.GetTitle(); got != "Full Name" {
+		t.Errorf("title = %q", got)
+	}
+	if got := b.GetAuthor(); got != "" {
+		t.Errorf("author = %q", got)
+	}
+	// Without EXTH 201 the first image record is used.
+	if cover, err := b.GetCover(t.TempDir()); err != nil || cover == "" {
+		t.Errorf("cover = %q, err = %v", cover, err)
+	}
+}
+
+func TestHash(t *testing.T) {
+	dir := t.TempDir()
+	a, b, c := filepath.Join(dir, "a.mobi"), filepath.Join(dir, "b.mobi"), filepath.Join(dir, "c.mobi")
+	// a and b only differ in their metadata.
+	if err := createMobi(a, "same text", encodingUTF8, []exthRecord{{ExthAuthor, []byte("A")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := createMobi(b, "same text", encodingUTF8, []exthRecord{{ExthAuthor, []byte("B")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := createMobi(c, "other text", encodingUTF8, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ha, err := Hash(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb, _ := Hash(b)
+	hc, _ := Hash(c)
+	if ha != hb {
+		t.Errorf("metadata edits changed the hash: %s != %s", ha, hb)
+	}
+	if ha == hc {
+		t.Errorf("different texts have the same hash")
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "bad.mobi")
+	if err := os.WriteFile(f, bytes.Repeat([]byte{0}, 128), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(f); err == nil {
+		t.Error("expected an error for a file without a BOOKMOBI header")
+	}
+}
@@ -0,0 +1,37 @@
+package mobi
+
+import (
+	"os"
+)
+
+// Open opens a Mobipocket (mobi, azw) or KF8 (azw3) file
+func Open(f string) (*Book, error) {
+	fd, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := readPalmDB(fd)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	rec, err := db.Record(0)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	header, err := parseHeader(rec)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	return &Book{DB: db, Header: header, fd: fd}, nil
+}
+
+// IsMobi reports whether data starts with a Mobipocket PalmDB header
+func IsMobi(data []byte) bool {
+	return len(data) >= 68 && string(data[60:68]) == "BOOKMOBI"
+}
@@ -0,0 +1,88 @@
+package mobi // import "github.com/Xunop/e-oasis/internal/util/parsers/mobi"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	palmDBHeaderLen = 78
+	// recordEntryLen is the size of one entry of the record list
+	recordEntryLen = 8
+)
+
+// PalmDB is the container format of Mobipocket and KF8 files, a header
+// followed by a list of records.
+type PalmDB struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Creator string `json:"creator"`
+
+	// offsets holds the start of every record, a record ends where the
+	// next one starts.
+	offsets []uint32
+	size    int64
+	fd      *os.File
+}
+
+func readPalmDB(fd *os.File) (*PalmDB, error) {
+	fi, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, palmDBHeaderLen)
+	if _, err := fd.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("mobi: short PalmDB header: %w", err)
+	}
+
+	db := &PalmDB{
+		Name:    strings.TrimRight(string(header[:32]), "\x00"),
+		Type:    string(header[60:64]),
+		Creator: string(header[64:68]),
+		size:    fi.Size(),
+		fd:      fd,
+	}
+	if db.Type+db.Creator != "BOOKMOBI" {
+		return nil, fmt.Errorf("mobi: invalid PalmDB type: %s%s", db.Type, db.Creator)
+	}
+
+	count := int(binary.BigEndian.Uint16(header[76:78]))
+	list := make([]byte, count*recordEntryLen)
+	if _, err := fd.ReadAt(list, palmDBHeaderLen); err != nil {
+		return nil, fmt.Errorf("mobi: short record list: %w", err)
+	}
+	db.offsets = make([]uint32, count)
+	for i := range db.offsets {
+		db.offsets[i] = binary.BigEndian.Uint32(list[i*recordEntryLen:])
+	}
+	return db, nil
+}
+
+// NumRecords returns the number of records in the database
+func (db *PalmDB) NumRecords() int {
+	return len(db.offsets)
+}
+
+// Record reads the record with the given index
+func (db *PalmDB) Record(i int) ([]byte, error) {
+	if i < 0 || i >= len(db.offsets) {
+		return nil, fmt.Errorf("mobi: record %d out of range", i)
+	}
+	start := int64(db.offsets[i])
+	end := db.size
+	if i+1 < len(db.offsets) {
+		end = int64(db.offsets[i+1])
+	}
+	if start > end || end > db.size {
+		return nil, fmt.Errorf("mobi: record %d has invalid bounds", i)
+	}
+
+	data := make([]byte, end-start)
+	if _, err := db.fd.ReadAt(data, start); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,63 @@
+package mobi
+
+import (
+	"github.com/Xunop/e-oasis/internal/util/parsers"
+)
+
+func init() {
+	parsers.Register(parser{})
+}
+
+type parser struct{}
+
+func (parser) Name() string         { return "mobi" }
+func (parser) Extensions() []string { return []string{".mobi", ".azw", ".azw3"} }
+func (parser) MIMETypes() []string  { return []string{MIMEType} }
+
+// Detect checks the PalmDB type, Mobipocket files have no signature at
+// offset 0.
+func (parser) Detect(head []byte) bool {
+	return IsMobi(head)
+}
+
+func (parser) Metadata(path string) (*parsers.Metadata, error) {
+	book, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+
+	meta := &parsers.Metadata{
+		Title:       book.GetTitle(),
+		Creators:    parsers.Authors(book.GetAuthors()...),
+		Publisher:   book.GetPublisher(),
+		Description: book.GetDescription(),
+		Tags:        book.GetSubjects(),
+		ISBN:        book.GetISBN(),
+		Date:        book.GetDate(),
+		Identifiers: map[string]string{},
+	}
+	if isbn := book.GetISBN(); isbn != "" {
+		meta.Identifiers["isbn"] = isbn
+	}
+	if asin := book.GetASIN(); asin != "" {
+		meta.Identifiers["amazon"] = asin
+	}
+	if lang := book.GetLanguage(); lang != "" {
+		meta.Languages = []string{lang}
+	}
+	return meta, nil
+}
+
+func (parser) Cover(path, dest string) (string, error) {
+	book, err := Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer book.Close()
+	return book.GetCover(dest)
+}
+
+func (parser) Hash(path string) (string, error) {
+	return Hash(path)
+}
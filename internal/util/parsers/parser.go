@@ -0,0 +1,193 @@
+// Package parsers holds the registry of the book formats e-oasis can import.
+// Every format lives in its own package and registers a BookParser from its
+// init function, the importer only needs to know the registry:
+//
+//	import _ "github.com/Xunop/e-oasis/internal/util/parsers/epub"
+package parsers // import "github.com/Xunop/e-oasis/internal/util/parsers"
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metadata is the metadata of a book independent of its format. Parsers
+// leave out what the format doesn't carry, defaults are up to the caller.
+type Metadata struct {
+	Title string
+	// Creators are the authors and contributors of the book in order
+	Creators    []Creator
+	Publisher   string
+	Description string
+	// Languages are the language codes of the book, e.g. "en" or "eng"
+	Languages []string
+	Tags      []string
+	Series    string
+	// SeriesIndex is the position of the book in the series, 0 when unknown
+	SeriesIndex float64
+	ISBN        string
+	UUID        string
+	// Date is the publish date in RFC3339 format
+	Date string
+	// Identifiers maps an identifier type (isbn, amazon...) to its value
+	Identifiers map[string]string
+}
+
+// Creator is a person credited for the book
+type Creator struct {
+	Name string
+	// Sort is the name as sorted by the book, e.g. "Tolkien, J. R. R."
+	Sort string
+	// Role is the MARC relator code used by OPF (aut, edt, trl, ill...), an
+	// empty role is an author.
+	Role string
+}
+
+// Authors returns creators with the author role from names
+func Authors(names ...string) []Creator {
+	creators := make([]Creator, 0, len(names))
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			creators = append(creators, Creator{Name: name, Role: "aut"})
+		}
+	}
+	return creators
+}
+
+// BookParser is implemented by every supported book format
+type BookParser interface {
+	// Name is the short name of the format, e.g. "epub"
+	Name() string
+	// Extensions lists the file extensions of the format with the leading dot
+	Extensions() []string
+	// MIMETypes lists the media types of the format
+	MIMETypes() []string
+	// Detect reports whether head, the first bytes of a file, looks like the format
+	Detect(head []byte) bool
+	// Metadata reads the metadata of the book at path
+	Metadata(path string) (*Metadata, error)
+	// Cover extracts the cover of the book into the dest directory and
+	// returns its path, or an empty path when the book has no cover.
+	Cover(path, dest string) (string, error)
+	// Hash returns a hash of the book content, stable across containers so
+	// that the same book is detected as a duplicate.
+	Hash(path string) (string, error)
+}
+
+// ImageExtractor is implemented by the formats holding images, another image
+// of the book can then be picked as its cover.
+type ImageExtractor interface {
+	// Images lists the names of the images in the book at path
+	Images(path string) ([]string, error)
+	// Image copies the image name of the book into the dest directory and
+	// returns its path.
+	Image(path, name, dest string) (string, error)
+}
+
+var (
+	mu      sync.RWMutex
+	formats = make(map[string]BookParser)
+	// byType maps both extensions and MIME types to their parser
+	byType = make(map[string]BookParser)
+)
+
+// Register makes a book format available. It panics if the format or one of
+// its extensions is registered twice, which is a programming error.
+func Register(p BookParser) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if p == nil {
+		panic("parsers: Register parser is nil")
+	}
+	if _, dup := formats[p.Name()]; dup {
+		panic("parsers: Register called twice for format " + p.Name())
+	}
+	for _, ext := range p.Extensions() {
+		ext = strings.ToLower(ext)
+		if _, dup := byType[ext]; dup {
+			panic(fmt.Sprintf("parsers: extension %s of %s is already registered", ext, p.Name()))
+		}
+		byType[ext] = p
+	}
+	for _, mime := range p.MIMETypes() {
+		// Formats may share a container type, the first one wins
+		if _, dup := byType[mime]; !dup {
+			byType[mime] = p
+		}
+	}
+	formats[p.Name()] = p
+}
+
+// Parsers returns the registered parsers sorted by name
+func Parsers() []BookParser {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	list := make([]BookParser, 0, len(formats))
+	for _, p := range formats {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	return list
+}
+
+// Lookup returns the parser of a file type, the type is either an extension,
+// with or without the leading dot, or a MIME type.
+func Lookup(fileType string) BookParser {
+	fileType = strings.ToLower(fileType)
+	if !strings.Contains(fileType, "/") && !strings.HasPrefix(fileType, ".") {
+		fileType = "." + fileType
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	return byType[fileType]
+}
+
+// ForPath returns the parser for the extension of path, nil if the format
+// isn't supported.
+func ForPath(path string) BookParser {
+	ext := Ext(path)
+	if ext == "" {
+		return nil
+	}
+	return Lookup(ext)
+}
+
+// Ext returns the extension of a book file like filepath.Ext, but keeps the
+// registered compound extensions such as ".fb2.zip" whole.
+func Ext(path string) string {
+	lower := strings.ToLower(path)
+
+	mu.RLock()
+	defer mu.RUnlock()
+	longest := ""
+	for ext := range byType {
+		if strings.HasPrefix(ext, ".") && strings.HasSuffix(lower, ext) && len(ext) > len(longest) {
+			longest = ext
+		}
+	}
+	if longest == "" {
+		return filepath.Ext(path)
+	}
+	return path[len(path)-len(longest):]
+}
+
+// SupportedTypes returns the extensions, without the leading dot, and MIME
+// types of every registered format.
+func SupportedTypes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	types := make([]string, 0, len(byType))
+	for t := range byType {
+		types = append(types, strings.TrimPrefix(t, "."))
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,78 @@
+package parsers
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+type fakeParser struct{}
+
+func (fakeParser) Name() string                       { return "fake" }
+func (fakeParser) Extensions() []string               { return []string{".fake", ".fake.gz"} }
+func (fakeParser) MIMETypes() []string                { return []string{"application/x-fake"} }
+func (fakeParser) Detect(head []byte) bool            { return bytes.HasPrefix(head, []byte("FAKE")) }
+func (fakeParser) Metadata(string) (*Metadata, error) { return &Metadata{Title: "fake"}, nil }
+func (fakeParser) Cover(string, string) (string, error) {
+	return "", nil
+}
+func (fakeParser) Hash(string) (string, error) { return "", nil }
+
+func init() {
+	Register(fakeParser{})
+}
+
+func TestLookup(t *testing.T) {
+	for _, typ := range []string{"fake", ".fake", ".FAKE", "fake.gz", "application/x-fake"} {
+		if p := Lookup(typ); p == nil || p.Name() != "fake" {
+			t.Errorf("Lookup(%q) = %v", typ, p)
+		}
+	}
+	for _, typ := range []string{"", ".", "gz", "epub", "application/zip"} {
+		if p := Lookup(typ); p != nil {
+			t.Errorf("Lookup(%q) = %s, want nil", typ, p.Name())
+		}
+	}
+}
+
+func TestExt(t *testing.T) {
+	tests := map[string]string{
+		"/books/a.fake":       ".fake",
+		"/books/a.Fake.GZ":    ".Fake.GZ",
+		"/books/a.b.gz":       ".gz",
+		"/books/no-extension": "",
+	}
+	for path, want := range tests {
+		if got := Ext(path); got != want {
+			t.Errorf("Ext(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	if ForPath("a.fake.gz") == nil {
+		t.Error("ForPath should find the parser of a compound extension")
+	}
+	if ForPath("a.gz") != nil || ForPath("fake") != nil {
+		t.Error("ForPath should not find a parser for unknown extensions")
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a format twice should panic")
+		}
+	}()
+	Register(fakeParser{})
+}
+
+func TestSupportedTypes(t *testing.T) {
+	types := SupportedTypes()
+	for _, want := range []string{"fake", "fake.gz", "application/x-fake"} {
+		if !slices.Contains(types, want) {
+			t.Errorf("SupportedTypes() = %v, missing %s", types, want)
+		}
+	}
+	if len(Parsers()) != 1 {
+		t.Errorf("Parsers() = %v", Parsers())
+	}
+}
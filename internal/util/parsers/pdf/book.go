@@ -0,0 +1,316 @@
+package pdf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Book is the main struct that holds the metadata of the pdf file
+type Book struct {
+	Info Dict `json:"info"`
+	XMP  *XMP `json:"xmp"`
+
+	doc *Document
+	fd  *os.File
+}
+
+// Close closes the pdf file
+func (p *Book) Close() error {
+	return p.fd.Close()
+}
+
+// Document returns the underlying object reader
+func (p *Book) Document() *Document {
+	return p.doc
+}
+
+// infoString returns a text entry of the Info dictionary
+func (p *Book) infoString(key Name) string {
+	if p.Info == nil {
+		return ""
+	}
+	if s, ok := p.doc.resolve(p.Info[key]).(String); ok {
+		return decodeText(s)
+	}
+	return ""
+}
+
+// The Info dictionary is preferred since most tools keep it up to date,
+// XMP is used to fill in what is missing.
+
+func (p *Book) GetTitle() string {
+	if title := p.infoString("Title"); title != "" {
+		return title
+	}
+	if p.XMP != nil {
+		return p.XMP.Title
+	}
+	return ""
+}
+
+func (p *Book) GetAuthor() string {
+	if author := p.infoString("Author"); author != "" {
+		return author
+	}
+	if p.XMP != nil && len(p.XMP.Creators) > 0 {
+		return strings.Join(p.XMP.Creators, " & ")
+	}
+	return ""
+}
+
+func (p *Book) GetSubject() string {
+	if subject := p.infoString("Subject"); subject != "" {
+		return subject
+	}
+	if p.XMP != nil {
+		return p.XMP.Description
+	}
+	return ""
+}
+
+// GetKeywords returns the keywords, split on commas and semicolons
+func (p *Book) GetKeywords() []string {
+	raw := p.infoString("Keywords")
+	if raw == "" && p.XMP != nil {
+		raw = p.XMP.Keywords
+		if raw == "" {
+			raw = strings.Join(p.XMP.Subjects, ",")
+		}
+	}
+
+	var keywords []string
+	seen := map[string]bool{}
+	for _, k := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ';' }) {
+		k = strings.TrimSpace(k)
+		if k == "" || seen[strings.ToLower(k)] {
+			continue
+		}
+		seen[strings.ToLower(k)] = true
+		keywords = append(keywords, k)
+	}
+	return keywords
+}
+
+// GetDate returns the creation date in RFC3339 format
+func (p *Book) GetDate() string {
+	if raw := p.infoString("CreationDate"); raw != "" {
+		if t, err := parseDate(raw); err == nil {
+			return t.Format(time.RFC3339)
+		}
+	}
+	if p.XMP != nil && p.XMP.CreateDate != "" {
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02", "2006-01", "2006"} {
+			if t, err := time.Parse(layout, p.XMP.CreateDate); err == nil {
+				return t.Format(time.RFC3339)
+			}
+		}
+	}
+	return ""
+}
+
+// IsEncrypted reports whether the document is encrypted, in which case the
+// strings of the Info dictionary can't be read.
+func (p *Book) IsEncrypted() bool {
+	return p.doc.trailer["Encrypt"] != nil
+}
+
+// NumPages returns the page count of the document
+func (p *Book) NumPages() int {
+	pages, _ := p.doc.resolve(p.catalog()["Pages"]).(Dict)
+	if pages == nil {
+		return 0
+	}
+	n, _ := p.doc.resolve(pages["Count"]).(int64)
+	return int(n)
+}
+
+func (p *Book) catalog() Dict {
+	root, _ := p.doc.resolve(p.doc.trailer["Root"]).(Dict)
+	return root
+}
+
+// firstPage walks the page tree down to the first leaf page and returns it
+// together with the resources it inherits.
+func (p *Book) firstPage() (Dict, Dict) {
+	node, _ := p.doc.resolve(p.catalog()["Pages"]).(Dict)
+	var resources Dict
+	for depth := 0; node != nil && depth < maxDepth; depth++ {
+		if r, ok := p.doc.resolve(node["Resources"]).(Dict); ok {
+			resources = r
+		}
+		if node["Type"] == Name("Page") {
+			return node, resources
+		}
+		kids, _ := p.doc.resolve(node["Kids"]).(Array)
+		if len(kids) == 0 {
+			// Some producers omit /Type on leaves.
+			if node["Contents"] != nil {
+				return node, resources
+			}
+			return nil, nil
+		}
+		node, _ = p.doc.resolve(kids[0]).(Dict)
+	}
+	return nil, nil
+}
+
+// largestImage returns the biggest image XObject reachable from resources,
+// descending into form XObjects.
+func (p *Book) largestImage(resources Dict, depth int) *Stream {
+	if resources == nil || depth > 3 {
+		return nil
+	}
+	xobjects, _ := p.doc.resolve(resources["XObject"]).(Dict)
+
+	var best *Stream
+	var bestArea int64
+	for _, v := range xobjects {
+		s, ok := p.doc.resolve(v).(*Stream)
+		if !ok {
+			continue
+		}
+		switch p.doc.resolve(s.Dict["Subtype"]) {
+		case Name("Image"):
+			w, _ := p.doc.resolve(s.Dict["Width"]).(int64)
+			h, _ := p.doc.resolve(s.Dict["Height"]).(int64)
+			if w*h > bestArea {
+				best, bestArea = s, w*h
+			}
+		case Name("Form"):
+			formRes, _ := p.doc.resolve(s.Dict["Resources"]).(Dict)
+			if img := p.largestImage(formRes, depth+1); img != nil {
+				w, _ := p.doc.resolve(img.Dict["Width"]).(int64)
+				h, _ := p.doc.resolve(img.Dict["Height"]).(int64)
+				if w*h > bestArea {
+					best, bestArea = img, w*h
+				}
+			}
+		}
+	}
+	return best
+}
+
+// GetCover extracts the largest image of the first page into dest and
+// returns its path. PDF pages can't be rendered here, so a page without an
+// embedded JPEG or plain RGB/gray image has no cover.
+func (p *Book) GetCover(dest string) (string, error) {
+	page, resources := p.firstPage()
+	if page == nil {
+		return "", nil
+	}
+	if r, ok := p.doc.resolve(page["Resources"]).(Dict); ok {
+		resources = r
+	}
+	img := p.largestImage(resources, 0)
+	if img == nil {
+		return "", nil
+	}
+
+	if _, err := os.Stat(dest); os.IsNotExist(err) {
+		return "", fmt.Errorf("Please make sure dirctory exist")
+	}
+
+	data, isJPEG, err := p.doc.decodeUntil(img, "DCTDecode")
+	if err != nil {
+		return "", err
+	}
+	if isJPEG {
+		fileDest := filepath.Join(dest, "cover.jpg")
+		if err := os.WriteFile(fileDest, data, 0644); err != nil {
+			return "", err
+		}
+		return fileDest, nil
+	}
+
+	decoded, err := p.rasterImage(img, data)
+	if err != nil {
+		return "", err
+	}
+	fileDest := filepath.Join(dest, "cover.png")
+	outFile, err := os.Create(fileDest)
+	if err != nil {
+		return "", err
+	}
+	defer outFile.Close()
+	if err := png.Encode(outFile, decoded); err != nil {
+		return "", err
+	}
+	return fileDest, nil
+}
+
+// rasterImage builds an image from decoded 8-bit RGB or gray samples.
+func (p *Book) rasterImage(s *Stream, data []byte) (image.Image, error) {
+	w, _ := p.doc.resolve(s.Dict["Width"]).(int64)
+	h, _ := p.doc.resolve(s.Dict["Height"]).(int64)
+	bpc, _ := p.doc.resolve(s.Dict["BitsPerComponent"]).(int64)
+	if w <= 0 || h <= 0 || bpc != 8 {
+		return nil, fmt.Errorf("pdf: unsupported cover image format")
+	}
+
+	components := 0
+	switch cs := p.doc.resolve(s.Dict["ColorSpace"]).(type) {
+	case Name:
+		switch cs {
+		case "DeviceRGB", "CalRGB":
+			components = 3
+		case "DeviceGray", "CalGray":
+			components = 1
+		}
+	case Array:
+		// [/ICCBased stream] carries its component count in /N.
+		if len(cs) == 2 && p.doc.resolve(cs[0]) == Name("ICCBased") {
+			if icc, ok := p.doc.resolve(cs[1]).(*Stream); ok {
+				n, _ := p.doc.resolve(icc.Dict["N"]).(int64)
+				if n == 1 || n == 3 {
+					components = int(n)
+				}
+			}
+		}
+	}
+	if components == 0 {
+		return nil, fmt.Errorf("pdf: unsupported cover color space")
+	}
+	if int64(len(data)) < w*h*int64(components) {
+		return nil, fmt.Errorf("pdf: truncated cover image")
+	}
+
+	width, height := int(w), int(h)
+	if components == 1 {
+		img := image.NewGray(image.Rect(0, 0, width, height))
+		copy(img.Pix, data)
+		return img, nil
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := (y*width + x) * 3
+			img.Set(x, y, color.RGBA{data[i], data[i+1], data[i+2], 0xff})
+		}
+	}
+	return img, nil
+}
+
+// Hash returns the sha256 of the whole file, which is stable for a pdf as
+// long as its bytes don't change.
+func Hash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,435 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// Document gives random access to the objects of a PDF file.
+type Document struct {
+	f       *os.File
+	size    int64
+	xref    map[int]xrefEntry
+	trailer Dict
+	cache   map[int]Object
+	// objStms caches decoded object streams by object number
+	objStms map[int]*objStm
+}
+
+type xrefEntry struct {
+	free bool
+	// offset is the byte offset of an uncompressed object
+	offset int64
+	// inStream is set for objects stored inside an object stream
+	inStream bool
+	stream   int
+	index    int
+}
+
+type objStm struct {
+	data    []byte
+	first   int64
+	offsets map[int]int64
+}
+
+// maxDepth bounds recursive walks so that malformed files cannot loop.
+const maxDepth = 32
+
+func newDocument(f *os.File) (*Document, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	d := &Document{
+		f:       f,
+		size:    fi.Size(),
+		xref:    map[int]xrefEntry{},
+		cache:   map[int]Object{},
+		objStms: map[int]*objStm{},
+	}
+
+	err = d.readXref()
+	// Lookups made while the table was incomplete may have cached misses.
+	d.cache = map[int]Object{}
+	if err != nil || d.trailer["Root"] == nil {
+		// The cross-reference data is broken, rebuild it by scanning the file.
+		d.xref = map[int]xrefEntry{}
+		d.trailer = nil
+		d.objStms = map[int]*objStm{}
+		if err := d.reconstruct(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Trailer returns the trailer dictionary.
+func (d *Document) Trailer() Dict {
+	return d.trailer
+}
+
+// resolve follows indirect references until it reaches a direct object.
+func (d *Document) resolve(obj Object) Object {
+	for i := 0; i < maxDepth; i++ {
+		ref, ok := obj.(Ref)
+		if !ok {
+			return obj
+		}
+		obj = d.object(ref.Num)
+	}
+	return nil
+}
+
+// object loads the object with the given number, or nil if it can't be read.
+func (d *Document) object(num int) Object {
+	if obj, ok := d.cache[num]; ok {
+		return obj
+	}
+	// Mark the object as being loaded to break reference cycles.
+	d.cache[num] = nil
+
+	entry, ok := d.xref[num]
+	if !ok || entry.free {
+		return nil
+	}
+
+	var obj Object
+	if entry.inStream {
+		obj = d.objectFromStream(entry.stream, num)
+	} else {
+		l := newLexer(io.NewSectionReader(d.f, entry.offset, d.size-entry.offset), d.resolve)
+		_, o, err := l.readIndirect()
+		if err == nil {
+			obj = o
+		}
+	}
+	d.cache[num] = obj
+	return obj
+}
+
+func (d *Document) objectFromStream(stmNum, num int) Object {
+	stm, err := d.loadObjStm(stmNum)
+	if err != nil {
+		return nil
+	}
+	off, ok := stm.offsets[num]
+	if !ok {
+		return nil
+	}
+	start := stm.first + off
+	if start < 0 || start >= int64(len(stm.data)) {
+		return nil
+	}
+	l := newLexer(bytes.NewReader(stm.data[start:]), d.resolve)
+	obj, err := l.readObject()
+	if err != nil {
+		return nil
+	}
+	if _, isKeyword := obj.(keyword); isKeyword {
+		return nil
+	}
+	return obj
+}
+
+func (d *Document) loadObjStm(num int) (*objStm, error) {
+	if stm, ok := d.objStms[num]; ok {
+		return stm, nil
+	}
+	s, ok := d.object(num).(*Stream)
+	if !ok {
+		return nil, fmt.Errorf("pdf: object %d is not an object stream", num)
+	}
+	data, err := d.decodeStream(s)
+	if err != nil {
+		return nil, err
+	}
+	n, _ := d.resolve(s.Dict["N"]).(int64)
+	first, _ := d.resolve(s.Dict["First"]).(int64)
+
+	stm := &objStm{data: data, first: first, offsets: map[int]int64{}}
+	l := newLexer(bytes.NewReader(data), nil)
+	for i := int64(0); i < n; i++ {
+		objNum, err1 := l.readToken()
+		off, err2 := l.readToken()
+		if err1 != nil || err2 != nil {
+			break
+		}
+		on, ok1 := objNum.(int64)
+		oo, ok2 := off.(int64)
+		if !ok1 || !ok2 {
+			break
+		}
+		stm.offsets[int(on)] = oo
+	}
+	d.objStms[num] = stm
+	return stm, nil
+}
+
+// readXref reads the cross-reference sections starting at startxref and
+// following the Prev chain of incremental updates.
+func (d *Document) readXref() error {
+	off, err := d.findStartXref()
+	if err != nil {
+		return err
+	}
+
+	seen := map[int64]bool{}
+	for i := 0; i < maxDepth && off > 0 && !seen[off]; i++ {
+		seen[off] = true
+		trailer, err := d.readXrefSection(off)
+		if err != nil {
+			return err
+		}
+		if d.trailer == nil {
+			d.trailer = trailer
+		}
+		// Hybrid files keep part of the table in a cross-reference stream.
+		if stmOff, ok := trailer["XRefStm"].(int64); ok && !seen[stmOff] {
+			seen[stmOff] = true
+			if _, err := d.readXrefSection(stmOff); err != nil {
+				return err
+			}
+		}
+		prev, ok := trailer["Prev"].(int64)
+		if !ok {
+			break
+		}
+		off = prev
+	}
+	if d.trailer == nil {
+		return fmt.Errorf("pdf: missing trailer")
+	}
+	return nil
+}
+
+func (d *Document) findStartXref() (int64, error) {
+	tail := int64(2048)
+	if tail > d.size {
+		tail = d.size
+	}
+	buf := make([]byte, tail)
+	if _, err := d.f.ReadAt(buf, d.size-tail); err != nil && err != io.EOF {
+		return 0, err
+	}
+	i := bytes.LastIndex(buf, []byte("startxref"))
+	if i < 0 {
+		return 0, fmt.Errorf("pdf: missing startxref")
+	}
+	l := newLexer(bytes.NewReader(buf[i+len("startxref"):]), nil)
+	tok, err := l.readToken()
+	if err != nil {
+		return 0, err
+	}
+	off, ok := tok.(int64)
+	if !ok || off <= 0 || off >= d.size {
+		return 0, fmt.Errorf("pdf: invalid startxref offset")
+	}
+	return off, nil
+}
+
+// readXrefSection reads a classic xref table or a cross-reference stream
+// at off and returns its trailer dictionary.
+func (d *Document) readXrefSection(off int64) (Dict, error) {
+	l := newLexer(io.NewSectionReader(d.f, off, d.size-off), d.resolve)
+	tok, err := l.readToken()
+	if err != nil {
+		return nil, err
+	}
+	if tok != keyword("xref") {
+		l.unreadToken(tok)
+		return d.readXrefStream(l)
+	}
+
+	for {
+		tok, err := l.readToken()
+		if err != nil {
+			return nil, err
+		}
+		if tok == keyword("trailer") {
+			obj, err := l.readObject()
+			if err != nil {
+				return nil, err
+			}
+			trailer, ok := obj.(Dict)
+			if !ok {
+				return nil, fmt.Errorf("pdf: malformed trailer")
+			}
+			return trailer, nil
+		}
+
+		start, ok := tok.(int64)
+		if !ok {
+			return nil, fmt.Errorf("pdf: malformed xref subsection")
+		}
+		countTok, err := l.readToken()
+		if err != nil {
+			return nil, err
+		}
+		count, ok := countTok.(int64)
+		if !ok {
+			return nil, fmt.Errorf("pdf: malformed xref subsection")
+		}
+		for i := int64(0); i < count; i++ {
+			offTok, err1 := l.readToken()
+			_, err2 := l.readToken()
+			kind, err3 := l.readToken()
+			if err1 != nil || err2 != nil || err3 != nil {
+				return nil, fmt.Errorf("pdf: truncated xref table")
+			}
+			num := int(start + i)
+			if _, exists := d.xref[num]; exists {
+				continue
+			}
+			entryOff, _ := offTok.(int64)
+			d.xref[num] = xrefEntry{free: kind != keyword("n"), offset: entryOff}
+		}
+	}
+}
+
+func (d *Document) readXrefStream(l *lexer) (Dict, error) {
+	_, obj, err := l.readIndirect()
+	if err != nil {
+		return nil, err
+	}
+	s, ok := obj.(*Stream)
+	if !ok || s.Dict["Type"] != Name("XRef") {
+		return nil, fmt.Errorf("pdf: expected xref stream")
+	}
+	data, err := d.decodeStream(s)
+	if err != nil {
+		return nil, err
+	}
+
+	w, _ := s.Dict["W"].(Array)
+	if len(w) != 3 {
+		return nil, fmt.Errorf("pdf: malformed xref stream W")
+	}
+	widths := make([]int, 3)
+	rowLen := 0
+	for i, v := range w {
+		n, _ := v.(int64)
+		widths[i] = int(n)
+		rowLen += int(n)
+	}
+	if rowLen == 0 {
+		return nil, fmt.Errorf("pdf: malformed xref stream W")
+	}
+
+	index, _ := s.Dict["Index"].(Array)
+	if index == nil {
+		size, _ := s.Dict["Size"].(int64)
+		index = Array{int64(0), size}
+	}
+
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		start, _ := index[i].(int64)
+		count, _ := index[i+1].(int64)
+		for j := int64(0); j < count; j++ {
+			if pos+rowLen > len(data) {
+				return s.Dict, nil
+			}
+			row := data[pos : pos+rowLen]
+			pos += rowLen
+
+			fields := make([]int64, 3)
+			p := 0
+			for k, width := range widths {
+				for b := 0; b < width; b++ {
+					fields[k] = fields[k]<<8 | int64(row[p])
+					p++
+				}
+			}
+			// The type field defaults to 1 when its width is zero.
+			if widths[0] == 0 {
+				fields[0] = 1
+			}
+
+			num := int(start + j)
+			if _, exists := d.xref[num]; exists {
+				continue
+			}
+			switch fields[0] {
+			case 0:
+				d.xref[num] = xrefEntry{free: true}
+			case 1:
+				d.xref[num] = xrefEntry{offset: fields[1]}
+			case 2:
+				d.xref[num] = xrefEntry{inStream: true, stream: int(fields[1]), index: int(fields[2])}
+			}
+		}
+	}
+	return s.Dict, nil
+}
+
+var objHeaderRegexp = regexp.MustCompile(`(\d+)[ \t\r\n\f\x00]+(\d+)[ \t\r\n\f\x00]+obj\b`)
+
+// reconstruct rebuilds the cross-reference table by scanning the whole file
+// for object headers. It's only used for damaged files.
+func (d *Document) reconstruct() error {
+	data, err := io.ReadAll(io.NewSectionReader(d.f, 0, d.size))
+	if err != nil {
+		return err
+	}
+
+	for _, m := range objHeaderRegexp.FindAllSubmatchIndex(data, -1) {
+		// Object numbers must start at a token boundary.
+		if m[0] > 0 && !isWhite(data[m[0]-1]) && !isDelim(data[m[0]-1]) {
+			continue
+		}
+		num, err := strconv.Atoi(string(data[m[2]:m[3]]))
+		if err != nil {
+			continue
+		}
+		// Later definitions override earlier ones, like incremental updates.
+		d.xref[num] = xrefEntry{offset: int64(m[0])}
+	}
+
+	// Objects inside object streams aren't visible to the scan above.
+	scanned := make([]int, 0, len(d.xref))
+	for num := range d.xref {
+		scanned = append(scanned, num)
+	}
+	for _, num := range scanned {
+		s, ok := d.object(num).(*Stream)
+		if !ok || s.Dict["Type"] != Name("ObjStm") {
+			continue
+		}
+		stm, err := d.loadObjStm(num)
+		if err != nil {
+			continue
+		}
+		for objNum := range stm.offsets {
+			if _, exists := d.xref[objNum]; !exists {
+				d.xref[objNum] = xrefEntry{inStream: true, stream: num}
+			}
+		}
+	}
+
+	if i := bytes.LastIndex(data, []byte("trailer")); i >= 0 {
+		l := newLexer(bytes.NewReader(data[i+len("trailer"):]), d.resolve)
+		if obj, err := l.readObject(); err == nil {
+			d.trailer, _ = obj.(Dict)
+		}
+	}
+	if d.trailer == nil {
+		d.trailer = Dict{}
+	}
+
+	if d.trailer["Root"] == nil {
+		for num := range d.xref {
+			if dict, ok := d.object(num).(Dict); ok && dict["Type"] == Name("Catalog") {
+				d.trailer["Root"] = Ref{Num: num}
+				break
+			}
+		}
+	}
+	if d.trailer["Root"] == nil {
+		return fmt.Errorf("pdf: unable to find document catalog")
+	}
+	return nil
+}
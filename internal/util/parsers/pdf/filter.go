@@ -0,0 +1,221 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// filters returns the stream filters and their decode parameters in order.
+func (d *Document) filters(s *Stream) ([]Name, []Dict) {
+	var names []Name
+	var params []Dict
+
+	switch f := d.resolve(s.Dict["Filter"]).(type) {
+	case Name:
+		names = append(names, f)
+	case Array:
+		for _, v := range f {
+			if n, ok := d.resolve(v).(Name); ok {
+				names = append(names, n)
+			}
+		}
+	}
+
+	switch p := d.resolve(s.Dict["DecodeParms"]).(type) {
+	case Dict:
+		params = append(params, p)
+	case Array:
+		for _, v := range p {
+			dict, _ := d.resolve(v).(Dict)
+			params = append(params, dict)
+		}
+	}
+	for len(params) < len(names) {
+		params = append(params, nil)
+	}
+	return names, params
+}
+
+// decodeStream applies every filter of the stream.
+func (d *Document) decodeStream(s *Stream) ([]byte, error) {
+	names, params := d.filters(s)
+	return decode(s.Data, names, params)
+}
+
+// decodeUntil applies the stream filters up to, but not including, the
+// first filter named stop. It returns the partially decoded data and
+// whether stop was found.
+func (d *Document) decodeUntil(s *Stream, stop Name) ([]byte, bool, error) {
+	names, params := d.filters(s)
+	for i, n := range names {
+		if n == stop {
+			data, err := decode(s.Data, names[:i], params[:i])
+			return data, true, err
+		}
+	}
+	data, err := decode(s.Data, names, params)
+	return data, false, err
+}
+
+func decode(data []byte, names []Name, params []Dict) ([]byte, error) {
+	var err error
+	for i, name := range names {
+		switch name {
+		case "FlateDecode", "Fl":
+			data, err = inflate(data)
+			if err != nil {
+				return nil, err
+			}
+			data, err = applyPredictor(data, params[i])
+			if err != nil {
+				return nil, err
+			}
+		case "ASCIIHexDecode", "AHx":
+			data, err = asciiHexDecode(data)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("pdf: unsupported filter %s", name)
+		}
+	}
+	return data, nil
+}
+
+// maxInflateSize caps the decompressed size of a stream, a few bytes of
+// FlateDecode can otherwise expand to fill the memory.
+const maxInflateSize = 128 << 20
+
+// errInflateTooLarge is returned for the streams decompressing past
+// maxInflateSize
+var errInflateTooLarge = fmt.Errorf("pdf: inflate: stream larger than %d bytes", maxInflateSize)
+
+// inflate decompresses zlib data. Some producers write raw deflate data
+// without the zlib header, so fall back to that.
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err == nil {
+		defer zr.Close()
+		out, err := readInflated(zr)
+		if err == errInflateTooLarge {
+			return nil, err
+		}
+		if err == nil || len(out) > 0 {
+			return out, nil
+		}
+	}
+	fr := flate.NewReader(bytes.NewReader(data))
+	defer fr.Close()
+	out, err := readInflated(fr)
+	if err == errInflateTooLarge {
+		return nil, err
+	}
+	if err != nil && len(out) == 0 {
+		return nil, fmt.Errorf("pdf: inflate: %w", err)
+	}
+	return out, nil
+}
+
+// readInflated reads r up to maxInflateSize. The data read before an error
+// is returned along with it.
+func readInflated(r io.Reader) ([]byte, error) {
+	out, err := io.ReadAll(io.LimitReader(r, maxInflateSize+1))
+	if len(out) > maxInflateSize {
+		return nil, errInflateTooLarge
+	}
+	return out, err
+}
+
+func asciiHexDecode(data []byte) ([]byte, error) {
+	l := newLexer(bytes.NewReader(append(data, '>')), nil)
+	s, err := l.readHexString()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s), nil
+}
+
+// applyPredictor reverses the PNG predictors used by FlateDecode.
+func applyPredictor(data []byte, params Dict) ([]byte, error) {
+	if params == nil {
+		return data, nil
+	}
+	predictor, _ := params["Predictor"].(int64)
+	if predictor < 10 {
+		if predictor > 1 {
+			return nil, fmt.Errorf("pdf: unsupported predictor %d", predictor)
+		}
+		return data, nil
+	}
+
+	colors, columns, bpc := int64(1), int64(1), int64(8)
+	if v, ok := params["Colors"].(int64); ok && v > 0 {
+		colors = v
+	}
+	if v, ok := params["Columns"].(int64); ok && v > 0 {
+		columns = v
+	}
+	if v, ok := params["BitsPerComponent"].(int64); ok && v > 0 {
+		bpc = v
+	}
+	bpp := int((colors*bpc + 7) / 8)
+	rowLen := int((colors*bpc*columns + 7) / 8)
+
+	out := make([]byte, 0, len(data))
+	prev := make([]byte, rowLen)
+	for i := 0; i < len(data); i += rowLen + 1 {
+		filter := data[i]
+		end := i + 1 + rowLen
+		if end > len(data) {
+			end = len(data)
+		}
+		row := make([]byte, rowLen)
+		copy(row, data[i+1:end])
+		for j := 0; j < rowLen; j++ {
+			var left, upLeft byte
+			if j >= bpp {
+				left = row[j-bpp]
+				upLeft = prev[j-bpp]
+			}
+			up := prev[j]
+			switch filter {
+			case 0:
+			case 1:
+				row[j] += left
+			case 2:
+				row[j] += up
+			case 3:
+				row[j] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[j] += paeth(left, up, upLeft)
+			default:
+				return nil, fmt.Errorf("pdf: unknown png filter %d", filter)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
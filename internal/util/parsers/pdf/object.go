@@ -0,0 +1,458 @@
+package pdf // import "github.com/Xunop/e-oasis/internal/util/parsers/pdf"
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Object is any PDF object: nil, bool, int64, float64, String, Name,
+// Array, Dict, Ref or *Stream.
+type Object interface{}
+
+// Name is a PDF name object, stored without the leading slash.
+type Name string
+
+// String is a PDF string object, stored as raw bytes.
+type String string
+
+// Array is a PDF array object.
+type Array []Object
+
+// Dict is a PDF dictionary object.
+type Dict map[Name]Object
+
+// Ref is an indirect reference to another object.
+type Ref struct {
+	Num int
+	Gen int
+}
+
+// Stream is a PDF stream object. Data holds the raw, still encoded bytes.
+type Stream struct {
+	Dict Dict
+	Data []byte
+}
+
+// keyword is a bare token such as obj, endobj, R, stream or a delimiter.
+type keyword string
+
+func isWhite(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isDelim(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// lexer reads PDF tokens and objects from a byte stream.
+type lexer struct {
+	r *bufio.Reader
+	// pending holds tokens that were read ahead and pushed back
+	pending []interface{}
+	// resolve is used to look up indirect stream lengths, may be nil
+	resolve func(Object) Object
+}
+
+func newLexer(r io.Reader, resolve func(Object) Object) *lexer {
+	return &lexer{r: bufio.NewReader(r), resolve: resolve}
+}
+
+func (l *lexer) unreadToken(tok interface{}) {
+	l.pending = append(l.pending, tok)
+}
+
+// readToken returns the next token, which is one of int64, float64,
+// String, Name or keyword.
+func (l *lexer) readToken() (interface{}, error) {
+	if n := len(l.pending); n > 0 {
+		tok := l.pending[n-1]
+		l.pending = l.pending[:n-1]
+		return tok, nil
+	}
+
+	c, err := l.skipSpace()
+	if err != nil {
+		return nil, err
+	}
+
+	switch c {
+	case '/':
+		return l.readName()
+	case '(':
+		return l.readLiteralString()
+	case '<':
+		next, err := l.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if next == '<' {
+			return keyword("<<"), nil
+		}
+		l.r.UnreadByte()
+		return l.readHexString()
+	case '>':
+		next, err := l.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if next == '>' {
+			return keyword(">>"), nil
+		}
+		return nil, fmt.Errorf("pdf: unexpected '>'")
+	case '[', ']', '{', '}':
+		return keyword(string(c)), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(c)
+	for {
+		c, err := l.r.ReadByte()
+		if err != nil {
+			break
+		}
+		if isWhite(c) || isDelim(c) {
+			l.r.UnreadByte()
+			break
+		}
+		buf.WriteByte(c)
+	}
+	word := buf.String()
+	if i, err := strconv.ParseInt(word, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(word, 64); err == nil {
+		return f, nil
+	}
+	return keyword(word), nil
+}
+
+// skipSpace skips whitespace and comments and returns the first other byte.
+func (l *lexer) skipSpace() (byte, error) {
+	for {
+		c, err := l.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if isWhite(c) {
+			continue
+		}
+		if c == '%' {
+			for {
+				c, err = l.r.ReadByte()
+				if err != nil {
+					return 0, err
+				}
+				if c == '\r' || c == '\n' {
+					break
+				}
+			}
+			continue
+		}
+		return c, nil
+	}
+}
+
+func (l *lexer) readName() (Name, error) {
+	var buf bytes.Buffer
+	for {
+		c, err := l.r.ReadByte()
+		if err != nil {
+			break
+		}
+		if isWhite(c) || isDelim(c) {
+			l.r.UnreadByte()
+			break
+		}
+		if c == '#' {
+			hex := make([]byte, 2)
+			if _, err := io.ReadFull(l.r, hex); err != nil {
+				return "", err
+			}
+			v, err := strconv.ParseUint(string(hex), 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("pdf: malformed name escape #%s", hex)
+			}
+			c = byte(v)
+		}
+		buf.WriteByte(c)
+	}
+	return Name(buf.String()), nil
+}
+
+func (l *lexer) readLiteralString() (String, error) {
+	var buf bytes.Buffer
+	depth := 1
+	for {
+		c, err := l.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return String(buf.String()), nil
+			}
+		case '\\':
+			c, err = l.r.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// Line continuation, swallow an optional LF as well.
+				if next, err := l.r.ReadByte(); err == nil && next != '\n' {
+					l.r.UnreadByte()
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := int(c - '0')
+					for i := 0; i < 2; i++ {
+						next, err := l.r.ReadByte()
+						if err != nil {
+							break
+						}
+						if next < '0' || next > '7' {
+							l.r.UnreadByte()
+							break
+						}
+						v = v*8 + int(next-'0')
+					}
+					c = byte(v)
+				}
+			}
+		}
+		buf.WriteByte(c)
+	}
+}
+
+func (l *lexer) readHexString() (String, error) {
+	var digits []byte
+	for {
+		c, err := l.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if c == '>' {
+			break
+		}
+		if isWhite(c) {
+			continue
+		}
+		digits = append(digits, c)
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		v, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("pdf: malformed hex string")
+		}
+		out[i] = byte(v)
+	}
+	return String(out), nil
+}
+
+// readObject reads one complete object. Keywords that are not part of an
+// object (obj, endobj, stream, ...) are returned as keyword values.
+func (l *lexer) readObject() (Object, error) {
+	tok, err := l.readToken()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case keyword:
+		switch t {
+		case "<<":
+			return l.readDict()
+		case "[":
+			return l.readArray()
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return t, nil
+	case int64:
+		// An integer may start an indirect reference "num gen R".
+		tok2, err := l.readToken()
+		if err != nil {
+			return t, nil
+		}
+		gen, ok := tok2.(int64)
+		if !ok {
+			l.unreadToken(tok2)
+			return t, nil
+		}
+		tok3, err := l.readToken()
+		if err != nil {
+			l.unreadToken(tok2)
+			return t, nil
+		}
+		if kw, ok := tok3.(keyword); ok && kw == "R" {
+			return Ref{Num: int(t), Gen: int(gen)}, nil
+		}
+		l.unreadToken(tok3)
+		l.unreadToken(tok2)
+		return t, nil
+	}
+	return tok, nil
+}
+
+func (l *lexer) readDict() (Dict, error) {
+	dict := Dict{}
+	for {
+		tok, err := l.readToken()
+		if err != nil {
+			return nil, err
+		}
+		if kw, ok := tok.(keyword); ok && kw == ">>" {
+			return dict, nil
+		}
+		key, ok := tok.(Name)
+		if !ok {
+			return nil, fmt.Errorf("pdf: dictionary key is not a name: %v", tok)
+		}
+		value, err := l.readObject()
+		if err != nil {
+			return nil, err
+		}
+		if kw, ok := value.(keyword); ok && kw == ">>" {
+			// Tolerate a missing value before the end of the dictionary.
+			return dict, nil
+		}
+		dict[key] = value
+	}
+}
+
+func (l *lexer) readArray() (Array, error) {
+	var arr Array
+	for {
+		obj, err := l.readObject()
+		if err != nil {
+			return nil, err
+		}
+		if kw, ok := obj.(keyword); ok && kw == "]" {
+			return arr, nil
+		}
+		arr = append(arr, obj)
+	}
+}
+
+// readIndirect reads "num gen obj ... endobj" and returns the object number
+// and the object. Streams are returned as *Stream.
+func (l *lexer) readIndirect() (int, Object, error) {
+	num, err := l.readToken()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, ok := num.(int64)
+	if !ok {
+		return 0, nil, fmt.Errorf("pdf: expected object number, got %v", num)
+	}
+	if _, err := l.readToken(); err != nil {
+		return 0, nil, err
+	}
+	kw, err := l.readToken()
+	if err != nil {
+		return 0, nil, err
+	}
+	if kw != keyword("obj") {
+		return 0, nil, fmt.Errorf("pdf: expected obj keyword, got %v", kw)
+	}
+
+	obj, err := l.readObject()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	dict, ok := obj.(Dict)
+	if !ok {
+		return int(n), obj, nil
+	}
+	next, err := l.readToken()
+	if err != nil || next != keyword("stream") {
+		return int(n), obj, nil
+	}
+
+	data, err := l.readStreamData(dict)
+	if err != nil {
+		return 0, nil, err
+	}
+	return int(n), &Stream{Dict: dict, Data: data}, nil
+}
+
+// readStreamData reads the stream body following the stream keyword.
+func (l *lexer) readStreamData(dict Dict) ([]byte, error) {
+	// The stream keyword is followed by CRLF or LF.
+	c, err := l.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if c == '\r' {
+		if c, err = l.r.ReadByte(); err == nil && c != '\n' {
+			l.r.UnreadByte()
+		}
+	} else if c != '\n' {
+		l.r.UnreadByte()
+	}
+
+	length := int64(-1)
+	lengthObj := dict["Length"]
+	if l.resolve != nil {
+		lengthObj = l.resolve(lengthObj)
+	}
+	if v, ok := lengthObj.(int64); ok && v >= 0 {
+		length = v
+	}
+
+	if length >= 0 {
+		data := make([]byte, length)
+		if _, err := io.ReadFull(l.r, data); err == nil {
+			return data, nil
+		}
+		return nil, fmt.Errorf("pdf: truncated stream")
+	}
+
+	// Unknown length, read until the endstream keyword.
+	var buf bytes.Buffer
+	marker := []byte("endstream")
+	for {
+		c, err := l.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(c)
+		if bytes.HasSuffix(buf.Bytes(), marker) {
+			data := buf.Bytes()[:buf.Len()-len(marker)]
+			return bytes.TrimRight(data, "\r\n"), nil
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+func Open(f string) (*Book, error) {
+	fd, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 1024)
+	n, err := io.ReadFull(fd, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		fd.Close()
+		return nil, err
+	}
+	if !bytes.Contains(header[:n], []byte("%PDF-")) {
+		fd.Close()
+		return nil, fmt.Errorf("pdf: missing %%PDF header")
+	}
+
+	doc, err := newDocument(fd)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	b := &Book{doc: doc, fd: fd}
+	if info, ok := doc.resolve(doc.trailer["Info"]).(Dict); ok && !b.IsEncrypted() {
+		b.Info = info
+	}
+
+	// XMP metadata streams are usually left uncompressed, but not always.
+	if s, ok := doc.resolve(b.catalog()["Metadata"]).(*Stream); ok && !b.IsEncrypted() {
+		if data, err := doc.decodeStream(s); err == nil {
+			b.XMP, _ = parseXMP(data)
+		}
+	}
+
+	return b, nil
+}
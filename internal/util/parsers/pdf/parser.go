@@ -0,0 +1,61 @@
+package pdf
+
+import (
+	"bytes"
+
+	"github.com/Xunop/e-oasis/internal/util/parsers"
+)
+
+// MIMEType is the media type of pdf files
+const MIMEType = "application/pdf"
+
+func init() {
+	parsers.Register(parser{})
+}
+
+type parser struct{}
+
+func (parser) Name() string         { return "pdf" }
+func (parser) Extensions() []string { return []string{".pdf"} }
+func (parser) MIMETypes() []string  { return []string{MIMEType} }
+
+// Detect looks for the header in the first kilobyte, like Open does, since
+// some writers put garbage before it.
+func (parser) Detect(head []byte) bool {
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	return bytes.Contains(head, []byte("%PDF-"))
+}
+
+func (parser) Metadata(path string) (*parsers.Metadata, error) {
+	book, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+
+	meta := &parsers.Metadata{
+		Title:       book.GetTitle(),
+		Description: book.GetSubject(),
+		Tags:        book.GetKeywords(),
+		Date:        book.GetDate(),
+	}
+	// The Author entry is free text, it is kept as a single author
+	meta.Creators = parsers.Authors(book.GetAuthor())
+	return meta, nil
+}
+
+func (parser) Cover(path, dest string) (string, error) {
+	book, err := Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer book.Close()
+	return book.GetCover(dest)
+}
+
+// Hash of a pdf is the file itself, there is no container to normalize
+func (parser) Hash(path string) (string, error) {
+	return Hash(path)
+}
@@ -0,0 +1,215 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testXMP = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:CreateDate="2019-05-04T10:00:00Z">
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">XMP title</rdf:li></rdf:Alt></dc:title>
+<dc:creator><rdf:Seq><rdf:li>Ann Author</rdf:li><rdf:li>Bob Writer</rdf:li></rdf:Seq></dc:creator>
+<dc:subject><rdf:Bag><rdf:li>go</rdf:li><rdf:li>testing</rdf:li></rdf:Bag></dc:subject>
+</rdf:Description>
+</rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+// createPdf writes a one page pdf with an optional Info dictionary, an XMP
+// packet and a 2x2 RGB image on the page. When brokenXref is set the
+// startxref offset points to garbage.
+func createPdf(n string, info string, brokenXref bool) error {
+	var pixels bytes.Buffer
+	zw := zlib.NewWriter(&pixels)
+	zw.Write([]byte{255, 0, 0, 0, 255, 0, 0, 0, 255, 255, 255, 255})
+	zw.Close()
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R /Metadata 5 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 /Resources << /XObject << /Im1 4 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 2 2] >>",
+		fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width 2 /Height 2 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", pixels.Len(), pixels.String()),
+		fmt.Sprintf("<< /Type /Metadata /Subtype /XML /Length %d >>\nstream\n%s\nendstream", len(testXMP), testXMP),
+	}
+	if info != "" {
+		objects = append(objects, info)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xrefOff := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	trailer := fmt.Sprintf("<< /Size %d /Root 1 0 R", len(objects)+1)
+	if info != "" {
+		trailer += fmt.Sprintf(" /Info %d 0 R", len(objects))
+	}
+	if brokenXref {
+		xrefOff = 20
+	}
+	fmt.Fprintf(&buf, "trailer\n%s >>\nstartxref\n%d\n%%%%EOF\n", trailer, xrefOff)
+
+	return os.WriteFile(n, buf.Bytes(), 0644)
+}
+
+func TestPdf(t *testing.T) {
+	withNewBook := func(t *testing.T, info string, brokenXref bool, fn func(*Book)) {
+		f := filepath.Join(t.TempDir(), "test.pdf")
+		if err := createPdf(f, info, brokenXref); err != nil {
+			t.Fatal(err)
+		}
+		b, err := Open(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		fn(b)
+	}
+
+	// The title is UTF-16BE with a byte order mark: "Título".
+	info := "<< /Title <FEFF005400ED00740075006C006F> /Author (Jane Doe) /Subject (A short book) " +
+		"/Keywords (go; pdf, Go) /CreationDate (D:20200131235959+08'00') >>"
+
+	t.Run("Info", func(t *testing.T) {
+		withNewBook(t, info, false, func(b *Book) {
+			if got := b.GetTitle(); got != "Título" {
+				t.Errorf("title = %q", got)
+			}
+			if got := b.GetAuthor(); got != "Jane Doe" {
+				t.Errorf("author = %q", got)
+			}
+			if got := b.GetSubject(); got != "A short book" {
+				t.Errorf("subject = %q", got)
+			}
+			if got := b.GetKeywords(); len(got) != 2 || got[0] != "go" || got[1] != "pdf" {
+				t.Errorf("keywords = %q", got)
+			}
+			if got := b.GetDate(); got != "2020-01-31T23:59:59+08:00" {
+				t.Errorf("date = %q", got)
+			}
+			if got := b.NumPages(); got != 1 {
+				t.Errorf("pages = %d", got)
+			}
+		})
+	})
+
+	t.Run("XMP", func(t *testing.T) {
+		withNewBook(t, "", false, func(b *Book) {
+			if got := b.GetTitle(); got != "XMP title" {
+				t.Errorf("title = %q", got)
+			}
+			if got := b.GetAuthor(); got != "Ann Author & Bob Writer" {
+				t.Errorf("author = %q", got)
+			}
+			if got := b.GetKeywords(); len(got) != 2 || got[1] != "testing" {
+				t.Errorf("keywords = %q", got)
+			}
+			if got := b.GetDate(); got != "2019-05-04T10:00:00Z" {
+				t.Errorf("date = %q", got)
+			}
+		})
+	})
+
+	t.Run("BrokenXref", func(t *testing.T) {
+		withNewBook(t, info, true, func(b *Book) {
+			if got := b.GetAuthor(); got != "Jane Doe" {
+				t.Errorf("author = %q", got)
+			}
+		})
+	})
+
+	t.Run("Cover", func(t *testing.T) {
+		withNewBook(t, info, false, func(b *Book) {
+			dir := t.TempDir()
+			cover, err := b.GetCover(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cover != filepath.Join(dir, "cover.png") {
+				t.Fatalf("cover = %q", cover)
+			}
+			f, err := os.Open(cover)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			img, err := png.Decode(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r, g, _, _ := img.At(1, 0).RGBA(); r != 0 || g != 0xffff {
+				t.Errorf("unexpected pixel at (1, 0): %v", img.At(1, 0))
+			}
+		})
+	})
+}
+
+func TestHash(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.pdf"), filepath.Join(dir, "b.pdf")
+	if err := createPdf(a, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := createPdf(b, "", false); err != nil {
+		t.Fatal(err)
+	}
+	ha, err := Hash(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb, err := Hash(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ha != hb {
+		t.Errorf("hash of identical files differ: %s != %s", ha, hb)
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	cases := map[string]string{
+		"D:2021":                  "2021-01-01T00:00:00Z",
+		"D:20210304":              "2021-03-04T00:00:00Z",
+		"D:20210304050607Z":       "2021-03-04T05:06:07Z",
+		"D:20210304050607-05'30'": "2021-03-04T05:06:07-05:30",
+	}
+	for in, want := range cases {
+		got, err := parseDate(in)
+		if err != nil {
+			t.Errorf("parseDate(%q): %v", in, err)
+			continue
+		}
+		if got.Format(time.RFC3339) != want {
+			t.Errorf("parseDate(%q) = %s, want %s", in, got.Format(time.RFC3339), want)
+		}
+	}
+}
+
+func TestInflateLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	zeros := make([]byte, 1<<20)
+	for i := 0; i <= maxInflateSize>>20; i++ {
+		w.Write(zeros)
+	}
+	w.Close()
+	if _, err := inflate(buf.Bytes()); err != errInflateTooLarge {
+		t.Fatalf("inflate of %d compressed bytes: %v, want %v", buf.Len(), err, errInflateTooLarge)
+	}
+}
@@ -0,0 +1,89 @@
+package pdf
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// pdfDocEncoding maps the PDFDocEncoding bytes that differ from Latin-1.
+var pdfDocEncoding = map[byte]rune{
+	0x18: '˘', 0x19: 'ˇ', 0x1a: 'ˆ', 0x1b: '˙',
+	0x1c: '˝', 0x1d: '˛', 0x1e: '˚', 0x1f: '˜',
+	0x80: '•', 0x81: '†', 0x82: '‡', 0x83: '…',
+	0x84: '—', 0x85: '–', 0x86: 'ƒ', 0x87: '⁄',
+	0x88: '‹', 0x89: '›', 0x8a: '−', 0x8b: '‰',
+	0x8c: '„', 0x8d: '“', 0x8e: '”', 0x8f: '‘',
+	0x90: '’', 0x91: '‚', 0x92: '™', 0x93: 'ﬁ',
+	0x94: 'ﬂ', 0x95: 'Ł', 0x96: 'Œ', 0x97: 'Š',
+	0x98: 'Ÿ', 0x99: 'Ž', 0x9a: 'ı', 0x9b: 'ł',
+	0x9c: 'œ', 0x9d: 'š', 0x9e: 'ž', 0xa0: '€',
+}
+
+// decodeText decodes a PDF text string, which is either UTF-16BE with a
+// byte order mark, UTF-8 with a byte order mark or PDFDocEncoding.
+func decodeText(s String) string {
+	b := []byte(s)
+	switch {
+	case len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff:
+		b = b[2:]
+		units := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return strings.TrimSpace(string(utf16.Decode(units)))
+	case len(b) >= 3 && b[0] == 0xef && b[1] == 0xbb && b[2] == 0xbf:
+		return strings.TrimSpace(string(b[3:]))
+	}
+
+	var sb strings.Builder
+	for _, c := range b {
+		if r, ok := pdfDocEncoding[c]; ok {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteRune(rune(c))
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// parseDate parses a PDF date string such as D:20200131235959+08'00'.
+func parseDate(s string) (time.Time, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "D:")
+	if len(s) < 4 {
+		return time.Time{}, fmt.Errorf("pdf: malformed date %q", s)
+	}
+
+	// Pad the optional components with their defaults.
+	digits := s
+	zone := ""
+	if i := strings.IndexAny(s, "Zz+-"); i >= 0 {
+		digits, zone = s[:i], s[i:]
+	}
+	defaults := "00000101000000"
+	if len(digits) > len(defaults) {
+		digits = digits[:len(defaults)]
+	}
+	digits += defaults[len(digits):]
+
+	t, err := time.Parse("20060102150405", digits)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("pdf: malformed date %q", s)
+	}
+
+	zone = strings.ReplaceAll(zone, "'", "")
+	if len(zone) >= 3 && (zone[0] == '+' || zone[0] == '-') {
+		var hh, mm int
+		fmt.Sscanf(zone[1:3], "%d", &hh)
+		if len(zone) >= 5 {
+			fmt.Sscanf(zone[3:5], "%d", &mm)
+		}
+		offset := hh*3600 + mm*60
+		if zone[0] == '-' {
+			offset = -offset
+		}
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.FixedZone("", offset))
+	}
+	return t, nil
+}
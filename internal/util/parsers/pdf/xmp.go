@@ -0,0 +1,113 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// XMP holds the fields read from the XMP metadata packet.
+type XMP struct {
+	Title       string
+	Creators    []string
+	Description string
+	Subjects    []string
+	Keywords    string
+	CreateDate  string
+}
+
+const (
+	nsDC  = "http://purl.org/dc/elements/1.1/"
+	nsXMP = "http://ns.adobe.com/xap/1.0/"
+	nsPDF = "http://ns.adobe.com/pdf/1.3/"
+	nsRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+// parseXMP extracts Dublin Core and XMP basic properties from an XMP
+// packet. Properties may be written as elements, as rdf:Alt/Seq/Bag lists
+// or as attributes of rdf:Description.
+func parseXMP(data []byte) (*XMP, error) {
+	x := &XMP{}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	// property is the currently open property element, values collects the
+	// text of its rdf:li children or its own character data.
+	var property xml.Name
+	var values []string
+	var text strings.Builder
+	depth, propDepth := 0, 0
+
+	set := func(name xml.Name, vals []string) {
+		if len(vals) == 0 {
+			return
+		}
+		switch {
+		case name.Space == nsDC && name.Local == "title":
+			x.Title = vals[0]
+		case name.Space == nsDC && name.Local == "creator":
+			x.Creators = vals
+		case name.Space == nsDC && name.Local == "description":
+			x.Description = vals[0]
+		case name.Space == nsDC && name.Local == "subject":
+			x.Subjects = vals
+		case name.Space == nsPDF && name.Local == "Keywords":
+			x.Keywords = vals[0]
+		case name.Space == nsXMP && name.Local == "CreateDate":
+			x.CreateDate = vals[0]
+		}
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return x, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if t.Name.Space == nsRDF && t.Name.Local == "Description" {
+				for _, attr := range t.Attr {
+					set(attr.Name, []string{strings.TrimSpace(attr.Value)})
+				}
+				continue
+			}
+			if propDepth == 0 && (t.Name.Space == nsDC || t.Name.Space == nsXMP || t.Name.Space == nsPDF) {
+				property, propDepth = t.Name, depth
+				values = nil
+				text.Reset()
+				continue
+			}
+			if propDepth > 0 && t.Name.Space == nsRDF && t.Name.Local == "li" {
+				text.Reset()
+			}
+		case xml.CharData:
+			if propDepth > 0 {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if propDepth > 0 && t.Name.Space == nsRDF && t.Name.Local == "li" {
+				if v := strings.TrimSpace(text.String()); v != "" {
+					values = append(values, v)
+				}
+				text.Reset()
+			}
+			if propDepth > 0 && depth == propDepth {
+				if len(values) == 0 {
+					if v := strings.TrimSpace(text.String()); v != "" {
+						values = append(values, v)
+					}
+				}
+				set(property, values)
+				propDepth = 0
+			}
+			depth--
+		}
+	}
+	return x, nil
+}
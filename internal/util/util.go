@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"fmt"
 	"image"
+	_ "image/gif"  // Register the GIF format
 	_ "image/jpeg" // Register the JPEG format
 	_ "image/png" // Register the PNG format
 	"math/big"
@@ -15,6 +16,7 @@ import (
 	"unicode"
 
 	"github.com/google/uuid"
+	"golang.org/x/text/language"
     "github.com/chai2010/webp"
 )
 
@@ -184,3 +186,18 @@ func CheckFileType(file string, types ...string) bool {
 	// Check type by fileSignatures
 	return false
 }
+
+// LanguageCode returns the ISO 639-2 code, the one calibre stores, of a
+// language tag such as "en" or "en-US". It returns an empty string when the
+// tag isn't a known language.
+func LanguageCode(tag string) string {
+	t, err := language.Parse(strings.TrimSpace(tag))
+	if err != nil {
+		return ""
+	}
+	base, confidence := t.Base()
+	if confidence != language.Exact {
+		return ""
+	}
+	return base.ISO3()
+}
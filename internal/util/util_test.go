@@ -162,3 +162,21 @@ func TestImageToWebpConcurrent(t *testing.T) {
 
 	waitGroup.Wait()
 }
+
+func TestLanguageCode(t *testing.T) {
+	tests := map[string]string{
+		"en":     "eng",
+		"en-US":  "eng",
+		"fr":     "fra",
+		"zh-CN":  "zho",
+		"eng":    "eng",
+		"":       "",
+		"und":    "",
+		"klingo": "",
+	}
+	for tag, want := range tests {
+		if got := LanguageCode(tag); got != want {
+			t.Errorf("LanguageCode(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
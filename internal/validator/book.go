@@ -0,0 +1,42 @@
+package validator
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/Xunop/e-oasis/internal/model"
+)
+
+func ValidateBookUpdateRequest(update *model.BookUpdateRequest) error {
+	if update == nil {
+		return errors.New("update is nil")
+	}
+	if update.Title != nil && strings.TrimSpace(*update.Title) == "" {
+		return errors.New("title is empty")
+	}
+	if update.Authors != nil {
+		hasPrimary := false
+		for _, author := range update.Authors {
+			if author == nil || strings.TrimSpace(author.Name) == "" {
+				return errors.New("author name is empty")
+			}
+			hasPrimary = hasPrimary || author.IsPrimary()
+		}
+		if !hasPrimary {
+			return errors.New("book has no author")
+		}
+	}
+	if update.SeriesIndex != nil && *update.SeriesIndex < 0 {
+		return errors.New("series index is negative")
+	}
+	if update.Rating != nil && (*update.Rating < 0 || *update.Rating > 10) {
+		return errors.New("rating must be between 0 and 10")
+	}
+	for typ, val := range update.Identifiers {
+		if strings.TrimSpace(typ) == "" || strings.TrimSpace(val) == "" {
+			return errors.New("identifier is empty")
+		}
+	}
+	return nil
+}
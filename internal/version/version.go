@@ -7,7 +7,7 @@ import (
 	"golang.org/x/mod/semver"
 )
 
-var version = "0.1.0"
+var version = "0.2.0"
 
 func GetCurrentVersion() string {
 	return version
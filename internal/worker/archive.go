@@ -0,0 +1,404 @@
+package worker
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/Xunop/e-oasis/internal/util"
+	"github.com/Xunop/e-oasis/internal/util/parsers"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// The archive formats an import is read from, told by their first bytes
+const (
+	ArchiveZip    = "zip"
+	ArchiveTar    = "tar"
+	ArchiveTarGz  = "tar.gz"
+	ArchiveTarZst = "tar.zst"
+)
+
+// ErrUnsupportedArchive is returned for an import which isn't a zip, a tar
+// or a gzip or zstd compressed tar.
+var ErrUnsupportedArchive = errors.New("unsupported archive format")
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// tarMagic is at tarMagicOffset of the first header
+	tarMagic       = []byte("ustar")
+	tarMagicOffset = 257
+)
+
+// ArchiveFormat returns the format of the archive path, ErrUnsupportedArchive
+// when it isn't one an import is read from.
+func ArchiveFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open archive")
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", errors.Wrap(err, "failed to read archive")
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, zipMagic):
+		return ArchiveZip, nil
+	case bytes.HasPrefix(head, gzipMagic):
+		return ArchiveTarGz, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		return ArchiveTarZst, nil
+	case len(head) >= tarMagicOffset+len(tarMagic) && bytes.Equal(head[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic):
+		return ArchiveTar, nil
+	}
+	return "", ErrUnsupportedArchive
+}
+
+// ImportArchive imports the books of the archive of an archive job, or of
+// its directory, the outcome of every book is saved in the job as it goes.
+// A resumed job skips the books it already went through.
+func ImportArchive(s *store.Store, job model.Job) {
+	if err := importArchive(s, &job); err != nil {
+		failJob(s, &job, err)
+		return
+	}
+	if job.Payload.File != "" {
+		os.Remove(job.Payload.File)
+		job.Payload.File = ""
+	}
+	job.Payload.Summary = job.Payload.Summarize()
+	finishJob(s, &job)
+	log.Info("Finished processing archive", zap.Int("job_id", job.ID),
+		zap.Int("imported", job.Payload.Summary.Imported),
+		zap.Int("shared", job.Payload.Summary.Shared),
+		zap.Int("duplicates", job.Payload.Summary.Duplicates),
+		zap.Int("unsupported", job.Payload.Summary.Unsupported),
+		zap.Int("failed", job.Payload.Summary.Failed))
+}
+
+// importFile is a file of an import, r is its content within an archive
+// and path the file of a directory.
+type importFile struct {
+	name string
+	r    io.Reader
+	path string
+}
+
+func importArchive(s *store.Store, job *model.Job) error {
+	if err := startJob(s, job); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(job.Payload.Items))
+	for _, item := range job.Payload.Items {
+		seen[item.Name] = true
+	}
+	add := func(file importFile) error {
+		if seen[file.name] || hiddenPath(file.name) {
+			return nil
+		}
+		item := importArchiveItem(s, job, file)
+		job.Payload.Items = append(job.Payload.Items, item)
+		if _, err := s.UpdateJob(*job); err != nil {
+			return err
+		}
+		event := job.Event()
+		event.Item = item
+		Events.Publish(event)
+		return nil
+	}
+
+	if job.Payload.Dir != "" {
+		log.Debug("Starting directory import", zap.String("dir", job.Payload.Dir))
+		return walkImportDir(job.Payload.Dir, add)
+	}
+	log.Debug("Starting archive processing", zap.String("archive", job.Payload.File))
+	return walkImportArchive(job.Payload.File, add)
+}
+
+// walkImportDir hands the files of dir to fn
+func walkImportDir(dir string, fn func(importFile) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.Wrap(err, "failed to read directory")
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return fn(importFile{name: filepath.ToSlash(name), path: path})
+	})
+}
+
+// walkImportArchive hands the files of the archive path to fn
+func walkImportArchive(path string, fn func(importFile) error) error {
+	format, err := ArchiveFormat(path)
+	if err != nil {
+		return err
+	}
+	if format == ArchiveZip {
+		return walkZip(path, fn)
+	}
+
+	archiveFile, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open archive")
+	}
+	defer archiveFile.Close()
+
+	var r io.Reader = bufio.NewReader(archiveFile)
+	switch format {
+	case ArchiveTarGz:
+		gzipReader, err := gzip.NewReader(r)
+		if err != nil {
+			return errors.Wrap(err, "failed to read archive")
+		}
+		defer gzipReader.Close()
+		r = gzipReader
+	case ArchiveTarZst:
+		zstdReader, err := zstd.NewReader(r)
+		if err != nil {
+			return errors.Wrap(err, "failed to read archive")
+		}
+		defer zstdReader.Close()
+		r = zstdReader
+	}
+
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read archive")
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(importFile{name: header.Name, r: tarReader}); err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(path string, fn func(importFile) error) error {
+	zipReader, err := zip.OpenReader(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read archive")
+	}
+	defer zipReader.Close()
+
+	for _, f := range zipReader.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", f.Name)
+		}
+		err = fn(importFile{name: f.Name, r: rc})
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hiddenPath reports whether a file of an import is hidden, like the
+// resource forks macOS adds to its zip archives.
+func hiddenPath(name string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// importArchiveItem saves a book of an import. The book is looked up by its
+// hash before it is copied into the library, a duplicate is never copied:
+// the book of another user is shared with the user instead.
+func importArchiveItem(s *store.Store, job *model.Job, file importFile) *model.JobItem {
+	name := file.name
+	item := &model.JobItem{Name: name, Status: model.JobItemFailed}
+	ext := parsers.Ext(name)
+	if parsers.ForPath(name) == nil || !config.CheckSupportedTypes(ext) {
+		item.Status, item.Error = model.JobItemUnsupported, "unsupported file type "+ext
+		return item
+	}
+
+	var tagsToAdd []string
+	if job.Payload.MapTags {
+		tagsToAdd = dirTags(name)
+	}
+
+	// fail logs why the book wasn't imported
+	fail := func(reason string, err error) *model.JobItem {
+		log.Error("Failed to import book of archive", zap.String("item", name), zap.String("reason", reason), zap.Error(err))
+		item.Error = reason + ": " + err.Error()
+		return item
+	}
+
+	// A book of an archive is hashed from the staging directory of the user
+	src := file.path
+	if src == "" {
+		tmpDir := filepath.Join(config.Opts.Data, strconv.Itoa(job.UserID), "tmp")
+		if err := os.MkdirAll(tmpDir, os.ModePerm); err != nil {
+			return fail("failed to create tmp directory", err)
+		}
+		staged, err := os.CreateTemp(tmpDir, "import-item-*"+ext)
+		if err != nil {
+			return fail("failed to stage book", err)
+		}
+		defer os.Remove(staged.Name())
+		_, err = io.Copy(staged, file.r)
+		if closeErr := staged.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fail("failed to stage book", err)
+		}
+		src = staged.Name()
+	}
+
+	bookHash, err := GenerateBookHash(src)
+	if err != nil {
+		return fail("failed to hash book", err)
+	}
+	if bookID, exists := s.CheckBookHash(bookHash); exists {
+		shared, err := shareBook(s, bookID, job.UserID)
+		if err != nil {
+			return fail("failed to share book", err)
+		}
+		if shared {
+			item.Status, item.BookID = model.JobItemShared, bookID
+			return item
+		}
+		log.Warn("Duplicate book in archive, skipping", zap.String("item", name), zap.Int("existing_book_id", bookID))
+		item.Status, item.DuplicateOf = model.JobItemDuplicate, bookID
+		return item
+	}
+
+	if err := CheckQuota(s, job.UserID, 1, fileSize(src)); err != nil {
+		log.Warn("Book of archive over quota", zap.String("item", name), zap.Error(err))
+		item.Error = err.Error()
+		return item
+	}
+
+	// Determine final path for the book
+	bookDir := strings.TrimSuffix(filepath.Base(name), ext)
+	finalBookDir := filepath.Join(config.Opts.Data, strconv.Itoa(job.UserID), "books", bookDir)
+	finalBookDir = util.GenerateNewDirName(finalBookDir) // Ensure unique directory
+	if err := os.MkdirAll(finalBookDir, os.ModePerm); err != nil {
+		return fail("failed to create book directory", err)
+	}
+	finalBookPath := filepath.Join(finalBookDir, filepath.Base(name))
+	failSaved := func(reason string, err error) *model.JobItem {
+		os.RemoveAll(finalBookDir)
+		return fail(reason, err)
+	}
+
+	// The files of a directory are left in place
+	if file.path != "" || os.Rename(src, finalBookPath) != nil {
+		if err := copyFile(src, finalBookPath); err != nil {
+			return failSaved("failed to write book file", err)
+		}
+	}
+
+	log.Debug("Imported book saved, now parsing", zap.String("path", finalBookPath))
+	bookMeta, err := ParseBook(finalBookPath)
+	if err != nil {
+		return failSaved("failed to parse book", err)
+	}
+	// An interrupted import of the book is completed or undone at the next
+	// startup
+	entry, err := s.AddJournalEntry(&model.JournalEntry{
+		Op:      model.JournalOpCreateBook,
+		UserID:  job.UserID,
+		Payload: model.JournalPayload{Path: finalBookPath, Dir: finalBookDir, Hashes: []string{bookHash}},
+	})
+	if err != nil {
+		return failSaved("failed to journal book", err)
+	}
+	book, err := s.SaveImportedBook(bookMeta, job.UserID, tagsToAdd)
+	if err != nil {
+		s.DeleteJournalEntry(entry.ID)
+		return failSaved("failed to save book", err)
+	}
+	if err := s.SetJournalBook(entry.ID, book.ID); err != nil {
+		log.Error("Failed to update journal entry", zap.Int("book_id", book.ID), zap.Error(err))
+	}
+	if err := s.AddBookHashLink(book.ID, bookHash); err != nil {
+		log.Error("Failed to link imported book hash", zap.Int("book_id", book.ID), zap.Error(err))
+	}
+	saveBookBlob(s, book.ID, bookHash, finalBookPath)
+	bookPath, err := ApplyBookLayout(s, book.ID, job.UserID)
+	if err != nil {
+		log.Error("Failed to move imported book", zap.Int("book_id", book.ID), zap.Error(err))
+		bookPath = finalBookPath
+	}
+	StoreBook(bookPath)
+	addUsage(s, job.UserID, bookPath)
+	if err := s.DeleteJournalEntry(entry.ID); err != nil {
+		log.Error("Failed to delete journal entry", zap.Int("book_id", book.ID), zap.Error(err))
+	}
+
+	item.Status, item.BookID = model.JobItemImported, book.ID
+	return item
+}
+
+// copyFile copies the file src to dest
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// dirTags returns the directories of the relative path name, the tags of a
+// book found in them.
+func dirTags(name string) []string {
+	// Get the directory part of the file's path
+	dir := filepath.Dir(filepath.Clean(name))
+	if dir == "." {
+		return nil
+	}
+	// Split the path by the separator to get individual directory names
+	return strings.Split(dir, string(filepath.Separator))
+}
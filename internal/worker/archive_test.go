@@ -0,0 +1,199 @@
+package worker
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	epub "github.com/go-shiori/go-epub"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/model"
+)
+
+func TestImportArchiveFormats(t *testing.T) {
+	s := newJobTestStore(t)
+	user, err := s.CreateUser(&model.User{Username: "test", PasswordHash: "test", Role: model.RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := int(user.ID)
+
+	dir := t.TempDir()
+	book := func(title string) []byte {
+		path := filepath.Join(dir, title+".epub")
+		b, err := epub.NewEpub(title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Write(path); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	tests := []struct {
+		format string
+		write  func(path string, files [][2]string)
+	}{
+		{ArchiveZip, func(path string, files [][2]string) { writeZip(t, path, files) }},
+		{ArchiveTar, func(path string, files [][2]string) { writeTar(t, path, files, false) }},
+		{ArchiveTarZst, func(path string, files [][2]string) { writeTar(t, path, files, true) }},
+		{"dir", func(path string, files [][2]string) {
+			for _, file := range files {
+				name := filepath.Join(path, file[0])
+				if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(name, []byte(file[1]), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			data := string(book("Book from " + tt.format))
+			files := [][2]string{
+				{"fiction/sci-fi/Book.epub", data},
+				{"Again.epub", data},
+				{"notes.txt", "not a book"},
+				{"Broken.epub", "not a book"},
+				{"__MACOSX/fiction/._Book.epub", "resource fork"},
+			}
+
+			payload := model.JobPayload{MapTags: true}
+			source := filepath.Join(dir, "import-"+tt.format)
+			tt.write(source, files)
+			if tt.format == "dir" {
+				payload.Dir = source
+			} else {
+				if format, err := ArchiveFormat(source); err != nil || format != tt.format {
+					t.Fatalf("ArchiveFormat() = %s, %v", format, err)
+				}
+				payload.File = source
+			}
+			job, err := s.AddJob(model.Job{UserID: uid, Type: model.JobTypeArchive, Stage: model.JobStageImport, Payload: payload})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ImportArchive(s, *job)
+
+			job, err = s.GetJob(job.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := &model.ImportSummary{Imported: 1, Duplicates: 1, Unsupported: 1, Failed: 1}
+			if job.Status != model.JobStatusDone || !reflect.DeepEqual(job.Payload.Summary, want) {
+				t.Fatalf("job = %+v, summary = %+v", job, job.Payload.Summary)
+			}
+			for _, item := range job.Payload.Items {
+				if item.Status != model.JobItemImported {
+					if item.Status != model.JobItemDuplicate && item.Error == "" {
+						t.Errorf("%s has no reason", item.Name)
+					}
+					continue
+				}
+				meta, err := s.GetBookMeta(item.BookID)
+				if err != nil || meta == nil {
+					t.Fatalf("book %d not saved: %v", item.BookID, err)
+				}
+				// A directory is walked in lexical order, the first copy
+				// is the one in the root
+				if len(meta.Tags) != len(dirTags(item.Name)) {
+					t.Errorf("%s tags = %v", item.Name, meta.Tags)
+				}
+			}
+
+			// The duplicate and the broken book leave nothing behind
+			books, _ := os.ReadDir(filepath.Join(config.Opts.Data, strconv.Itoa(uid), "books"))
+			if len(books) != 1 {
+				t.Errorf("%d book directories", len(books))
+			}
+			os.RemoveAll(filepath.Join(config.Opts.Data, strconv.Itoa(uid), "books"))
+			if tt.format == "dir" {
+				if _, err := os.Stat(filepath.Join(source, "Again.epub")); err != nil {
+					t.Errorf("the directory should be left in place, %v", err)
+				}
+			} else if _, err := os.Stat(source); !os.IsNotExist(err) {
+				t.Error("the archive should be removed")
+			}
+			if entries, _ := os.ReadDir(filepath.Join(config.Opts.Data, strconv.Itoa(uid), "tmp")); len(entries) != 0 {
+				t.Errorf("%d staged files left", len(entries))
+			}
+		})
+	}
+
+	garbage := filepath.Join(dir, "garbage.rar")
+	if err := os.WriteFile(garbage, []byte("Rar!"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ArchiveFormat(garbage); err != ErrUnsupportedArchive {
+		t.Errorf("ArchiveFormat(rar) error = %v", err)
+	}
+}
+
+// writeZip writes the files, name and content pairs, to a zip archive at path
+func writeZip(t *testing.T, path string, files [][2]string) {
+	t.Helper()
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+	for _, file := range files {
+		w, err := zw.Create(file[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, file[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTar writes the files, name and content pairs, to a tar archive at
+// path, compressed with zstd when compress is set
+func writeTar(t *testing.T, path string, files [][2]string, compress bool) {
+	t.Helper()
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	var w io.Writer = out
+	if compress {
+		zw, err := zstd.NewWriter(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zw.Close()
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	for _, file := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: file[0], Mode: 0o644, Size: int64(len(file[1])), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, file[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,716 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/storage"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/Xunop/e-oasis/internal/store/db"
+	"github.com/Xunop/e-oasis/internal/version"
+)
+
+// A backup is a directory holding:
+//
+//	manifest.json                  what the backup holds, written last
+//	db/e-oasis.db, db/metadata.db  the snapshots of the databases
+//	objects/<sha[:2]>/<sha>        the files of the data directory by content
+//
+// An incremental backup only copies the objects its base doesn't have.
+const (
+	// BackupFormatVersion is the version of the layout of the backups
+	BackupFormatVersion = 1
+	BackupManifestName  = "manifest.json"
+	// BackupDefaultDir is the directory of the data directory the backups are
+	// written to when the backup_dir option is not set
+	BackupDefaultDir = "backups"
+
+	backupDBDir     = "db"
+	backupObjectDir = "objects"
+	backupAppDB     = "e-oasis.db"
+	backupMetaDB    = "metadata.db"
+)
+
+// backupLock keeps a single backup running at a time
+var backupLock sync.Mutex
+
+// BackupFile is a file of the backup
+type BackupFile struct {
+	// Path is relative to the data directory, or the name of the snapshot
+	// for the databases
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// Link is the file of the backup this one was a hard link to, the link
+	// is made again on restore
+	Link string `json:"link,omitempty"`
+}
+
+// BackupManifest describes a backup, it is checked before restoring
+type BackupManifest struct {
+	Version       int    `json:"version"`
+	AppVersion    string `json:"app_version"`
+	SchemaVersion string `json:"schema_version"`
+	// Migrations are the migration files applied to the application
+	// database, a backup with one this version doesn't know isn't restored
+	Migrations []string `json:"migrations,omitempty"`
+	CreatedTs  int64    `json:"created_ts"`
+	// Base is the backup keeping the objects this one didn't copy
+	Base      string        `json:"base,omitempty"`
+	Databases []*BackupFile `json:"databases"`
+	Files     []*BackupFile `json:"files"`
+}
+
+// BackupInfo sums up a backup of the backup directory
+type BackupInfo struct {
+	Name          string `json:"name"`
+	Dir           string `json:"dir"`
+	AppVersion    string `json:"app_version"`
+	SchemaVersion string `json:"schema_version"`
+	CreatedTs     int64  `json:"created_ts"`
+	Base          string `json:"base,omitempty"`
+	Files         int    `json:"files"`
+	// Size is the size of the library, Stored what the backup takes on disk
+	// on top of its base
+	Size   int64 `json:"size"`
+	Stored int64 `json:"stored"`
+}
+
+// BackupDir returns the directory the backups are written to
+func BackupDir() string {
+	if config.Opts.BackupDir != "" {
+		if dir, err := filepath.Abs(config.Opts.BackupDir); err == nil {
+			return dir
+		}
+		return config.Opts.BackupDir
+	}
+	return filepath.Join(config.Opts.Data, BackupDefaultDir)
+}
+
+// NewBackupDir returns the directory of BackupDir for a backup made now
+func NewBackupDir() string {
+	return filepath.Join(BackupDir(), time.Now().Format("20060102-150405"))
+}
+
+// Backup copies the databases and the files of the data directory to dir
+// while the server is running, or to a new directory of BackupDir when dir
+// is empty. The files already in the backup base and its own bases are not
+// copied again. With the S3 storage, the files only in the bucket are
+// fetched into the data directory first.
+func Backup(ctx context.Context, s *store.Store, dir, base string) (*BackupInfo, error) {
+	backupLock.Lock()
+	defer backupLock.Unlock()
+
+	if dir == "" {
+		dir = NewBackupDir()
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return nil, errors.Errorf("backup directory %s is not empty", dir)
+	}
+
+	stored := make(map[string]string)
+	if base != "" {
+		if base, err = filepath.Abs(base); err != nil {
+			return nil, err
+		}
+		if _, err := readBackupManifest(base); err != nil {
+			return nil, errors.Wrap(err, "failed to read base backup")
+		}
+		if err := listBackupObjects(base, stored, map[string]bool{}); err != nil {
+			return nil, errors.Wrap(err, "failed to list objects of base backup")
+		}
+	}
+	schema, err := s.GetSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := s.ListMigrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	manifest := &BackupManifest{
+		Version:       BackupFormatVersion,
+		AppVersion:    version.GetCurrentVersion(),
+		SchemaVersion: schema,
+		Migrations:    migrations,
+		CreatedTs:     time.Now().Unix(),
+		Base:          base,
+		Databases:     make([]*BackupFile, 0, 2),
+		Files:         make([]*BackupFile, 0),
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, backupDBDir), os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "failed to create backup directory")
+	}
+	done := false
+	defer func() {
+		if !done {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	if err := storage.FetchDir(ctx, storage.Default, config.Opts.Data); err != nil {
+		return nil, errors.Wrap(err, "failed to fetch the data directory")
+	}
+	// The files are listed along with the snapshots, the books created or
+	// deleted since are left out
+	var entries []*backupEntry
+	appPath, metaPath := filepath.Join(dir, backupDBDir, backupAppDB), filepath.Join(dir, backupDBDir, backupMetaDB)
+	err = s.SnapshotDatabases(appPath, metaPath, func() error {
+		entries, err = listBackupEntries(ctx, dir)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range []string{appPath, metaPath} {
+		sum, size, err := hashFile(path)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Databases = append(manifest.Databases, &BackupFile{Path: filepath.Base(path), SHA256: sum, Size: size})
+	}
+
+	// The files of the backup by content, to find the hard links
+	sources := make(map[string][]*backupSource)
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		file, err := backupDataFile(dir, entry.path, stored)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Deleted since, checked against the snapshots below
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to back up %s", entry.path)
+		}
+		file.Path = entry.rel
+		for _, source := range sources[file.SHA256] {
+			if os.SameFile(source.info, entry.info) {
+				file.Link = source.file.Path
+				break
+			}
+		}
+		if file.Link == "" {
+			sources[file.SHA256] = append(sources[file.SHA256], &backupSource{file: file, info: entry.info})
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+	if err := checkBackupBooks(metaPath, manifest); err != nil {
+		return nil, err
+	}
+
+	if err := writeBackupManifest(dir, manifest); err != nil {
+		return nil, err
+	}
+	done = true
+	log.Info("Backup done", zap.String("dir", dir), zap.String("base", base), zap.Int("files", len(manifest.Files)))
+	return newBackupInfo(dir, manifest), nil
+}
+
+// backupEntry is a file of the data directory to back up, rel is its path
+// in the manifest
+type backupEntry struct {
+	path string
+	rel  string
+	info fs.FileInfo
+}
+
+// listBackupEntries lists the files of the data directory to back up to dir
+func listBackupEntries(ctx context.Context, dir string) ([]*backupEntry, error) {
+	entries := make([]*backupEntry, 0)
+	err := filepath.WalkDir(config.Opts.Data, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if isBackupSkipped(path, dir, d) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(config.Opts.Data, path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &backupEntry{path: path, rel: filepath.ToSlash(rel), info: info})
+		return nil
+	})
+	return entries, err
+}
+
+// checkBackupBooks checks that the file of every book of the snapshot of
+// the metadata database at metaPath was backed up, the books of an imported
+// library aside. A book deleted while its files were copied fails it.
+func checkBackupBooks(metaPath string, manifest *BackupManifest) error {
+	files := make(map[string]bool, len(manifest.Files))
+	for _, file := range manifest.Files {
+		files[file.Path] = true
+	}
+
+	snapshot, err := sql.Open("sqlite", "file:"+metaPath+"?mode=ro")
+	if err != nil {
+		return errors.Wrap(err, "failed to open database snapshot")
+	}
+	defer snapshot.Close()
+	rows, err := snapshot.Query(`SELECT id, path FROM books WHERE path != ''`)
+	if err != nil {
+		return errors.Wrap(err, "failed to list books of the snapshot")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var path string
+		if err := rows.Scan(&id, &path); err != nil {
+			return errors.Wrap(err, "failed to scan book of the snapshot")
+		}
+		if key, ok := storage.Key(path); ok && !files[key] {
+			return errors.Errorf("the file of book %d is gone since the snapshot, back up again", id)
+		}
+	}
+	return rows.Err()
+}
+
+// backupSource is a file of the data directory backed up, the files linked
+// to it are not copied again
+type backupSource struct {
+	file *BackupFile
+	info fs.FileInfo
+}
+
+// backupDataFile copies the file at path to the objects of the backup at dir
+// unless stored has its content already
+func backupDataFile(dir, path string, stored map[string]string) (*BackupFile, error) {
+	sum, size, err := hashFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &BackupFile{SHA256: sum, Size: size}
+	if _, ok := stored[sum]; ok {
+		return file, nil
+	}
+
+	object := backupObjectPath(dir, sum)
+	if err := os.MkdirAll(filepath.Dir(object), os.ModePerm); err != nil {
+		return nil, err
+	}
+	copied, err := copyBackupFile(path, object)
+	if err != nil {
+		return nil, err
+	}
+	if copied != sum {
+		os.Remove(object)
+		return nil, errors.New("file changed while backing it up")
+	}
+	stored[sum] = object
+	return file, nil
+}
+
+// isBackupSkipped reports whether the path of the data directory is left out
+// of the backup: the databases, backed up from their snapshots, the uploads
+// in progress, the quarantine, the backups and the pid file
+func isBackupSkipped(path, dir string, d fs.DirEntry) bool {
+	if path == dir || path == BackupDir() || path == pidFilePath() {
+		return true
+	}
+	rel, err := filepath.Rel(config.Opts.Data, path)
+	if err != nil {
+		return true
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if d.IsDir() {
+		return rel == QuarantineDir || len(parts) == 2 && parts[1] == "tmp"
+	}
+	for _, db := range []string{config.Opts.DSN, config.Opts.MetaDSN} {
+		for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+			if path == db+suffix {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ListBackups returns the backups of BackupDir, the newest first
+func ListBackups() ([]*BackupInfo, error) {
+	entries, err := os.ReadDir(BackupDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return []*BackupInfo{}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read backup directory")
+	}
+
+	list := make([]*BackupInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(BackupDir(), entry.Name())
+		manifest, err := readBackupManifest(dir)
+		if err != nil {
+			// Not a backup, or one not finished
+			continue
+		}
+		list = append(list, newBackupInfo(dir, manifest))
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].CreatedTs > list[j].CreatedTs })
+	return list, nil
+}
+
+func newBackupInfo(dir string, manifest *BackupManifest) *BackupInfo {
+	info := &BackupInfo{
+		Name:          filepath.Base(dir),
+		Dir:           dir,
+		AppVersion:    manifest.AppVersion,
+		SchemaVersion: manifest.SchemaVersion,
+		CreatedTs:     manifest.CreatedTs,
+		Base:          manifest.Base,
+		Files:         len(manifest.Files),
+		Stored:        dirSize(dir),
+	}
+	for _, file := range manifest.Files {
+		info.Size += file.Size
+	}
+	return info
+}
+
+// LatestBackup returns the directory of the newest backup of BackupDir, the
+// base of the incremental backups
+func LatestBackup() (string, error) {
+	list, err := ListBackups()
+	if err != nil {
+		return "", err
+	}
+	if len(list) == 0 {
+		return "", errors.New("no backup to start from")
+	}
+	return list[0].Dir, nil
+}
+
+// RestoreBackup puts the backup at dir back into the data directory, it is
+// refused while a server uses it. The backup is verified first and refused
+// when its schema is newer than this version knows. It refuses to replace a
+// library unless force is set, the files of the library the backup doesn't
+// have are then left for the doctor.
+func RestoreBackup(dir string, force bool) (*BackupManifest, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	// The server can't start on the data directory while it is restored
+	unlock, err := LockDataDir()
+	if err != nil {
+		return nil, errors.Wrap(err, "stop it before restoring")
+	}
+	defer unlock()
+	manifest, objects, err := verifyBackup(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !force {
+		if _, err := os.Stat(config.Opts.DSN); err == nil {
+			return nil, errors.Errorf("a library exists at %s", config.Opts.Data)
+		}
+	}
+
+	// The files first, the library is left as it was when they fail
+	for _, file := range manifest.Files {
+		target, err := backupTarget(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return nil, errors.Wrap(err, "failed to create directory")
+		}
+		tmp := filepath.Join(filepath.Dir(target), ".restore-"+filepath.Base(target))
+		os.Remove(tmp)
+		if file.Link != "" {
+			if link, err := backupTarget(file.Link); err == nil && os.Link(link, tmp) == nil {
+				if err := os.Rename(tmp, target); err != nil {
+					return nil, errors.Wrapf(err, "failed to restore %s", file.Path)
+				}
+				continue
+			}
+		}
+		if _, err := copyBackupFile(objects[file.SHA256], tmp); err != nil {
+			return nil, errors.Wrapf(err, "failed to restore %s", file.Path)
+		}
+		if err := os.Rename(tmp, target); err != nil {
+			return nil, errors.Wrapf(err, "failed to restore %s", file.Path)
+		}
+	}
+
+	targets := map[string]string{backupAppDB: config.Opts.DSN, backupMetaDB: config.Opts.MetaDSN}
+	for _, db := range manifest.Databases {
+		target := targets[db.Path]
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return nil, errors.Wrap(err, "failed to create directory")
+		}
+		tmp := target + ".restore"
+		if _, err := copyBackupFile(filepath.Join(dir, backupDBDir, db.Path), tmp); err != nil {
+			return nil, errors.Wrapf(err, "failed to restore database %s", db.Path)
+		}
+		// The journal of the replaced database would be replayed on the
+		// snapshot
+		for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+			if err := os.Remove(target + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, errors.Wrap(err, "failed to remove database journal")
+			}
+		}
+		if err := os.Rename(tmp, target); err != nil {
+			return nil, errors.Wrapf(err, "failed to restore database %s", db.Path)
+		}
+	}
+	log.Info("Backup restored", zap.String("dir", dir), zap.Int("files", len(manifest.Files)))
+	return manifest, nil
+}
+
+// verifyBackup checks the manifest of the backup at dir and the checksums of
+// its files, it returns the object of each content found in the backup or
+// its bases
+func verifyBackup(dir string) (*BackupManifest, map[string]string, error) {
+	manifest, err := readBackupManifest(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if manifest.Version > BackupFormatVersion {
+		return nil, nil, errors.Errorf("backup format %d is newer than %d", manifest.Version, BackupFormatVersion)
+	}
+	// Two builds of a version may have different migrations, the backups
+	// listing theirs are checked by migration
+	if len(manifest.Migrations) > 0 {
+		known := make(map[string]bool)
+		for _, name := range db.MigrationFiles() {
+			known[name] = true
+		}
+		for _, name := range manifest.Migrations {
+			if !known[name] {
+				return nil, nil, errors.Errorf("backup schema has migration %s unknown to e-oasis %s, upgrade e-oasis to restore it", name, version.GetCurrentVersion())
+			}
+		}
+	} else {
+		current := version.GetSchemaVersion(version.GetCurrentVersion())
+		if version.IsVersionGreaterThan(manifest.SchemaVersion, current) {
+			return nil, nil, errors.Errorf("backup schema %s is newer than %s, upgrade e-oasis to restore it", manifest.SchemaVersion, current)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, db := range manifest.Databases {
+		if db.Path != backupAppDB && db.Path != backupMetaDB {
+			return nil, nil, errors.Errorf("unknown database %s", db.Path)
+		}
+		names[db.Path] = true
+		if err := checkBackupFile(filepath.Join(dir, backupDBDir, db.Path), db.SHA256); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(names) != 2 {
+		return nil, nil, errors.New("backup is missing a database")
+	}
+
+	objects := make(map[string]string)
+	if err := listBackupObjects(dir, objects, map[string]bool{}); err != nil {
+		return nil, nil, err
+	}
+	checked := make(map[string]bool)
+	for _, file := range manifest.Files {
+		if _, err := backupTarget(file.Path); err != nil {
+			return nil, nil, err
+		}
+		if checked[file.SHA256] {
+			continue
+		}
+		object, ok := objects[file.SHA256]
+		if !ok {
+			return nil, nil, errors.Errorf("object of %s is missing", file.Path)
+		}
+		if err := checkBackupFile(object, file.SHA256); err != nil {
+			return nil, nil, err
+		}
+		checked[file.SHA256] = true
+	}
+	return manifest, objects, nil
+}
+
+// listBackupObjects adds the objects of the backup at dir and of its bases
+// to objects, the ones of the newest backup are kept
+func listBackupObjects(dir string, objects map[string]string, visited map[string]bool) error {
+	if visited[dir] {
+		return errors.Errorf("backup %s is its own base", dir)
+	}
+	visited[dir] = true
+
+	manifest, err := readBackupManifest(dir)
+	if err != nil {
+		return err
+	}
+	root := filepath.Join(dir, backupObjectDir)
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if _, ok := objects[d.Name()]; !ok && d.Type().IsRegular() {
+			objects[d.Name()] = path
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list backup objects")
+	}
+	if manifest.Base == "" {
+		return nil
+	}
+	return listBackupObjects(resolveBackupBase(dir, manifest.Base), objects, visited)
+}
+
+// resolveBackupBase returns the base of the backup at dir, looked for next
+// to it when the backups were moved together
+func resolveBackupBase(dir, base string) string {
+	if _, err := os.Stat(base); err == nil {
+		return base
+	}
+	return filepath.Join(filepath.Dir(dir), filepath.Base(base))
+}
+
+// backupTarget returns where the file of the backup goes in the data
+// directory, the paths out of it are refused
+func backupTarget(rel string) (string, error) {
+	path := filepath.FromSlash(rel)
+	if !filepath.IsLocal(path) {
+		return "", errors.Errorf("backup file %s is out of the data directory", rel)
+	}
+	return filepath.Join(config.Opts.Data, path), nil
+}
+
+func backupObjectPath(dir, sum string) string {
+	return filepath.Join(dir, backupObjectDir, sum[:2], sum)
+}
+
+func readBackupManifest(dir string) (*BackupManifest, error) {
+	buf, err := os.ReadFile(filepath.Join(dir, BackupManifestName))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read backup manifest")
+	}
+	manifest := &BackupManifest{}
+	if err := json.Unmarshal(buf, manifest); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal backup manifest")
+	}
+	return manifest, nil
+}
+
+// writeBackupManifest writes the manifest last, a backup without one is
+// not finished
+func writeBackupManifest(dir string, manifest *BackupManifest) error {
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal backup manifest")
+	}
+	tmp := filepath.Join(dir, BackupManifestName+".tmp")
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return errors.Wrap(err, "failed to write backup manifest")
+	}
+	return os.Rename(tmp, filepath.Join(dir, BackupManifestName))
+}
+
+func checkBackupFile(path, sum string) error {
+	got, _, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	if got != sum {
+		return errors.Errorf("checksum of %s does not match the manifest", path)
+	}
+	return nil
+}
+
+// hashFile returns the sha256 and the size of the file at path
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// copyBackupFile copies the file src to dst and returns the sha256 of what
+// was copied
+func copyBackupFile(src, dst string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hash), in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,159 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	epub "github.com/go-shiori/go-epub"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+)
+
+func TestBackup(t *testing.T) {
+	s := newJobTestStore(t)
+	user, err := s.CreateUser(&model.User{Username: "reader", PasswordHash: "test", Role: model.RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := int(user.ID)
+	importBook := func(title string) *model.Book {
+		t.Helper()
+		dir := t.TempDir()
+		b, err := epub.NewEpub(title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Write(filepath.Join(dir, title+".epub")); err != nil {
+			t.Fatal(err)
+		}
+		job, err := s.AddJob(model.Job{UserID: uid, Type: model.JobTypeArchive, Stage: model.JobStageImport, Payload: model.JobPayload{Dir: dir}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ImportArchive(s, *job)
+		if job, err = s.GetJob(job.ID); err != nil || job.Payload.Summary.Imported != 1 {
+			t.Fatalf("job = %+v, %v", job, err)
+		}
+		book, err := s.GetBook(&model.FindBook{BookID: &job.Payload.Items[0].BookID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return book
+	}
+
+	first := importBook("First")
+	full := filepath.Join(t.TempDir(), "full")
+	info, err := Backup(context.Background(), s, full, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := readBackupManifest(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Databases) != 2 || manifest.SchemaVersion == "" || info.Files != len(manifest.Files) {
+		t.Fatalf("manifest = %+v, info = %+v", manifest, info)
+	}
+	// The book file and its blob are the same file, backed up once
+	rel, _ := filepath.Rel(config.Opts.Data, first.Path)
+	var book, blob *BackupFile
+	for _, file := range manifest.Files {
+		switch {
+		case file.Path == filepath.ToSlash(rel):
+			book = file
+		case filepath.Dir(filepath.Dir(file.Path)) == store.BlobDir:
+			blob = file
+		}
+	}
+	if book == nil || blob == nil || book.SHA256 != blob.SHA256 || book.Link == "" && blob.Link == "" {
+		t.Fatalf("book = %+v, blob = %+v", book, blob)
+	}
+	// A book of the snapshot whose file went missing fails the backup
+	metaPath := filepath.Join(full, backupDBDir, backupMetaDB)
+	if err := checkBackupBooks(metaPath, manifest); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkBackupBooks(metaPath, &BackupManifest{Files: []*BackupFile{blob}}); err == nil {
+		t.Fatal("backup without the book file succeeded")
+	}
+
+	importBook("Second")
+	incremental := filepath.Join(filepath.Dir(full), "incremental")
+	if _, err := Backup(context.Background(), s, incremental, full); err != nil {
+		t.Fatal(err)
+	}
+	objects := map[string]string{}
+	if err := listBackupObjects(incremental, objects, map[string]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := objects[book.SHA256]; !ok || filepath.Dir(filepath.Dir(filepath.Dir(objects[book.SHA256]))) != full {
+		t.Fatalf("object of the first book = %q, want it in the full backup", objects[book.SHA256])
+	}
+
+	// Restore into an empty data directory, once the server using it stopped
+	data := t.TempDir()
+	config.Opts.Data = data
+	config.Opts.DSN = filepath.Join(data, "e-oasis.db")
+	config.Opts.MetaDSN = filepath.Join(data, "metadata.db")
+	if err := os.WriteFile(filepath.Join(data, PidFileName), []byte(strconv.Itoa(os.Getppid())), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestoreBackup(incremental, true); err == nil {
+		t.Fatal("restored while a server uses the data directory")
+	}
+	if _, err := os.Stat(config.Opts.DSN); err == nil {
+		t.Fatal("the refused restore wrote the database")
+	}
+	if err := os.Remove(filepath.Join(data, PidFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestoreBackup(incremental, false); err != nil {
+		t.Fatal(err)
+	}
+	restored := store.NewStore(openTestDB(t, config.Opts.DSN, "system").DB, openTestDB(t, config.Opts.MetaDSN, "meta").DB)
+	books, err := restored.ListBooks(&model.FindBook{UserID: &uid})
+	if err != nil || len(books) != 2 {
+		t.Fatalf("books = %v, %v", books, err)
+	}
+	bookInfo, err := os.Stat(filepath.Join(data, filepath.FromSlash(book.Path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobInfo, err := os.Stat(filepath.Join(data, filepath.FromSlash(blob.Path)))
+	if err != nil || !os.SameFile(bookInfo, blobInfo) {
+		t.Fatalf("book and blob are not linked, %v", err)
+	}
+
+	if _, err := RestoreBackup(incremental, false); err == nil {
+		t.Fatal("restored over a library without force")
+	}
+
+	// A newer schema is refused, by version for the backups without
+	// migrations
+	if len(manifest.Migrations) == 0 {
+		t.Fatal("backup without migrations")
+	}
+	manifest.Migrations = append(manifest.Migrations, "0.2/99999_unknown.sql")
+	buf, _ := json.Marshal(manifest)
+	if err := os.WriteFile(filepath.Join(full, BackupManifestName), buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestoreBackup(full, true); err == nil {
+		t.Fatal("restored a backup of an unknown migration")
+	}
+	manifest.Migrations = nil
+	manifest.SchemaVersion = "99.0.0"
+	buf, _ = json.Marshal(manifest)
+	if err := os.WriteFile(filepath.Join(full, BackupManifestName), buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestoreBackup(full, true); err == nil {
+		t.Fatal("restored a backup of a newer schema")
+	}
+}
@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// saveBookBlob puts the file of a new book into the content-addressed store.
+// The book is kept when it fails, it is then deleted as a whole.
+func saveBookBlob(s *store.Store, bookID int, hash, bookFile string) {
+	if err := s.SaveBookBlob(hash, bookFile); err != nil {
+		log.Error("Failed to store book blob", zap.Int("book_id", bookID), zap.String("path", bookFile), zap.Error(err))
+	}
+}
+
+// shareBook links a book already in the library to the user instead of
+// saving a second copy of it, it returns false when the user owns it
+// already. A book of the trash of the user is restored instead.
+func shareBook(s *store.Store, bookID, uid int) (bool, error) {
+	if s.CheckBookUserLink(bookID, uid) {
+		n, err := s.RestoreBook(bookID, &uid)
+		return n > 0, err
+	}
+	// The book counts for the quota of each of its owners
+	var size int64
+	if blob, err := s.GetBookBlob(bookID); err != nil {
+		return false, err
+	} else if blob != nil {
+		size = blob.Size
+	} else if book, err := s.GetBook(&model.FindBook{BookID: &bookID}); err == nil && book != nil {
+		size = fileSize(book.Path)
+	}
+	if err := CheckQuota(s, uid, 1, size); err != nil {
+		return false, err
+	}
+
+	if _, err := s.AddBookUserLink(&model.BookUserLink{BookID: bookID, UserID: uid}); err != nil {
+		return false, errors.Wrap(err, "failed to link book")
+	}
+	if err := s.AddUserUsage(uid, 1, size); err != nil {
+		log.Error("Failed to update user usage", zap.Int("user_id", uid), zap.Error(err))
+	}
+	log.Info("Book shared with user", zap.Int("book_id", bookID), zap.Int("user_id", uid))
+	return true, nil
+}
@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	epub "github.com/go-shiori/go-epub"
+
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+)
+
+func TestSharedBook(t *testing.T) {
+	s := newJobTestStore(t)
+	var uids []int
+	for _, name := range []string{"alice", "bob"} {
+		user, err := s.CreateUser(&model.User{Username: name, PasswordHash: "test", Role: model.RoleUser})
+		if err != nil {
+			t.Fatal(err)
+		}
+		uids = append(uids, int(user.ID))
+	}
+
+	dir := t.TempDir()
+	b, err := epub.NewEpub("Shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Write(filepath.Join(dir, "Shared.epub")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both users import the same book, then alice once more
+	var items []*model.JobItem
+	for _, uid := range append(uids, uids[0]) {
+		job, err := s.AddJob(model.Job{UserID: uid, Type: model.JobTypeArchive, Stage: model.JobStageImport, Payload: model.JobPayload{Dir: dir}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ImportArchive(s, *job)
+		if job, err = s.GetJob(job.ID); err != nil || len(job.Payload.Items) != 1 {
+			t.Fatalf("job = %+v, %v", job, err)
+		}
+		items = append(items, job.Payload.Items[0])
+	}
+	bookID := items[0].BookID
+	if items[0].Status != model.JobItemImported || items[1].Status != model.JobItemShared || items[1].BookID != bookID {
+		t.Fatalf("items = %+v, %+v", items[0], items[1])
+	}
+	if items[2].Status != model.JobItemDuplicate || items[2].DuplicateOf != bookID {
+		t.Errorf("the copy of alice = %+v", items[2])
+	}
+	for _, uid := range uids {
+		if !s.CheckBookUserLink(bookID, uid) {
+			t.Errorf("book not linked to user %d", uid)
+		}
+	}
+
+	book, err := s.GetBook(&model.FindBook{BookID: &bookID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := s.GetBookBlob(bookID)
+	if err != nil || blob == nil || blob.RefCount != 2 {
+		t.Fatalf("blob = %+v, %v", blob, err)
+	}
+	blobInfo, err := os.Stat(store.BlobPath(blob.Hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookInfo, err := os.Stat(book.Path)
+	if err != nil || !os.SameFile(blobInfo, bookInfo) {
+		t.Fatalf("the book file should be a link to the blob, %v", err)
+	}
+
+	// Alice removes the book, bob keeps it
+	if err := s.RemoveBook(&model.FindBook{BookID: &bookID, UserID: &uids[0]}); err != nil {
+		t.Fatal(err)
+	}
+	if s.CheckBookUserLink(bookID, uids[0]) || !s.CheckBookUserLink(bookID, uids[1]) {
+		t.Error("only the link of alice should be removed")
+	}
+	if blob, _ := s.GetBookBlob(bookID); blob == nil || blob.RefCount != 1 {
+		t.Errorf("blob = %+v", blob)
+	}
+	if _, err := os.Stat(book.Path); err != nil {
+		t.Errorf("the book file of bob is gone, %v", err)
+	}
+
+	// The last owner takes the bytes along
+	if err := s.RemoveBook(&model.FindBook{BookID: &bookID, UserID: &uids[1]}); err != nil {
+		t.Fatal(err)
+	}
+	if s.CheckBook(bookID) {
+		t.Error("the book should be deleted")
+	}
+	if _, err := os.Stat(store.BlobPath(blob.Hash)); !os.IsNotExist(err) {
+		t.Error("the blob should be deleted")
+	}
+	if _, err := os.Stat(filepath.Dir(book.Path)); !os.IsNotExist(err) {
+		t.Error("the book directory should be deleted")
+	}
+}
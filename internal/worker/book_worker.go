@@ -1,23 +1,18 @@
 package worker // import "github.com/Xunop/e-oasis/internal/worker"
 
 import (
-	"archive/zip"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"io"
-	"mime/multipart"
-	"net/http"
 	"os"
-	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Xunop/e-oasis/internal/config"
 	"github.com/Xunop/e-oasis/internal/log"
 	"github.com/Xunop/e-oasis/internal/model"
 	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/Xunop/e-oasis/internal/util/parsers"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
@@ -25,14 +20,18 @@ import (
 var (
 	uploadDone = make(chan model.Job)
 	metaBatch  = make(chan *model.BookMeta, 10)
-	MetaSingle = make(chan model.BookMeta, 1)
-	ErrorChan  = make(chan error, 1)
 )
 
+// maxJobAttempts is how many times a stage of a job is started before the
+// job is given up, a job crashing the server mustn't be resumed forever.
+const maxJobAttempts = 3
+
 type BookUploadPool struct {
 	queue chan model.Job
 }
 
+// NewUploadPool starts the upload workers, the jobs left in the upload stage
+// by the last run are pushed again.
 func NewUploadPool(store *store.Store, size int) *BookUploadPool {
 	pool := &BookUploadPool{
 		queue: make(chan model.Job),
@@ -42,6 +41,8 @@ func NewUploadPool(store *store.Store, size int) *BookUploadPool {
 		worker := &BookUploadWorker{id: i, store: store}
 		go worker.Run(pool.queue)
 	}
+	go resumeJobs(store, model.JobStageUpload, pool.Push)
+	go sweepUploads()
 
 	return pool
 }
@@ -55,6 +56,100 @@ func (p *BookUploadPool) Push(job model.Job) {
 	p.queue <- job
 }
 
+// resumeJobs hands the unfinished jobs of a stage to run
+func resumeJobs(s *store.Store, stage string, run func(model.Job)) {
+	jobs, err := s.ListJobs(&model.FindJob{
+		Stage:    &stage,
+		Statuses: []string{model.JobStatusPending, model.JobStatusRunning},
+	})
+	if err != nil {
+		log.Error("Failed to list unfinished jobs", zap.String("stage", stage), zap.Error(err))
+		return
+	}
+
+	for _, job := range jobs {
+		if job.Attempts >= maxJobAttempts {
+			failJob(s, job, errors.Errorf("gave up after %d attempts", job.Attempts))
+			continue
+		}
+		log.Info("Resuming job", zap.Int("job_id", job.ID), zap.String("stage", stage), zap.Int("attempts", job.Attempts))
+		run(*job)
+	}
+}
+
+// startJob records that a worker runs the job, it fails when the job was
+// canceled.
+func startJob(s *store.Store, job *model.Job) error {
+	job.Status = model.JobStatusRunning
+	job.Attempts++
+	if job.StartedTs == 0 {
+		job.StartedTs = time.Now().Unix()
+	}
+	return saveJob(s, job)
+}
+
+// checkCanceled fails when the user canceled the job since it started
+func checkCanceled(s *store.Store, job *model.Job) error {
+	current, err := s.GetJob(job.ID)
+	if err != nil {
+		return err
+	}
+	if current.Status == model.JobStatusCanceled {
+		return store.ErrJobCanceled
+	}
+	return nil
+}
+
+// CancelJob cancels a job that isn't finished and tells its user,
+// sql.ErrNoRows is returned for the finished ones.
+func CancelJob(s *store.Store, id int) (*model.Job, error) {
+	job, err := s.CancelJob(id)
+	if err != nil {
+		return nil, err
+	}
+	Events.Publish(job.Event())
+	return job, nil
+}
+
+// finishJob records that the job is done
+func finishJob(s *store.Store, job *model.Job) {
+	job.Status = model.JobStatusDone
+	job.Error = ""
+	job.FinishedTs = time.Now().Unix()
+	if err := saveJob(s, job); err != nil {
+		log.Error("Failed to update job", zap.Int("job_id", job.ID), zap.Error(err))
+	}
+}
+
+// failJob records why the job failed and removes its files, unless the book
+// was saved. The request waiting for the job gets the error.
+func failJob(s *store.Store, job *model.Job, jobErr error) {
+	if job.Payload.File != "" {
+		os.Remove(job.Payload.File)
+		job.Payload.File = ""
+	}
+	if job.Payload.BookID == 0 && job.Path != "" {
+		os.RemoveAll(job.Path)
+	}
+
+	if errors.Is(jobErr, store.ErrJobCanceled) {
+		log.Info("Job canceled", zap.Int("job_id", job.ID), zap.String("stage", job.Stage))
+		job.Status = model.JobStatusCanceled
+		job.Error = ""
+		Events.Publish(job.Event())
+	} else {
+		log.Error("Job failed", zap.Int("job_id", job.ID), zap.String("stage", job.Stage), zap.Error(jobErr))
+		job.Status = model.JobStatusFailed
+		job.Error = jobErr.Error()
+		job.FinishedTs = time.Now().Unix()
+		if err := saveJob(s, job); err != nil {
+			log.Error("Failed to update job", zap.Int("job_id", job.ID), zap.Error(err))
+		}
+	}
+
+	Results.deliver(job.ID, JobResult{Err: jobErr})
+}
+
 type BookUploadWorker struct {
 	id    int
 	store *store.Store
@@ -64,98 +159,114 @@ type BookUploadWorker struct {
 func (w *BookUploadWorker) Run(c <-chan model.Job) {
 	log.Debug("BookDownloadWorker is running", zap.Int("worker_id", w.id))
 
-	for {
-		job := <-c
-
+	for job := range c {
 		log.Debug("Job reveived by worker",
 			zap.Int("work_id", w.id),
+			zap.Int("job_id", job.ID),
 			zap.Int("user_id", job.UserID))
 
-		// startTiime := time.Now()
-
-		uid := job.UserID
-		fileHeader := job.Item.(*multipart.FileHeader)
-		// Open the file
-		file, err := fileHeader.Open()
-		if err != nil {
-			log.Error("Error opening file", zap.Error(err))
-			ErrorChan <- err
+		if err := w.upload(&job); err != nil {
+			failJob(w.store, &job, err)
 			continue
 		}
-		defer file.Close()
 
-		buff := make([]byte, fileHeader.Size)
-		_, err = file.Read(buff)
-		if err != nil {
-			log.Error("Error reading file", zap.Error(err))
-			ErrorChan <- err
-			continue
-		}
+		// Next Parse the book
+		// File path is the path of the book: /path/uid/books/book.epub
+		uploadDone <- job
 
-		fileType := http.DetectContentType(buff)
-		if !config.CheckSupportedTypes(fileType) {
-			log.Error("Unsupported file type", zap.String("file_type", fileType))
-			ErrorChan <- err
-			continue
-		}
+		log.Debug("File uploaded successfully",
+			zap.String("file_name", job.Payload.FileName),
+			zap.Int("user_id", job.UserID),
+			zap.Int("job_id", job.ID))
+	}
+}
 
-		_, err = file.Seek(0, io.SeekStart)
-		if err != nil {
-			log.Error("Error seeking file", zap.Error(err))
-			ErrorChan <- err
-			continue
-		}
+// upload moves the staged file of the job into the book directory
+func (w *BookUploadWorker) upload(job *model.Job) error {
+	if err := startJob(w.store, job); err != nil {
+		return err
+	}
+	filePath := job.BookFile()
 
-		// Check if the user has a folder
-		if _, err := os.Stat(job.Path); os.IsNotExist(err) {
-			err = os.MkdirAll(job.Path, os.ModePerm)
-			if err != nil {
-				log.Error("Error creating folder", zap.Error(err))
-				ErrorChan <- err
-				continue
-			}
+	if _, err := os.Stat(job.Payload.File); err != nil {
+		// The file may have been moved right before a restart
+		if _, statErr := os.Stat(filePath); statErr == nil {
+			return w.uploaded(job)
 		}
+		return errors.Wrap(err, "staged file is gone")
+	}
 
-		filePath := fmt.Sprintf("%s/%s", job.Path, job.Item.(*multipart.FileHeader).Filename)
+	file, err := os.Open(job.Payload.File)
+	if err != nil {
+		return errors.Wrap(err, "error opening file")
+	}
+	head := make([]byte, 4096)
+	n, err := io.ReadFull(file, head)
+	file.Close()
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return errors.Wrap(err, "error reading file")
+	}
 
-		log.Debug("File path", zap.String("path", filePath))
+	// The extension picks the parser, the content must match it
+	ext := parsers.Ext(job.Payload.FileName)
+	parser := parsers.ForPath(job.Payload.FileName)
+	if parser == nil || !config.CheckSupportedTypes(ext) || !parser.Detect(head[:n]) {
+		return fmt.Errorf("Unsupported file type: %s", ext)
+	}
 
-		f, err := os.Create(filePath)
-		if err != nil {
-			log.Error("Error creating file", zap.Error(err))
-			ErrorChan <- err
-			os.RemoveAll(job.Path)
-			continue
-		}
-		defer f.Close()
+	// Check if the user has a folder
+	if err := os.MkdirAll(job.Path, os.ModePerm); err != nil {
+		return errors.Wrap(err, "error creating folder")
+	}
+	log.Debug("File path", zap.String("path", filePath))
+	if err := moveFile(job, job.Payload.File, filePath); err != nil {
+		return err
+	}
+	return w.uploaded(job)
+}
 
-		_, err = io.Copy(f, file)
-		if err != nil {
-			log.Error("Error copying file", zap.Error(err))
-			ErrorChan <- err
-			os.RemoveAll(job.Path)
-			continue
-		}
+// uploaded hands the job over to the parse stage
+func (w *BookUploadWorker) uploaded(job *model.Job) error {
+	job.Payload.File = ""
+	job.Stage = model.JobStageParse
+	job.Status = model.JobStatusPending
+	job.Attempts = 0
+	return saveJob(w.store, job)
+}
 
-		job.Status = model.JobStatusDone
-		j, err := w.store.AddJob(job)
-		if err != nil {
-			log.Error("Error adding job", zap.Error(err))
-			// ErrorChan <- err
-			// continue
-		} else {
-			w.store.JobCache.Store(j.ID, &j)
-		}
+// moveFile renames src to dest, copying it when they aren't on the same
+// file system. The user of the job follows the copy.
+func moveFile(job *model.Job, src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "error opening file")
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return errors.Wrap(err, "error opening file")
+	}
+	progress := &progressWriter{job: job, total: info.Size()}
 
-		// Next Parse the book
-		// File path is the path of the book: /path/uid/books/book.epub
-		uploadDone <- job
+	if err := os.Rename(src, dest); err == nil {
+		progress.done = progress.total
+		progress.publish()
+		return nil
+	}
 
-		log.Debug("File uploaded successfully",
-			zap.String("file_name", fileHeader.Filename),
-			zap.Int("user_id", uid),
-			zap.Int("job_id", j.ID))
+	out, err := os.Create(dest)
+	if err != nil {
+		return errors.Wrap(err, "error creating file")
+	}
+	progress.w = out
+	if _, err := io.Copy(progress, in); err != nil {
+		out.Close()
+		return errors.Wrap(err, "error copying file")
 	}
+	if err := out.Close(); err != nil {
+		return errors.Wrap(err, "error copying file")
+	}
+	return os.Remove(src)
 }
 
 type BookParsePool struct {
@@ -167,9 +278,12 @@ func (p *BookParsePool) Push(job model.Job) {
 	p.Queue <- job
 }
 
+// NewParsePool starts the parse workers, they take the jobs uploaded by the
+// upload pool. The jobs left in the parse stage by the last run are pushed
+// again, the archive imports go on where they stopped.
 func NewParsePool(store *store.Store, size int) *BookParsePool {
 	pool := &BookParsePool{
-		Queue: make(chan model.Job),
+		Queue: uploadDone,
 	}
 
 	go SaveBookMeta(store)
@@ -177,6 +291,14 @@ func NewParsePool(store *store.Store, size int) *BookParsePool {
 		worker := &BookParseWorker{id: i, store: store}
 		go worker.Run()
 	}
+	go resumeJobs(store, model.JobStageParse, pool.Push)
+	go resumeJobs(store, model.JobStageImport, func(job model.Job) {
+		if job.Type == model.JobTypeCalibre {
+			ImportCalibre(store, job)
+			return
+		}
+		ImportArchive(store, job)
+	})
 
 	return pool
 }
@@ -192,198 +314,231 @@ type BookParseWorker struct {
 func (w *BookParseWorker) Run() {
 	log.Debug("BookParseWorker is running", zap.Int("worker_id", w.id))
 
-	for {
-		job := <-uploadDone
-
+	for job := range uploadDone {
 		log.Debug("Job reveived by worker",
 			zap.Int("work_id", w.id),
+			zap.Int("job_id", job.ID),
 			zap.String("path", job.Path))
 
-		log.Debug("Parse book in", zap.String("dir", job.Path))
-
-		filePath := fmt.Sprintf("%s/%s", job.Path, job.Item.(*multipart.FileHeader).Filename)
-
-		bookHash, err := generateBookHash(filePath)
-		if err != nil {
-			log.Error("Failed to generate book hash", zap.String("Book", filePath), zap.Error(err))
-			ErrorChan <- errors.Wrap(err, "failed to generate book hash")
-			os.RemoveAll(job.Path)
-			continue
+		if err := w.parse(&job); err != nil {
+			failJob(w.store, &job, err)
 		}
+	}
+}
 
-		if bookID, exists := w.store.CheckBookHash(bookHash); exists {
-			log.Warn("Duplicate book detected, aborting import.",
-				zap.String("hash", bookHash),
-				zap.Int("existing_book_id", bookID),
-				zap.String("path", filePath))
+// parse saves the book of the job, the rest of the metadata is saved by
+// SaveBookMeta.
+func (w *BookParseWorker) parse(job *model.Job) error {
+	if err := startJob(w.store, job); err != nil {
+		return err
+	}
+	filePath := job.BookFile()
+	log.Debug("Parse book in", zap.String("dir", job.Path))
 
-			os.RemoveAll(job.Path)
+	bookHash, err := GenerateBookHash(filePath)
+	if err != nil {
+		return errors.Wrap(err, "failed to generate book hash")
+	}
 
-			if job.Type == "SINGLE" {
-				ErrorChan <- errors.New("book already exists")
-			}
-			continue
+	if bookID, exists := w.store.CheckBookHash(bookHash); exists {
+		// The book may have been saved right before a restart
+		if book, err := w.store.GetBook(&model.FindBook{BookID: &bookID}); err == nil && book.Path == filePath {
+			job.Payload.BookID = bookID
+			finishJob(w.store, job)
+			return nil
 		}
-
-		bookMeta, err := ParseBook(filePath)
+		// The copy of another user is shared, the upload isn't kept
+		shared, err := shareBook(w.store, bookID, job.UserID)
 		if err != nil {
-			log.Error("Parse book error", zap.String("Book", filePath), zap.Error(err))
-			ErrorChan <- err
-			os.RemoveAll(job.Path)
-			continue
+			return err
 		}
-
-		// When We parse the book, we need to save the book metadata
-		// Save the book metadata
-		newBook := &model.Book{
-			Title:        bookMeta.Book.Title,
-			SortTitle:    bookMeta.Book.SortTitle,
-			PublishDate:  bookMeta.Book.PublishDate,
-			AuthorSort:   bookMeta.Book.AuthorSort,
-			ISBN:         bookMeta.Book.ISBN,
-			Path:         bookMeta.Book.Path,
-			UUID:         bookMeta.Book.UUID,
-			HasCover:     bookMeta.Book.HasCover,
-			LastModified: bookMeta.Book.LastModified,
+		if shared {
+			os.RemoveAll(job.Path)
+			meta, err := w.store.GetBookMeta(bookID)
+			if err != nil {
+				return err
+			}
+			job.Payload.BookID, job.Payload.Shared = bookID, true
+			finishJob(w.store, job)
+			Results.deliver(job.ID, JobResult{Meta: meta})
+			return nil
 		}
+		log.Warn("Duplicate book detected, aborting import.",
+			zap.String("hash", bookHash),
+			zap.Int("existing_book_id", bookID),
+			zap.String("path", filePath))
+		job.Payload.DuplicateOf = bookID
+		return errors.New("book already exists")
+	}
 
-		returnBook, err := w.store.AddBook(newBook)
-		if err != nil {
-			log.Error("Error adding book", zap.Error(err))
-			ErrorChan <- err
-			continue
-		}
+	if err := CheckQuota(w.store, job.UserID, 1, fileSize(filePath)); err != nil {
+		return err
+	}
+	bookMeta, err := ParseBook(filePath)
+	if err != nil {
+		return err
+	}
+	// Parsing takes a while, the user may have given up
+	if err := checkCanceled(w.store, job); err != nil {
+		return err
+	}
 
-		if err := w.store.AddBookHashLink(returnBook.ID, bookHash); err != nil {
-			log.Error("Failed to link book hash",
-				zap.Int("book_id", returnBook.ID),
-				zap.String("hash", bookHash),
-				zap.Error(err))
-		}
+	// When We parse the book, we need to save the book metadata
+	// Save the book metadata
+	newBook := &model.Book{
+		Title:        bookMeta.Book.Title,
+		SortTitle:    bookMeta.Book.SortTitle,
+		PublishDate:  bookMeta.Book.PublishDate,
+		AuthorSort:   bookMeta.Book.AuthorSort,
+		ISBN:         bookMeta.Book.ISBN,
+		Path:         bookMeta.Book.Path,
+		UUID:         bookMeta.Book.UUID,
+		HasCover:     bookMeta.Book.HasCover,
+		LastModified: bookMeta.Book.LastModified,
+	}
 
-		w.store.BookCache.Store(returnBook.ID, returnBook)
-		bookMeta.Book = returnBook
-		if job.Type == "SINGLE" {
-			MetaSingle <- *bookMeta
-		}
+	// The book is saved by SaveBookMeta after the job is done, an
+	// interrupted creation is completed at the next startup
+	entry, err := w.store.AddJournalEntry(&model.JournalEntry{
+		Op:      model.JournalOpCreateBook,
+		UserID:  job.UserID,
+		JobID:   job.ID,
+		Payload: model.JournalPayload{Path: filePath, Hashes: []string{bookHash}},
+	})
+	if err != nil {
+		return err
+	}
+	returnBook, err := w.store.AddBook(newBook)
+	if err != nil {
+		w.store.DeleteJournalEntry(entry.ID)
+		return errors.Wrap(err, "error adding book")
+	}
+	job.Payload.BookID = returnBook.ID
+	if err := w.store.SetJournalBook(entry.ID, returnBook.ID); err != nil {
+		log.Error("Failed to update journal entry", zap.Int("book_id", returnBook.ID), zap.Error(err))
+	}
 
-		metaBatch <- bookMeta
+	if err := w.store.AddBookHashLink(returnBook.ID, bookHash); err != nil {
+		log.Error("Failed to link book hash",
+			zap.Int("book_id", returnBook.ID),
+			zap.String("hash", bookHash),
+			zap.Error(err))
 	}
+	saveBookBlob(w.store, returnBook.ID, bookHash, filePath)
+
+	// The series index is saved along with the series by SaveBookMeta
+	returnBook.SeriesIndex = bookMeta.Book.SeriesIndex
+	w.store.BookCache.Store(returnBook.ID, returnBook)
+	bookMeta.Book = returnBook
+	bookMeta.Tags = append(bookMeta.Tags, job.Payload.Tags...)
+	result := *bookMeta
+	metaBatch <- bookMeta
+	finishJob(w.store, job)
+	Results.deliver(job.ID, JobResult{Meta: &result})
+	return nil
 }
 
 func SaveBookMeta(s *store.Store) {
 	for {
-		metaData := <-metaBatch
-
-		// When We parse the book, we need to save the book metadata
-		// Save the book metadata
-		// newBook := &model.Book{
-		// 	Title:        metaData.Book.Title,
-		// 	SortTitle:    metaData.Book.SortTitle,
-		// 	PublishDate:  metaData.Book.PublishDate,
-		// 	AuthorSort:   metaData.Book.AuthorSort,
-		// 	ISBN:         metaData.Book.ISBN,
-		// 	Path:         metaData.Book.Path,
-		// 	UUID:         metaData.Book.UUID,
-		// 	HasCover:     metaData.Book.HasCover,
-		// 	LastModified: metaData.Book.LastModified,
-		// }
-		//
-		// returnBook, err := s.AddBook(newBook)
-		// if err != nil {
-		// 	log.Error("Error adding book", zap.Error(err))
-		// 	ErrorChan <- err
-		// 	continue
-		// }
-		// s.BookCache.Store(returnBook.ID, returnBook)
-
-		returnBook := metaData.Book
-
-		publisherRes, err := s.AddPublisher(metaData.Publisher)
-		if err != nil {
-			log.Error("Error add publisher", zap.String("publisher", metaData.Publisher.Name), zap.Error(err))
-		}
-		log.Debug("Add publisher response", zap.Any("response", publisherRes))
+		saveBookMeta(s, <-metaBatch)
+	}
+}
 
-		authorRes, err := s.AddAuthor(metaData.Author)
-		if err != nil {
-			log.Error("Error add author", zap.String("author", metaData.Author.Name), zap.Error(err))
-		}
-		log.Debug("Add author response", zap.Any("response", authorRes))
+// saveBookMeta saves the metadata of a book added by parse, then links it to
+// its user. The creation of the book is done once it is linked.
+func saveBookMeta(s *store.Store, metaData *model.BookMeta) {
+	returnBook := metaData.Book
 
-		authorLink := model.BookAuthorLink{BookID: returnBook.ID, AuthorID: authorRes.ID}
-		linkRes, err := s.AddBookAuthorLink(&authorLink)
-		if err != nil {
-			log.Error("Error add book author link", zap.Error(err))
-		}
-		log.Debug("Add book author link response", zap.Any("response", linkRes))
+	publisherRes, err := s.AddPublisher(metaData.Publisher)
+	if err != nil {
+		log.Error("Error add publisher", zap.String("publisher", metaData.Publisher.Name), zap.Error(err))
+	}
+	log.Debug("Add publisher response", zap.Any("response", publisherRes))
 
-		uidIdx := 0
-		for idx, part := range strings.Split(metaData.Book.Path, "/") {
-			if part == "books" {
-				uidIdx = idx
-			}
+	if err := s.SetBookAuthors(returnBook.ID, metaData.Authors); err != nil {
+		log.Error("Error add book authors", zap.Error(err))
+	} else {
+		returnBook.Authors = metaData.Authors
+	}
+
+	for _, tag := range metaData.Tags {
+		if err := s.AddTagToBook(returnBook.ID, tag); err != nil {
+			log.Error("Error add book tag", zap.String("tag", tag), zap.Error(err))
 		}
-		if uidIdx == 0 {
-			log.Error("Error getting user ID", zap.String("path", metaData.Book.Path))
-			continue
+	}
+	if metaData.Description != "" {
+		if err := s.SetBookComment(returnBook.ID, metaData.Description); err != nil {
+			log.Error("Error add book comment", zap.Error(err))
 		}
-		uid, err := strconv.Atoi(strings.Split(metaData.Book.Path, "/")[uidIdx-1])
+	}
+	if metaData.Series != nil && metaData.Series.Name != "" {
+		seriesRes, err := s.AddSeries(metaData.Series)
 		if err != nil {
-			log.Error("Error getting user ID", zap.Error(err), zap.String("path", metaData.Book.Path))
+			log.Error("Error add series", zap.String("series", metaData.Series.Name), zap.Error(err))
+		} else if err := s.SetBookSeries(returnBook.ID, seriesRes); err != nil {
+			log.Error("Error add book series link", zap.Error(err))
 		}
-		bookUserLinkRes, err := s.AddBookUserLink(&model.BookUserLink{BookID: returnBook.ID, UserID: uid})
-		if err != nil {
-			log.Error("Error add book user link", zap.Error(err))
+	}
+	if len(metaData.Languages) > 0 {
+		codes := make([]string, 0, len(metaData.Languages))
+		for _, lang := range metaData.Languages {
+			codes = append(codes, lang.LangCode)
+		}
+		if err := s.SetBookLanguages(returnBook.ID, codes); err != nil {
+			log.Error("Error add book languages", zap.Strings("languages", codes), zap.Error(err))
 		}
-		log.Debug("Add book user link response", zap.Any("response", bookUserLinkRes))
-		// _, err := w.store.AddLanguage(&model.Language{Name: bookLanguage})
-		// w.store.AddBookAuthorLink(&model.BookAuthorLink{BookID: returnBook.ID, AuthorID: 1})
 	}
-}
-
-// generateBookHash generate the hash of the book
-func generateBookHash(bookPath string) (string, error) {
-	bookType := filepath.Ext(bookPath)
-	switch bookType {
-	case ".epub":
-		r, err := zip.OpenReader(bookPath)
-		if err != nil {
-			log.Error("Error opening epub for hashing", zap.Error(err), zap.String("path", bookPath))
-			return "", err
+	for typ, val := range metaData.Identifiers {
+		if err := s.SetBookIdentifier(returnBook.ID, typ, val); err != nil {
+			log.Error("Error add book identifier", zap.String("type", typ), zap.Error(err))
 		}
-		defer r.Close()
-
-		// To ensure the stability of the hash, we must always process files in the same order.
-        // Here, we sort them alphabetically by filename.
-		sort.Slice(r.File, func(i, j int) bool {
-			return r.File[i].Name < r.File[j].Name
-		})
-
-		hash := sha256.New()
-
-		for _, f := range r.File {
-			if f.FileInfo().IsDir() {
-				continue
-			}
-
-			rc, err := f.Open()
-			if err != nil {
-				log.Error("Error opening internal file for hashing", zap.Error(err), zap.String("file", f.Name))
-				return "", err
-			}
+	}
 
-			if _, err := io.Copy(hash, rc); err != nil {
-				rc.Close()
-				log.Error("Error copying file content to hash", zap.Error(err), zap.String("file", f.Name))
-				return "", err
-			}
-			rc.Close()
+	uidIdx := 0
+	for idx, part := range strings.Split(metaData.Book.Path, "/") {
+		if part == "books" {
+			uidIdx = idx
 		}
+	}
+	if uidIdx == 0 {
+		log.Error("Error getting user ID", zap.String("path", metaData.Book.Path))
+		return
+	}
+	uid, err := strconv.Atoi(strings.Split(metaData.Book.Path, "/")[uidIdx-1])
+	if err != nil {
+		log.Error("Error getting user ID", zap.Error(err), zap.String("path", metaData.Book.Path))
+	}
+	bookUserLinkRes, err := s.AddBookUserLink(&model.BookUserLink{BookID: returnBook.ID, UserID: uid})
+	if err != nil {
+		// The journal entry is left, the book is linked at the next startup
+		log.Error("Error add book user link", zap.Error(err))
+		return
+	}
+	log.Debug("Add book user link response", zap.Any("response", bookUserLinkRes))
+	bookPath, err := ApplyBookLayout(s, returnBook.ID, uid)
+	if err != nil {
+		log.Error("Error moving book", zap.Int("book_id", returnBook.ID), zap.Error(err))
+		bookPath = returnBook.Path
+	}
+	StoreBook(bookPath)
+	addUsage(s, uid, bookPath)
+	if err := s.DeleteBookJournal(model.JournalOpCreateBook, returnBook.ID); err != nil {
+		log.Error("Failed to delete journal entry", zap.Int("book_id", returnBook.ID), zap.Error(err))
+	}
+	// w.store.AddBookAuthorLink(&model.BookAuthorLink{BookID: returnBook.ID, AuthorID: 1})
+}
 
-		return hex.EncodeToString(hash.Sum(nil)), nil
-
-	default:
+// GenerateBookHash generate the hash of the book
+func GenerateBookHash(bookPath string) (string, error) {
+	parser := parsers.ForPath(bookPath)
+	if parser == nil {
 		return "", errors.New("Unsupported book type")
 	}
+
+	bookHash, err := parser.Hash(bookPath)
+	if err != nil {
+		log.Error("Error hashing book", zap.String("format", parser.Name()), zap.Error(err), zap.String("path", bookPath))
+		return "", err
+	}
+	return bookHash, nil
 }
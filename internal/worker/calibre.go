@@ -0,0 +1,499 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/Xunop/e-oasis/internal/util/parsers"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	_ "modernc.org/sqlite"
+)
+
+// calibreFormatOrder is the order in which the format of a calibre book is
+// picked as its main file, formats not listed come last.
+var calibreFormatOrder = []string{"EPUB", "AZW3", "MOBI", "AZW", "FB2", "FB2.ZIP", "PDF", "CBZ", "CBR", "CB7"}
+
+// CalibreImportIssue is a calibre book that wasn't imported
+type CalibreImportIssue struct {
+	// CalibreID is the id of the book in the calibre library
+	CalibreID int    `json:"calibre_id"`
+	Title     string `json:"title"`
+	Path      string `json:"path"`
+	Reason    string `json:"reason"`
+	// BookID is the existing book of a conflict
+	BookID int `json:"book_id,omitempty"`
+}
+
+// CalibreImportReport sums up the import of a calibre library
+type CalibreImportReport struct {
+	Library  string `json:"library"`
+	Total    int    `json:"total"`
+	Imported int    `json:"imported"`
+	// Skipped are the books without a supported file
+	Skipped []*CalibreImportIssue `json:"skipped"`
+	// Conflicts are the books already in e-oasis
+	Conflicts []*CalibreImportIssue `json:"conflicts"`
+}
+
+// CalibreImportProgress is sent after each book of the library
+type CalibreImportProgress struct {
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+	Title string `json:"title"`
+}
+
+// calibreNoFormat is the reason of the books skipped for having no file
+// e-oasis reads
+const calibreNoFormat = "no supported format"
+
+// calibreBook is a row of the books table of a calibre library
+type calibreBook struct {
+	id          int
+	title       string
+	sort        string
+	timestamp   string
+	pubdate     string
+	seriesIndex float64
+	authorSort  string
+	isbn        string
+	path        string
+	uuid        string
+	hasCover    bool
+}
+
+// ImportCalibreLibrary attaches the books of the calibre library at dir to a
+// user. The books stay where they are: their metadata is read from the
+// metadata.db of the library rather than from the files, which are only
+// hashed to detect the books already in e-oasis. progress may be nil.
+func ImportCalibreLibrary(ctx context.Context, s *store.Store, dir string, userID int, progress func(CalibreImportProgress)) (*CalibreImportReport, error) {
+	dir, library, books, err := openCalibreLibrary(s, dir, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer library.Close()
+
+	report := &CalibreImportReport{
+		Library:   dir,
+		Total:     len(books),
+		Skipped:   []*CalibreImportIssue{},
+		Conflicts: []*CalibreImportIssue{},
+	}
+	log.Info("Importing calibre library", zap.String("library", dir), zap.Int("books", len(books)), zap.Int("uid", userID))
+
+	for i, book := range books {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		issue, err := importCalibreBook(s, library, dir, book, userID)
+		if err != nil {
+			return report, errors.Wrapf(err, "failed to import calibre book %d", book.id)
+		}
+		switch {
+		case issue == nil:
+			report.Imported++
+		case issue.BookID != 0:
+			report.Conflicts = append(report.Conflicts, issue)
+		default:
+			report.Skipped = append(report.Skipped, issue)
+		}
+
+		if progress != nil {
+			progress(CalibreImportProgress{Done: i + 1, Total: len(books), Title: book.title})
+		}
+	}
+
+	log.Info("Calibre library imported", zap.String("library", dir), zap.Int("imported", report.Imported),
+		zap.Int("skipped", len(report.Skipped)), zap.Int("conflicts", len(report.Conflicts)))
+	return report, nil
+}
+
+// openCalibreLibrary opens the metadata.db of the calibre library at dir and
+// lists its books, once the user they are attached to is found. It returns
+// the absolute path of the library.
+func openCalibreLibrary(s *store.Store, dir string, userID int) (string, *sql.DB, []*calibreBook, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", nil, nil, errors.Wrap(err, "invalid library path")
+	}
+	dbPath := filepath.Join(dir, "metadata.db")
+	if _, err := os.Stat(dbPath); err != nil {
+		return "", nil, nil, errors.Wrapf(err, "%s is not a calibre library", dir)
+	}
+
+	uid := int32(userID)
+	user, err := s.GetUser(&model.FindUser{ID: &uid})
+	if err != nil {
+		return "", nil, nil, errors.Wrap(err, "failed to get user")
+	}
+	if user == nil {
+		return "", nil, nil, errors.Errorf("user %d not found", userID)
+	}
+
+	library, err := sql.Open("sqlite", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return "", nil, nil, errors.Wrap(err, "failed to open calibre library")
+	}
+	books, err := listCalibreBooks(library)
+	if err != nil {
+		library.Close()
+		return "", nil, nil, err
+	}
+	return dir, library, books, nil
+}
+
+// ImportCalibre runs a calibre job, the outcome of every book of the library
+// is saved in the job as it goes, the conflicts are its duplicate items. A
+// resumed job skips the books it already went through.
+func ImportCalibre(s *store.Store, job model.Job) {
+	if err := importCalibre(s, &job); err != nil {
+		failJob(s, &job, err)
+		return
+	}
+	job.Payload.Summary = job.Payload.Summarize()
+	finishJob(s, &job)
+	log.Info("Calibre library imported", zap.Int("job_id", job.ID), zap.String("library", job.Payload.Dir),
+		zap.Int("imported", job.Payload.Summary.Imported),
+		zap.Int("conflicts", job.Payload.Summary.Duplicates),
+		zap.Int("skipped", job.Payload.Summary.Unsupported+job.Payload.Summary.Failed))
+}
+
+func importCalibre(s *store.Store, job *model.Job) error {
+	if err := startJob(s, job); err != nil {
+		return err
+	}
+	dir, library, books, err := openCalibreLibrary(s, job.Payload.Dir, job.Payload.OwnerID)
+	if err != nil {
+		return err
+	}
+	defer library.Close()
+	job.Payload.Total = len(books)
+	log.Info("Importing calibre library", zap.Int("job_id", job.ID), zap.String("library", dir),
+		zap.Int("books", len(books)), zap.Int("uid", job.Payload.OwnerID))
+
+	seen := make(map[string]bool, len(job.Payload.Items))
+	for _, item := range job.Payload.Items {
+		seen[item.Name] = true
+	}
+	for _, book := range books {
+		if seen[book.path] {
+			continue
+		}
+		if err := checkCanceled(s, job); err != nil {
+			return err
+		}
+
+		issue, err := importCalibreBook(s, library, dir, book, job.Payload.OwnerID)
+		if err != nil {
+			return errors.Wrapf(err, "failed to import calibre book %d", book.id)
+		}
+		item := calibreJobItem(book, issue)
+		job.Payload.Items = append(job.Payload.Items, item)
+		if _, err := s.UpdateJob(*job); err != nil {
+			return err
+		}
+		event := job.Event()
+		event.Item = item
+		event.ItemsDone, event.ItemsTotal = len(job.Payload.Items), job.Payload.Total
+		Events.Publish(event)
+	}
+	return nil
+}
+
+// calibreJobItem returns the outcome of a book of the library, named after
+// its directory in the library
+func calibreJobItem(book *calibreBook, issue *CalibreImportIssue) *model.JobItem {
+	item := &model.JobItem{Name: book.path, Status: model.JobItemImported}
+	switch {
+	case issue == nil:
+	case issue.BookID != 0:
+		item.Status, item.DuplicateOf = model.JobItemDuplicate, issue.BookID
+	case issue.Reason == calibreNoFormat:
+		item.Status = model.JobItemUnsupported
+	default:
+		item.Status = model.JobItemFailed
+	}
+	if issue != nil {
+		item.Error = issue.Reason
+	}
+	return item
+}
+
+// importCalibreBook saves a book of the library, it returns the issue when the
+// book is skipped.
+func importCalibreBook(s *store.Store, library *sql.DB, dir string, book *calibreBook, userID int) (*CalibreImportIssue, error) {
+	bookDir := filepath.Join(dir, filepath.FromSlash(book.path))
+	issue := &CalibreImportIssue{CalibreID: book.id, Title: book.title, Path: bookDir}
+
+	formats, err := listCalibreFormats(library, book.id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first supported format found on disk is the file of the book
+	var bookPath string
+	var parser parsers.BookParser
+	for _, format := range formats {
+		ext := "." + strings.ToLower(format.Format)
+		p := parsers.Lookup(ext)
+		if p == nil || !config.CheckSupportedTypes(ext) {
+			continue
+		}
+		path := filepath.Join(bookDir, format.Name+ext)
+		if _, err := os.Stat(path); err != nil {
+			log.Warn("Calibre book file is missing", zap.String("path", path))
+			continue
+		}
+		bookPath, parser = path, p
+		break
+	}
+	if parser == nil {
+		issue.Reason = calibreNoFormat
+		return issue, nil
+	}
+	issue.Path = bookPath
+
+	hash, err := parser.Hash(bookPath)
+	if err != nil {
+		log.Warn("Failed to hash calibre book", zap.String("path", bookPath), zap.Error(err))
+		issue.Reason = "failed to hash the book: " + err.Error()
+		return issue, nil
+	}
+	if bookID, exists := s.CheckBookHash(hash); exists {
+		// The book of another user is shared with the user
+		shared, err := shareBook(s, bookID, userID)
+		if errors.Is(err, ErrQuotaExceeded) {
+			issue.Reason = err.Error()
+			return issue, nil
+		}
+		if err != nil || shared {
+			return nil, err
+		}
+		issue.Reason = "book already exists"
+		issue.BookID = bookID
+		return issue, nil
+	}
+
+	if err := CheckQuota(s, userID, 1, fileSize(bookPath)); err != nil {
+		issue.Reason = err.Error()
+		return issue, nil
+	}
+
+	meta, err := calibreBookMeta(library, book)
+	if err != nil {
+		return nil, err
+	}
+	meta.Book.Path = bookPath
+	meta.Formats = formats
+
+	// The cover is served as webp, keep the original for calibre
+	if book.hasCover {
+		if _, err := os.Stat(CoverPath(bookPath, CoverFull)); err != nil {
+			if err := SaveCover(bookPath, filepath.Join(bookDir, "cover.jpg")); err != nil {
+				log.Warn("Failed to convert cover", zap.String("path", bookDir), zap.Error(err))
+			}
+		}
+		_, err := os.Stat(CoverPath(bookPath, CoverFull))
+		meta.Book.HasCover = err == nil
+	}
+
+	newBook, err := s.SaveImportedBook(meta, userID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.AddBookHashLink(newBook.ID, hash); err != nil {
+		return nil, err
+	}
+	addUsage(s, userID, bookPath)
+	log.Debug("Calibre book imported", zap.Int("calibre_id", book.id), zap.Int("book_id", newBook.ID))
+	return nil, nil
+}
+
+func listCalibreBooks(library *sql.DB) ([]*calibreBook, error) {
+	rows, err := library.Query(`SELECT id, title, COALESCE(sort, ''), COALESCE(timestamp, ''), COALESCE(pubdate, ''),
+		series_index, COALESCE(author_sort, ''), COALESCE(isbn, ''), path, COALESCE(uuid, ''), COALESCE(has_cover, 0)
+		FROM books ORDER BY id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list calibre books")
+	}
+	defer rows.Close()
+
+	var books []*calibreBook
+	for rows.Next() {
+		var book calibreBook
+		if err := rows.Scan(&book.id, &book.title, &book.sort, &book.timestamp, &book.pubdate, &book.seriesIndex,
+			&book.authorSort, &book.isbn, &book.path, &book.uuid, &book.hasCover); err != nil {
+			return nil, errors.Wrap(err, "failed to scan calibre book")
+		}
+		books = append(books, &book)
+	}
+	return books, rows.Err()
+}
+
+// listCalibreFormats returns the files of a book, the preferred format first
+func listCalibreFormats(library *sql.DB, bookID int) ([]*model.BookFormat, error) {
+	rows, err := library.Query(`SELECT format, uncompressed_size, name FROM data WHERE book = ? ORDER BY id`, bookID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list calibre book formats")
+	}
+	defer rows.Close()
+
+	var formats []*model.BookFormat
+	for rows.Next() {
+		var format model.BookFormat
+		if err := rows.Scan(&format.Format, &format.Size, &format.Name); err != nil {
+			return nil, errors.Wrap(err, "failed to scan calibre book format")
+		}
+		format.Format = strings.ToUpper(format.Format)
+		formats = append(formats, &format)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rank := func(format string) int {
+		for i, f := range calibreFormatOrder {
+			if f == format {
+				return i
+			}
+		}
+		return len(calibreFormatOrder)
+	}
+	sort.SliceStable(formats, func(i, j int) bool {
+		return rank(formats[i].Format) < rank(formats[j].Format)
+	})
+	return formats, nil
+}
+
+// calibreBookMeta reads the metadata of a book from the calibre library
+func calibreBookMeta(library *sql.DB, book *calibreBook) (*model.BookMeta, error) {
+	meta := &model.BookMeta{
+		Book: &model.Book{
+			Title:       book.title,
+			SortTitle:   book.sort,
+			TimeStamp:   book.timestamp,
+			PublishDate: book.pubdate,
+			AuthorSort:  book.authorSort,
+			ISBN:        book.isbn,
+			UUID:        book.uuid,
+		},
+		Identifiers: map[string]string{},
+	}
+
+	var creators []parsers.Creator
+	err := queryCalibre(library, `SELECT a.name, COALESCE(a.sort, '') FROM books_authors_link bal
+		JOIN authors a ON a.id = bal.author WHERE bal.book = ? ORDER BY bal.id`, book.id, func(rows *sql.Rows) error {
+		var creator parsers.Creator
+		if err := rows.Scan(&creator.Name, &creator.Sort); err != nil {
+			return err
+		}
+		creator.Role = model.AuthorRoleAuthor
+		creators = append(creators, creator)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read calibre book authors")
+	}
+	meta.Authors = bookAuthors(creators)
+	if meta.Book.AuthorSort == "" {
+		meta.Book.AuthorSort = meta.Authors[0].Sort
+	}
+
+	err = queryCalibre(library, `SELECT p.name FROM books_publishers_link bpl
+		JOIN publishers p ON p.id = bpl.publisher WHERE bpl.book = ? LIMIT 1`, book.id, func(rows *sql.Rows) error {
+		meta.Publisher = &model.Publisher{}
+		return rows.Scan(&meta.Publisher.Name)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read calibre book publisher")
+	}
+
+	err = queryCalibre(library, `SELECT t.name FROM books_tags_link btl
+		JOIN tags t ON t.id = btl.tag WHERE btl.book = ? ORDER BY btl.id`, book.id, func(rows *sql.Rows) error {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return err
+		}
+		meta.Tags = append(meta.Tags, tag)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read calibre book tags")
+	}
+
+	err = queryCalibre(library, `SELECT s.name, COALESCE(s.sort, '') FROM books_series_link bsl
+		JOIN series s ON s.id = bsl.series WHERE bsl.book = ? LIMIT 1`, book.id, func(rows *sql.Rows) error {
+		meta.Series = &model.Series{Index: book.seriesIndex}
+		return rows.Scan(&meta.Series.Name, &meta.Series.Sort)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read calibre book series")
+	}
+
+	err = queryCalibre(library, `SELECT l.lang_code FROM books_languages_link bll
+		JOIN languages l ON l.id = bll.lang_code WHERE bll.book = ? ORDER BY bll.item_order`, book.id, func(rows *sql.Rows) error {
+		var lang model.Language
+		if err := rows.Scan(&lang.LangCode); err != nil {
+			return err
+		}
+		meta.Languages = append(meta.Languages, &lang)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read calibre book languages")
+	}
+
+	err = queryCalibre(library, `SELECT text FROM comments WHERE book = ?`, book.id, func(rows *sql.Rows) error {
+		return rows.Scan(&meta.Description)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read calibre book comment")
+	}
+
+	err = queryCalibre(library, `SELECT type, val FROM identifiers WHERE book = ?`, book.id, func(rows *sql.Rows) error {
+		var typ, val string
+		if err := rows.Scan(&typ, &val); err != nil {
+			return err
+		}
+		meta.Identifiers[typ] = val
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read calibre book identifiers")
+	}
+
+	err = queryCalibre(library, `SELECT COALESCE(r.rating, 0) FROM books_ratings_link brl
+		JOIN ratings r ON r.id = brl.rating WHERE brl.book = ? LIMIT 1`, book.id, func(rows *sql.Rows) error {
+		return rows.Scan(&meta.Rating)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read calibre book rating")
+	}
+	return meta, nil
+}
+
+// queryCalibre runs a query on the library and calls scan for every row
+func queryCalibre(library *sql.DB, query string, bookID int, scan func(rows *sql.Rows) error) error {
+	rows, err := library.Query(query, bookID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,233 @@
+package worker
+
+import (
+	"archive/zip"
+	"context"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/Xunop/e-oasis/internal/store/db"
+)
+
+// TestMain writes the logs of the tests to a temporary directory, the
+// default log file is relative to the working directory
+func TestMain(m *testing.M) {
+	config.Opts = config.GetDefaultOptions()
+	dir, err := os.MkdirTemp("", "e-oasis-worker-test")
+	if err != nil {
+		panic(err)
+	}
+	config.Opts.LogFile = filepath.Join(dir, "logs.log")
+	log.Logger = log.NewLogger()
+
+	code := m.Run()
+	log.Logger.Sync()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func openTestDB(t *testing.T, path, name string) *db.DB {
+	t.Helper()
+	d, err := db.NewDB(path, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err := d.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// writeCalibreLibrary creates a library with an EPUB book, a book without a
+// file and the same EPUB again.
+func writeCalibreLibrary(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	library := openTestDB(t, filepath.Join(dir, "metadata.db"), "meta")
+
+	stmts := []string{
+		`INSERT INTO books (id, title, sort, author_sort, path, has_cover, series_index) VALUES
+			(1, 'The Book', 'Book, The', 'Doe, Jane', 'Jane Doe/The Book (1)', 1, 2),
+			(2, 'No File', 'No File', 'Doe, Jane', 'Jane Doe/No File (2)', 0, 1),
+			(3, 'The Book Again', 'Book Again, The', 'Doe, Jane', 'Jane Doe/The Book Again (3)', 0, 1)`,
+		`INSERT INTO authors (id, name, sort, link) VALUES (1, 'Jane Doe', 'Doe, Jane', ''), (2, 'John Roe', 'Roe, John', '')`,
+		`INSERT INTO books_authors_link (book, author) VALUES (1, 2), (1, 1), (2, 1), (3, 1)`,
+		`INSERT INTO data (book, format, uncompressed_size, name) VALUES
+			(1, 'PDF', 10, 'The Book - Jane Doe'), (1, 'EPUB', 20, 'The Book - Jane Doe'),
+			(2, 'EPUB', 20, 'No File - Jane Doe'), (3, 'EPUB', 20, 'The Book Again - Jane Doe')`,
+		`INSERT INTO tags (id, name) VALUES (1, 'Fiction')`,
+		`INSERT INTO books_tags_link (book, tag) VALUES (1, 1)`,
+		`INSERT INTO series (id, name, sort) VALUES (1, 'The Series', 'Series, The')`,
+		`INSERT INTO books_series_link (book, series) VALUES (1, 1)`,
+		`INSERT INTO ratings (id, rating) VALUES (1, 8)`,
+		`INSERT INTO books_ratings_link (book, rating) VALUES (1, 1)`,
+		`INSERT INTO identifiers (book, type, val) VALUES (1, 'isbn', '9780101010101')`,
+		`INSERT INTO comments (book, text) VALUES (1, 'A comment')`,
+	}
+	for _, stmt := range stmts {
+		if _, err := library.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	for _, path := range []string{"Jane Doe/The Book (1)/The Book - Jane Doe.epub", "Jane Doe/The Book Again (3)/The Book Again - Jane Doe.epub"} {
+		writeTestZip(t, filepath.Join(dir, path))
+	}
+	cover, err := os.Create(filepath.Join(dir, "Jane Doe/The Book (1)/cover.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cover.Close()
+	if err := jpeg.Encode(cover, image.NewRGBA(image.Rect(0, 0, 4, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestZip(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	fw, err := w.Create("mimetype")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("application/epub+zip"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportCalibreLibrary(t *testing.T) {
+	dir := t.TempDir()
+	config.Opts.Data = filepath.Join(dir, "data")
+	config.Opts.DSN = filepath.Join(dir, "data", "e-oasis.db")
+	config.Opts.MetaDSN = filepath.Join(dir, "data", "metadata.db")
+	if err := os.MkdirAll(config.Opts.Data, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	libraryDir := filepath.Join(dir, "library")
+	writeCalibreLibrary(t, libraryDir)
+
+	systemDb := openTestDB(t, config.Opts.DSN, "system")
+	metaDb := openTestDB(t, config.Opts.MetaDSN, "meta")
+	s := store.NewStore(systemDb.DB, metaDb.DB)
+	user, err := s.CreateUser(&model.User{Username: "test", PasswordHash: "test", Role: model.RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var done int
+	report, err := ImportCalibreLibrary(context.Background(), s, libraryDir, int(user.ID), func(p CalibreImportProgress) {
+		done = p.Done
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done != 3 || report.Total != 3 || report.Imported != 1 {
+		t.Fatalf("report = %+v, progress = %d", report, done)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0].CalibreID != 2 {
+		t.Errorf("skipped = %+v", report.Skipped)
+	}
+	if len(report.Conflicts) != 1 || report.Conflicts[0].CalibreID != 3 || report.Conflicts[0].BookID == 0 {
+		t.Errorf("conflicts = %+v", report.Conflicts)
+	}
+
+	books, err := s.ListBooksByUserID(int(user.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 {
+		t.Fatalf("books = %+v", books)
+	}
+	book := books[0]
+	if want := filepath.Join(libraryDir, "Jane Doe/The Book (1)/The Book - Jane Doe.epub"); book.Path != want {
+		t.Errorf("path = %q, want %q", book.Path, want)
+	}
+	if !book.HasCover || book.SeriesIndex != 2 {
+		t.Errorf("book = %+v", book)
+	}
+	if len(book.Authors) != 2 || book.Authors[0].Name != "John Roe" || book.Authors[1].Name != "Jane Doe" {
+		t.Errorf("authors = %+v", book.Authors)
+	}
+	if _, err := os.Stat(filepath.Join(libraryDir, "Jane Doe/The Book (1)/cover.jpg")); err != nil {
+		t.Error("the calibre cover should be kept")
+	}
+
+	var rating, formats int
+	var comment string
+	metaDb.QueryRow(`SELECT r.rating FROM books_ratings_link l JOIN ratings r ON r.id = l.rating WHERE l.book = ?`, book.ID).Scan(&rating)
+	metaDb.QueryRow(`SELECT COUNT(*) FROM data WHERE book = ?`, book.ID).Scan(&formats)
+	metaDb.QueryRow(`SELECT text FROM comments WHERE book = ?`, book.ID).Scan(&comment)
+	if rating != 8 || formats != 2 || comment != "A comment" {
+		t.Errorf("rating = %d, formats = %d, comment = %q", rating, formats, comment)
+	}
+}
+
+func TestImportCalibreJob(t *testing.T) {
+	libraryDir := filepath.Join(t.TempDir(), "library")
+	writeCalibreLibrary(t, libraryDir)
+	s := newJobTestStore(t)
+	admin, err := s.CreateUser(&model.User{Username: "admin", PasswordHash: "test", Role: model.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.CreateUser(&model.User{Username: "reader", PasswordHash: "test", Role: model.RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, unsubscribe := Events.Subscribe(int(admin.ID))
+	defer unsubscribe()
+	job, err := s.AddJob(model.Job{UserID: int(admin.ID), Type: model.JobTypeCalibre, Stage: model.JobStageImport,
+		Payload: model.JobPayload{Dir: libraryDir, OwnerID: int(user.ID)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ImportCalibre(s, *job)
+
+	if job, err = s.GetJob(job.ID); err != nil || job.Status != model.JobStatusDone {
+		t.Fatalf("job = %+v, %v", job, err)
+	}
+	summary := job.Payload.Summary
+	if job.Payload.Total != 3 || summary.Imported != 1 || summary.Unsupported != 1 || summary.Duplicates != 1 {
+		t.Fatalf("total = %d, summary = %+v", job.Payload.Total, summary)
+	}
+	var conflict *model.JobItem
+	for _, item := range job.Payload.Items {
+		if item.Status == model.JobItemDuplicate {
+			conflict = item
+		}
+	}
+	if conflict == nil || conflict.DuplicateOf == 0 || conflict.Error == "" {
+		t.Errorf("conflict = %+v", conflict)
+	}
+
+	var last *model.JobEvent
+	for len(events) > 0 {
+		if event := <-events; event.Item != nil {
+			last = event
+		}
+	}
+	if last == nil || last.UserID != int(admin.ID) || last.ItemsDone != 3 || last.ItemsTotal != 3 {
+		t.Errorf("last item event = %+v", last)
+	}
+}
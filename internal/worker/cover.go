@@ -0,0 +1,165 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/storage"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/Xunop/e-oasis/internal/util"
+	"github.com/Xunop/e-oasis/internal/util/parsers"
+	"github.com/pkg/errors"
+)
+
+// Cover sizes, the full cover keeps the size of the original image
+const (
+	CoverThumbnail = "thumbnail"
+	CoverMedium    = "medium"
+	CoverFull      = "full"
+)
+
+// coverQuality is the webp quality of the covers
+const coverQuality = 75
+
+// coverSizes are the widths the covers are generated at
+var coverSizes = []struct {
+	name  string
+	width int
+}{
+	{CoverThumbnail, 200},
+	{CoverMedium, 600},
+	{CoverFull, 0},
+}
+
+// ValidCoverSize reports whether size is one of the cover sizes
+func ValidCoverSize(size string) bool {
+	for _, s := range coverSizes {
+		if s.name == size {
+			return true
+		}
+	}
+	return false
+}
+
+// CoverPath returns the path of the cover of the book at bookPath in size.
+// The full cover is cover.webp, the name calibre and the sidecars know.
+func CoverPath(bookPath, size string) string {
+	if size == "" || size == CoverFull {
+		return filepath.Join(filepath.Dir(bookPath), "cover.webp")
+	}
+	return filepath.Join(filepath.Dir(bookPath), "cover-"+size+".webp")
+}
+
+// SaveCover converts the image src to the covers of every size of the book
+// at bookPath, replacing the former ones.
+func SaveCover(bookPath, src string) error {
+	for _, size := range coverSizes {
+		if err := util.ImageToWebpWidth(src, CoverPath(bookPath, size.name), size.width, coverQuality); err != nil {
+			return errors.Wrapf(err, "failed to generate %s cover", size.name)
+		}
+	}
+	return nil
+}
+
+// EnsureCover returns the cover of the book at bookPath in size, it is
+// generated from the full cover for the books saved before the size existed.
+func EnsureCover(bookPath, size string) (string, error) {
+	path := CoverPath(bookPath, size)
+	if _, err := storage.Size(context.Background(), storage.Default, path); !errors.Is(err, storage.ErrNotFound) {
+		return path, err
+	}
+	full := CoverPath(bookPath, CoverFull)
+	if err := storage.Fetch(context.Background(), storage.Default, full); err != nil {
+		return "", errors.Wrap(err, "failed to fetch cover")
+	}
+	for _, s := range coverSizes {
+		if s.name == size {
+			if err := util.ImageToWebpWidth(full, path, s.width, coverQuality); err != nil {
+				return "", err
+			}
+		}
+	}
+	storeCovers(bookPath, size)
+	return path, nil
+}
+
+// SetBookCover replaces the cover of a book with the image src
+func SetBookCover(s *store.Store, bookID int, src string) error {
+	book, err := s.GetBook(&model.FindBook{BookID: &bookID})
+	if err != nil {
+		return err
+	}
+	if err := SaveCover(book.Path, src); err != nil {
+		return err
+	}
+	storeCovers(book.Path, CoverThumbnail, CoverMedium, CoverFull)
+	return s.SetBookHasCover(bookID, true)
+}
+
+// ExtractBookCover sets the cover of a book from the book file, either the
+// cover the format points at, or image when it isn't empty, see BookImages.
+func ExtractBookCover(s *store.Store, bookID int, image string) error {
+	book, err := s.GetBook(&model.FindBook{BookID: &bookID})
+	if err != nil {
+		return err
+	}
+	parser := parsers.ForPath(book.Path)
+	if parser == nil {
+		return errors.Errorf("unsupported file type: %s", parsers.Ext(book.Path))
+	}
+	if err := storage.Fetch(context.Background(), storage.Default, book.Path); err != nil {
+		return errors.Wrap(err, "failed to fetch book")
+	}
+
+	tmp, err := os.MkdirTemp("", "e-oasis-cover-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary directory")
+	}
+	defer os.RemoveAll(tmp)
+
+	var src string
+	if image == "" {
+		src, err = parser.Cover(book.Path, tmp)
+	} else if extractor, ok := parser.(parsers.ImageExtractor); ok {
+		src, err = extractor.Image(book.Path, image, tmp)
+	} else {
+		return errors.Errorf("%s books hold no images", parser.Name())
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to extract cover")
+	}
+	if src == "" {
+		return errors.New("the book has no cover")
+	}
+
+	if err := SaveCover(book.Path, src); err != nil {
+		return err
+	}
+	storeCovers(book.Path, CoverThumbnail, CoverMedium, CoverFull)
+	return s.SetBookHasCover(bookID, true)
+}
+
+// BookImages lists the images of the book at path that can be its cover
+func BookImages(path string) ([]string, error) {
+	parser := parsers.ForPath(path)
+	if parser == nil {
+		return nil, errors.Errorf("unsupported file type: %s", parsers.Ext(path))
+	}
+	extractor, ok := parser.(parsers.ImageExtractor)
+	if !ok {
+		return []string{}, nil
+	}
+	if err := storage.Fetch(context.Background(), storage.Default, path); err != nil {
+		return nil, errors.Wrap(err, "failed to fetch book")
+	}
+	images, err := extractor.Images(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list images")
+	}
+	if images == nil {
+		images = []string{}
+	}
+	return images, nil
+}
@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/storage"
+)
+
+func TestSaveCover(t *testing.T) {
+	dir := t.TempDir()
+	bookPath := filepath.Join(dir, "book.epub")
+
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 1500))
+	for y := 0; y < 1500; y++ {
+		for x := 0; x < 1000; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	src := filepath.Join(dir, "upload.png")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err := SaveCover(bookPath, src); err != nil {
+		t.Fatal(err)
+	}
+	for size, width := range map[string]int{CoverThumbnail: 200, CoverMedium: 600, CoverFull: 1000} {
+		if got := coverWidth(t, CoverPath(bookPath, size)); got != width {
+			t.Errorf("%s cover is %dpx wide, want %d", size, got, width)
+		}
+	}
+	if CoverPath(bookPath, CoverFull) != filepath.Join(dir, "cover.webp") {
+		t.Errorf("full cover = %s", CoverPath(bookPath, CoverFull))
+	}
+
+	// Books saved before the sizes existed only have the full cover
+	os.Remove(CoverPath(bookPath, CoverThumbnail))
+	path, err := EnsureCover(bookPath, CoverThumbnail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := coverWidth(t, path); got != 200 {
+		t.Errorf("thumbnail is %dpx wide, want 200", got)
+	}
+}
+
+// TestEnsureCoverFromStorage generates a cover of a book whose directory is
+// only in the storage
+func TestEnsureCoverFromStorage(t *testing.T) {
+	config.Opts.Data = t.TempDir()
+	bookPath := filepath.Join(config.Opts.Data, "1", "books", "Title", "Title.epub")
+	if err := os.MkdirAll(filepath.Dir(bookPath), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 800, 1200))
+	src := filepath.Join(t.TempDir(), "upload.png")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := SaveCover(bookPath, src); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(s storage.Storage) { storage.Default = s }(storage.Default)
+	bucket := &storage.LocalStorage{Path: t.TempDir()}
+	storage.Default = bucket
+	StoreBook(bookPath)
+	ctx := context.Background()
+	if err := bucket.Delete(ctx, "1/books/Title/cover-medium.webp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Dir(bookPath)); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := EnsureCover(bookPath, CoverMedium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := coverWidth(t, path); got != 600 {
+		t.Errorf("medium cover is %dpx wide, want 600", got)
+	}
+	if _, err := bucket.Stat(ctx, "1/books/Title/cover-medium.webp"); err != nil {
+		t.Errorf("the medium cover should be stored, %v", err)
+	}
+}
+
+func coverWidth(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "webp" {
+		t.Errorf("%s is %s", path, format)
+	}
+	return cfg.Width
+}
@@ -0,0 +1,560 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/storage"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/Xunop/e-oasis/internal/util/parsers"
+)
+
+// The kinds of issues the doctor finds
+const (
+	// DoctorMissingFile is a book whose file is gone
+	DoctorMissingFile = "missing_file"
+	// DoctorOrphanDir is a book directory no book knows of
+	DoctorOrphanDir = "orphan_dir"
+	// DoctorDanglingLink is a row of the application database for a book
+	// the metadata database doesn't have
+	DoctorDanglingLink = "dangling_link"
+	// DoctorUnownedBook is a book no user owns, no one sees it
+	DoctorUnownedBook = "unowned_book"
+	// DoctorMissingCover is a book with a cover but without cover.webp
+	DoctorMissingCover = "missing_cover"
+	// DoctorOrphanBlob is a blob no book is found by
+	DoctorOrphanBlob = "orphan_blob"
+	// DoctorMissingBlob is a blob whose file is gone
+	DoctorMissingBlob = "missing_blob"
+	// DoctorBlobRefs is a blob counting the wrong number of owners
+	DoctorBlobRefs = "blob_refs"
+	// DoctorUsage is a usage not matching the books of the user
+	DoctorUsage = "usage"
+	// DoctorNotStored is a book on the disk the storage doesn't have, like
+	// the ones saved before the S3 storage was set up
+	DoctorNotStored = "not_stored"
+)
+
+// QuarantineDir is the directory of the data directory the doctor moves the
+// files nothing knows of to, they are kept for the admin to look at
+const QuarantineDir = "quarantine"
+
+// DoctorIssue is an inconsistency between the databases and the data
+// directory
+type DoctorIssue struct {
+	Kind   string `json:"kind"`
+	BookID int    `json:"book_id,omitempty"`
+	UserID int    `json:"user_id,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	// Fix is what was done about the issue, empty when it wasn't fixed
+	Fix   string `json:"fix,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func (i *DoctorIssue) String() string {
+	parts := make([]string, 0, 4)
+	if i.BookID != 0 {
+		parts = append(parts, fmt.Sprintf("book %d", i.BookID))
+	}
+	if i.UserID != 0 {
+		parts = append(parts, fmt.Sprintf("user %d", i.UserID))
+	}
+	if i.Path != "" {
+		parts = append(parts, i.Path)
+	}
+	if i.Detail != "" {
+		parts = append(parts, i.Detail)
+	}
+	return i.Kind + ": " + strings.Join(parts, ", ")
+}
+
+// DoctorReport sums up a check of the library
+type DoctorReport struct {
+	Issues []*DoctorIssue `json:"issues"`
+	Fixed  int            `json:"fixed"`
+	// Quarantine is where the files were moved, empty when none was
+	Quarantine string `json:"quarantine,omitempty"`
+}
+
+type doctor struct {
+	s      *store.Store
+	fix    bool
+	report *DoctorReport
+	// quarantine is the directory of this run, created when a file is moved
+	quarantine string
+	books      []*model.Book
+	removed    map[int]bool
+	// The books, paths and blobs of the imports running, they are left
+	// alone
+	busyBooks  map[int]bool
+	busyPaths  map[string]bool
+	busyHashes map[string]bool
+}
+
+// RunDoctor checks the metadata database, the application database and the
+// data directory against each other. With fix, each issue is repaired, or
+// its files are moved to the quarantine when nothing knows what they are.
+func RunDoctor(ctx context.Context, s *store.Store, fix bool) (*DoctorReport, error) {
+	d := &doctor{
+		s:          s,
+		fix:        fix,
+		report:     &DoctorReport{Issues: []*DoctorIssue{}},
+		quarantine: filepath.Join(config.Opts.Data, QuarantineDir, time.Now().Format("20060102-150405")),
+		removed:    make(map[int]bool),
+		busyBooks:  make(map[int]bool),
+		busyPaths:  make(map[string]bool),
+		busyHashes: make(map[string]bool),
+	}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+
+	// The books are deleted before their links are checked, and the
+	// usage is counted last
+	checks := []func() error{
+		d.checkBookFiles,
+		d.checkBookDirs,
+		d.checkLinks,
+		d.checkOwners,
+		d.checkCovers,
+		d.checkBlobs,
+		d.checkStored,
+		d.checkUsage,
+	}
+	for _, check := range checks {
+		if err := ctx.Err(); err != nil {
+			return d.report, err
+		}
+		if err := check(); err != nil {
+			return d.report, err
+		}
+	}
+	log.Info("Library checked", zap.Int("issues", len(d.report.Issues)), zap.Int("fixed", d.report.Fixed))
+	return d.report, nil
+}
+
+// load reads the books and what the running imports work on
+func (d *doctor) load() error {
+	books, err := d.s.ListBooks(&model.FindBook{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list books")
+	}
+	d.books = books
+
+	jobs, err := d.s.ListJobs(&model.FindJob{Statuses: []string{model.JobStatusPending, model.JobStatusRunning}})
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if job.Path != "" {
+			d.busyPaths[job.Path] = true
+		}
+	}
+	entries, err := d.s.ListJournalEntries()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		d.busyBooks[entry.BookID] = true
+		if entry.Payload.Path != "" {
+			d.busyPaths[filepath.Dir(entry.Payload.Path)] = true
+		}
+		if entry.Payload.Dir != "" {
+			d.busyPaths[entry.Payload.Dir] = true
+		}
+		for _, hash := range entry.Payload.Hashes {
+			d.busyHashes[hash] = true
+		}
+	}
+	return nil
+}
+
+// add records an issue, with fix it is repaired by repair which returns what
+// it did. A nil repair only reports the issue.
+func (d *doctor) add(issue *DoctorIssue, repair func() (string, error)) {
+	d.report.Issues = append(d.report.Issues, issue)
+	if !d.fix || repair == nil {
+		return
+	}
+	fix, err := repair()
+	if err != nil {
+		log.Error("Failed to fix library issue", zap.String("kind", issue.Kind), zap.Int("book_id", issue.BookID),
+			zap.String("path", issue.Path), zap.Error(err))
+		issue.Error = err.Error()
+		return
+	}
+	issue.Fix = fix
+	d.report.Fixed++
+}
+
+// moveToQuarantine moves the file or directory at path to the quarantine,
+// under its path in the data directory
+func (d *doctor) moveToQuarantine(path string) (string, error) {
+	rel, err := filepath.Rel(config.Opts.Data, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(path)
+	}
+	dest := filepath.Join(d.quarantine, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return "", errors.Wrap(err, "failed to create quarantine directory")
+	}
+	if err := os.Rename(path, dest); err != nil {
+		return "", errors.Wrap(err, "failed to move to quarantine")
+	}
+	d.report.Quarantine = d.quarantine
+	return "moved to " + dest, nil
+}
+
+// checkBookFiles deletes the books whose file is gone
+func (d *doctor) checkBookFiles() error {
+	for _, book := range d.books {
+		if book.Path == "" || d.busyBooks[book.ID] {
+			continue
+		}
+		if _, err := storage.Size(context.Background(), storage.Default, book.Path); !errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		bookID := book.ID
+		d.add(&DoctorIssue{Kind: DoctorMissingFile, BookID: bookID, Path: book.Path}, func() (string, error) {
+			if err := d.s.RemoveBook(&model.FindBook{BookID: &bookID}); err != nil {
+				return "", err
+			}
+			d.removed[bookID] = true
+			return "deleted the book", nil
+		})
+	}
+	return nil
+}
+
+// checkBookDirs moves the book directories of the users no book knows of to
+// the quarantine
+func (d *doctor) checkBookDirs() error {
+	known := make(map[string]bool, len(d.books))
+	for _, book := range d.books {
+		known[filepath.Dir(book.Path)] = true
+	}
+
+	entries, err := os.ReadDir(config.Opts.Data)
+	if err != nil {
+		return errors.Wrap(err, "failed to read data directory")
+	}
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil || !entry.IsDir() {
+			continue
+		}
+		root := filepath.Join(config.Opts.Data, entry.Name(), "books")
+		err := filepath.WalkDir(root, func(path string, de fs.DirEntry, err error) error {
+			if err != nil {
+				if path == root && os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !de.IsDir() || path == root {
+				return nil
+			}
+			if known[path] || d.busyPaths[path] {
+				return filepath.SkipDir
+			}
+			if !holdsBook(path) {
+				return nil
+			}
+			d.add(&DoctorIssue{Kind: DoctorOrphanDir, Path: path}, func() (string, error) {
+				return d.moveToQuarantine(path)
+			})
+			return filepath.SkipDir
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to walk book directories")
+		}
+	}
+	return nil
+}
+
+// holdsBook reports whether dir holds a book file
+func holdsBook(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && parsers.ForPath(entry.Name()) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLinks deletes the rows of the application database left by deleted
+// books
+func (d *doctor) checkLinks() error {
+	ids, err := d.s.ListBookIDs()
+	if err != nil {
+		return err
+	}
+	for _, table := range store.BookLinkTables {
+		linked, err := d.s.ListLinkedBookIDs(table)
+		if err != nil {
+			return err
+		}
+		for _, bookID := range linked {
+			if ids[bookID] || d.busyBooks[bookID] {
+				continue
+			}
+			d.add(&DoctorIssue{Kind: DoctorDanglingLink, BookID: bookID, Detail: table}, func() (string, error) {
+				if err := d.s.DeleteBookLinks(table, bookID); err != nil {
+					return "", err
+				}
+				return "deleted the rows of " + table, nil
+			})
+		}
+	}
+	return nil
+}
+
+// checkOwners gives the books no one owns back to the user whose directory
+// holds them
+func (d *doctor) checkOwners() error {
+	linked, err := d.s.ListLinkedBookIDs("book_user_link")
+	if err != nil {
+		return err
+	}
+	owned := make(map[int]bool, len(linked))
+	for _, bookID := range linked {
+		owned[bookID] = true
+	}
+
+	for _, book := range d.books {
+		if owned[book.ID] || d.removed[book.ID] || d.busyBooks[book.ID] {
+			continue
+		}
+		issue := &DoctorIssue{Kind: DoctorUnownedBook, BookID: book.ID, Path: book.Path}
+		uid := bookDirOwner(book.Path)
+		if uid == 0 || !d.userExists(uid) {
+			issue.Detail = "no user found for the book"
+			d.add(issue, nil)
+			continue
+		}
+		bookID := book.ID
+		d.add(issue, func() (string, error) {
+			if _, err := d.s.AddBookUserLink(&model.BookUserLink{BookID: bookID, UserID: uid}); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("linked to user %d", uid), nil
+		})
+	}
+	return nil
+}
+
+// bookDirOwner returns the user of <data>/<uid>/books holding the book at
+// path, 0 for the books elsewhere
+func bookDirOwner(path string) int {
+	rel, err := filepath.Rel(config.Opts.Data, path)
+	if err != nil {
+		return 0
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) < 3 || parts[1] != "books" {
+		return 0
+	}
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0
+	}
+	return uid
+}
+
+func (d *doctor) userExists(uid int) bool {
+	id := int32(uid)
+	user, err := d.s.GetUser(&model.FindUser{ID: &id})
+	return err == nil && user != nil
+}
+
+// checkCovers extracts again the covers that are gone, the book has no cover
+// when it can't be
+func (d *doctor) checkCovers() error {
+	for _, book := range d.books {
+		if !book.HasCover || d.removed[book.ID] || d.busyBooks[book.ID] {
+			continue
+		}
+		cover := CoverPath(book.Path, CoverFull)
+		if _, err := storage.Size(context.Background(), storage.Default, cover); !errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		bookID := book.ID
+		d.add(&DoctorIssue{Kind: DoctorMissingCover, BookID: bookID, Path: cover}, func() (string, error) {
+			if err := ExtractBookCover(d.s, bookID, ""); err == nil {
+				return "extracted the cover from the book file", nil
+			}
+			if err := d.s.SetBookHasCover(bookID, false); err != nil {
+				return "", err
+			}
+			return "marked the book without cover", nil
+		})
+	}
+	return nil
+}
+
+// checkStored puts the directories of the books the storage doesn't have
+// into it, from the disk
+func (d *doctor) checkStored() error {
+	for _, book := range d.books {
+		if d.removed[book.ID] || d.busyBooks[book.ID] {
+			continue
+		}
+		key, ok := storage.Key(book.Path)
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(book.Path); err != nil {
+			continue
+		}
+		_, err := storage.Default.Stat(context.Background(), key)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		dir := filepath.Dir(book.Path)
+		d.add(&DoctorIssue{Kind: DoctorNotStored, BookID: book.ID, Path: book.Path}, func() (string, error) {
+			if err := storage.PutDir(context.Background(), storage.Default, dir); err != nil {
+				return "", err
+			}
+			return "stored the book directory", nil
+		})
+	}
+	return nil
+}
+
+// checkBlobs checks the rows of the content-addressed store against its
+// files
+func (d *doctor) checkBlobs() error {
+	blobs, err := d.s.ListBookBlobs()
+	if err != nil {
+		return err
+	}
+	rows := make(map[string]bool, len(blobs))
+	for _, blob := range blobs {
+		rows[blob.Hash] = true
+		if d.busyHashes[blob.Hash] {
+			continue
+		}
+		hash, path := blob.Hash, store.BlobPath(blob.Hash)
+
+		bookID, linked := d.s.CheckBookHash(hash)
+		if !linked {
+			d.add(&DoctorIssue{Kind: DoctorOrphanBlob, Path: path, Detail: "no book has the hash " + hash}, func() (string, error) {
+				if err := d.s.DeleteBookBlob(hash); err != nil {
+					return "", err
+				}
+				if _, err := os.Stat(path); err != nil {
+					return "deleted the blob", nil
+				}
+				return d.moveToQuarantine(path)
+			})
+			continue
+		}
+
+		// The blobs are a cache too when the books are in a bucket
+		if _, err := os.Stat(path); os.IsNotExist(err) && !storage.Cached(storage.Default) {
+			d.add(&DoctorIssue{Kind: DoctorMissingBlob, BookID: bookID, Path: path}, func() (string, error) {
+				book, err := d.s.GetBook(&model.FindBook{BookID: &bookID})
+				if err == nil && book != nil && fileSize(book.Path) > 0 {
+					if err := storage.Fetch(context.Background(), storage.Default, book.Path); err != nil {
+						return "", err
+					}
+					if err := d.s.SaveBookBlob(hash, book.Path); err != nil {
+						return "", err
+					}
+					return "stored the book file again", nil
+				}
+				if err := d.s.DeleteBookBlob(hash); err != nil {
+					return "", err
+				}
+				return "deleted the blob", nil
+			})
+			continue
+		}
+
+		owners, err := d.s.CountBlobOwners(hash)
+		if err != nil {
+			return err
+		}
+		if owners != blob.RefCount {
+			detail := fmt.Sprintf("ref_count is %d for %d owners", blob.RefCount, owners)
+			d.add(&DoctorIssue{Kind: DoctorBlobRefs, BookID: bookID, Path: path, Detail: detail}, func() (string, error) {
+				if err := d.s.RefreshBlobRefs(hash); err != nil {
+					return "", err
+				}
+				return "counted the owners again", nil
+			})
+		}
+	}
+
+	// The files of the store no row knows of
+	root := filepath.Join(config.Opts.Data, store.BlobDir)
+	err = filepath.WalkDir(root, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !de.Type().IsRegular() || rows[de.Name()] || d.busyHashes[de.Name()] {
+			return nil
+		}
+		d.add(&DoctorIssue{Kind: DoctorOrphanBlob, Path: path, Detail: "no blob is stored as " + de.Name()}, func() (string, error) {
+			return d.moveToQuarantine(path)
+		})
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to walk blobs")
+	}
+	return nil
+}
+
+// checkUsage counts again the usage of the users
+func (d *doctor) checkUsage() error {
+	users, err := d.s.ListUsers(&model.FindUser{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list users")
+	}
+	for _, user := range users {
+		uid := int(user.ID)
+		counted, err := CountUsage(d.s, uid)
+		if err != nil {
+			return err
+		}
+		saved, err := d.s.GetUserUsage(uid)
+		if err != nil {
+			return err
+		}
+		if counted.Books == saved.Books && counted.Bytes == saved.Bytes {
+			continue
+		}
+		detail := fmt.Sprintf("%d books of %d bytes saved, %d books of %d bytes counted",
+			saved.Books, saved.Bytes, counted.Books, counted.Bytes)
+		d.add(&DoctorIssue{Kind: DoctorUsage, UserID: uid, Detail: detail}, func() (string, error) {
+			if err := d.s.SaveUserUsage(counted); err != nil {
+				return "", err
+			}
+			return "saved the usage counted", nil
+		})
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	epub "github.com/go-shiori/go-epub"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/storage"
+)
+
+func TestDoctor(t *testing.T) {
+	s := newJobTestStore(t)
+	user, err := s.CreateUser(&model.User{Username: "reader", PasswordHash: "test", Role: model.RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := int(user.ID)
+
+	dir := t.TempDir()
+	for _, title := range []string{"Kept", "Lost"} {
+		b, err := epub.NewEpub(title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Write(filepath.Join(dir, title+".epub")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job, err := s.AddJob(model.Job{UserID: uid, Type: model.JobTypeArchive, Stage: model.JobStageImport, Payload: model.JobPayload{Dir: dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ImportArchive(s, *job)
+	if job, err = s.GetJob(job.ID); err != nil || job.Payload.Summary.Imported != 2 {
+		t.Fatalf("job = %+v, %v", job, err)
+	}
+	books := map[string]*model.Book{}
+	for _, item := range job.Payload.Items {
+		book, err := s.GetBook(&model.FindBook{BookID: &item.BookID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		books[item.Name] = book
+	}
+
+	// One of each issue
+	if err := os.Remove(books["Lost.epub"].Path); err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(config.Opts.Data, strconv.Itoa(uid), "books", "Orphan")
+	if err := os.MkdirAll(orphan, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyFile(filepath.Join(dir, "Kept.epub"), filepath.Join(orphan, "Orphan.epub")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddBookUserLink(&model.BookUserLink{BookID: 999, UserID: uid}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddBookHashLink(999, "dangling"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetBookHasCover(books["Kept.epub"].ID, true); err != nil {
+		t.Fatal(err)
+	}
+	strayBlob := filepath.Join(config.Opts.Data, "blobs", "ab", "abcdef")
+	if err := os.MkdirAll(filepath.Dir(strayBlob), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(strayBlob, []byte("stray"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddUserUsage(uid, 5, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{
+		DoctorMissingFile:  1,
+		DoctorOrphanDir:    1,
+		DoctorDanglingLink: 2,
+		DoctorMissingCover: 1,
+		DoctorOrphanBlob:   1,
+		DoctorUsage:        1,
+	}
+	report, err := RunDoctor(context.Background(), s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]int{}
+	for _, issue := range report.Issues {
+		kinds[issue.Kind]++
+	}
+	for kind, n := range want {
+		if kinds[kind] != n {
+			t.Errorf("%d %s issues, want %d", kinds[kind], kind, n)
+		}
+	}
+	if len(report.Issues) != 7 || report.Fixed != 0 {
+		t.Fatalf("report = %+v", report.Issues)
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Error("the check alone shouldn't move anything")
+	}
+
+	if report, err = RunDoctor(context.Background(), s, true); err != nil {
+		t.Fatal(err)
+	}
+	if report.Fixed != len(report.Issues) {
+		for _, issue := range report.Issues {
+			t.Logf("%s: %s %s", issue, issue.Fix, issue.Error)
+		}
+		t.Fatalf("fixed %d of %d issues", report.Fixed, len(report.Issues))
+	}
+	if _, err := os.Stat(filepath.Join(report.Quarantine, strconv.Itoa(uid), "books", "Orphan", "Orphan.epub")); err != nil {
+		t.Errorf("the orphan directory should be in the quarantine, %v", err)
+	}
+	if s.CheckBook(books["Lost.epub"].ID) {
+		t.Error("the book without file should be deleted")
+	}
+
+	if report, err = RunDoctor(context.Background(), s, false); err != nil || len(report.Issues) != 0 {
+		t.Errorf("issues left after the fix: %+v, %v", report.Issues, err)
+	}
+}
+
+// TestDoctorStorage checks the books against a storage other than the data
+// directory
+func TestDoctorStorage(t *testing.T) {
+	s := newJobTestStore(t)
+	defer func(s storage.Storage) { storage.Default = s }(storage.Default)
+	bucket := &storage.LocalStorage{Path: t.TempDir()}
+	storage.Default = bucket
+	user, err := s.CreateUser(&model.User{Username: "reader", PasswordHash: "test", Role: model.RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	b, err := epub.NewEpub("Stored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Write(filepath.Join(dir, "Stored.epub")); err != nil {
+		t.Fatal(err)
+	}
+	job, err := s.AddJob(model.Job{UserID: int(user.ID), Type: model.JobTypeArchive, Stage: model.JobStageImport, Payload: model.JobPayload{Dir: dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ImportArchive(s, *job)
+	if job, err = s.GetJob(job.ID); err != nil || job.Payload.Summary.Imported != 1 {
+		t.Fatalf("job = %+v, %v", job, err)
+	}
+	book, err := s.GetBook(&model.FindBook{BookID: &job.Payload.Items[0].BookID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := storage.Key(book.Path)
+
+	// A book the bucket is missing is put into it
+	if err := bucket.Delete(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	report, err := RunDoctor(context.Background(), s, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Kind != DoctorNotStored || report.Fixed != 1 {
+		t.Fatalf("issues = %+v", report.Issues)
+	}
+	if _, err := bucket.Stat(context.Background(), key); err != nil {
+		t.Fatalf("the book should be stored, %v", err)
+	}
+
+	// The data directory is a cache of the bucket
+	if err := os.RemoveAll(filepath.Dir(book.Path)); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = RunDoctor(context.Background(), s, false); err != nil || len(report.Issues) != 0 {
+		t.Errorf("issues of a book only in the bucket: %+v, %v", report.Issues, err)
+	}
+}
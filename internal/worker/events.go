@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+)
+
+// eventBuffer is how many events a slow subscriber may lag behind before
+// its events are dropped.
+const eventBuffer = 64
+
+// JobEvents sends the progress of the jobs to the subscribed users
+type JobEvents struct {
+	mu   sync.Mutex
+	subs map[chan *model.JobEvent]int // channel -> user ID
+}
+
+// Events are the events of every job
+var Events = NewJobEvents()
+
+func NewJobEvents() *JobEvents {
+	return &JobEvents{subs: make(map[chan *model.JobEvent]int)}
+}
+
+// Subscribe returns the events of the jobs of a user, the returned function
+// ends the subscription.
+func (e *JobEvents) Subscribe(userID int) (<-chan *model.JobEvent, func()) {
+	c := make(chan *model.JobEvent, eventBuffer)
+	e.mu.Lock()
+	e.subs[c] = userID
+	e.mu.Unlock()
+
+	return c, func() {
+		e.mu.Lock()
+		delete(e.subs, c)
+		e.mu.Unlock()
+	}
+}
+
+// Publish sends an event to the subscribers of its user. It never blocks,
+// a subscriber that doesn't keep up misses events, the state of the job can
+// still be read.
+func (e *JobEvents) Publish(event *model.JobEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for c, userID := range e.subs {
+		if userID != event.UserID {
+			continue
+		}
+		select {
+		case c <- event:
+		default:
+		}
+	}
+}
+
+// saveJob saves the job and tells its user
+func saveJob(s *store.Store, job *model.Job) error {
+	saved, err := s.UpdateJob(*job)
+	if err != nil {
+		return err
+	}
+	Events.Publish(saved.Event())
+	return nil
+}
+
+// progressInterval is how often the progress of a copy is sent
+const progressInterval = 250 * time.Millisecond
+
+// progressWriter sends the bytes written for a job, at most every
+// progressInterval and once at the end.
+type progressWriter struct {
+	w     io.Writer
+	job   *model.Job
+	total int64
+	done  int64
+	last  time.Time
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	if now := time.Now(); now.Sub(p.last) >= progressInterval || p.done == p.total {
+		p.last = now
+		p.publish()
+	}
+	return n, err
+}
+
+func (p *progressWriter) publish() {
+	event := p.job.Event()
+	event.BytesDone, event.BytesTotal = p.done, p.total
+	Events.Publish(event)
+}
@@ -0,0 +1,190 @@
+package worker
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	epub "github.com/go-shiori/go-epub"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+)
+
+func TestJobEvents(t *testing.T) {
+	events := NewJobEvents()
+	mine, unsubscribe := events.Subscribe(1)
+	other, unsubscribeOther := events.Subscribe(2)
+	defer unsubscribeOther()
+
+	events.Publish(&model.JobEvent{JobID: 7, UserID: 1})
+	select {
+	case event := <-mine:
+		if event.JobID != 7 {
+			t.Errorf("event = %+v", event)
+		}
+	default:
+		t.Error("the user should get the event of their job")
+	}
+	select {
+	case event := <-other:
+		t.Errorf("another user got %+v", event)
+	default:
+	}
+
+	// Publishing never blocks, even when nobody reads
+	for i := 0; i < eventBuffer*2; i++ {
+		events.Publish(&model.JobEvent{JobID: i, UserID: 1})
+	}
+	unsubscribe()
+	events.Publish(&model.JobEvent{JobID: 8, UserID: 1})
+	if len(mine) != eventBuffer {
+		t.Errorf("%d events buffered, want %d", len(mine), eventBuffer)
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	s := newJobTestStore(t)
+	job, err := s.AddJob(model.Job{UserID: 1, Type: model.JobTypeBatch, Status: model.JobStatusRunning})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canceled, err := s.CancelJob(job.ID)
+	if err != nil || canceled.Status != model.JobStatusCanceled || canceled.FinishedTs == 0 {
+		t.Fatalf("canceled = %+v, %v", canceled, err)
+	}
+	// The worker can't overwrite the status
+	job.Stage = model.JobStageParse
+	if _, err := s.UpdateJob(*job); err != store.ErrJobCanceled {
+		t.Errorf("UpdateJob() error = %v, want %v", err, store.ErrJobCanceled)
+	}
+	if _, err := s.CancelJob(job.ID); err == nil {
+		t.Error("a finished job can't be canceled")
+	}
+}
+
+func TestImportArchive(t *testing.T) {
+	s := newJobTestStore(t)
+	user, err := s.CreateUser(&model.User{Username: "test", PasswordHash: "test", Role: model.RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := int(user.ID)
+
+	dir := t.TempDir()
+	book := func(title string) string {
+		path := filepath.Join(dir, title+".epub")
+		b, err := epub.NewEpub(title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.SetAuthor("Jane Doe")
+		if err := b.Write(path); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	first := book("First")
+	archive := writeTarGz(t, filepath.Join(dir, "books.tar.gz"), [][2]string{
+		{"fiction/First.epub", first},
+		{"fiction/Again.epub", first},
+		{"Broken.epub", filepath.Join(dir, "broken")},
+	})
+
+	job, err := s.AddJob(model.Job{
+		UserID:  uid,
+		Type:    model.JobTypeArchive,
+		Stage:   model.JobStageImport,
+		Payload: model.JobPayload{File: archive, MapTags: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, unsubscribe := Events.Subscribe(uid)
+	defer unsubscribe()
+
+	ImportArchive(s, *job)
+
+	job, err = s.GetJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != model.JobStatusDone || len(job.Payload.Items) != 3 {
+		t.Fatalf("job = %+v", job)
+	}
+	items := map[string]*model.JobItem{}
+	for _, item := range job.Payload.Items {
+		items[item.Name] = item
+	}
+	imported := items["fiction/First.epub"]
+	if imported == nil || imported.Status != model.JobItemImported || imported.BookID == 0 {
+		t.Errorf("first = %+v", imported)
+	}
+	if item := items["fiction/Again.epub"]; item == nil || item.Status != model.JobItemDuplicate || item.DuplicateOf != imported.BookID {
+		t.Errorf("duplicate = %+v", item)
+	}
+	if item := items["Broken.epub"]; item == nil || item.Status != model.JobItemFailed || item.Error == "" {
+		t.Errorf("broken = %+v", item)
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Error("the archive should be removed")
+	}
+
+	var itemEvents int
+	for len(events) > 0 {
+		if event := <-events; event.Item != nil {
+			itemEvents++
+		}
+	}
+	if itemEvents != 3 {
+		t.Errorf("%d item events, want 3", itemEvents)
+	}
+}
+
+func newJobTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	dir := t.TempDir()
+	config.Opts.Data = dir
+	config.Opts.DSN = filepath.Join(dir, "e-oasis.db")
+	config.Opts.MetaDSN = filepath.Join(dir, "metadata.db")
+	return store.NewStore(openTestDB(t, config.Opts.DSN, "system").DB, openTestDB(t, config.Opts.MetaDSN, "meta").DB)
+}
+
+// writeTarGz writes the files, name and source pairs, to a tar.gz archive at
+// path. A missing source is written as garbage.
+func writeTarGz(t *testing.T, path string, files [][2]string) string {
+	t.Helper()
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		name := file[0]
+		data, err := os.ReadFile(file[1])
+		if err != nil {
+			data = []byte("not a book")
+		}
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
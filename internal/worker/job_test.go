@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	epub "github.com/go-shiori/go-epub"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+)
+
+func TestResumeJobs(t *testing.T) {
+	dir := t.TempDir()
+	config.Opts.Data = dir
+	config.Opts.DSN = filepath.Join(dir, "e-oasis.db")
+	config.Opts.MetaDSN = filepath.Join(dir, "metadata.db")
+	s := store.NewStore(openTestDB(t, config.Opts.DSN, "system").DB, openTestDB(t, config.Opts.MetaDSN, "meta").DB)
+	user, err := s.CreateUser(&model.User{Username: "test", PasswordHash: "test", Role: model.RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := int(user.ID)
+
+	// A job left running by a crash, its upload is staged
+	staged := filepath.Join(dir, "1", "tmp", "upload-1.epub")
+	if err := os.MkdirAll(filepath.Dir(staged), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	book, err := epub.NewEpub("Resumed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.SetAuthor("Jane Doe")
+	if err := book.Write(staged); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := s.AddJob(model.Job{
+		UserID:   uid,
+		Path:     filepath.Join(dir, "1", "books", "Resumed"),
+		Type:     model.JobTypeSingle,
+		Status:   model.JobStatusRunning,
+		Stage:    model.JobStageUpload,
+		Attempts: 1,
+		Payload:  model.JobPayload{File: staged, FileName: "Resumed.epub"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A job crashing the server every time
+	crashing, err := s.AddJob(model.Job{
+		UserID:   uid,
+		Path:     filepath.Join(dir, "1", "books", "Crashing"),
+		Type:     model.JobTypeBatch,
+		Status:   model.JobStatusRunning,
+		Stage:    model.JobStageParse,
+		Attempts: maxJobAttempts,
+		Payload:  model.JobPayload{FileName: "Crashing.epub"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	NewParsePool(s, 1)
+	NewUploadPool(s, 1)
+
+	job := waitJob(t, s, resumed.ID)
+	if job.Status != model.JobStatusDone || job.Stage != model.JobStageParse || job.Payload.BookID == 0 {
+		t.Fatalf("job = %+v", job)
+	}
+	if job.StartedTs == 0 || job.FinishedTs < job.StartedTs || job.Error != "" {
+		t.Errorf("job = %+v", job)
+	}
+	if _, err := os.Stat(staged); !os.IsNotExist(err) {
+		t.Error("the staged file should be moved")
+	}
+	saved, err := s.GetBook(&model.FindBook{BookID: &job.Payload.BookID})
+	if err != nil || saved.Title != "Resumed" {
+		t.Errorf("book = %+v, %v", saved, err)
+	}
+	// Let SaveBookMeta finish with the book before the directory goes away
+	for i := 0; i < 100; i++ {
+		if owner, err := s.GetBookOwnerID(job.Payload.BookID); err == nil && owner == uid {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	job = waitJob(t, s, crashing.ID)
+	if job.Status != model.JobStatusFailed || job.Error == "" {
+		t.Errorf("job = %+v", job)
+	}
+
+	jobs, err := s.ListJobs(&model.FindJob{Statuses: []string{model.JobStatusPending, model.JobStatusRunning}})
+	if err != nil || len(jobs) != 0 {
+		t.Errorf("unfinished jobs = %v, %v", jobs, err)
+	}
+}
+
+// waitJob waits for a job to finish
+func waitJob(t *testing.T, s *store.Store, id int) *model.Job {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		job, err := s.GetJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Finished() {
+			return job
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("job %d didn't finish", id)
+	return nil
+}
@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/storage"
+	"github.com/Xunop/e-oasis/internal/store"
+)
+
+// RecoverJournal completes or undoes the operations interrupted by the last
+// shutdown, it runs at startup before the workers. The entries it fails to
+// recover are kept for the next startup.
+func RecoverJournal(s *store.Store) {
+	entries, err := s.ListJournalEntries()
+	if err != nil {
+		log.Error("Failed to list journal entries", zap.Error(err))
+		return
+	}
+	for _, entry := range entries {
+		switch entry.Op {
+		case model.JournalOpCreateBook:
+			err = recoverBookCreation(s, entry)
+		case model.JournalOpDeleteBook:
+			// A deletion is always completed, the user asked for it
+			err = s.DeleteBookData(entry)
+		default:
+			err = errors.Errorf("unknown journal operation %q", entry.Op)
+		}
+		if err != nil {
+			log.Error("Failed to recover journal entry", zap.Int("id", entry.ID), zap.String("op", entry.Op),
+				zap.Int("book_id", entry.BookID), zap.Error(err))
+			continue
+		}
+		if err := s.DeleteJournalEntry(entry.ID); err != nil {
+			log.Error("Failed to delete journal entry", zap.Int("id", entry.ID), zap.Error(err))
+			continue
+		}
+		log.Info("Journal entry recovered", zap.Int("id", entry.ID), zap.String("op", entry.Op), zap.Int("book_id", entry.BookID))
+	}
+}
+
+// recoverBookCreation completes the creation of a book saved in the
+// metadata database, the user only sees it once it is linked to them. A
+// book that wasn't saved, or whose file is gone, is undone.
+func recoverBookCreation(s *store.Store, entry *model.JournalEntry) error {
+	bookID := entry.BookID
+	if bookID == 0 {
+		// The book may have been saved right before the journal knew it
+		bookID, _ = s.GetBookIDByPath(entry.Payload.Path)
+	}
+	if bookID == 0 || !s.CheckBook(bookID) {
+		// The job left is resumed with its files, the directory of an
+		// archive item is removed
+		if entry.Payload.Dir != "" {
+			return os.RemoveAll(entry.Payload.Dir)
+		}
+		return nil
+	}
+	book, err := s.GetBook(&model.FindBook{BookID: &bookID})
+	if err != nil {
+		return err
+	}
+	if _, err := storage.Size(context.Background(), storage.Default, book.Path); errors.Is(err, storage.ErrNotFound) {
+		log.Warn("Book file of an interrupted creation is gone, deleting the book", zap.Int("book_id", bookID), zap.String("path", book.Path))
+		return s.DeleteBookData(&model.JournalEntry{
+			Op:      model.JournalOpDeleteBook,
+			BookID:  bookID,
+			Payload: model.JournalPayload{Path: book.Path, Hashes: entry.Payload.Hashes},
+		})
+	}
+
+	for _, hash := range entry.Payload.Hashes {
+		if _, exists := s.CheckBookHash(hash); !exists {
+			if err := s.AddBookHashLink(bookID, hash); err != nil {
+				return errors.Wrap(err, "failed to link book hash")
+			}
+		}
+		saveBookBlob(s, bookID, hash, book.Path)
+	}
+
+	var job *model.Job
+	if entry.JobID != 0 {
+		if job, err = s.GetJob(entry.JobID); err != nil {
+			return errors.Wrap(err, "failed to get job")
+		}
+	}
+	if s.CheckBookUserLink(bookID, entry.UserID) {
+		bookPath, err := ApplyBookLayout(s, bookID, entry.UserID)
+		if err != nil {
+			return err
+		}
+		StoreBook(bookPath)
+		if _, err := RefreshUsage(s, entry.UserID); err != nil {
+			return err
+		}
+	} else {
+		// The metadata of the book wasn't saved by SaveBookMeta
+		meta, err := ParseBook(book.Path)
+		if err != nil {
+			return err
+		}
+		meta.Book = book
+		if job != nil {
+			meta.Tags = append(meta.Tags, job.Payload.Tags...)
+		}
+		saveBookMeta(s, meta)
+		if !s.CheckBookUserLink(bookID, entry.UserID) {
+			return errors.Errorf("failed to link book %d to user %d", bookID, entry.UserID)
+		}
+	}
+
+	// The job isn't resumed, it would take its own book for a duplicate
+	if job != nil && job.Status != model.JobStatusDone {
+		job.Payload.BookID = bookID
+		finishJob(s, job)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	epub "github.com/go-shiori/go-epub"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/model"
+)
+
+func TestRecoverJournal(t *testing.T) {
+	s := newJobTestStore(t)
+	user, err := s.CreateUser(&model.User{Username: "reader", PasswordHash: "test", Role: model.RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := int(user.ID)
+	books := filepath.Join(config.Opts.Data, strconv.Itoa(uid), "books")
+
+	// The server stopped once the book was added, before it was linked to
+	// the user and before the journal knew it
+	bookDir := filepath.Join(books, "Interrupted")
+	if err := os.MkdirAll(bookDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	b, err := epub.NewEpub("Interrupted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookFile := filepath.Join(bookDir, "Interrupted.epub")
+	if err := b.Write(bookFile); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := GenerateBookHash(bookFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddJournalEntry(&model.JournalEntry{
+		Op:      model.JournalOpCreateBook,
+		UserID:  uid,
+		Payload: model.JournalPayload{Path: bookFile, Hashes: []string{hash}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	book, err := s.AddBook(&model.Book{Title: "Interrupted", Path: bookFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An archive item was left without a book
+	itemDir := filepath.Join(books, "Unsaved")
+	if err := os.MkdirAll(itemDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddJournalEntry(&model.JournalEntry{
+		Op:      model.JournalOpCreateBook,
+		UserID:  uid,
+		Payload: model.JournalPayload{Path: filepath.Join(itemDir, "Unsaved.epub"), Dir: itemDir},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	RecoverJournal(s)
+	if !s.CheckBookUserLink(book.ID, uid) {
+		t.Error("the book should be linked to the user")
+	}
+	if bookID, exists := s.CheckBookHash(hash); !exists || bookID != book.ID {
+		t.Errorf("CheckBookHash() = %d, %v", bookID, exists)
+	}
+	if _, err := os.Stat(itemDir); !os.IsNotExist(err) {
+		t.Error("the directory of the unsaved item should be removed")
+	}
+	if entries, err := s.ListJournalEntries(); err != nil || len(entries) != 0 {
+		t.Errorf("journal = %+v, %v", entries, err)
+	}
+
+	// The server stopped while deleting the book
+	if _, err := s.AddJournalEntry(&model.JournalEntry{
+		Op:      model.JournalOpDeleteBook,
+		BookID:  book.ID,
+		Payload: model.JournalPayload{Path: bookFile, Hashes: []string{hash}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	RecoverJournal(s)
+	if s.CheckBook(book.ID) || s.CheckBookUserLink(book.ID, uid) {
+		t.Error("the book should be deleted")
+	}
+	if _, exists := s.CheckBookHash(hash); exists {
+		t.Error("the book hash should be deleted")
+	}
+	if _, err := os.Stat(bookDir); !os.IsNotExist(err) {
+		t.Error("the book directory should be deleted")
+	}
+	if entries, err := s.ListJournalEntries(); err != nil || len(entries) != 0 {
+		t.Errorf("journal = %+v, %v", entries, err)
+	}
+}
@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/storage"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/Xunop/e-oasis/internal/util"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// maxPathComponent is the length a value is cut to in a book directory
+const maxPathComponent = 100
+
+// ApplyBookLayout moves the directory of a book of the user where
+// config.Opts.BookPathTemplate wants it and returns the new path of the
+// book. Nothing is done without a template, or for books outside the
+// directory of the user.
+func ApplyBookLayout(s *store.Store, bookID, uid int) (string, error) {
+	meta, err := s.GetBookMeta(bookID)
+	if err != nil {
+		return "", err
+	}
+	if meta == nil {
+		return "", errors.Errorf("book %d not found", bookID)
+	}
+	if config.Opts.BookPathTemplate == "" {
+		return meta.Book.Path, nil
+	}
+
+	root := filepath.Join(config.Opts.Data, strconv.Itoa(uid), "books")
+	oldDir := filepath.Dir(meta.Book.Path)
+	if rel, err := filepath.Rel(root, oldDir); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return meta.Book.Path, nil
+	}
+
+	newDir := filepath.Join(root, BookDir(config.Opts.BookPathTemplate, meta))
+	if newDir == oldDir {
+		return meta.Book.Path, nil
+	}
+	newDir = util.GenerateNewDirName(newDir)
+	// The whole directory is moved, the files only in the storage too
+	if err := storage.FetchDir(context.Background(), storage.Default, oldDir); err != nil {
+		return "", errors.Wrap(err, "failed to fetch book directory")
+	}
+	if err := os.MkdirAll(filepath.Dir(newDir), os.ModePerm); err != nil {
+		return "", errors.Wrap(err, "failed to create book directory")
+	}
+	if err := os.Rename(oldDir, newDir); err != nil {
+		return "", errors.Wrap(err, "failed to move book directory")
+	}
+
+	newPath := filepath.Join(newDir, filepath.Base(meta.Book.Path))
+	if err := s.SetBookPath(bookID, newPath); err != nil {
+		os.Rename(newDir, oldDir)
+		return "", err
+	}
+
+	// Remove the parents left empty, like the directory of the former author
+	for dir := filepath.Dir(oldDir); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	log.Debug("Book moved", zap.Int("book_id", bookID), zap.String("from", oldDir), zap.String("to", newDir))
+	return newPath, nil
+}
+
+// BookDir renders a book directory template, the values are made safe to
+// use as file names.
+func BookDir(template string, meta *model.BookMeta) string {
+	author := "Unknown"
+	if authors := meta.PrimaryAuthors(); len(authors) > 0 {
+		author = authors[0].Name
+	}
+	r := strings.NewReplacer(
+		"{author}", safePathComponent(author),
+		"{title}", safePathComponent(meta.Book.Title),
+		"{id}", strconv.Itoa(meta.Book.ID),
+	)
+
+	parts := strings.Split(r.Replace(template), "/")
+	for i, part := range parts {
+		// The template itself may hold a stray "..", keep the book in place
+		if part = strings.TrimSpace(part); part == "" || part == "." || part == ".." {
+			part = "_"
+		}
+		parts[i] = part
+	}
+	return filepath.Join(parts...)
+}
+
+// safePathComponent replaces the characters that aren't allowed in a file
+// name on common file systems, like calibre does.
+func safePathComponent(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	if runes := []rune(s); len(runes) > maxPathComponent {
+		s = string(runes[:maxPathComponent])
+	}
+	// Windows doesn't allow trailing dots and spaces
+	s = strings.TrimRight(strings.TrimSpace(s), ".")
+	if s == "" {
+		return "Unknown"
+	}
+	return s
+}
@@ -11,93 +11,163 @@ import (
 	"github.com/Xunop/e-oasis/internal/log"
 	"github.com/Xunop/e-oasis/internal/model"
 	"github.com/Xunop/e-oasis/internal/util"
-	"github.com/Xunop/e-oasis/internal/util/parsers/epub"
+	"github.com/Xunop/e-oasis/internal/util/parsers"
 	"go.uber.org/zap"
+
+	// Book formats register themselves to parsers
+	_ "github.com/Xunop/e-oasis/internal/util/parsers/comic"
+	_ "github.com/Xunop/e-oasis/internal/util/parsers/epub"
+	_ "github.com/Xunop/e-oasis/internal/util/parsers/fb2"
+	_ "github.com/Xunop/e-oasis/internal/util/parsers/mobi"
+	_ "github.com/Xunop/e-oasis/internal/util/parsers/pdf"
 )
 
+// ParseBook reads the metadata and the cover of a book with the parser
+// registered for its format.
 func ParseBook(path string) (*model.BookMeta, error) {
-	bookType := filepath.Ext(path)
-	switch bookType {
-	case ".epub":
-		return parseEpub(path)
-	default:
-		return nil, fmt.Errorf("Unsupported file type: %s", bookType)
+	parser := parsers.ForPath(path)
+	if parser == nil {
+		return nil, fmt.Errorf("Unsupported file type: %s", parsers.Ext(path))
 	}
-}
 
-func parseEpub(path string) (*model.BookMeta, error) {
-	book, err := epub.Open(path)
+	meta, err := parser.Metadata(path)
 	if err != nil {
-		log.Error("Error opening epub", zap.Error(err))
+		log.Error("Error opening book", zap.String("format", parser.Name()), zap.Error(err))
 		return nil, err
 	}
-	defer book.Close()
 
-	// Get the book metadata(title, author, etc)
-	bookTitle := book.GetTitle()
-	bookAuthor := book.GetAuthor()
+	// Plenty of books carry no title at all, fall back to the file name
+	bookTitle := strings.TrimSpace(meta.Title)
+	if bookTitle == "" {
+		bookTitle = strings.TrimSuffix(filepath.Base(path), parsers.Ext(path))
+	}
+	authors := bookAuthors(meta.Creators)
+	bookPublisher := strings.TrimSpace(meta.Publisher)
+	if bookPublisher == "" {
+		bookPublisher = "Unknown"
+	}
+
 	hasCover := false
 	// Book cover always in book directory, but don't know the extension of the cover(jpg/png?)
-	bookCover, err := book.GetCover(filepath.Dir(path))
+	bookCover, err := parser.Cover(path, filepath.Dir(path))
+	if err != nil {
+		// A missing cover shouldn't fail the whole import
+		log.Warn("Error extracting book cover", zap.String("path", path), zap.Error(err))
+	}
 	if bookCover != "" && err == nil {
 		hasCover = true
 	}
 
 	// Transform the book cover to webp format
 	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		handleBookCover(bookCover)
-	}()
-
-	bookUUID := book.GetUUID()
-	bookISBN := book.GetISBN()
-	bookDate := book.GetDate()
-	bookPublisher := book.GetPublisher()
-	bookPublisher = strings.TrimSpace(bookPublisher)
-	if bookPublisher == "" {
-		bookPublisher = "Unknown"
+	if hasCover {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hasCover = handleBookCover(path, bookCover)
+		}()
 	}
-	// bookLanguage := book.GetLanguage()
 
-	log.Debug("Book parse worker:", zap.String("Book title", bookTitle), zap.String("Book author", bookAuthor))
+	// Like calibre, the author sort of the book is the one of every author
+	var names, sorts []string
+	for _, author := range authors {
+		if author.IsPrimary() {
+			names = append(names, author.Name)
+			sorts = append(sorts, author.Sort)
+		}
+	}
+	sortAuthor := strings.Join(sorts, " & ")
+
+	log.Debug("Book parse worker:", zap.String("Book title", bookTitle), zap.Strings("Book authors", names))
 
-	sortAuthor := util.AuthorSort(bookAuthor)
 	sortTitle := util.TitleSort(bookTitle)
 
-	log.Debug("Book title: %s, Book author: %s", zap.String("title", sortTitle), zap.String("author", sortAuthor))
+	var series *model.Series
+	if meta.Series != "" {
+		// Calibre numbers books without an index as the first of the series
+		index := meta.SeriesIndex
+		if index == 0 {
+			index = 1
+		}
+		series = &model.Series{Name: meta.Series, Sort: util.TitleSort(meta.Series), Index: index}
+	}
+
+	var languages []*model.Language
+	for _, lang := range meta.Languages {
+		if code := util.LanguageCode(lang); code != "" {
+			languages = append(languages, &model.Language{LangCode: code})
+		}
+	}
+
+	// Wait for the book cover to be transformed
+	wg.Wait()
 
-	// Save the book metadata
 	newBook := &model.Book{
 		Title:        bookTitle,
 		SortTitle:    sortTitle,
-		PublishDate:  bookDate,
+		PublishDate:  meta.Date,
 		AuthorSort:   sortAuthor,
-		ISBN:         bookISBN,
+		ISBN:         meta.ISBN,
 		Path:         path,
-		UUID:         bookUUID,
+		UUID:         meta.UUID,
 		HasCover:     hasCover,
 		LastModified: time.Now().String(),
 	}
+	if series != nil {
+		newBook.SeriesIndex = series.Index
+	}
 	bookMeta := &model.BookMeta{
-		Book:      newBook,
-		Publisher: &model.Publisher{Name: bookPublisher},
-		Language:  &model.Language{},
-		Author:    &model.Author{Name: bookAuthor, Sort: sortAuthor},
+		Book:        newBook,
+		Publisher:   &model.Publisher{Name: bookPublisher},
+		Languages:   languages,
+		Authors:     authors,
+		Series:      series,
+		Description: meta.Description,
+		Tags:        meta.Tags,
+		Identifiers: meta.Identifiers,
 	}
 
-	// Wait for the book cover to be transformed
-	wg.Wait()
-
-	return bookMeta, err
+	return bookMeta, nil
 }
 
-// Transform the book cover to webp format
-func handleBookCover(bookCover string) {
-	util.ImageToWebp(bookCover, 75)
+// bookAuthors turns the creators found by a parser into authors with their
+// sort names. A book always has an author, "Unknown" when none is known.
+func bookAuthors(creators []parsers.Creator) []*model.Author {
+	authors := make([]*model.Author, 0, len(creators)+1)
+	hasPrimary := false
+	for _, creator := range creators {
+		name := strings.TrimSpace(creator.Name)
+		if name == "" {
+			continue
+		}
+		sort := strings.TrimSpace(creator.Sort)
+		if sort == "" {
+			sort = util.AuthorSort(name)
+		}
+		role := strings.ToLower(strings.TrimSpace(creator.Role))
+		if role == "" {
+			role = model.AuthorRoleAuthor
+		}
+		author := &model.Author{Name: name, Sort: sort, Role: role}
+		hasPrimary = hasPrimary || author.IsPrimary()
+		authors = append(authors, author)
+	}
 
+	if !hasPrimary {
+		unknown := &model.Author{Name: "Unknown", Sort: util.AuthorSort("Unknown"), Role: model.AuthorRoleAuthor}
+		authors = append([]*model.Author{unknown}, authors...)
+	}
+	return authors
+}
+
+// Transform the book cover to webp format, in every size
+func handleBookCover(path, bookCover string) bool {
 	// Remove the original cover
-	os.Remove(bookCover)
-	return
+	defer os.Remove(bookCover)
+
+	if err := SaveCover(path, bookCover); err != nil {
+		log.Warn("Failed to convert book cover", zap.String("path", path), zap.Error(err))
+		return false
+	}
+	return true
 }
@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/Xunop/e-oasis/internal/config"
+)
+
+// PidFileName is the file of the data directory holding the pid of the
+// process using it, the server or a restore
+const PidFileName = "e-oasis.pid"
+
+func pidFilePath() string {
+	return filepath.Join(config.Opts.Data, PidFileName)
+}
+
+// LockDataDir records the process as the one using the data directory, the
+// returned function releases it. It fails while another process holds it.
+// The pid file is written aside and linked in place, of two processes
+// starting at once only one gets it and it is never seen empty.
+func LockDataDir() (func(), error) {
+	if err := os.MkdirAll(config.Opts.Data, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "failed to create data directory")
+	}
+	tmp, err := writePidFile()
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	for retried := false; ; retried = true {
+		err := os.Link(tmp, pidFilePath())
+		if err == nil {
+			return func() { os.Remove(pidFilePath()) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) || retried {
+			return nil, errors.Wrap(err, "failed to create pid file")
+		}
+
+		stale, err := os.Stat(pidFilePath())
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to check pid file")
+		}
+		if pid, running := DataDirOwner(); running {
+			return nil, errors.Errorf("e-oasis (pid %d) is using the data directory %s, remove %s if it isn't running", pid, config.Opts.Data, pidFilePath())
+		}
+		// Left by a process which didn't stop cleanly. The file another
+		// process created since is kept, creating ours fails then.
+		if current, err := os.Stat(pidFilePath()); err == nil && os.SameFile(stale, current) {
+			if err := os.Remove(pidFilePath()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, errors.Wrap(err, "failed to remove stale pid file")
+			}
+		}
+	}
+}
+
+// writePidFile writes the pid of the process to a new file of the data
+// directory
+func writePidFile() (string, error) {
+	f, err := os.CreateTemp(config.Opts.Data, PidFileName+".*")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create pid file")
+	}
+	_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", errors.Wrap(err, "failed to write pid file")
+	}
+	return f.Name(), nil
+}
+
+// DataDirOwner returns the pid of the process using the data directory. The
+// pid file left by a process which didn't stop cleanly is ignored.
+func DataDirOwner() (int, bool) {
+	buf, err := os.ReadFile(pidFilePath())
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	return pid, processAlive(pid)
+}
+
+// processAlive tells whether the process is running. A process of another
+// user can't be signaled, the pid of a server run by another user may have
+// been reused by any process since. It is taken for the server only if
+// /proc shows it runs e-oasis, or if /proc isn't there to tell.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		return false
+	}
+	cmdline, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return !errors.Is(err, fs.ErrNotExist) || !isDir("/proc")
+	}
+	return isExecutable(cmdline)
+}
+
+// isExecutable tells whether the command line, as /proc has it, runs the
+// executable of this process
+func isExecutable(cmdline []byte) bool {
+	exe, err := os.Executable()
+	if err != nil {
+		return true
+	}
+	name, _, _ := strings.Cut(string(cmdline), "\x00")
+	return filepath.Base(name) == filepath.Base(exe)
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
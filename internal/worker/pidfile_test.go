@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/Xunop/e-oasis/internal/config"
+)
+
+func TestLockDataDir(t *testing.T) {
+	data := config.Opts.Data
+	config.Opts.Data = t.TempDir()
+	t.Cleanup(func() { config.Opts.Data = data })
+
+	// The pid file of a process which is gone is taken over
+	if err := os.WriteFile(pidFilePath(), []byte(strconv.Itoa(1<<30)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := LockDataDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid, running := DataDirOwner(); !running || pid != os.Getpid() {
+		t.Fatalf("owner = %d, %v", pid, running)
+	}
+	if _, err := LockDataDir(); err == nil {
+		t.Fatal("locked the data directory twice")
+	}
+	unlock()
+
+	// Of the processes starting at once only one gets it
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	locked := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := LockDataDir(); err == nil {
+				mu.Lock()
+				locked++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if locked != 1 {
+		t.Fatalf("locked %d times", locked)
+	}
+}
+
+func TestIsExecutable(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isExecutable([]byte(exe + "\x00-test.v\x00")) {
+		t.Fatal("own command line not recognized")
+	}
+	if isExecutable([]byte("/usr/bin/sleep\x0060\x00")) {
+		t.Fatal("another command line taken for e-oasis")
+	}
+}
@@ -0,0 +1,129 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/storage"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// ErrQuotaExceeded is returned when books would take the library of a user
+// over its quota
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// CheckQuota returns ErrQuotaExceeded when adding books of size bytes to the
+// library of the user goes over its quota. The admins have no quota.
+func CheckQuota(s *store.Store, uid, books int, size int64) error {
+	userID := int32(uid)
+	user, err := s.GetUser(&model.FindUser{ID: &userID})
+	if err != nil {
+		return errors.Wrap(err, "failed to get user")
+	}
+	if user == nil || user.Role == model.RoleHost || user.Role == model.RoleAdmin {
+		return nil
+	}
+	quota, err := s.GetUserQuota(userID)
+	if err != nil {
+		return err
+	}
+	if quota.Unlimited() {
+		return nil
+	}
+
+	usage, err := s.GetUserUsage(uid)
+	if err != nil {
+		return err
+	}
+	if quota.MaxBooks > 0 && usage.Books+books > quota.MaxBooks {
+		return errors.Wrapf(ErrQuotaExceeded, "%d of %d books used", usage.Books, quota.MaxBooks)
+	}
+	if quota.MaxBytes > 0 && usage.Bytes+size > quota.MaxBytes {
+		return errors.Wrapf(ErrQuotaExceeded, "%d of %d bytes used, %d more needed", usage.Bytes, quota.MaxBytes, size)
+	}
+	return nil
+}
+
+// GetUsage returns the usage of the user along with its quota
+func GetUsage(s *store.Store, uid int) (*model.Usage, error) {
+	usage, err := s.GetUserUsage(uid)
+	if err != nil {
+		return nil, err
+	}
+	if usage.Quota, err = s.GetUserQuota(int32(uid)); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// RefreshUsage counts again the books of the user and the bytes of their
+// files, see CountUsage.
+func RefreshUsage(s *store.Store, uid int) (*model.Usage, error) {
+	usage, err := CountUsage(s, uid)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SaveUserUsage(usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// CountUsage counts the books of the user and the bytes of their files
+// without saving them. The size of a book is the one of its blob, or of its
+// file for the books out of the content-addressed store.
+func CountUsage(s *store.Store, uid int) (*model.Usage, error) {
+	books, err := s.ListBooksByUserID(uid)
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := s.ListUserBookSizes(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &model.Usage{UserID: uid, Books: len(books)}
+	for _, book := range books {
+		if size, ok := sizes[book.ID]; ok {
+			usage.Bytes += size
+		} else if size, err := storage.Size(context.Background(), storage.Default, book.Path); err == nil {
+			usage.Bytes += size
+		}
+	}
+	return usage, nil
+}
+
+// RefreshAllUsage counts again the usage of every user
+func RefreshAllUsage(s *store.Store) {
+	users, err := s.ListUsers(&model.FindUser{})
+	if err != nil {
+		log.Error("Failed to list users", zap.Error(err))
+		return
+	}
+	for _, user := range users {
+		if _, err := RefreshUsage(s, int(user.ID)); err != nil {
+			log.Error("Failed to count user usage", zap.Int32("user_id", user.ID), zap.Error(err))
+		}
+	}
+	log.Debug("User usage counted", zap.Int("users", len(users)))
+}
+
+// addUsage adds the book file at path to the usage of the user
+func addUsage(s *store.Store, uid int, path string) {
+	if err := s.AddUserUsage(uid, 1, fileSize(path)); err != nil {
+		log.Error("Failed to update user usage", zap.Int("user_id", uid), zap.Error(err))
+	}
+}
+
+// fileSize returns the size of the file at path, from the storage when it
+// isn't on the disk, 0 when it can't be read
+func fileSize(path string) int64 {
+	size, err := storage.Size(context.Background(), storage.Default, path)
+	if err != nil {
+		return 0
+	}
+	return size
+}
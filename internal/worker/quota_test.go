@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	epub "github.com/go-shiori/go-epub"
+
+	"github.com/Xunop/e-oasis/internal/model"
+)
+
+func TestQuota(t *testing.T) {
+	s := newJobTestStore(t)
+	user, err := s.CreateUser(&model.User{Username: "reader", PasswordHash: "test", Role: model.RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := s.CreateUser(&model.User{Username: "admin", PasswordHash: "test", Role: model.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := int(user.ID)
+
+	if _, err := s.UpsetQuotaSettings(&model.SystemSettingQuota{MaxBooks: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, title := range []string{"First", "Second"} {
+		b, err := epub.NewEpub(title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Write(filepath.Join(dir, title+".epub")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job, err := s.AddJob(model.Job{UserID: uid, Type: model.JobTypeArchive, Stage: model.JobStageImport, Payload: model.JobPayload{Dir: dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ImportArchive(s, *job)
+	if job, err = s.GetJob(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if job.Payload.Summary.Imported != 1 || job.Payload.Summary.Failed != 1 {
+		t.Fatalf("summary = %+v", job.Payload.Summary)
+	}
+	for _, item := range job.Payload.Items {
+		if item.Status == model.JobItemFailed && !strings.Contains(item.Error, ErrQuotaExceeded.Error()) {
+			t.Errorf("%s error = %s", item.Name, item.Error)
+		}
+	}
+
+	usage, err := s.GetUserUsage(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "First.epub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Books != 1 || usage.Bytes != info.Size() {
+		t.Errorf("usage = %+v, want 1 book of %d bytes", usage, info.Size())
+	}
+	if refreshed, err := RefreshUsage(s, uid); err != nil || refreshed.Books != usage.Books || refreshed.Bytes != usage.Bytes {
+		t.Errorf("RefreshUsage() = %+v, %v", refreshed, err)
+	}
+
+	// The quota of the user overrides the default one
+	unlimited, maxBytes := 0, usage.Bytes+10
+	if _, err := s.UpsetUserQuotaSetting(user.ID, &model.QuotaUserSetting{MaxBooks: &unlimited, MaxBytes: &maxBytes}); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckQuota(s, uid, 1, 10); err != nil {
+		t.Errorf("CheckQuota(within bytes) error = %v", err)
+	}
+	if err := CheckQuota(s, uid, 1, 11); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("CheckQuota(over bytes) error = %v", err)
+	}
+	if err := CheckQuota(s, int(admin.ID), 100, 1<<40); err != nil {
+		t.Errorf("the admins have no quota, %v", err)
+	}
+}
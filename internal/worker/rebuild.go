@@ -0,0 +1,172 @@
+package worker
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/storage"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/Xunop/e-oasis/internal/util/opf"
+	"github.com/Xunop/e-oasis/internal/util/parsers"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// RebuildIssue is a sidecar that couldn't be restored
+type RebuildIssue struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// RebuildReport sums up the rebuild of the metadata database
+type RebuildReport struct {
+	Total    int             `json:"total"`
+	Restored int             `json:"restored"`
+	Skipped  []*RebuildIssue `json:"skipped"`
+}
+
+// RebuildProgress is sent after each sidecar
+type RebuildProgress struct {
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+	Title string `json:"title"`
+}
+
+// RebuildMetaDB restores the metadata database from the metadata.opf sidecars
+// of the books in the data directory. The database has to be empty, the book
+// ids are kept so that the links of the application database stay valid.
+// progress may be nil.
+func RebuildMetaDB(ctx context.Context, s *store.Store, progress func(RebuildProgress)) (*RebuildReport, error) {
+	count, err := s.CountBooks()
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.Errorf("the metadata database holds %d books, move it away before rebuilding it", count)
+	}
+
+	// The book directories only in the storage are read too
+	if err := storage.FetchDir(ctx, storage.Default, config.Opts.Data); err != nil {
+		return nil, errors.Wrap(err, "failed to fetch the data directory")
+	}
+
+	// Sidecars are at <data>/<uid>/books/.../metadata.opf
+	var sidecars []string
+	err = filepath.WalkDir(config.Opts.Data, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != opf.FileName {
+			return nil
+		}
+		rel, err := filepath.Rel(config.Opts.Data, path)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) >= 4 && parts[1] == "books" {
+			if _, err := strconv.Atoi(parts[0]); err == nil {
+				sidecars = append(sidecars, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to look for sidecars")
+	}
+
+	report := &RebuildReport{Total: len(sidecars), Skipped: []*RebuildIssue{}}
+	log.Info("Rebuilding metadata database", zap.String("data", config.Opts.Data), zap.Int("sidecars", len(sidecars)))
+
+	for i, sidecar := range sidecars {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		title, reason, err := restoreBook(s, sidecar)
+		if err != nil {
+			return report, errors.Wrapf(err, "failed to restore %s", sidecar)
+		}
+		if reason != "" {
+			log.Warn("Book not restored", zap.String("sidecar", sidecar), zap.String("reason", reason))
+			report.Skipped = append(report.Skipped, &RebuildIssue{Path: sidecar, Reason: reason})
+		} else {
+			report.Restored++
+		}
+
+		if progress != nil {
+			progress(RebuildProgress{Done: i + 1, Total: len(sidecars), Title: title})
+		}
+	}
+
+	RefreshAllUsage(s)
+	log.Info("Metadata database rebuilt", zap.Int("restored", report.Restored), zap.Int("skipped", len(report.Skipped)))
+	return report, nil
+}
+
+// restoreBook saves the book of a sidecar, it returns why when the book
+// can't be restored.
+func restoreBook(s *store.Store, sidecar string) (title, reason string, err error) {
+	dir := filepath.Dir(sidecar)
+	rel, _ := filepath.Rel(config.Opts.Data, sidecar)
+	uid, _ := strconv.Atoi(strings.Split(filepath.ToSlash(rel), "/")[0])
+
+	meta, err := opf.ReadFile(dir)
+	if err != nil {
+		return "", "invalid sidecar: " + err.Error(), nil
+	}
+	title = meta.Book.Title
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return title, "", errors.Wrap(err, "failed to read book directory")
+	}
+	var bookPath string
+	var parser parsers.BookParser
+	for _, entry := range entries {
+		if p := parsers.ForPath(entry.Name()); p != nil && entry.Type().IsRegular() {
+			bookPath, parser = filepath.Join(dir, entry.Name()), p
+			break
+		}
+	}
+	if parser == nil {
+		return title, "no book file", nil
+	}
+
+	hash, err := parser.Hash(bookPath)
+	if err != nil {
+		return title, "failed to hash the book: " + err.Error(), nil
+	}
+	// The application database knows the id of the book from its hash
+	bookID, hashLinked := s.CheckBookHash(hash)
+	if hashLinked {
+		if s.CheckBook(bookID) {
+			return title, "duplicate of book " + strconv.Itoa(bookID), nil
+		}
+		meta.Book.ID = bookID
+	} else if meta.Book.ID != 0 && s.CheckBook(meta.Book.ID) {
+		meta.Book.ID = 0
+	}
+
+	meta.Book.Path = bookPath
+	_, err = os.Stat(filepath.Join(dir, "cover.webp"))
+	meta.Book.HasCover = err == nil
+
+	book, err := s.SaveImportedBook(meta, uid, nil)
+	if err != nil {
+		return title, "", err
+	}
+	if !hashLinked {
+		if err := s.AddBookHashLink(book.ID, hash); err != nil {
+			return title, "", err
+		}
+	}
+	saveBookBlob(s, book.ID, hash, bookPath)
+	return title, "", nil
+}
@@ -0,0 +1,114 @@
+package worker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/Xunop/e-oasis/internal/util/opf"
+)
+
+func TestBookDir(t *testing.T) {
+	meta := &model.BookMeta{
+		Book:    &model.Book{ID: 7, Title: `What? A "Title": Part 1/2.`},
+		Authors: []*model.Author{{Name: "An Editor", Role: model.AuthorRoleEditor}, {Name: "Jane Doe", Role: model.AuthorRoleAuthor}},
+	}
+	if got, want := BookDir("{author}/{title} ({id})", meta), filepath.Join("Jane Doe", "What_ A _Title__ Part 1_2 (7)"); got != want {
+		t.Errorf("BookDir() = %q, want %q", got, want)
+	}
+	if got := BookDir("../{title}", meta); got != filepath.Join("_", "What_ A _Title__ Part 1_2") {
+		t.Errorf("BookDir() = %q", got)
+	}
+}
+
+func TestRebuildMetaDB(t *testing.T) {
+	dir := t.TempDir()
+	config.Opts.Data = dir
+	config.Opts.DSN = filepath.Join(dir, "e-oasis.db")
+	config.Opts.MetaDSN = filepath.Join(dir, "metadata.db")
+	config.Opts.BookPathTemplate = "{author}/{title} ({id})"
+	defer func() { config.Opts.BookPathTemplate = "" }()
+
+	systemDb := openTestDB(t, config.Opts.DSN, "system")
+	s := store.NewStore(systemDb.DB, openTestDB(t, config.Opts.MetaDSN, "meta").DB)
+	user, err := s.CreateUser(&model.User{Username: "test", PasswordHash: "test", Role: model.RoleUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := int(user.ID)
+
+	bookPath := filepath.Join(dir, "1", "books", "upload", "upload.epub")
+	writeTestZip(t, bookPath)
+	book, err := s.SaveImportedBook(&model.BookMeta{
+		Book:      &model.Book{Title: "The Book", Path: bookPath, UUID: "uuid"},
+		Authors:   []*model.Author{{Name: "Jane Doe", Sort: "Doe, Jane", Role: model.AuthorRoleAuthor}, {Name: "A Translator", Sort: "Translator, A", Role: model.AuthorRoleTranslator}},
+		Tags:      []string{"Fiction"},
+		Series:    &model.Series{Name: "The Series", Index: 3},
+		Languages: []*model.Language{{LangCode: "eng"}},
+		Rating:    6,
+	}, uid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := GenerateBookHash(bookPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddBookHashLink(book.ID, hash); err != nil {
+		t.Fatal(err)
+	}
+
+	newPath, err := ApplyBookLayout(s, book.ID, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "1", "books", "Jane Doe", "The Book (1)", "upload.epub"); newPath != want {
+		t.Fatalf("path = %q, want %q", newPath, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1", "books", "upload")); !os.IsNotExist(err) {
+		t.Error("the former directory should be gone")
+	}
+	sidecar, err := opf.ReadFile(filepath.Dir(newPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sidecar.Book.ID != book.ID || sidecar.Book.Title != "The Book" || len(sidecar.Authors) != 2 {
+		t.Errorf("sidecar = %+v", sidecar)
+	}
+
+	// Lose the metadata database
+	config.Opts.MetaDSN = filepath.Join(dir, "new-metadata.db")
+	s = store.NewStore(systemDb.DB, openTestDB(t, config.Opts.MetaDSN, "meta").DB)
+	report, err := RebuildMetaDB(context.Background(), s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 1 || report.Restored != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	meta, err := s.GetBookMeta(book.ID)
+	if err != nil || meta == nil {
+		t.Fatalf("book %d not restored: %v", book.ID, err)
+	}
+	if meta.Book.Path != newPath || meta.Book.UUID != sidecar.Book.UUID || meta.Rating != 6 {
+		t.Errorf("book = %+v", meta.Book)
+	}
+	if len(meta.Authors) != 2 || meta.Authors[1].Role != model.AuthorRoleTranslator {
+		t.Errorf("authors = %+v", meta.Authors)
+	}
+	if meta.Series == nil || meta.Series.Name != "The Series" || meta.Book.SeriesIndex != 3 {
+		t.Errorf("series = %+v", meta.Series)
+	}
+	if len(meta.Tags) != 1 || len(meta.Languages) != 1 || meta.Languages[0].LangCode != "eng" {
+		t.Errorf("tags = %v, languages = %v", meta.Tags, meta.Languages)
+	}
+
+	if _, err := RebuildMetaDB(context.Background(), s, nil); err == nil {
+		t.Error("rebuilding a database that isn't empty should fail")
+	}
+}
@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"sync"
+
+	"github.com/Xunop/e-oasis/internal/model"
+)
+
+// JobResult is the outcome of a job, the metadata of the book saved or why
+// the job failed.
+type JobResult struct {
+	Meta *model.BookMeta
+	Err  error
+}
+
+// JobResults hands the outcome of a job to the request waiting for it. The
+// jobs are keyed by ID so that a request only ever gets the outcome of its
+// own job, and nothing blocks when nobody waits.
+type JobResults struct {
+	mu      sync.Mutex
+	waiting map[int]chan JobResult
+}
+
+// Results are the outcomes of the jobs run by the workers
+var Results = NewJobResults()
+
+func NewJobResults() *JobResults {
+	return &JobResults{waiting: make(map[int]chan JobResult)}
+}
+
+// Wait registers a wait for the outcome of a job, it must be called before
+// the job is queued. The returned function ends the wait.
+func (r *JobResults) Wait(jobID int) (<-chan JobResult, func()) {
+	c := make(chan JobResult, 1)
+	r.mu.Lock()
+	r.waiting[jobID] = c
+	r.mu.Unlock()
+
+	return c, func() {
+		r.mu.Lock()
+		if r.waiting[jobID] == c {
+			delete(r.waiting, jobID)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// deliver sends the outcome of a job to the request waiting for it, if any
+func (r *JobResults) deliver(jobID int, result JobResult) {
+	r.mu.Lock()
+	c, ok := r.waiting[jobID]
+	delete(r.waiting, jobID)
+	r.mu.Unlock()
+
+	if ok {
+		c <- result
+	}
+}
@@ -0,0 +1,34 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Xunop/e-oasis/internal/model"
+)
+
+func TestJobResults(t *testing.T) {
+	results := NewJobResults()
+	first, stopFirst := results.Wait(1)
+	defer stopFirst()
+	second, stopSecond := results.Wait(2)
+
+	// Nobody waits for the job, delivering doesn't block
+	results.deliver(3, JobResult{Err: errors.New("failed")})
+
+	results.deliver(2, JobResult{Meta: &model.BookMeta{Book: &model.Book{ID: 2}}})
+	results.deliver(1, JobResult{Err: errors.New("failed")})
+	if result := <-first; result.Err == nil {
+		t.Errorf("first = %+v", result)
+	}
+	if result := <-second; result.Err != nil || result.Meta.Book.ID != 2 {
+		t.Errorf("second = %+v", result)
+	}
+
+	// A request that gave up gets nothing
+	stopSecond()
+	results.deliver(2, JobResult{Err: errors.New("late")})
+	if len(results.waiting) != 0 {
+		t.Errorf("%d waits left", len(results.waiting))
+	}
+}
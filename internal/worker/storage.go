@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/storage"
+	"go.uber.org/zap"
+)
+
+// StoreBook puts the directory of the book at path, the book file, its
+// covers and its sidecar, into the storage. The directory stays in the data
+// directory as a cache of the storage.
+func StoreBook(path string) {
+	if err := storage.PutDir(context.Background(), storage.Default, filepath.Dir(path)); err != nil {
+		log.Error("Failed to store book", zap.String("path", path), zap.Error(err))
+	}
+}
+
+// MoveStoredBook stores the book moved from oldPath to newPath under its new
+// keys, the former ones are deleted.
+func MoveStoredBook(oldPath, newPath string) {
+	if filepath.Dir(oldPath) == filepath.Dir(newPath) {
+		return
+	}
+	StoreBook(newPath)
+	if err := storage.DeleteDir(context.Background(), storage.Default, filepath.Dir(oldPath)); err != nil {
+		log.Error("Failed to delete stored book", zap.String("path", oldPath), zap.Error(err))
+	}
+}
+
+// storeCovers puts the covers of the book at bookPath into the storage
+func storeCovers(bookPath string, sizes ...string) {
+	for _, size := range sizes {
+		if err := storage.PutFile(context.Background(), storage.Default, CoverPath(bookPath, size)); err != nil {
+			log.Error("Failed to store cover", zap.String("path", bookPath), zap.String("size", size), zap.Error(err))
+		}
+	}
+}
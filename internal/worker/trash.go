@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+)
+
+// trashPurgeInterval is how often the books past the retention of the trash
+// are purged
+const trashPurgeInterval = time.Hour
+
+// PurgeBook removes the book from the trash of the user for good. The book
+// stays in the libraries of its other owners, its files are deleted along
+// with the last one.
+func PurgeBook(s *store.Store, bookID, userID int) error {
+	trashed := true
+	if err := s.RemoveBook(&model.FindBook{BookID: &bookID, UserID: &userID, Trashed: &trashed}); err != nil {
+		return err
+	}
+	s.BookCache.Delete(bookID)
+	if _, err := RefreshUsage(s, userID); err != nil {
+		log.Error("Failed to count user usage", zap.Int("user_id", userID), zap.Error(err))
+	}
+	return nil
+}
+
+// PurgeTrash purges the books of the trash matching find and returns how
+// many were purged
+func PurgeTrash(s *store.Store, find *model.FindTrashedBook) (int, error) {
+	list, err := s.ListTrashedBooks(find)
+	if err != nil {
+		return 0, err
+	}
+	for i, trashed := range list {
+		if err := PurgeBook(s, trashed.BookID, trashed.UserID); err != nil {
+			return i, err
+		}
+	}
+	return len(list), nil
+}
+
+// PurgeExpiredTrash purges the books kept in the trash longer than its
+// retention
+func PurgeExpiredTrash(s *store.Store, now time.Time) (int, error) {
+	settings, err := s.GetTrashSetting()
+	if err != nil {
+		return 0, err
+	}
+	if settings.RetentionDays <= 0 {
+		return 0, nil
+	}
+	before := now.AddDate(0, 0, -settings.RetentionDays).Unix()
+	return PurgeTrash(s, &model.FindTrashedBook{TrashedBefore: &before})
+}
+
+// StartTrashPurge purges the expired books of the trash now and every
+// trashPurgeInterval until ctx is done
+func StartTrashPurge(ctx context.Context, s *store.Store) {
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := PurgeExpiredTrash(s, time.Now()); err != nil {
+				log.Error("Failed to purge the trash", zap.Error(err))
+			} else if n > 0 {
+				log.Info("Trash purged", zap.Int("books", n))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// setPurgeTs sets when the books of the trash are purged
+func setPurgeTs(s *store.Store, list []*model.TrashedBook) error {
+	settings, err := s.GetTrashSetting()
+	if err != nil {
+		return err
+	}
+	if settings.RetentionDays <= 0 {
+		return nil
+	}
+	for _, trashed := range list {
+		trashed.PurgeTs = time.Unix(trashed.TrashedTs, 0).AddDate(0, 0, settings.RetentionDays).Unix()
+	}
+	return nil
+}
+
+// ListTrash returns the books of the trash matching find, along with their
+// book and when they are purged
+func ListTrash(s *store.Store, find *model.FindTrashedBook) ([]*model.TrashedBook, error) {
+	list, err := s.ListTrashedBooks(find)
+	if err != nil {
+		return nil, err
+	}
+	if err := setPurgeTs(s, list); err != nil {
+		return nil, err
+	}
+	for _, trashed := range list {
+		if trashed.Book, err = s.GetBook(&model.FindBook{BookID: &trashed.BookID}); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,129 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	epub "github.com/go-shiori/go-epub"
+
+	"github.com/Xunop/e-oasis/internal/model"
+)
+
+func TestTrash(t *testing.T) {
+	s := newJobTestStore(t)
+	var uids []int
+	for _, name := range []string{"alice", "bob"} {
+		user, err := s.CreateUser(&model.User{Username: name, PasswordHash: "test", Role: model.RoleUser})
+		if err != nil {
+			t.Fatal(err)
+		}
+		uids = append(uids, int(user.ID))
+	}
+
+	dir := t.TempDir()
+	b, err := epub.NewEpub("Trashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Write(filepath.Join(dir, "Trashed.epub")); err != nil {
+		t.Fatal(err)
+	}
+	var bookID int
+	for _, uid := range uids {
+		job, err := s.AddJob(model.Job{UserID: uid, Type: model.JobTypeArchive, Stage: model.JobStageImport, Payload: model.JobPayload{Dir: dir}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ImportArchive(s, *job)
+		if job, err = s.GetJob(job.ID); err != nil || len(job.Payload.Items) != 1 {
+			t.Fatalf("job = %+v, %v", job, err)
+		}
+		bookID = job.Payload.Items[0].BookID
+	}
+	book, err := s.GetBook(&model.FindBook{BookID: &bookID})
+	if err != nil || book == nil {
+		t.Fatalf("book = %+v, %v", book, err)
+	}
+
+	visible := func(uid *int) bool {
+		trashed := false
+		list, err := s.ListBooks(&model.FindBook{BookID: &bookID, UserID: uid, Trashed: &trashed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(list) > 0
+	}
+
+	// Alice trashes the book, bob still sees it
+	if n, err := s.TrashBook(bookID, &uids[0]); err != nil || n != 1 {
+		t.Fatalf("TrashBook() = %d, %v", n, err)
+	}
+	if visible(&uids[0]) || !visible(&uids[1]) || !visible(nil) {
+		t.Error("the book should only be hidden from alice")
+	}
+	for i, want := range []bool{true, false} {
+		if trashed, err := s.IsBookTrashed(bookID, &uids[i]); err != nil || trashed != want {
+			t.Errorf("IsBookTrashed(user %d) = %v, %v, want %v", uids[i], trashed, err, want)
+		}
+	}
+	if trashed, err := s.IsBookTrashed(bookID, nil); err != nil || trashed {
+		t.Errorf("IsBookTrashed() = %v, %v, want false", trashed, err)
+	}
+	notTrashed := false
+	all, err := s.ListBooks(&model.FindBook{Trashed: &notTrashed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{len(all) - 1, len(all)} {
+		if list, err := s.HideTrashedBooks(all, uids[i]); err != nil || len(list) != want {
+			t.Errorf("HideTrashedBooks(user %d) = %d books, %v, want %d", uids[i], len(list), err, want)
+		}
+	}
+
+	// Once bob trashes it too, it is hidden from everyone
+	if _, err := s.TrashBook(bookID, &uids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if visible(&uids[1]) || visible(nil) {
+		t.Error("the book should be hidden")
+	}
+	if trashed, err := s.IsBookTrashed(bookID, nil); err != nil || !trashed {
+		t.Errorf("IsBookTrashed() = %v, %v", trashed, err)
+	}
+	if list, err := ListTrash(s, &model.FindTrashedBook{UserID: &uids[0]}); err != nil || len(list) != 1 || list[0].Book == nil || list[0].PurgeTs == 0 {
+		t.Errorf("ListTrash() = %+v, %v", list, err)
+	}
+
+	// Alice gets it back by importing it again
+	if shared, err := shareBook(s, bookID, uids[0]); err != nil || !shared || !visible(&uids[0]) {
+		t.Errorf("shareBook() = %v, %v", shared, err)
+	}
+	if n, err := s.RestoreBook(bookID, &uids[1]); err != nil || n != 1 || !visible(&uids[1]) {
+		t.Errorf("RestoreBook() = %d, %v", n, err)
+	}
+
+	// The retention purges the trashed books
+	if _, err := s.TrashBook(bookID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := PurgeExpiredTrash(s, time.Now()); err != nil || n != 0 {
+		t.Errorf("PurgeExpiredTrash(now) = %d, %v", n, err)
+	}
+	later := time.Now().AddDate(0, 0, model.DefaultTrashRetentionDays+1)
+	if n, err := PurgeExpiredTrash(s, later); err != nil || n != 2 {
+		t.Errorf("PurgeExpiredTrash(later) = %d, %v", n, err)
+	}
+	if s.CheckBook(bookID) {
+		t.Error("the book should be deleted")
+	}
+	if _, err := os.Stat(filepath.Dir(book.Path)); !os.IsNotExist(err) {
+		t.Error("the book directory should be deleted")
+	}
+	for _, uid := range uids {
+		if usage, err := s.GetUserUsage(uid); err != nil || usage.Books != 0 {
+			t.Errorf("usage = %+v, %v", usage, err)
+		}
+	}
+}
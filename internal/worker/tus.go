@@ -0,0 +1,371 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/util/parsers"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// TusVersion is the version of the tus resumable upload protocol served
+const TusVersion = "1.0.0"
+
+// TusChecksumAlgorithms are the algorithms of the tus checksum extension
+const TusChecksumAlgorithms = "md5,sha1,sha256"
+
+// UploadExpiration is how long a resumable upload is kept after its last
+// chunk, the sweeper removes it after that.
+const UploadExpiration = 24 * time.Hour
+
+// uploadSweepInterval is how often the expired uploads are removed
+const uploadSweepInterval = time.Hour
+
+var (
+	// ErrUploadNotFound is returned for an unknown upload
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrUploadExpired is returned for an upload past its expiration
+	ErrUploadExpired = errors.New("upload expired")
+	// ErrUploadOffset is returned when a chunk doesn't start at the offset
+	ErrUploadOffset = errors.New("upload offset mismatch")
+	// ErrUploadLocked is returned when a chunk of the upload is being written
+	ErrUploadLocked = errors.New("upload is being written")
+	// ErrChecksumMismatch is returned when a chunk doesn't match its checksum
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrChecksumAlgorithm is returned for an unsupported checksum algorithm
+	ErrChecksumAlgorithm = errors.New("unsupported checksum algorithm")
+)
+
+var uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// uploadLocks keeps two chunks of an upload from being written at once
+var uploadLocks sync.Map // upload ID -> *sync.Mutex
+
+// TusUpload is a resumable upload. The partial file and the info of the
+// upload are kept in the tmp directory of the user, the offset is the size
+// of the partial file so that a chunk cut short still counts.
+type TusUpload struct {
+	ID     string `json:"id"`
+	UserID int    `json:"user_id"`
+	// Length is the size of the whole file
+	Length int64 `json:"length"`
+	// Offset is how much of the file was received
+	Offset int64 `json:"-"`
+	// Metadata is the Upload-Metadata of the creation request
+	Metadata  map[string]string `json:"metadata"`
+	FileName  string            `json:"file_name"`
+	CreatedTs int64             `json:"created_ts"`
+	ExpiresTs int64             `json:"expires_ts"`
+}
+
+func uploadDir(uid int) string {
+	return filepath.Join(config.Opts.Data, strconv.Itoa(uid), "tmp")
+}
+
+// File is the partial file of the upload
+func (u *TusUpload) File() string {
+	return filepath.Join(uploadDir(u.UserID), "tus-"+u.ID+".part")
+}
+
+func (u *TusUpload) infoFile() string {
+	return filepath.Join(uploadDir(u.UserID), "tus-"+u.ID+".info")
+}
+
+// Expires returns when the upload expires
+func (u *TusUpload) Expires() time.Time {
+	return time.Unix(u.ExpiresTs, 0)
+}
+
+// Complete reports whether the whole file was received
+func (u *TusUpload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// CreateTusUpload starts a resumable upload of length bytes for a user. The
+// file name is the filename or name of the metadata, it must be a supported
+// book.
+func CreateTusUpload(uid int, length int64, metadata map[string]string) (*TusUpload, error) {
+	name := metadata["filename"]
+	if name == "" {
+		name = metadata["name"]
+	}
+	name = filepath.Base(name)
+	if ext := parsers.Ext(name); parsers.ForPath(name) == nil || !config.CheckSupportedTypes(ext) {
+		return nil, errors.Wrap(ErrUnsupportedType, ext)
+	}
+	if limit := UploadLimit(); limit > 0 && length > limit {
+		return nil, errors.Wrapf(ErrUploadTooLarge, "%s is over %d bytes", name, limit)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.Wrap(err, "failed to generate upload ID")
+	}
+	now := time.Now()
+	upload := &TusUpload{
+		ID:        hex.EncodeToString(id),
+		UserID:    uid,
+		Length:    length,
+		Metadata:  metadata,
+		FileName:  name,
+		CreatedTs: now.Unix(),
+		ExpiresTs: now.Add(UploadExpiration).Unix(),
+	}
+
+	if err := os.MkdirAll(uploadDir(uid), os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "failed to create tmp directory")
+	}
+	file, err := os.Create(upload.File())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create upload")
+	}
+	file.Close()
+	if err := upload.save(); err != nil {
+		os.Remove(upload.File())
+		return nil, err
+	}
+	return upload, nil
+}
+
+// GetTusUpload returns the upload id of a user, an expired upload is
+// removed.
+func GetTusUpload(uid int, id string) (*TusUpload, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return nil, ErrUploadNotFound
+	}
+	upload := &TusUpload{ID: id, UserID: uid}
+	data, err := os.ReadFile(upload.infoFile())
+	if os.IsNotExist(err) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read upload")
+	}
+	if err := json.Unmarshal(data, upload); err != nil {
+		return nil, errors.Wrap(err, "failed to read upload")
+	}
+	// The info is only read from the directory of the user
+	upload.ID, upload.UserID = id, uid
+
+	if time.Now().After(upload.Expires()) {
+		upload.remove()
+		return nil, ErrUploadExpired
+	}
+	info, err := os.Stat(upload.File())
+	if err != nil {
+		upload.remove()
+		return nil, ErrUploadNotFound
+	}
+	upload.Offset = info.Size()
+	return upload, nil
+}
+
+// WriteChunk appends the chunk read from r at offset, which must be the
+// offset of the upload. With a checksum, "<algorithm> <base64 digest>", a
+// chunk that doesn't match is dropped. Without one, what was received of a
+// chunk cut short is kept and the client resumes from there.
+func (u *TusUpload) WriteChunk(offset int64, r io.Reader, checksum string) error {
+	lock, _ := uploadLocks.LoadOrStore(u.ID, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		return ErrUploadLocked
+	}
+	defer lock.(*sync.Mutex).Unlock()
+
+	// Another chunk may have been written since the upload was read
+	info, err := os.Stat(u.File())
+	if err != nil {
+		return ErrUploadNotFound
+	}
+	u.Offset = info.Size()
+	if offset != u.Offset {
+		return errors.Wrapf(ErrUploadOffset, "offset is %d", u.Offset)
+	}
+
+	var sum hash.Hash
+	var expected []byte
+	if checksum != "" {
+		if sum, expected, err = parseChecksum(checksum); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(u.File(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to open upload")
+	}
+	var w io.Writer = file
+	if sum != nil {
+		w = io.MultiWriter(file, sum)
+	}
+	n, copyErr := io.Copy(w, io.LimitReader(r, u.Length-u.Offset))
+	if copyErr == nil && sum != nil && !bytes.Equal(sum.Sum(nil), expected) {
+		copyErr = ErrChecksumMismatch
+	}
+	if copyErr != nil && sum != nil {
+		// A chunk can't be verified in part
+		file.Truncate(offset)
+		n = 0
+	}
+	if err := file.Close(); err != nil && copyErr == nil {
+		copyErr = errors.Wrap(err, "failed to write upload")
+	}
+
+	u.Offset = offset + n
+	u.ExpiresTs = time.Now().Add(UploadExpiration).Unix()
+	if err := u.save(); err != nil {
+		return err
+	}
+	if copyErr != nil && !errors.Is(copyErr, ErrChecksumMismatch) {
+		return errors.Wrap(copyErr, "failed to write upload")
+	}
+	return copyErr
+}
+
+// Stage hands the completed upload over to the job pipeline, the type of
+// the file is checked and the upload is forgotten. The returned payload
+// describes the staged file.
+func (u *TusUpload) Stage() (*model.JobPayload, error) {
+	file, err := os.Open(u.File())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open upload")
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, errors.Wrap(err, "failed to read upload")
+	}
+	head = head[:n]
+	if parser := parsers.ForPath(u.FileName); parser == nil || !parser.Detect(head) {
+		u.remove()
+		return nil, errors.Wrapf(ErrUnsupportedType, "%s isn't a supported book", u.FileName)
+	}
+
+	sum := sha256.New()
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, "failed to read upload")
+	}
+	if _, err := io.Copy(sum, file); err != nil {
+		return nil, errors.Wrap(err, "failed to read upload")
+	}
+	file.Close()
+
+	// The file now belongs to its job, the sweeper leaves it alone
+	staged := filepath.Join(uploadDir(u.UserID), "upload-"+u.ID+parsers.Ext(u.FileName))
+	if err := os.Rename(u.File(), staged); err != nil {
+		return nil, errors.Wrap(err, "failed to stage upload")
+	}
+	os.Remove(u.infoFile())
+	uploadLocks.Delete(u.ID)
+
+	return &model.JobPayload{
+		File:     staged,
+		FileName: u.FileName,
+		Size:     u.Length,
+		SHA256:   hex.EncodeToString(sum.Sum(nil)),
+		MIMEType: http.DetectContentType(head),
+	}, nil
+}
+
+func (u *TusUpload) save() error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return errors.Wrap(err, "failed to save upload")
+	}
+	tmp := u.infoFile() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return errors.Wrap(err, "failed to save upload")
+	}
+	return errors.Wrap(os.Rename(tmp, u.infoFile()), "failed to save upload")
+}
+
+func (u *TusUpload) remove() {
+	os.Remove(u.File())
+	os.Remove(u.infoFile())
+	uploadLocks.Delete(u.ID)
+}
+
+// parseChecksum parses an Upload-Checksum header
+func parseChecksum(checksum string) (hash.Hash, []byte, error) {
+	algorithm, digest, ok := strings.Cut(checksum, " ")
+	if !ok {
+		return nil, nil, errors.Errorf("invalid checksum: %s", checksum)
+	}
+	expected, err := base64.StdEncoding.DecodeString(digest)
+	if err != nil {
+		return nil, nil, errors.Errorf("invalid checksum: %s", checksum)
+	}
+	switch algorithm {
+	case "md5":
+		return md5.New(), expected, nil
+	case "sha1":
+		return sha1.New(), expected, nil
+	case "sha256":
+		return sha256.New(), expected, nil
+	}
+	return nil, nil, errors.Wrap(ErrChecksumAlgorithm, algorithm)
+}
+
+// SweepUploads removes the resumable uploads past their expiration, along
+// with the partial files whose info is gone.
+func SweepUploads() {
+	infos, err := filepath.Glob(filepath.Join(config.Opts.Data, "*", "tmp", "tus-*.info"))
+	if err != nil {
+		log.Error("Failed to list uploads", zap.Error(err))
+		return
+	}
+	for _, info := range infos {
+		uid, err := strconv.Atoi(filepath.Base(filepath.Dir(filepath.Dir(info))))
+		if err != nil {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(info), "tus-"), ".info")
+		if _, err := GetTusUpload(uid, id); errors.Is(err, ErrUploadExpired) || errors.Is(err, ErrUploadNotFound) {
+			log.Info("Removed expired upload", zap.Int("user_id", uid), zap.String("upload_id", id))
+		}
+	}
+
+	parts, err := filepath.Glob(filepath.Join(config.Opts.Data, "*", "tmp", "tus-*.part"))
+	if err != nil {
+		log.Error("Failed to list uploads", zap.Error(err))
+		return
+	}
+	for _, part := range parts {
+		info := strings.TrimSuffix(part, ".part") + ".info"
+		if _, err := os.Stat(info); !os.IsNotExist(err) {
+			continue
+		}
+		if stat, err := os.Stat(part); err == nil && time.Since(stat.ModTime()) > UploadExpiration {
+			os.Remove(part)
+		}
+	}
+}
+
+// sweepUploads runs SweepUploads every uploadSweepInterval
+func sweepUploads() {
+	for {
+		SweepUploads()
+		time.Sleep(uploadSweepInterval)
+	}
+}
@@ -0,0 +1,132 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	epub "github.com/go-shiori/go-epub"
+
+	"github.com/Xunop/e-oasis/internal/config"
+)
+
+// brokenReader returns the data then fails, like a dropped connection
+type brokenReader struct {
+	data []byte
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestTusUpload(t *testing.T) {
+	dir := t.TempDir()
+	config.Opts.Data = dir
+
+	src := filepath.Join(dir, "Resumable.epub")
+	book, err := epub.NewEpub("Resumable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := book.Write(src); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreateTusUpload(1, 10, map[string]string{"filename": "book.exe"}); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("CreateTusUpload(book.exe) error = %v", err)
+	}
+	upload, err := CreateTusUpload(1, int64(len(data)), map[string]string{"filename": "Resumable.epub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetTusUpload(2, upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("another user got the upload, %v", err)
+	}
+
+	// The connection drops in the middle of the first chunk
+	half := len(data) / 2
+	if err := upload.WriteChunk(0, &brokenReader{data: data[:100]}, ""); err == nil {
+		t.Error("an interrupted chunk should fail")
+	}
+	upload, err = GetTusUpload(1, upload.ID)
+	if err != nil || upload.Offset != 100 {
+		t.Fatalf("upload = %+v, %v", upload, err)
+	}
+	if err := upload.WriteChunk(0, bytes.NewReader(data), ""); !errors.Is(err, ErrUploadOffset) {
+		t.Errorf("WriteChunk(0) error = %v, want %v", err, ErrUploadOffset)
+	}
+
+	// A chunk not matching its checksum is dropped
+	if err := upload.WriteChunk(100, bytes.NewReader(data[100:half]), "sha1 "+base64.StdEncoding.EncodeToString([]byte("wrong"))); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("WriteChunk() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+	if upload.Offset != 100 {
+		t.Errorf("offset = %d after a checksum mismatch", upload.Offset)
+	}
+	sum := sha1.Sum(data[100:half])
+	if err := upload.WriteChunk(100, bytes.NewReader(data[100:half]), "sha1 "+base64.StdEncoding.EncodeToString(sum[:])); err != nil {
+		t.Fatal(err)
+	}
+	if err := upload.WriteChunk(int64(half), bytes.NewReader(data[half:]), ""); err != nil {
+		t.Fatal(err)
+	}
+	if !upload.Complete() {
+		t.Fatalf("upload = %+v", upload)
+	}
+
+	payload, err := upload.Stage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staged, err := os.ReadFile(payload.File); err != nil || !bytes.Equal(staged, data) {
+		t.Errorf("staged file differs, %v", err)
+	}
+	if payload.FileName != "Resumable.epub" || payload.Size != int64(len(data)) {
+		t.Errorf("payload = %+v", payload)
+	}
+	if _, err := GetTusUpload(1, upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("a staged upload should be forgotten, %v", err)
+	}
+}
+
+func TestSweepUploads(t *testing.T) {
+	dir := t.TempDir()
+	config.Opts.Data = dir
+
+	expired, err := CreateTusUpload(1, 10, map[string]string{"filename": "Expired.epub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired.ExpiresTs = time.Now().Add(-time.Minute).Unix()
+	if err := expired.save(); err != nil {
+		t.Fatal(err)
+	}
+	active, err := CreateTusUpload(1, 10, map[string]string{"filename": "Active.epub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SweepUploads()
+
+	if _, err := os.Stat(expired.File()); !os.IsNotExist(err) {
+		t.Error("the expired upload should be removed")
+	}
+	if _, err := GetTusUpload(1, active.ID); err != nil {
+		t.Errorf("the active upload should be kept, %v", err)
+	}
+}
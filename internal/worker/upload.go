@@ -0,0 +1,130 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/Xunop/e-oasis/internal/util"
+	"github.com/Xunop/e-oasis/internal/util/parsers"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// sniffLen is how much of an upload is read to tell its type, as much as
+// http.DetectContentType looks at.
+const sniffLen = 512
+
+var (
+	// ErrUploadTooLarge is returned when an upload is over the size limit
+	ErrUploadTooLarge = errors.New("upload too large")
+	// ErrUnsupportedType is returned when an upload isn't a supported book
+	ErrUnsupportedType = errors.New("unsupported file type")
+)
+
+// UploadLimit returns the size limit of an upload in bytes, 0 when there is
+// none.
+func UploadLimit() int64 {
+	if config.Opts.MaxUploadSize <= 0 {
+		return 0
+	}
+	return config.Opts.MaxUploadSize << 20
+}
+
+// StageUpload streams the file name read from r to the tmp directory of the
+// user, the job of the file can then be resumed until the file is in its book
+// directory. The type is checked on the first bytes and the size limit as
+// the bytes arrive, nothing is kept when either fails. The returned payload
+// describes the staged file.
+func StageUpload(uid int, name string, r io.Reader, limit int64) (*model.JobPayload, error) {
+	name = filepath.Base(name)
+	ext := parsers.Ext(name)
+	parser := parsers.ForPath(name)
+	if parser == nil || !config.CheckSupportedTypes(ext) {
+		return nil, errors.Wrap(ErrUnsupportedType, ext)
+	}
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, errors.Wrap(err, "failed to read upload")
+	}
+	head = head[:n]
+	if !parser.Detect(head) {
+		return nil, errors.Wrapf(ErrUnsupportedType, "%s isn't a %s book", name, parser.Name())
+	}
+
+	tmpDir := filepath.Join(config.Opts.Data, strconv.Itoa(uid), "tmp")
+	if err := os.MkdirAll(tmpDir, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "failed to create tmp directory")
+	}
+	staged, err := os.CreateTemp(tmpDir, "upload-*"+ext)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stage upload")
+	}
+	defer staged.Close()
+
+	hash := sha256.New()
+	src := io.MultiReader(bytes.NewReader(head), r)
+	if limit > 0 {
+		// One byte more tells that the upload is over the limit
+		src = io.LimitReader(src, limit+1)
+	}
+	size, err := io.Copy(io.MultiWriter(staged, hash), src)
+	if err == nil && limit > 0 && size > limit {
+		err = errors.Wrapf(ErrUploadTooLarge, "%s is over %d bytes", name, limit)
+	}
+	if err == nil {
+		err = staged.Close()
+	}
+	if err != nil {
+		os.Remove(staged.Name())
+		if errors.Is(err, ErrUploadTooLarge) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "failed to stage upload")
+	}
+
+	return &model.JobPayload{
+		File:     staged.Name(),
+		FileName: name,
+		Size:     size,
+		SHA256:   hex.EncodeToString(hash.Sum(nil)),
+		MIMEType: http.DetectContentType(head),
+	}, nil
+}
+
+// AddUploadJob saves the job of a staged book, which is resumed if the
+// server stops. The staged file is removed when the job can't be saved, the
+// caller queues the job.
+func AddUploadJob(s *store.Store, uid int, jobType string, payload *model.JobPayload) (*model.Job, error) {
+	bookFileName := strings.TrimSuffix(payload.FileName, parsers.Ext(payload.FileName))
+	bookPath := fmt.Sprintf("%s/%d/books/%s", config.Opts.Data, uid, bookFileName)
+	bookPath = util.GenerateNewDirName(bookPath)
+	log.Debug("Book path", zap.String("path", bookPath))
+
+	job, err := s.AddJob(model.Job{
+		UserID:  uid,
+		Path:    bookPath,
+		Type:    jobType,
+		Status:  model.JobStatusPending,
+		Stage:   model.JobStageUpload,
+		Payload: *payload,
+	})
+	if err != nil {
+		os.Remove(payload.File)
+		return nil, err
+	}
+	return job, nil
+}
@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	epub "github.com/go-shiori/go-epub"
+
+	"github.com/Xunop/e-oasis/internal/config"
+)
+
+func TestStageUpload(t *testing.T) {
+	dir := t.TempDir()
+	config.Opts.Data = dir
+
+	src := filepath.Join(dir, "Staged.epub")
+	book, err := epub.NewEpub("Staged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := book.Write(src); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := StageUpload(1, "some/dir/Staged.epub", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if payload.FileName != "Staged.epub" || payload.Size != int64(len(data)) || payload.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("payload = %+v", payload)
+	}
+	if payload.MIMEType != "application/zip" {
+		t.Errorf("MIME type = %s", payload.MIMEType)
+	}
+	if staged, err := os.ReadFile(payload.File); err != nil || !bytes.Equal(staged, data) {
+		t.Errorf("staged file differs, %v", err)
+	}
+
+	tmp := filepath.Join(dir, "1", "tmp")
+	tests := []struct {
+		name string
+		body []byte
+		want error
+	}{
+		{"Large.epub", data, ErrUploadTooLarge},
+		{"Fake.epub", []byte("not a zip file"), ErrUnsupportedType},
+		{"Book.exe", data, ErrUnsupportedType},
+	}
+	for _, tt := range tests {
+		_, err := StageUpload(1, tt.name, bytes.NewReader(tt.body), int64(len(data)-1))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("StageUpload(%s) error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	entries, _ := os.ReadDir(tmp)
+	for _, entry := range entries {
+		if !strings.HasSuffix(payload.File, entry.Name()) {
+			t.Errorf("%s is left behind", entry.Name())
+		}
+	}
+}
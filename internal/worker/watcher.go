@@ -0,0 +1,305 @@
+package worker
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/Xunop/e-oasis/internal/config"
+	"github.com/Xunop/e-oasis/internal/log"
+	"github.com/Xunop/e-oasis/internal/model"
+	"github.com/Xunop/e-oasis/internal/store"
+	"github.com/Xunop/e-oasis/internal/util"
+	"github.com/Xunop/e-oasis/internal/util/parsers"
+)
+
+// settleInterval is how often the new files of a watched folder are looked
+// at, a file is imported once its size stays the same for a whole interval.
+var settleInterval = 2 * time.Second
+
+// Folders watches the watched folders of every user, it is started along
+// with the upload pool.
+var Folders = NewFolderWatcher()
+
+// FolderWatcher imports the books dropped into the watched folders through
+// the upload pool
+type FolderWatcher struct {
+	mu      sync.Mutex
+	store   *store.Store
+	pool    WorkPool
+	watches map[int]*folderWatch
+}
+
+// NewFolderWatcher returns a watcher watching nothing until started
+func NewFolderWatcher() *FolderWatcher {
+	return &FolderWatcher{watches: make(map[int]*folderWatch)}
+}
+
+// Start watches the saved folders, their new books are pushed to pool
+func (w *FolderWatcher) Start(s *store.Store, pool WorkPool) error {
+	w.mu.Lock()
+	w.store, w.pool = s, pool
+	w.mu.Unlock()
+
+	folders, err := s.ListWatchedFolders(&model.FindWatchedFolder{})
+	if err != nil {
+		return err
+	}
+	for _, folder := range folders {
+		// A folder gone missing shouldn't keep the others from being watched
+		if err := w.Watch(folder); err != nil {
+			log.Error("Failed to watch folder", zap.String("path", folder.Path), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// Watch starts watching folder. The files already in the folder are imported
+// too when they are moved or deleted afterwards, the kept ones would be
+// imported again at every start.
+func (w *FolderWatcher) Watch(folder *model.WatchedFolder) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.store == nil {
+		// The folder is watched once started
+		return nil
+	}
+	if _, ok := w.watches[folder.ID]; ok {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "failed to create watcher")
+	}
+	fw := &folderWatch{
+		folder:  folder,
+		store:   w.store,
+		pool:    w.pool,
+		watcher: watcher,
+		pending: make(map[string]fileState),
+		done:    make(chan struct{}),
+	}
+	if err := fw.addDir(folder.Path, folder.AfterImport != model.AfterImportKeep); err != nil {
+		watcher.Close()
+		return err
+	}
+	w.watches[folder.ID] = fw
+	go fw.run()
+
+	log.Info("Watching folder", zap.Int("folder_id", folder.ID), zap.Int("user_id", folder.UserID), zap.String("path", folder.Path))
+	return nil
+}
+
+// Unwatch stops watching the folder id
+func (w *FolderWatcher) Unwatch(id int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if fw, ok := w.watches[id]; ok {
+		close(fw.done)
+		delete(w.watches, id)
+	}
+}
+
+// Close stops watching every folder
+func (w *FolderWatcher) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, fw := range w.watches {
+		close(fw.done)
+		delete(w.watches, id)
+	}
+}
+
+// fileState is the size of a new file when last looked at
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+type folderWatch struct {
+	folder  *model.WatchedFolder
+	store   *store.Store
+	pool    WorkPool
+	watcher *fsnotify.Watcher
+	// pending are the files still being written, only used by run
+	pending map[string]fileState
+	done    chan struct{}
+}
+
+func (fw *folderWatch) run() {
+	defer fw.watcher.Close()
+	ticker := time.NewTicker(settleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fw.done:
+			return
+		case event, ok := <-fw.watcher.Events:
+			if !ok {
+				return
+			}
+			fw.handle(event)
+		case err, ok := <-fw.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warn("Watched folder error", zap.String("path", fw.folder.Path), zap.Error(err))
+		case <-ticker.C:
+			fw.settle()
+		}
+	}
+}
+
+// handle takes note of the files created, moved in or written to
+func (fw *folderWatch) handle(event fsnotify.Event) {
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+	if fw.ignored(event.Name) {
+		return
+	}
+	info, err := os.Stat(event.Name)
+	if err != nil {
+		return
+	}
+	if info.IsDir() {
+		// The files moved in along with the directory send no event
+		if event.Has(fsnotify.Create) {
+			if err := fw.addDir(event.Name, true); err != nil {
+				log.Warn("Failed to watch directory", zap.String("path", event.Name), zap.Error(err))
+			}
+		}
+		return
+	}
+	fw.track(event.Name)
+}
+
+// addDir watches dir and its subdirectories, their files are imported when
+// scan is set
+func (fw *folderWatch) addDir(dir string, scan bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && fw.ignored(path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return errors.Wrapf(fw.watcher.Add(path), "failed to watch %s", path)
+		}
+		if scan {
+			fw.track(path)
+		}
+		return nil
+	})
+}
+
+// ignored reports whether path is left alone, hidden files, the files of the
+// archive directory and the files which aren't books.
+func (fw *folderWatch) ignored(path string) bool {
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		return true
+	}
+	if archive := fw.folder.ArchiveDir; archive != "" {
+		rel, err := filepath.Rel(archive, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return false
+	}
+	ext := parsers.Ext(path)
+	return parsers.ForPath(path) == nil || !config.CheckSupportedTypes(ext)
+}
+
+func (fw *folderWatch) track(path string) {
+	if _, ok := fw.pending[path]; !ok {
+		fw.pending[path] = fileState{size: -1}
+	}
+}
+
+// settle imports the pending files whose size didn't change since last time
+func (fw *folderWatch) settle() {
+	for path, last := range fw.pending {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Moved away or deleted before it was complete
+			delete(fw.pending, path)
+			continue
+		}
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		if state != last || state.size == 0 {
+			fw.pending[path] = state
+			continue
+		}
+		delete(fw.pending, path)
+		if err := fw.ingest(path); err != nil {
+			log.Error("Failed to import watched file", zap.String("path", path), zap.Error(err))
+		}
+	}
+}
+
+// ingest stages the file path as an upload of the user of the folder, then
+// moves or deletes it as the folder says.
+func (fw *folderWatch) ingest(path string) error {
+	folder := fw.folder
+	rel, err := filepath.Rel(folder.Path, path)
+	if err != nil {
+		return errors.Wrap(err, "file out of the watched folder")
+	}
+
+	// A book over the quota is left in the folder
+	if err := CheckQuota(fw.store, folder.UserID, 1, fileSize(path)); err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file")
+	}
+	payload, err := StageUpload(folder.UserID, path, file, UploadLimit())
+	file.Close()
+	if err != nil {
+		return err
+	}
+	if folder.MapTags {
+		payload.Tags = dirTags(rel)
+	}
+
+	job, err := AddUploadJob(fw.store, folder.UserID, model.JobTypeWatch, payload)
+	if err != nil {
+		return err
+	}
+	go fw.pool.Push(*job)
+	log.Info("Watched file queued", zap.String("path", path), zap.Int("job_id", job.ID))
+
+	// The job works on its staged copy
+	switch folder.AfterImport {
+	case model.AfterImportArchive:
+		dest := util.GenerateNewFileName(filepath.Join(folder.ArchiveDir, rel))
+		if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+			return errors.Wrap(err, "failed to create archive directory")
+		}
+		if err := os.Rename(path, dest); err != nil {
+			return errors.Wrap(err, "failed to archive file")
+		}
+	case model.AfterImportDelete:
+		if err := os.Remove(path); err != nil {
+			return errors.Wrap(err, "failed to delete file")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	epub "github.com/go-shiori/go-epub"
+
+	"github.com/Xunop/e-oasis/internal/model"
+)
+
+// chanPool hands the pushed jobs to the test
+type chanPool chan model.Job
+
+func (p chanPool) Push(job model.Job) {
+	p <- job
+}
+
+func TestFolderWatcher(t *testing.T) {
+	s := newJobTestStore(t)
+	settleInterval = 20 * time.Millisecond
+
+	src := filepath.Join(t.TempDir(), "Watched.epub")
+	book, err := epub.NewEpub("Watched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := book.Write(src); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	// Already there, imported since it is deleted afterwards
+	if err := os.WriteFile(filepath.Join(root, "Old.epub"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pool := make(chanPool, 2)
+	watcher := NewFolderWatcher()
+	if err := watcher.Start(s, pool); err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	folder, err := s.AddWatchedFolder(&model.WatchedFolder{UserID: 1, Path: root, AfterImport: model.AfterImportDelete, MapTags: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.Watch(folder); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(root, "Fiction", "SciFi")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	// Not a book
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "New.epub"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := make(map[string]model.Job)
+	for len(jobs) < 2 {
+		select {
+		case job := <-pool:
+			jobs[job.Payload.FileName] = job
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only got %d jobs", len(jobs))
+		}
+	}
+	if job := jobs["New.epub"]; job.Type != model.JobTypeWatch || !reflect.DeepEqual(job.Payload.Tags, []string{"Fiction", "SciFi"}) {
+		t.Errorf("job = %+v", job)
+	}
+	if job := jobs["Old.epub"]; len(job.Payload.Tags) != 0 {
+		t.Errorf("tags = %v", job.Payload.Tags)
+	}
+	for _, job := range jobs {
+		if staged, err := os.ReadFile(job.Payload.File); err != nil || len(staged) != len(data) {
+			t.Errorf("%s isn't staged, %v", job.Payload.FileName, err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, name := range []string{filepath.Join(root, "Old.epub"), filepath.Join(dir, "New.epub")} {
+		for _, err := os.Stat(name); !os.IsNotExist(err); _, err = os.Stat(name) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s should be deleted", name)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("the other files should be left alone, %v", err)
+	}
+}
+
+func TestFolderWatcherArchive(t *testing.T) {
+	s := newJobTestStore(t)
+	settleInterval = 20 * time.Millisecond
+
+	root := t.TempDir()
+	archive := filepath.Join(root, ".imported")
+	src := filepath.Join(t.TempDir(), "Archived.epub")
+	book, err := epub.NewEpub("Archived")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := book.Write(src); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := make(chanPool, 1)
+	watcher := NewFolderWatcher()
+	if err := watcher.Start(s, pool); err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	folder := &model.WatchedFolder{ID: 1, UserID: 1, Path: root, AfterImport: model.AfterImportArchive, ArchiveDir: archive}
+	if err := watcher.Watch(folder); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moved in like a finished download
+	if err := os.MkdirAll(filepath.Join(root, "Comics"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := os.Rename(src, filepath.Join(root, "Comics", "Archived.epub")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case job := <-pool:
+		if len(job.Payload.Tags) != 0 {
+			t.Errorf("tags = %v without MapTags", job.Payload.Tags)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the moved file wasn't imported")
+	}
+	archived := filepath.Join(archive, "Comics", "Archived.epub")
+	deadline := time.Now().Add(5 * time.Second)
+	for _, err := os.Stat(archived); err != nil; _, err = os.Stat(archived) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s should be archived, %v", archived, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The archive isn't imported again
+	select {
+	case job := <-pool:
+		t.Errorf("archived file imported again, %+v", job)
+	case <-time.After(100 * time.Millisecond):
+	}
+}